@@ -1,11 +1,17 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/VKCOM/nocc/internal/client"
 	"github.com/VKCOM/nocc/internal/common"
@@ -22,21 +28,30 @@ func failedStartDaemon(err interface{}) {
 }
 
 func readNoccServersFile(envNoccServersFilename string) (remoteNoccHosts []string) {
-	contents, err := os.ReadFile(envNoccServersFilename)
+	remoteNoccHosts, err := client.ReadNoccServersFiles(envNoccServersFilename)
 	if err != nil {
 		failedStart(err)
 	}
-	lines := bytes.Split(contents, []byte{'\n'})
-	remoteNoccHosts = make([]string, 0, len(lines))
+	return remoteNoccHosts
+}
 
-	for _, line := range lines {
-		hostAndComment := bytes.SplitN(bytes.TrimSpace(line), []byte{'#'}, 2)
-		if len(hostAndComment) > 0 && len(hostAndComment[0]) > 0 {
-			trimmedHost := string(bytes.Trim(hostAndComment[0], " ;,"))
-			remoteNoccHosts = append(remoteNoccHosts, trimmedHost)
-		}
+// readNoccServersFd reads a servers list (in the same format as NOCC_SERVERS_FILENAME) from an inherited file descriptor.
+// It's for sandboxed builds, where writing a file to disk is disallowed, but an fd can be passed.
+func readNoccServersFd(envNoccServersFd string) (remoteNoccHosts []string) {
+	fd, err := strconv.Atoi(envNoccServersFd)
+	if err != nil || fd < 0 {
+		failedStart(fmt.Errorf("invalid NOCC_SERVERS_FD=%s: a file descriptor number expected", envNoccServersFd))
 	}
-	return
+	f := os.NewFile(uintptr(fd), "NOCC_SERVERS_FD")
+	if _, err := f.Stat(); err != nil {
+		failedStart(fmt.Errorf("NOCC_SERVERS_FD=%d is not an open file descriptor: %v", fd, err))
+	}
+	_, _ = f.Seek(0, io.SeekStart) // if it's a regular file, read it from the beginning; for pipes, it just fails
+	contents, err := io.ReadAll(f)
+	if err != nil {
+		failedStart(fmt.Errorf("can't read from NOCC_SERVERS_FD=%d: %v", fd, err))
+	}
+	return client.ParseNoccServersFileContents(contents)
 }
 
 func parseNoccServersEnv(envNoccServers string) (remoteNoccHosts []string) {
@@ -50,52 +65,195 @@ func parseNoccServersEnv(envNoccServers string) (remoteNoccHosts []string) {
 	return
 }
 
+// parseForceInterruptTimeoutEnv parses NOCC_FORCE_INTERRUPT_TIMEOUT, a duration with units like "8m" or "90s".
+func parseForceInterruptTimeoutEnv(envTimeout string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(envTimeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid NOCC_FORCE_INTERRUPT_TIMEOUT: %q, expected a duration like '8m' or '90s'", envTimeout)
+	}
+	return timeout, nil
+}
+
+// parsePurgeOlderThanArg parses -purge-older-than, a duration like "12h" or a number of days like "7d".
+func parsePurgeOlderThanArg(arg string) (time.Duration, error) {
+	olderThan, err := time.ParseDuration(arg)
+	if days, errDays := strconv.Atoi(strings.TrimSuffix(arg, "d")); strings.HasSuffix(arg, "d") && errDays == nil {
+		olderThan, err = time.Duration(days)*24*time.Hour, nil
+	}
+	if err != nil || olderThan < time.Second {
+		return 0, fmt.Errorf("invalid -purge-older-than: %q, expected a duration like '7d' or '12h'", arg)
+	}
+	return olderThan, nil
+}
+
+// parseDirsEnv splits NOCC_CACHEABLE_INCLUDE_DIRS and similar (separated by ';', like NOCC_SERVERS) into dir prefixes.
+// Every dir must be absolute, a trailing slash is appended not to treat /opt/sdk as a prefix of /opt/sdk2.
+func parseDirsEnv(envName string, envDirs string) (dirs []string, err error) {
+	for _, dir := range strings.Split(envDirs, ";") {
+		if dir = strings.TrimSpace(dir); len(dir) == 0 {
+			continue
+		}
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("invalid %s: %s is not an absolute path", envName, dir)
+		}
+		dirs = append(dirs, strings.TrimSuffix(filepath.Clean(dir), "/")+"/")
+	}
+	return
+}
+
 func main() {
 	showVersionAndExit := common.CmdEnvBool("Show version and exit.", false,
 		"version", "")
 	showVersionAndExitShort := common.CmdEnvBool("Show version and exit.", false,
 		"v", "")
+	showVersionJSON := common.CmdEnvBool("With -version, print version and build metadata as JSON.", false,
+		"json", "")
 	checkServersAndExit := common.CmdEnvBool("Print out servers status and exit.", false,
 		"check-servers", "")
 	dumpServerLogsAndExit := common.CmdEnvBool("Dump logs from all servers to /tmp/nocc-dump-logs/ and exit.\nServers must be launched with the `-log-filename` option.", false,
 		"dump-server-logs", "")
+	followServerLogs := common.CmdEnvBool("Print new lines of logs from all servers as they appear, prefixed with a host, until Ctrl+C.\nServers must be launched with the `-log-filename` option.", false,
+		"follow-server-logs", "")
+	selftestAndExit := common.CmdEnvBool("Compile a tiny .cpp on every server end-to-end, link it locally and exit.\nPrints pass/fail per server with a round-trip time, to check that a setup actually works.", false,
+		"selftest", "")
+	setServersAndExit := common.CmdEnvString("Replace servers of a running daemon with a list of 'host:port' delimited by ';' and exit.\nIt's for dynamic fleets: new servers are connected, invocations in flight keep their current routing.", "",
+		"set-servers", "")
 	dropServerCachesAndExit := common.CmdEnvBool("Drop src cache and obj cache on all servers and exit.", false,
 		"drop-server-caches", "")
-	noccServers := common.CmdEnvString("Remote nocc servers — a list of 'host:port' delimited by ';'.\nIf not set, nocc will read NOCC_SERVERS_FILENAME.", "",
+	purgeServerCachesAndExit := common.CmdEnvString("Purge src cache and obj cache files not accessed for a given time (like '7d' or '12h') on all servers and exit.\nUnlike -drop-server-caches, a warm set is kept.", "",
+		"purge-older-than", "")
+	noccServers := common.CmdEnvString("Remote nocc servers — a list of 'host:port' delimited by ';'.\nIf not set, nocc will read NOCC_SERVERS_FD or NOCC_SERVERS_FILENAME.", "",
 		"", "NOCC_SERVERS")
-	noccServersFilename := common.CmdEnvString("A file with nocc servers — a list of 'host:port', one per line (with optional comments starting with '#').\nUsed if NOCC_SERVERS is unset.", "",
+	noccServersFilename := common.CmdEnvString("A file with nocc servers — a list of 'host:port', one per line (with optional comments starting with '#').\nSeveral files separated by ',' or ':' (e.g. a base pool and an overlay) are merged, duplicates removed. Used if NOCC_SERVERS is unset.", "",
 		"", "NOCC_SERVERS_FILENAME")
+	noccServersFd := common.CmdEnvString("An open file descriptor to read nocc servers from, in the same format as NOCC_SERVERS_FILENAME.\nUsed if NOCC_SERVERS is unset, has a priority over NOCC_SERVERS_FILENAME.", "",
+		"", "NOCC_SERVERS_FD")
+	noccServersC := common.CmdEnvString("Remote nocc servers for compiling .c files, in the same format as NOCC_SERVERS.\nIf not set, NOCC_SERVERS are used for .c files.", "",
+		"", "NOCC_SERVERS_C")
+	noccServersCxx := common.CmdEnvString("Remote nocc servers for compiling C++ files, in the same format as NOCC_SERVERS.\nIf not set, NOCC_SERVERS are used for C++ files.", "",
+		"", "NOCC_SERVERS_CXX")
+	forceServer := common.CmdEnvString("A server ('host:port') to send all sources to, bypassing hashing; it may be not listed in NOCC_SERVERS.\nUseful for reproducing server-specific issues. If it's unavailable, a server is chosen as usual.", "",
+		"", "NOCC_FORCE_SERVER")
+	connectAttempts := common.CmdEnvInt("Attempts to connect to every remote on daemon start, with a small backoff between them.\nIf all attempts fail, a remote is considered unavailable.", 3,
+		"", "NOCC_CONNECT_ATTEMPTS")
+	forceInterruptTimeout := common.CmdEnvString("A timeout for one invocation, like '8m' or '90s', default 8m.\nIf remote compilation lasts longer (a remote hangs), it's interrupted, and cxx is launched locally.", "8m",
+		"", "NOCC_FORCE_INTERRUPT_TIMEOUT")
+	connectTimeoutMs := common.CmdEnvInt("A timeout for one attempt to connect to a remote, in milliseconds.", 2000,
+		"", "NOCC_CONNECT_TIMEOUT_MS")
+	chunkSize := common.CmdEnvInt("Files are uploaded to remotes by chunks of this size, in bytes, default 64K.", 64*1024,
+		"", "NOCC_CHUNK_SIZE")
+	uploadQueueSize := common.CmdEnvInt("Files waiting for being uploaded to one remote, default 50.", 50,
+		"", "NOCC_UPLOAD_QUEUE_SIZE")
+	grpcWindowSize := common.CmdEnvInt("Initial grpc window for a stream and a connection, in bytes.\nBy default (0), a window grows dynamically; a fixed one is good for fast links with a high latency.", 0,
+		"", "NOCC_GRPC_WINDOW_SIZE")
+	grpcMaxMsgSize := common.CmdEnvInt("Max size of a grpc message received from remotes, in bytes, default 4M.\nShould be more than servers' -chunk-size.", 0,
+		"", "NOCC_GRPC_MAX_MSG_SIZE")
+	grpcKeepalive := common.CmdEnvInt("Ping remotes after this idle time, in seconds, to keep idle streams alive through NATs and detect dead remotes promptly.\nBy default (0), a daemon doesn't ping, relying on pings of servers. Servers older than this option allow pings at most every 300 seconds.", 0,
+		"", "NOCC_GRPC_KEEPALIVE")
+	tlsCA := common.CmdEnvString("Connect to remotes via TLS, verifying their certificates against CA certs (PEM) in this file.\nServers must be launched with -tls-cert and -tls-key; if only a client cert is set, system roots are used.", "",
+		"", "NOCC_TLS_CA")
+	tlsClientCert := common.CmdEnvString("A client certificate (PEM) to present to remotes over TLS, along with NOCC_TLS_CLIENT_KEY.\nRequired by servers launched with -tls-client-ca (mutual TLS).", "",
+		"", "NOCC_TLS_CLIENT_CERT")
+	tlsClientKey := common.CmdEnvString("A private key (PEM) of NOCC_TLS_CLIENT_CERT.", "",
+		"", "NOCC_TLS_CLIENT_KEY")
+	compressTransfers := common.CmdEnvBool("Gzip sources and objs sent over the network, for slow links.\nSmall and already compressed files (by extension, e.g. .nocc-pch) are sent as is.", false,
+		"", "NOCC_COMPRESS_TRANSFERS")
 	logFileName := common.CmdEnvString("A filename to log, nothing by default.\nErrors are duplicated to stderr always.", "",
 		"", "NOCC_LOG_FILENAME")
 	logVerbosity := common.CmdEnvInt("Logger verbosity level for INFO (-1 off, default 0, max 2).\nErrors are logged always.", 0,
 		"", "NOCC_LOG_VERBOSITY")
 	disableObjCache := common.CmdEnvBool("Disable obj cache on remote: .o will be compiled always and won't be stored.", false,
 		"", "NOCC_DISABLE_OBJ_CACHE")
+	seedObjCache := common.CmdEnvBool("Compile locally, but upload every resulting .o to the remote's obj cache in background,\nso that other clients would take it from there.", false,
+		"", "NOCC_SEED_OBJ_CACHE")
+	recacheObjs := common.CmdEnvBool("Don't take objs from obj cache on remote: compile always, but store .o replacing an existing one.\nUseful to refresh obj cache after a suspected corruption or a toolchain hotfix.", false,
+		"", "NOCC_RECACHE_OBJS")
+	ccacheCompat := common.CmdEnvBool("Map CCACHE_* env vars (left in scripts after migrating from ccache) to nocc equivalents on daemon start:\nCCACHE_DISABLE as NOCC_DISABLE_OBJ_CACHE, CCACHE_RECACHE as NOCC_RECACHE_OBJS.", false,
+		"", "NOCC_CCACHE_COMPAT")
 	disableOwnIncludes := common.CmdEnvBool("Disable own includes parser: use a C++ preprocessor instead.\nIt's much slower, but 100% works.\nBy default, nocc traverses #include-s recursively using its own built-in parser.", false,
 		"", "NOCC_DISABLE_OWN_INCLUDES")
+	disableOwnPch := common.CmdEnvBool("Don't look for .nocc-pch files next to included headers: use headers directly.\nUseful when a project mixes pch and non-pch builds, and a stale .nocc-pch may be picked up.", false,
+		"", "NOCC_DISABLE_OWN_PCH")
+	compressOwnPch := common.CmdEnvBool("Compress dependencies inside generated .nocc-pch files (gzip), they become much smaller to upload.\nRequires all servers to be updated: older ones fail to extract such files.", false,
+		"", "NOCC_COMPRESS_OWN_PCH")
+	dedupeOwnPch := common.CmdEnvBool("Save dependencies with equal contents (e.g. similar autogenerated headers) inside generated .nocc-pch files once, others just reference them.\nRequires all servers to be updated: older ones fail to extract such files.", false,
+		"", "NOCC_DEDUPE_OWN_PCH")
+	rewriteIncludes := common.CmdEnvBool("For clang, when own includes parser gives up on #include MACRO, preprocess a file locally with -frewrite-includes\nand compile a resulting single file remotely instead of compiling it locally.", false,
+		"", "NOCC_REWRITE_INCLUDES")
+	includesOnServer := common.CmdEnvBool("Experimental: collect includes on a server with cxx -M instead of own includes parser on a client.\nIt takes preprocessing CPU off a client at the cost of round trips, a server requests back headers it doesn't have.", false,
+		"", "NOCC_COLLECT_INCLUDES_ON_SERVER")
+	cacheableIncludeDirs := common.CmdEnvString("Dirs (separated by ';') with stable headers, that are cached by own includes parser like system ones.\nUse only for dirs whose headers are resolved the same way by every invocation (e.g. a vendored SDK), even if passed via -I.", "",
+		"", "NOCC_CACHEABLE_INCLUDE_DIRS")
+	generatedIncludeDirs := common.CmdEnvString("Dirs (separated by ';') with headers generated during a build (e.g. Qt's ui_*.h), whose contents are cached by own includes parser.\nThey are revalidated by mtime when regenerated, and <...> resolves to them are looked up by every invocation, so dirs may differ between builds.", "",
+		"", "NOCC_GENERATED_INCLUDE_DIRS")
+	skipObjCacheLookupDirs := common.CmdEnvString("Dirs (separated by ';') with always-changing sources (e.g. generated code), whose objs are hardly ever reused.\nFor sources inside them, servers skip obj cache lookup (and don't store their objs).", "",
+		"", "NOCC_SKIP_OBJ_CACHE_LOOKUP_DIRS")
+	ownIncludesMaxDepth := common.CmdEnvInt("Max #include nesting depth for own includes parser, 0 means no limit.\nIf exceeded (e.g. a header includes itself without guards), includes are collected with cxx -M instead.", 200,
+		"", "NOCC_OWN_INCLUDES_MAX_DEPTH")
+	ownIncludesMaxFiles := common.CmdEnvInt("Max number of files own includes parser resolves for one source, 0 means no limit.\nIf exceeded, includes are collected with cxx -M instead.", 50000,
+		"", "NOCC_OWN_INCLUDES_MAX_FILES")
+	includesCacheLimit := common.CmdEnvInt("A soft limit of memory for caching includes (resolved #include-s and headers info) per compiler, in bytes, 0 means no limit.\nWhen exceeded, the least recently used entries are evicted: for long-living daemons on huge monorepos.", 0,
+		"", "NOCC_INCLUDES_CACHE_LIMIT")
+	echoServerCmdLine := common.CmdEnvBool("Ask servers to send back a C++ compiler command line they launch for every source, and log it.\nServer paths are shown as-is, it's for debugging \"compiles locally, but fails remotely\".", false,
+		"", "NOCC_ECHO_SERVER_CMD_LINE")
+	summaryFileName := common.CmdEnvString("A file to append a TSV record with timings to for every invocation compiled remotely.\nUnlike a log, it has a stable set of columns (see the first line), for offline analysis.", "",
+		"", "NOCC_SUMMARY_FILE")
+	uploadsFileName := common.CmdEnvString("A file to append paths of all files uploaded to remotes to (never contents), for auditing what leaves a machine.\nA TSV record per file: an invocation it was uploaded for and a remote, see the first line.", "",
+		"", "NOCC_UPLOADS_FILE")
+	compDBFileName := common.CmdEnvString("A compile_commands.json to collect every source compiled during a build into.\nIt's written in batches as compilations finish and on daemon quit, merging with entries already there.", "",
+		"", "NOCC_COMPDB")
+	skipUnchangedFileName := common.CmdEnvString("A file to keep a state of compiled objs in; if set, an invocation is skipped when its obj is up to date:\ncompiled with the same cmd line, and files listed in its depfile (-MD) are unchanged.", "",
+		"", "NOCC_SKIP_UNCHANGED")
+	serversAffinityFileName := common.CmdEnvString("A file to keep which server every file was sent to; if set, a file is sent there again while it's in a servers list,\neven if other servers are added or removed, so that its obj cache stays warm. For iterative development, see docs.", "",
+		"", "NOCC_SERVERS_AFFINITY_FILE")
+	sortServers := common.CmdEnvBool("Route files over servers sorted by host:port, so that machines listing NOCC_SERVERS in a different order send a file to the same server.\nSwitching it on changes a server of most files once (obj caches get cold), enable it on all machines at the same time.", false,
+		"", "NOCC_SORT_SERVERS")
+	preflight := common.CmdEnvBool("On daemon start, check all servers and log a verdict per server (reachable, version, compilers).\nIf none is reachable and local compilation is disabled, a daemon fails to start instead of failing every invocation.", false,
+		"", "NOCC_PREFLIGHT")
 	localCxxQueueSize := common.CmdEnvInt("Amount of parallel processes when remotes aren't available and cxx is launched locally.\nBy default, it's a number of CPUs on the current machine.", int64(runtime.NumCPU()),
 		"", "NOCC_LOCAL_CXX_QUEUE_SIZE")
+	localCxxQueueSizePartialOutage := common.CmdEnvInt("Amount of parallel local processes while some remotes are unavailable, but others are still up.\nMakes sense if less than NOCC_LOCAL_CXX_QUEUE_SIZE: to leave CPU for other work while most files still go remote. 0 means the same.", 0,
+		"", "NOCC_LOCAL_CXX_QUEUE_SIZE_PARTIAL_OUTAGE")
+	localCxxOverride := common.CmdEnvString("A compiler to launch instead of the original one when cxx is launched locally (remote compilation is unaffected).\nIf it's a dir of wrappers, a compiler with the same name is taken from there, e.g. /usr/lib/ccache to chain with a local ccache.", "",
+		"", "NOCC_LOCAL_CXX_OVERRIDE")
 
 	common.ParseCmdFlagsCombiningWithEnv()
 
 	var remoteNoccHosts []string
 	if *noccServers != "" {
 		remoteNoccHosts = parseNoccServersEnv(*noccServers)
+	} else if *noccServersFd != "" {
+		remoteNoccHosts = readNoccServersFd(*noccServersFd)
 	} else if *noccServersFilename != "" {
 		remoteNoccHosts = readNoccServersFile(*noccServersFilename)
 	}
+	remoteNoccHostsC := parseNoccServersEnv(*noccServersC)
+	remoteNoccHostsCxx := parseNoccServersEnv(*noccServersCxx)
+	cacheableDirs, cacheableDirsErr := parseDirsEnv("NOCC_CACHEABLE_INCLUDE_DIRS", *cacheableIncludeDirs)
+	generatedDirs, generatedDirsErr := parseDirsEnv("NOCC_GENERATED_INCLUDE_DIRS", *generatedIncludeDirs)
+	skipObjCacheDirs, skipObjCacheDirsErr := parseDirsEnv("NOCC_SKIP_OBJ_CACHE_LOOKUP_DIRS", *skipObjCacheLookupDirs)
+	interruptTimeout, interruptTimeoutErr := parseForceInterruptTimeoutEnv(*forceInterruptTimeout)
 
 	if *showVersionAndExit || *showVersionAndExitShort {
-		fmt.Println(common.GetVersion())
+		if *showVersionJSON {
+			fmt.Println(common.GetVersionJSON())
+		} else {
+			fmt.Println(common.GetVersion())
+		}
 		os.Exit(0)
 	}
 
+	// all connections to remotes, including -check-servers and similar, are made via TLS if it's set up
+	if err := client.SetupTLS(*tlsCA, *tlsClientCert, *tlsClientKey); err != nil {
+		failedStart(err)
+	}
+
 	if *checkServersAndExit {
 		if len(os.Args) == 3 { // nocc -check-servers {remoteHostPort}
 			remoteNoccHosts = []string{os.Args[2]}
 		}
 		if len(remoteNoccHosts) == 0 {
-			failedStart("no remote hosts set; you should set NOCC_SERVERS or NOCC_SERVERS_FILENAME")
+			failedStart("no remote hosts set; you should set NOCC_SERVERS, NOCC_SERVERS_FD or NOCC_SERVERS_FILENAME")
 		}
 		client.RequestRemoteStatus(remoteNoccHosts)
 		os.Exit(0)
@@ -106,20 +264,71 @@ func main() {
 			remoteNoccHosts = []string{os.Args[2]}
 		}
 		if len(remoteNoccHosts) == 0 {
-			failedStart("no remote hosts set; you should set NOCC_SERVERS or NOCC_SERVERS_FILENAME")
+			failedStart("no remote hosts set; you should set NOCC_SERVERS, NOCC_SERVERS_FD or NOCC_SERVERS_FILENAME")
 		}
 		client.RequestRemoteDumpLogs(remoteNoccHosts, "/tmp/nocc-dump-logs")
 		os.Exit(0)
 	}
 
+	if *followServerLogs {
+		if len(os.Args) == 3 { // nocc -follow-server-logs {remoteHostPort}
+			remoteNoccHosts = []string{os.Args[2]}
+		}
+		if len(remoteNoccHosts) == 0 {
+			failedStart("no remote hosts set; you should set NOCC_SERVERS, NOCC_SERVERS_FD or NOCC_SERVERS_FILENAME")
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		client.FollowRemoteLogs(ctx, remoteNoccHosts, os.Stdout)
+		stop()
+		os.Exit(0)
+	}
+
+	if *selftestAndExit {
+		if len(os.Args) == 3 { // nocc -selftest {remoteHostPort}
+			remoteNoccHosts = []string{os.Args[2]}
+		}
+		if len(remoteNoccHosts) == 0 {
+			failedStart("no remote hosts set; you should set NOCC_SERVERS, NOCC_SERVERS_FD or NOCC_SERVERS_FILENAME")
+		}
+		if err := client.MakeLoggerClient(*logFileName, *logVerbosity, *logFileName != "stderr"); err != nil {
+			failedStart(err)
+		}
+		if nFailed := client.RunSelftest(remoteNoccHosts, "g++"); nFailed > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *setServersAndExit != "" {
+		exitCode, stdout, stderr, err := client.RequestDaemonSetServers("/tmp/nocc.sock", parseNoccServersEnv(*setServersAndExit))
+		if err != nil {
+			failedStart(err)
+		}
+		_, _ = os.Stdout.Write(stdout)
+		_, _ = os.Stderr.Write(stderr)
+		os.Exit(exitCode)
+	}
+
 	if *dropServerCachesAndExit {
 		if len(remoteNoccHosts) == 0 {
-			failedStart("no remote hosts set; you should set NOCC_SERVERS or NOCC_SERVERS_FILENAME")
+			failedStart("no remote hosts set; you should set NOCC_SERVERS, NOCC_SERVERS_FD or NOCC_SERVERS_FILENAME")
 		}
 		client.RequestDropAllCaches(remoteNoccHosts)
 		os.Exit(0)
 	}
 
+	if *purgeServerCachesAndExit != "" {
+		if len(remoteNoccHosts) == 0 {
+			failedStart("no remote hosts set; you should set NOCC_SERVERS, NOCC_SERVERS_FD or NOCC_SERVERS_FILENAME")
+		}
+		olderThan, err := parsePurgeOlderThanArg(*purgeServerCachesAndExit)
+		if err != nil {
+			failedStart(err)
+		}
+		client.RequestPurgeStaleCaches(remoteNoccHosts, olderThan)
+		os.Exit(0)
+	}
+
 	// `nocc-daemon start {cxxName}`
 	// on init fail, we should print an error to stdout (a parent process is listening to stdout pipe)
 	// on init success, we should print '1' to stdout
@@ -127,11 +336,77 @@ func main() {
 		if err := client.MakeLoggerClient(*logFileName, *logVerbosity, *logFileName != "stderr"); err != nil {
 			failedStartDaemon(err)
 		}
+		if cacheableDirsErr != nil {
+			failedStartDaemon(cacheableDirsErr)
+		}
+		if generatedDirsErr != nil {
+			failedStartDaemon(generatedDirsErr)
+		}
+		if skipObjCacheDirsErr != nil {
+			failedStartDaemon(skipObjCacheDirsErr)
+		}
+		if interruptTimeoutErr != nil {
+			failedStartDaemon(interruptTimeoutErr)
+		}
+		if *ccacheCompat {
+			client.ApplyCcacheCompatEnv(disableObjCache, recacheObjs)
+		}
 
-		daemon, err := client.MakeDaemon(remoteNoccHosts, *disableObjCache, *disableOwnIncludes, *localCxxQueueSize)
+		transferTuning := client.TransferTuning{
+			ChunkSize:       int(*chunkSize),
+			UploadQueueSize: int(*uploadQueueSize),
+			GrpcWindowSize:  int(*grpcWindowSize),
+			GrpcMaxMsgSize:  int(*grpcMaxMsgSize),
+			Compress:        *compressTransfers,
+			GrpcKeepalive:   time.Duration(*grpcKeepalive) * time.Second,
+		}
+		if err := transferTuning.Validate(); err != nil {
+			failedStartDaemon(err)
+		}
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts:                   remoteNoccHosts,
+			RemoteNoccHostsC:                  remoteNoccHostsC,
+			RemoteNoccHostsCxx:                remoteNoccHostsCxx,
+			ForcedNoccHost:                    *forceServer,
+			ConnectAttempts:                   *connectAttempts,
+			ConnectTimeout:                    time.Duration(*connectTimeoutMs) * time.Millisecond,
+			InterruptTimeout:                  interruptTimeout,
+			TransferTuning:                    transferTuning,
+			DisableObjCache:                   *disableObjCache,
+			SeedObjCache:                      *seedObjCache,
+			DisableOwnIncludes:                *disableOwnIncludes,
+			DisableOwnPch:                     *disableOwnPch,
+			CompressOwnPch:                    *compressOwnPch,
+			DedupeOwnPch:                      *dedupeOwnPch,
+			RewriteIncludes:                   *rewriteIncludes,
+			IncludesOnServer:                  *includesOnServer,
+			CacheableIncludeDirs:              cacheableDirs,
+			SkipObjCacheLookupDirs:            skipObjCacheDirs,
+			OwnIncludesMaxDepth:               *ownIncludesMaxDepth,
+			OwnIncludesMaxFiles:               *ownIncludesMaxFiles,
+			EchoServerCmdLine:                 *echoServerCmdLine,
+			SummaryFileName:                   *summaryFileName,
+			CompDBFileName:                    *compDBFileName,
+			SkipUnchangedFileName:             *skipUnchangedFileName,
+			MaxLocalCxxProcesses:              *localCxxQueueSize,
+			MaxLocalCxxProcessesPartialOutage: *localCxxQueueSizePartialOutage,
+			LocalCxxOverride:                  *localCxxOverride,
+			RecacheObjs:                       *recacheObjs,
+			GeneratedIncludeDirs:              generatedDirs,
+			UploadsFileName:                   *uploadsFileName,
+			IncludesCacheLimit:                *includesCacheLimit,
+			ServersAffinityFileName:           *serversAffinityFileName,
+			SortServers:                       *sortServers,
+		})
 		if err != nil {
 			failedStartDaemon(err)
 		}
+		if *preflight {
+			if err := daemon.RunPreflight(); err != nil {
+				daemon.QuitDaemonGracefully("preflight failed")
+				failedStartDaemon(err)
+			}
+		}
 		err = daemon.StartListeningUnixSocket("/tmp/nocc.sock")
 		if err != nil {
 			failedStartDaemon(err)
@@ -154,10 +429,13 @@ func main() {
 	}
 
 	if len(remoteNoccHosts) == 0 {
-		failedStart("no remote hosts set; you should set NOCC_SERVERS or NOCC_SERVERS_FILENAME")
+		failedStart("no remote hosts set; you should set NOCC_SERVERS, NOCC_SERVERS_FD or NOCC_SERVERS_FILENAME")
+	}
+	if cacheableDirsErr != nil {
+		failedStart(cacheableDirsErr)
 	}
 
-	exitCode, stdout, stderr := client.EmulateDaemonInsideThisProcessForDev(remoteNoccHosts, os.Args[1:], *disableOwnIncludes, 1)
+	exitCode, stdout, stderr := client.EmulateDaemonInsideThisProcessForDev(remoteNoccHosts, os.Args[1:], *disableOwnIncludes, *disableOwnPch, *compressOwnPch, cacheableDirs, 1)
 	_, _ = os.Stdout.Write(stdout)
 	_, _ = os.Stderr.Write(stderr)
 	os.Exit(exitCode)
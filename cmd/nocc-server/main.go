@@ -1,7 +1,9 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"math"
 	"net"
 	"os"
 	"runtime"
@@ -11,6 +13,8 @@ import (
 	"github.com/VKCOM/nocc/internal/server"
 	"github.com/VKCOM/nocc/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 )
 
 func failedStart(message string, err error) {
@@ -18,6 +22,29 @@ func failedStart(message string, err error) {
 	os.Exit(1)
 }
 
+// makeTLSConfig is -tls-cert, -tls-key and -tls-client-ca: the last one turns on mutual TLS,
+// unauthenticated clients are rejected by a handshake, so no rpc handler is ever reached by them
+func makeTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both -tls-cert and -tls-key should be set")
+	}
+	serverCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		if tlsConfig.ClientCAs, err = common.LoadCertPool(clientCAFile); err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
 // prepareEmptyDir ensures that serverDir exists and is empty
 // it's executed on server launch
 // as a consequence, all file caches are lost on restart
@@ -59,6 +86,8 @@ func main() {
 		"version", "")
 	showVersionAndExitShort := common.CmdEnvBool("Show version and exit", false,
 		"v", "")
+	showVersionJSON := common.CmdEnvBool("With -version, print version and build metadata as JSON", false,
+		"json", "")
 	bindHost := common.CmdEnvString("Binding address, default 0.0.0.0.", "0.0.0.0",
 		"host", "")
 	listenPort := common.CmdEnvInt("Listening port, default 43210.", 43210,
@@ -73,17 +102,57 @@ func main() {
 		"log-verbosity", "")
 	srcCacheLimit := common.CmdEnvInt("Header and source cache limit, in bytes, default 4G.", 4*1024*1024*1024,
 		"src-cache-limit", "")
+	srcCacheCompress := common.CmdEnvBool("Store files in src cache gzipped: the limit fits several times more of text headers.\nBut a cache hit decompresses a file into a client dir instead of hard linking it, costing CPU and disk for a copy.", false,
+		"src-cache-compress", "")
 	objCacheLimit := common.CmdEnvInt("Compiled obj cache limit, in bytes, default 16G.", 16*1024*1024*1024,
 		"obj-cache-limit", "")
+	disableObjCacheLookup := common.CmdEnvBool("Don't look up obj cache on session start (and don't fill it), for workloads with near-zero hit rate.\nThe same as all clients had NOCC_SKIP_OBJ_CACHE_LOOKUP_DIRS=/.", false,
+		"disable-obj-cache-lookup", "")
+	cacheObjsWithWarnings := common.CmdEnvBool("Save objs to obj cache even if cxx printed warnings (still requiring exit code 0), default true.\nThe output is stored alongside and replayed on a cache hit (for the same client path of a .cpp). Set it to false to cache only objs compiled silently.", true,
+		"cache-objs-with-warnings", "")
+	objCachePerClient := common.CmdEnvBool("Namespace obj cache keys by a client (its NOCC_OBJ_CACHE_NAMESPACE, or clientID if not set), so that objs are never shared between clients.\nFor single-tenant setups valuing isolation over cache hits; it disables the cross-agent obj cache benefit.", false,
+		"obj-cache-per-client", "")
+	acceptObjCacheSeeds := common.CmdEnvBool("Store objs compiled by clients launched with NOCC_SEED_OBJ_CACHE to obj cache, default false.\nSuch objs can't be verified by a server: enable it only if all clients are trusted (e.g. restricted by -tls-client-ca).", false,
+		"accept-obj-cache-seeds", "")
+	cacheFsync := common.CmdEnvBool("Fsync files and dirs before they are committed to src cache and obj cache, for durability on a crash or a power loss.\nIt trades some throughput for durability, useless if cache dirs are on tmpfs.", false,
+		"cache-fsync", "")
 	statsdHostPort := common.CmdEnvString("Statsd udp address (host:port), omitted by default.\nIf omitted, stats won't be written.", "",
 		"statsd", "")
 	maxParallelCxx := common.CmdEnvInt("Max amount of C++ compiler processes launched in parallel, other ready sessions are waiting in a queue.\nBy default, it's a number of CPUs on the current machine.", int64(runtime.NumCPU()),
 		"max-parallel-cxx", "")
+	maxCxxDuration := common.CmdEnvInt("Max duration of one C++ compiler process, in seconds, default 600 (0 means no limit).\nAfter it, cxx is killed, and a client gets an error: it protects from pathological inputs holding cxx slots.", 600,
+		"max-cxx-duration", "")
+	compilerMapStr := common.CmdEnvString("Compilers to launch instead of ones sent by clients, comma-separated: \"g++=/opt/gcc-12/bin/g++,gcc=/opt/gcc-12/bin/gcc\".\nA client name is matched exactly or by basename; unmapped names are launched as is.", "",
+		"compiler-map", "")
+	maxSessionDeps := common.CmdEnvInt("Max amount of dependencies (.cpp/.h/etc.) in one compilation session, default 50000 (0 means no limit).\nSessions with more are rejected, and a client compiles such a file locally.", 50000,
+		"max-session-deps", "")
+	chunkSize := common.CmdEnvInt("Objs are sent to clients by chunks of this size, in bytes, default 64K.\nShould be less than clients' NOCC_GRPC_MAX_MSG_SIZE.", 64*1024,
+		"chunk-size", "")
+	grpcWindowSize := common.CmdEnvInt("Initial grpc window for a stream and a connection, in bytes.\nBy default (0), a window grows dynamically; a fixed one is good for fast links with a high latency.", 0,
+		"grpc-window-size", "")
+	busyQueueSize := common.CmdEnvInt("If more sessions than this are waiting for cxx (see -max-parallel-cxx), hint clients that a server is busy, default 0 (never).\nIt's advisory: sessions are accepted anyway, clients may compile next files locally while a hint lasts.", 0,
+		"busy-queue-size", "")
+	keepClientDirs := common.CmdEnvInt("Keep working dirs of disconnected clients for this time, in seconds, default 0 (removed immediately).\nFor post-mortem of a failed remote compilation: uploaded files stay in /tmp/nocc/cpp/clients/{clientID}.old.{time}.", 0,
+		"keep-client-dirs", "")
+	grpcKeepalive := common.CmdEnvInt("Ping clients after this idle time, in seconds, default 60 (0 means no pings).\nIt keeps idle streams alive through NATs and load balancers, and drops connections of dead clients.", 60,
+		"grpc-keepalive", "")
+	grpcMaxMsgSize := common.CmdEnvInt("Max size of a grpc message received from clients, in bytes, default 4M.\nShould be more than clients' NOCC_CHUNK_SIZE.", 0,
+		"grpc-max-msg-size", "")
+	tlsCert := common.CmdEnvString("A server certificate (PEM) to accept TLS connections with, along with -tls-key.\nIf set, plaintext connections are not accepted; clients should set NOCC_TLS_CA (or trust it via system roots).", "",
+		"tls-cert", "")
+	tlsKey := common.CmdEnvString("A private key (PEM) of -tls-cert.", "",
+		"tls-key", "")
+	tlsClientCA := common.CmdEnvString("Require client certificates signed by CA certs (PEM) in this file (mutual TLS), requires -tls-cert.\nClients without a valid one (see NOCC_TLS_CLIENT_CERT) are rejected during a TLS handshake, before any rpc.", "",
+		"tls-client-ca", "")
 
 	common.ParseCmdFlagsCombiningWithEnv()
 
 	if *showVersionAndExit || *showVersionAndExitShort {
-		fmt.Println(common.GetVersion())
+		if *showVersionJSON {
+			fmt.Println(common.GetVersionJSON())
+		} else {
+			fmt.Println(common.GetVersion())
+		}
 		os.Exit(0)
 	}
 
@@ -91,8 +160,27 @@ func main() {
 		failedStart("Can't init logger", err)
 	}
 
+	if *chunkSize <= 0 {
+		failedStart("Invalid -chunk-size", fmt.Errorf("%d, should be positive", *chunkSize))
+	}
+	// grpc silently ignores a window less than 64K
+	if *grpcWindowSize != 0 && (*grpcWindowSize < 64*1024 || *grpcWindowSize > math.MaxInt32) {
+		failedStart("Invalid -grpc-window-size", fmt.Errorf("%d, should be 0 (dynamic) or from 64K to 2G", *grpcWindowSize))
+	}
+	if *grpcMaxMsgSize < 0 {
+		failedStart("Invalid -grpc-max-msg-size", fmt.Errorf("%d, should be 0 (a grpc default) or positive", *grpcMaxMsgSize))
+	}
+
 	s := &server.NoccServer{
 		StartTime: time.Now(),
+		ChunkSize: int(*chunkSize),
+
+		DisableObjCacheLookup: *disableObjCacheLookup,
+		CacheObjsWithWarnings: *cacheObjsWithWarnings,
+		ObjCachePerClient:     *objCachePerClient,
+		AcceptObjCacheSeeds:   *acceptObjCacheSeeds,
+		MaxSessionDeps:        int(*maxSessionDeps),
+		BusyQueueSize:         *busyQueueSize,
 	}
 
 	s.Stats, err = server.MakeStatsd(*statsdHostPort)
@@ -100,12 +188,16 @@ func main() {
 		failedStart("Failed to connect to statsd", err)
 	}
 
-	s.ActiveClients, err = server.MakeClientsStorage(prepareEmptyDir(cppStoreDir, "clients"))
+	s.ActiveClients, err = server.MakeClientsStorage(prepareEmptyDir(cppStoreDir, "clients"), time.Duration(*keepClientDirs)*time.Second)
 	if err != nil {
 		failedStart("Failed to init clients hashtable", err)
 	}
 
-	s.CxxLauncher, err = server.MakeCxxLauncher(*maxParallelCxx)
+	compilerMap, err := server.ParseCompilerMap(*compilerMapStr)
+	if err != nil {
+		failedStart("Failed to parse -compiler-map", err)
+	}
+	s.CxxLauncher, err = server.MakeCxxLauncher(*maxParallelCxx, time.Duration(*maxCxxDuration)*time.Second, compilerMap)
 	if err != nil {
 		failedStart("Failed to init cxx launcher", err)
 	}
@@ -115,12 +207,12 @@ func main() {
 		failedStart("Failed to init system headers hashtable", err)
 	}
 
-	s.SrcFileCache, err = server.MakeSrcFileCache(prepareEmptyDir(cppStoreDir, "src-cache"), *srcCacheLimit)
+	s.SrcFileCache, err = server.MakeSrcFileCache(prepareEmptyDir(cppStoreDir, "src-cache"), *srcCacheLimit, *cacheFsync, *srcCacheCompress)
 	if err != nil {
 		failedStart("Failed to init src file cache", err)
 	}
 
-	s.ObjFileCache, err = server.MakeObjFileCache(prepareEmptyDir(objStoreDir, "obj-cache"), prepareEmptyDir(objStoreDir, "cxx-out"), *objCacheLimit)
+	s.ObjFileCache, err = server.MakeObjFileCache(prepareEmptyDir(objStoreDir, "obj-cache"), prepareEmptyDir(objStoreDir, "cxx-out"), *objCacheLimit, *cacheFsync)
 	if err != nil {
 		failedStart("Failed to init obj file cache", err)
 	}
@@ -130,7 +222,39 @@ func main() {
 		failedStart("Failed to init pch compilation", err)
 	}
 
-	s.GRPCServer = grpc.NewServer()
+	// caches are restored by hard links, catch misconfigured mounts (e.g., tmpfs) at startup, not on every restore
+	for _, dirs := range [][2]string{{*cppStoreDir + "/src-cache", *cppStoreDir + "/clients"}, {*objStoreDir + "/obj-cache", *objStoreDir + "/cxx-out"}} {
+		if err := server.CheckHardLinks(dirs[0], dirs[1]); err != nil {
+			failedStart("Failed to check hard links", err)
+		}
+	}
+
+	var grpcOptions []grpc.ServerOption
+	if *grpcWindowSize > 0 {
+		grpcOptions = append(grpcOptions, grpc.InitialWindowSize(int32(*grpcWindowSize)), grpc.InitialConnWindowSize(int32(*grpcWindowSize)))
+	}
+	if *grpcMaxMsgSize > 0 {
+		grpcOptions = append(grpcOptions, grpc.MaxRecvMsgSize(int(*grpcMaxMsgSize)))
+	}
+	if *grpcKeepalive > 0 {
+		grpcOptions = append(grpcOptions, grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    time.Duration(*grpcKeepalive) * time.Second,
+			Timeout: 20 * time.Second,
+		}))
+	}
+	// clients may ping idle connections too (see NOCC_GRPC_KEEPALIVE), grpc's default policy closes them for pinging more often than every 5 minutes
+	grpcOptions = append(grpcOptions, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             5 * time.Second,
+		PermitWithoutStream: true,
+	}))
+	if *tlsCert != "" || *tlsKey != "" || *tlsClientCA != "" {
+		tlsConfig, err := makeTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			failedStart("Failed to init TLS", err)
+		}
+		grpcOptions = append(grpcOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s.GRPCServer = grpc.NewServer(grpcOptions...)
 	pb.RegisterCompilationServiceServer(s.GRPCServer, s)
 
 	s.Cron, err = server.MakeCron(s)
@@ -17,12 +17,14 @@
 #include <sys/file.h>
 #include <sys/socket.h>
 #include <sys/un.h>
+#include <sys/wait.h>
 #include <unistd.h>
 #include <stdlib.h>
 #include <stdio.h>
 #include <string.h>
 #include <errno.h>
 #include <time.h>
+#include <limits.h>
 
 const char *LOCKFILE = "/tmp/nocc.lock";    // an inter-process lockfile to launch a daemon only once
 const char *UNIX_SOCK = "/tmp/nocc.sock";   // hardcoded in a daemon also
@@ -34,10 +36,14 @@ char **ARGV;
 const int BUF_PIPE_LEN = 32768;
 char BUF_PIPE[BUF_PIPE_LEN]; // a single buffer for in/out communication with nocc-daemon
 
+char *STDIN_BUF = nullptr;   // stdin contents if a source is read from stdin ("-" input), see read_stdin_if_it_is_input()
+size_t STDIN_LEN = 0;
+
 struct GoDaemonResponse {
   int ExitCode{0};
   char *Stdout{nullptr};
   char *Stderr{nullptr};
+  const char *Outcome{""}; // "remote {host}" / "cache {host}" / "local" / ..., empty if not sent, see daemon-sock.go
 };
 
 char *format_time_to_log() {
@@ -82,6 +88,38 @@ void append_error_to_stderr(const char *errToPrint, int errnum) {
   }
 }
 
+// if stdin was already captured by read_stdin_if_it_is_input(), it's at EOF for cxx launched locally,
+// so cxx gets captured contents via a pipe, written by a detached process (cxx may read stdin while writing output)
+void pipe_captured_stdin_to_cxx() {
+  int fds[2];
+  if (pipe(fds) != 0) {
+    return;
+  }
+  pid_t pid = fork();
+  if (pid == 0) {
+    close(fds[0]);
+    if (fork() == 0) { // a grandchild is adopted by init, not to be left a zombie of cxx
+      const char *buf = STDIN_BUF;
+      size_t len = STDIN_LEN;
+      while (len > 0) {
+        ssize_t n_written = write(fds[1], buf, len);
+        if (n_written <= 0 && errno != EINTR) {
+          break;
+        }
+        buf += n_written > 0 ? n_written : 0;
+        len -= n_written > 0 ? n_written : 0;
+      }
+    }
+    _exit(0);
+  }
+  close(fds[1]);
+  if (pid > 0) {
+    waitpid(pid, nullptr, 0);
+    dup2(fds[0], STDIN_FILENO);
+  }
+  close(fds[0]);
+}
+
 // execute_cxx_locally() replaces current process (nocc.cpp) with a cxx process
 // it's called when a daemon is unavailable or for linking
 // (for linking, nocc.cpp doesn't send a command to a daemon, as an optimization)
@@ -92,6 +130,9 @@ void __attribute__((noreturn)) execute_cxx_locally(const char *errToPrint, int e
     append_error_to_log_file(errToPrint, errnum);
     append_error_to_stderr(errToPrint, errnum);
   }
+  if (STDIN_BUF != nullptr) {
+    pipe_captured_stdin_to_cxx();
+  }
   execvp(ARGV[1], ARGV + 1);
   printf("could not run %s, exit(1)\n", ARGV[1]);
   exit(1);
@@ -174,6 +215,109 @@ int connect_to_go_daemon_or_start_a_new_one() {
   return -1;
 }
 
+// options followed by a separate value, like `-o {file}` or `-x {lang}`: the value is never an input, even if it's "-"
+// the list mirrors a daemon's parser (see ParseCmdLineInvocation), so that both agree on what is stdin input
+bool is_option_with_separate_value(const char *arg) {
+  static const char *options[] = {
+    "-o", "-x", "-I", "-iquote", "-idirafter", "-isystem-after", "-isystem", "-include-pch", "-include", "-isysroot",
+    "-L", "-B", "-working-directory", "-target", "-arch", "-MF", "-MT", "-MQ",
+  };
+  for (const char *option : options) {
+    if (!strcmp(arg, option)) {
+      return true;
+    }
+  }
+  return false;
+}
+
+// `g++ -x c++ -c - -o 1.o` reads a source from stdin, but a daemon can't read stdin of this process
+// that's why it's captured here and sent to a daemon before a command line
+// (a value of an option, e.g. `-MF -`, is not an input, it's skipped like a daemon does)
+void read_stdin_if_it_is_input() {
+  bool is_stdin_input = false;
+  for (int i = 2; i < ARGC; ++i) {
+    if (!strcmp(ARGV[i], "-")) {
+      is_stdin_input = true;
+    } else if (!strcmp(ARGV[i], "-Xclang") && i + 1 < ARGC) {
+      const char *x_arg = ARGV[i + 1];
+      if (strcmp(x_arg, "-I") && strcmp(x_arg, "-iquote") && strcmp(x_arg, "-isystem") && strcmp(x_arg, "-include")) {
+        i++; // "-Xclang {xArg}", but "-Xclang -include -Xclang {file}" is parsed as -include
+      }
+    } else if (is_option_with_separate_value(ARGV[i]) && i + 1 < ARGC) {
+      i++;
+      if (!strcmp(ARGV[i], "-Xclang") && i + 1 < ARGC) {
+        i++;
+      }
+    }
+  }
+  if (!is_stdin_input) {
+    return;
+  }
+
+  size_t capacity = 65536;
+  STDIN_BUF = static_cast<char *>(malloc(capacity));
+  while (true) {
+    if (STDIN_LEN == capacity) {
+      capacity *= 2;
+      STDIN_BUF = static_cast<char *>(realloc(STDIN_BUF, capacity));
+    }
+    ssize_t n_read = read(STDIN_FILENO, STDIN_BUF + STDIN_LEN, capacity - STDIN_LEN);
+    if (n_read == 0) {
+      break;
+    }
+    if (n_read < 0 && errno != EINTR) {
+      execute_cxx_locally("could not read stdin", errno);
+    }
+    STDIN_LEN += n_read > 0 ? n_read : 0;
+  }
+}
+
+bool send_all(int sockfd, const char *buf, size_t len) {
+  while (len > 0) {
+    ssize_t n_sent = send(sockfd, buf, len, 0);
+    if (n_sent <= 0) {
+      return false;
+    }
+    buf += n_sent;
+    len -= n_sent;
+  }
+  return true;
+}
+
+// `NOCC_RECACHE=1 nocc g++ ...` compiles ignoring obj cache and replaces a cached obj (a targeted cache repair)
+bool is_recache_requested() {
+  const char *recache = getenv("NOCC_RECACHE");
+  return recache != nullptr && recache[0] != '\0' && strcmp(recache, "0") != 0;
+}
+
+// `NOCC_PRINT_OUTCOME=1 nocc g++ ...` prints whether a file was compiled remotely, taken from obj cache or compiled locally
+// (to stderr, after cxx output), so that build logs can be grepped for an offload ratio
+bool is_outcome_print_requested() {
+  const char *print_outcome = getenv("NOCC_PRINT_OUTCOME");
+  return print_outcome != nullptr && print_outcome[0] != '\0' && strcmp(print_outcome, "0") != 0;
+}
+
+// message format:
+// "RECACHE\0", prior to stdin and a command-line
+// see daemon-sock.go, onRequest()
+void write_recache_marker_to_go_daemon(int sockfd) {
+  if (!send_all(sockfd, "RECACHE", sizeof("RECACHE"))) {
+    execute_cxx_locally("could not write to daemon socket", errno);
+  }
+}
+
+// pipe stdin captured by read_stdin_if_it_is_input() to a daemon, prior to a command-line
+// message format:
+// "STDIN\b{StdinLen}\0{Stdin}"
+// see daemon-sock.go, onRequest()
+void write_stdin_to_go_daemon(int sockfd) {
+  char header[64];
+  int header_len = snprintf(header, sizeof(header), "STDIN\b%zu", STDIN_LEN);
+  if (!send_all(sockfd, header, header_len + 1) || !send_all(sockfd, STDIN_BUF, STDIN_LEN)) {
+    execute_cxx_locally("could not write stdin to daemon socket", errno);
+  }
+}
+
 // pipe current command-line invocation to a daemon via unix socket
 // request message format:
 // "{Cwd} {CmdLine...}\0"
@@ -205,7 +349,8 @@ void write_request_to_go_daemon(int sockfd) {
 // read a response from a daemon
 // reading will block until a daemon responses: only then it writes back to socket
 // response message format:
-// "{ExitCode}\0{Stdout}\0{Stderr}\0"
+// "{ExitCode}\0{Stdout}\0{Stderr}\0{Outcome}\0"
+// {Outcome} is optional (older daemons don't send it)
 // if remote compilation fails, it falls back to local compilation within a daemon,
 // so a daemon always responds in such a format
 // see daemon-sock.go, onRequest()
@@ -227,6 +372,10 @@ GoDaemonResponse read_response_from_go_daemon(int sockfd) {
   }
   output.Stdout = end + 1;
   output.Stderr = output.Stdout + strlen(output.Stdout) + 1;
+  char *outcome = output.Stderr + strlen(output.Stderr) + 1;
+  if (outcome < BUF_PIPE + len) {
+    output.Outcome = outcome;
+  }
   return output;
 }
 
@@ -261,6 +410,87 @@ bool is_called_for_linking() {
   return in_o_count > 1;
 }
 
+bool has_extension(const char *arg, const char *ext) {
+  size_t l = strlen(arg), l_ext = strlen(ext);
+  return l > l_ext && !strcmp(arg + l - l_ext, ext);
+}
+
+bool is_c_source_file(const char *arg) {
+  return has_extension(arg, ".c");
+}
+
+bool is_cxx_source_file(const char *arg) {
+  return has_extension(arg, ".cpp") || has_extension(arg, ".cc") || has_extension(arg, ".cxx") ||
+         has_extension(arg, ".c++") || has_extension(arg, ".C");
+}
+
+// checks whether a compiler (a name looked up in $PATH or a path) exists and is executable
+bool is_executable_compiler(const char *cxx_name) {
+  if (strchr(cxx_name, '/')) {
+    return access(cxx_name, X_OK) == 0;
+  }
+
+  const char *path_env = getenv("PATH");
+  if (path_env == nullptr) {
+    return false;
+  }
+  char full_path[PATH_MAX];
+  for (const char *dir = path_env; *dir;) {
+    const char *dir_end = strchrnul(dir, ':');
+    int dir_len = static_cast<int>(dir_end - dir);
+    snprintf(full_path, PATH_MAX, "%.*s/%s", dir_len, dir_len ? dir : ".", cxx_name);
+    if (access(full_path, X_OK) == 0) {
+      return true;
+    }
+    dir = *dir_end ? dir_end + 1 : dir_end;
+  }
+  return false;
+}
+
+// a "bare" invocation is when the compiler is omitted: `nocc -c 1.cpp -o 1.o` instead of `nocc g++ -c 1.cpp -o 1.o`
+// then the compiler is taken from env: NOCC_CXX if set, otherwise CXX/CC (CC is preferred for .c files)
+// ARGV is modified, so that further code works as if the compiler was passed explicitly
+void insert_compiler_from_env_if_bare_invocation() {
+  bool has_c_source = false, has_cxx_source = false;
+  for (int i = 1; i < ARGC; ++i) {
+    has_c_source |= is_c_source_file(ARGV[i]);
+    has_cxx_source |= is_cxx_source_file(ARGV[i]);
+  }
+  bool is_bare = (has_c_source || has_cxx_source) &&
+                 (ARGV[1][0] == '-' || is_c_source_file(ARGV[1]) || is_cxx_source_file(ARGV[1]));
+  if (!is_bare) {
+    return;
+  }
+
+  const char *env_name = "NOCC_CXX";
+  const char *cxx_name = getenv(env_name);
+  const char *env_order[2] = {"CXX", "CC"};
+  if (has_c_source && !has_cxx_source) {
+    env_order[0] = "CC", env_order[1] = "CXX";
+  }
+  for (int i = 0; i < 2 && (cxx_name == nullptr || !*cxx_name); ++i) {
+    env_name = env_order[i];
+    cxx_name = getenv(env_name);
+  }
+  if (cxx_name == nullptr || !*cxx_name) {
+    fprintf(stderr, "[nocc] a compiler is not specified in the command line; set NOCC_CXX (or CXX/CC) env variable or call `nocc g++ ...`\n");
+    exit(1);
+  }
+  if (!is_executable_compiler(cxx_name)) {
+    fprintf(stderr, "[nocc] compiler '%s' taken from env %s is not found or is not executable\n", cxx_name, env_name);
+    exit(1);
+  }
+
+  char **argv_with_cxx = new char *[ARGC + 2];
+  argv_with_cxx[0] = ARGV[0];
+  argv_with_cxx[1] = const_cast<char *>(cxx_name);
+  for (int i = 1; i <= ARGC; ++i) {
+    argv_with_cxx[i + 1] = ARGV[i];
+  }
+  ARGV = argv_with_cxx;
+  ARGC++;
+}
+
 
 int main(int argc, char *argv[]) {
   ARGC = argc;
@@ -276,6 +506,9 @@ int main(int argc, char *argv[]) {
     int sockfd = connect_to_go_daemon_or_start_a_new_one();
     exit(sockfd == -1 ? 1 : 0);
   }
+  if (ARGC >= 2) {
+    insert_compiler_from_env_if_bare_invocation();
+  }
   if (ARGC < 3 || ARGV[1] && ARGV[1][0] == '-') {
     execute_go_nocc_instead_of_cpp();
   }
@@ -284,16 +517,26 @@ int main(int argc, char *argv[]) {
     execute_cxx_locally(nullptr);
   }
 
+  read_stdin_if_it_is_input();
   int sockfd = connect_to_go_daemon_or_start_a_new_one();
   if (sockfd == -1) {
     execute_cxx_locally("could not connect to daemon after starting");
   }
+  if (is_recache_requested()) {
+    write_recache_marker_to_go_daemon(sockfd);
+  }
+  if (STDIN_BUF != nullptr) {
+    write_stdin_to_go_daemon(sockfd);
+  }
   write_request_to_go_daemon(sockfd);
 
   GoDaemonResponse response = read_response_from_go_daemon(sockfd);
 
   fwrite(response.Stdout, strlen(response.Stdout), 1, stdout);
   fwrite(response.Stderr, strlen(response.Stderr), 1, stderr);
+  if (response.Outcome[0] != '\0' && is_outcome_print_requested()) {
+    fprintf(stderr, "[nocc] outcome: %s\n", response.Outcome);
+  }
   return response.ExitCode;
 }
 
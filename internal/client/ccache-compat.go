@@ -0,0 +1,43 @@
+package client
+
+import (
+	"os"
+	"strings"
+)
+
+// ApplyCcacheCompatEnv is NOCC_CCACHE_COMPAT: scripts migrating from ccache often set CCACHE_* env vars,
+// and recognized ones are mapped to nocc equivalents on daemon start (each mapping is logged):
+// * CCACHE_DISABLE → NOCC_DISABLE_OBJ_CACHE (compile always, don't store objs)
+// * CCACHE_RECACHE → NOCC_RECACHE_OBJS (compile always, replacing objs in obj cache)
+// Others (CCACHE_DIR, CCACHE_MAXSIZE, etc.) have no meaning for nocc and are ignored.
+// A nocc setting enabled explicitly is never turned off by a mapping.
+func ApplyCcacheCompatEnv(disableObjCache *bool, recacheObjs *bool) {
+	mappings := []struct {
+		ccacheEnv string
+		noccEnv   string
+		setting   *bool
+	}{
+		{"CCACHE_DISABLE", "NOCC_DISABLE_OBJ_CACHE", disableObjCache},
+		{"CCACHE_RECACHE", "NOCC_RECACHE_OBJS", recacheObjs},
+	}
+
+	for _, m := range mappings {
+		if isCcacheEnvTrue(m.ccacheEnv) && !*m.setting {
+			*m.setting = true
+			logClient.Info(0, "ccache compat:", m.ccacheEnv, "is set, acting as", m.noccEnv)
+		}
+	}
+}
+
+// isCcacheEnvTrue interprets a boolean like ccache does: set means true, unless it's "0", "false", "disable" or "no".
+func isCcacheEnvTrue(envName string) bool {
+	value, isSet := os.LookupEnv(envName)
+	if !isSet {
+		return false
+	}
+	switch strings.ToLower(value) {
+	case "0", "false", "disable", "no":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// CompilationDatabase is NOCC_COMPDB: a daemon sees every invocation, so it collects compile_commands.json
+// for the whole build as a side effect (unlike -MJ, which writes a fragment per TU).
+// Entries are added as compilations finish and written in batches, periodically while changed and on daemon quit,
+// so that a file is usable (by an IDE, for example) during a long build; since a daemon quits when a build is idle
+// and is started again by the next nocc invocation, entries from an existing file are loaded on start.
+// A source recompiled to the same output (with changed flags, for example) replaces its previous entry.
+type CompilationDatabase struct {
+	fileName string
+	saveMu   sync.Mutex // saves are serialized, so that an older snapshot never replaces a newer one
+
+	mu      sync.Mutex
+	entries []compDBEntry
+	index   map[string]int // an absolute output file -> index in entries
+	changed bool           // entries were added after the last Save()
+}
+
+// compDBEntry is a format of compile_commands.json, see https://clang.llvm.org/docs/JSONCompilationDatabase.html
+type compDBEntry struct {
+	Directory string   `json:"directory"`
+	Arguments []string `json:"arguments"`
+	File      string   `json:"file"`
+	Output    string   `json:"output"`
+}
+
+func MakeCompilationDatabase(fileName string) (*CompilationDatabase, error) {
+	compDB := &CompilationDatabase{
+		fileName: fileName,
+		entries:  make([]compDBEntry, 0, 1024),
+		index:    make(map[string]int, 1024),
+	}
+
+	var entries []compDBEntry
+	if ok, err := loadStateFile(fileName, &entries); err != nil {
+		return nil, err
+	} else if ok {
+		for _, entry := range entries {
+			compDB.add(entry)
+		}
+	}
+	return compDB, nil
+}
+
+// Add records a compiled source; it's called concurrently from invocations.
+func (compDB *CompilationDatabase) Add(cwd string, cmdLine []string, cppInFile string, objOutFile string) {
+	compDB.mu.Lock()
+	compDB.add(compDBEntry{
+		Directory: cwd,
+		Arguments: cmdLine,
+		File:      cppInFile,
+		Output:    objOutFile,
+	})
+	compDB.changed = true
+	compDB.mu.Unlock()
+}
+
+func (compDB *CompilationDatabase) add(entry compDBEntry) {
+	outputAbs := entry.Output
+	if outputAbs != "" && entry.Directory != "" {
+		outputAbs = pathAbs(entry.Directory, entry.Output)
+	}
+	if idx, exists := compDB.index[outputAbs]; exists {
+		compDB.entries[idx] = entry
+		return
+	}
+	compDB.index[outputAbs] = len(compDB.entries)
+	compDB.entries = append(compDB.entries, entry)
+}
+
+// Save writes all entries as a JSON array; a file is replaced atomically, so it's never seen partially written.
+func (compDB *CompilationDatabase) Save() error {
+	compDB.saveMu.Lock()
+	defer compDB.saveMu.Unlock()
+
+	compDB.mu.Lock()
+	contents, err := json.MarshalIndent(compDB.entries, "", "  ")
+	compDB.changed = false
+	compDB.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = saveStateFile(compDB.fileName, append(contents, '\n')); err != nil {
+		compDB.mu.Lock()
+		compDB.changed = true
+		compDB.mu.Unlock()
+	}
+	return err
+}
+
+// SaveIfChanged is called periodically by a daemon, writing entries added since the last time.
+func (compDB *CompilationDatabase) SaveIfChanged() error {
+	compDB.mu.Lock()
+	changed := compDB.changed
+	compDB.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return compDB.Save()
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"time"
 )
 
 // LocalCxxLaunch describes an invocation when it's executed locally, not remotely.
@@ -17,6 +18,7 @@ import (
 type LocalCxxLaunch struct {
 	cmdLine []string
 	cwd     string
+	stdin   []byte // captured by the `nocc` wrapper if cmdLine reads from stdin ("-" input)
 }
 
 func (localCxx *LocalCxxLaunch) RunCxxLocally() (exitCode int, stdout []byte, stderr []byte) {
@@ -24,6 +26,9 @@ func (localCxx *LocalCxxLaunch) RunCxxLocally() (exitCode int, stdout []byte, st
 
 	cxxCommand := exec.Command(localCxx.cmdLine[0], localCxx.cmdLine[1:]...)
 	cxxCommand.Dir = localCxx.cwd
+	if localCxx.stdin != nil {
+		cxxCommand.Stdin = bytes.NewReader(localCxx.stdin)
+	}
 	var cxxStdout, cxxStderr bytes.Buffer
 	cxxCommand.Stdout = &cxxStdout
 	cxxCommand.Stderr = &cxxStderr
@@ -40,8 +45,16 @@ func (localCxx *LocalCxxLaunch) RunCxxLocally() (exitCode int, stdout []byte, st
 
 // EmulateDaemonInsideThisProcessForDev is for dev purposes:
 // for development, I use `nocc-daemon g++ ...` from GoLand directly (without a C++ `nocc` wrapper).
-func EmulateDaemonInsideThisProcessForDev(remoteNoccHosts []string, cmdLine []string, disableOwnIncludes bool, localCxxQueueSize int) (exitCode int, stdout []byte, stderr []byte) {
-	daemon, err := MakeDaemon(remoteNoccHosts, false, disableOwnIncludes, int64(localCxxQueueSize))
+func EmulateDaemonInsideThisProcessForDev(remoteNoccHosts []string, cmdLine []string, disableOwnIncludes bool, disableOwnPch bool, compressOwnPch bool, cacheableIncludeDirs []string, localCxxQueueSize int) (exitCode int, stdout []byte, stderr []byte) {
+	daemon, err := MakeDaemon(DaemonOptions{
+		RemoteNoccHosts:      remoteNoccHosts,
+		ConnectTimeout:       5 * time.Second,
+		DisableOwnIncludes:   disableOwnIncludes,
+		DisableOwnPch:        disableOwnPch,
+		CompressOwnPch:       compressOwnPch,
+		CacheableIncludeDirs: cacheableIncludeDirs,
+		MaxLocalCxxProcesses: int64(localCxxQueueSize),
+	})
 	if err != nil {
 		panic(err)
 	}
@@ -49,7 +62,7 @@ func EmulateDaemonInsideThisProcessForDev(remoteNoccHosts []string, cmdLine []st
 	go daemon.PeriodicallyInterruptHangedInvocations()
 
 	cwd, _ := os.Getwd()
-	request := DaemonSockRequest{cwd, cmdLine}
+	request := DaemonSockRequest{Cwd: cwd, CmdLine: cmdLine}
 	response := daemon.HandleInvocation(request)
 
 	return response.ExitCode, response.Stdout, response.Stderr
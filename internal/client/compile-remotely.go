@@ -1,8 +1,11 @@
 package client
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"strings"
+	"sync"
 
 	"github.com/VKCOM/nocc/pb"
 )
@@ -14,7 +17,42 @@ func CompileCppRemotely(daemon *Daemon, cwd string, invocation *Invocation, remo
 	invocation.wgRecv.Add(1)
 
 	// 1. For an input .cpp file, find all dependent .h/.nocc-pch/etc. that are required for compilation
-	hFiles, cppFile, err := invocation.CollectDependentIncludes(cwd, daemon.disableOwnIncludes)
+	// (experimentally, they can be collected by the remote; if it fails, they are collected on a client as usual)
+	var hFiles []*IncludedFile
+	var cppFile IncludedFile
+	if daemon.includesOnServer && !invocation.cppInPreprocessed {
+		if hFiles, cppFile, err = remote.CollectDependentIncludesOnServer(invocation, cwd); err != nil {
+			logClient.Info(0, "can't collect includes on remote", remote.remoteHost, "fallback to own includes parser:", err)
+		} else {
+			invocation.summary.AddTiming("collected_includes_on_server")
+		}
+	}
+	if !daemon.includesOnServer || err != nil {
+		hFiles, cppFile, err = invocation.CollectDependentIncludes(cwd, daemon.disableOwnIncludes, daemon.disableOwnPch)
+	}
+	depHFiles := hFiles
+	// if own includes parser can't find all dependencies, preprocess locally, sending all in one file
+	var gaveUp *errOwnIncludesGaveUp
+	if errors.As(err, &gaveUp) && isDirectivesOnlySupported(invocation.cxxName) {
+		logClient.Info(0, "preprocess with -fdirectives-only locally:", gaveUp)
+		var tmpDir string
+		if tmpDir, err = os.MkdirTemp("", "nocc-directives-only-"); err == nil {
+			defer os.RemoveAll(tmpDir)
+			hFiles = nil
+			cppFile, depHFiles, err = invocation.preprocessDirectivesOnly(cwd, tmpDir)
+			invocation.summary.AddTiming("preprocessed_directives_only")
+		}
+	} else if errors.As(err, &gaveUp) && daemon.rewriteIncludes && isRewriteIncludesSupported(invocation.cxxName) {
+		logClient.Info(0, "preprocess with -frewrite-includes locally:", gaveUp)
+		var tmpDir string
+		if tmpDir, err = os.MkdirTemp("", "nocc-rewrite-includes-"); err == nil {
+			defer os.RemoveAll(tmpDir)
+			hFiles = nil
+			cppFile, depHFiles, err = invocation.preprocessRewriteIncludes(cwd, tmpDir)
+			invocation.summary.AddTiming("preprocessed_rewrite_includes")
+			logClient.Info(1, "rewritten", invocation.cppInFile, "to a single file of", cppFile.fileSize, "bytes, sessionID", invocation.sessionID)
+		}
+	}
 	if err != nil {
 		return 0, nil, nil, fmt.Errorf("failed to collect depencies: %v", err)
 	}
@@ -22,15 +60,27 @@ func CompileCppRemotely(daemon *Daemon, cwd string, invocation *Invocation, remo
 	invocation.summary.AddTiming("collected_includes")
 
 	// uncomment this to debug if "cxx -M" finds more #include dependencies than own parser
-	// cxxMFoundHFiles, _, _ := invocation.CollectDependentIncludes(true)
+	// cxxMFoundHFiles, _, _ := invocation.CollectDependentIncludes(cwd, true, daemon.disableOwnPch)
 	// CompareOwnIncludesParserAndCxxM(invocation.cppInFile, hFiles, cxxMFoundHFiles)
 
 	// if cxx is launched with -MD/-MF flags, it generates a .o.d file (a dependency file with include list)
 	// we do it on a client side (moreover, they are stripped off cxxArgs and not sent to the remote)
 	// note, that .o.d file is generated ALONG WITH .o (like "a side effect of compilation")
-	if invocation.depsFlags.ShouldGenerateDepFile() {
+	// it's awaited before returning, so that a build system never sees .o without .o.d
+	// (for a preprocessed input, cxx doesn't generate it, so do we)
+	if invocation.depsFlags.ShouldGenerateDepFile() && !invocation.cppInPreprocessed {
+		var wgDepFile sync.WaitGroup
+		defer func() {
+			wgDepFile.Wait()
+			if err == nil { // for -MF /dev/stdout, see GenerateAndSaveDepFile
+				stdout = append(invocation.depFileStdout, stdout...)
+				stderr = append(invocation.depFileStderr, stderr...)
+			}
+		}()
+		wgDepFile.Add(1)
 		go func() {
-			depFileName, err := invocation.depsFlags.GenerateAndSaveDepFile(invocation, hFiles)
+			defer wgDepFile.Done()
+			depFileName, err := invocation.depsFlags.GenerateAndSaveDepFile(invocation, depHFiles)
 			if err == nil {
 				logClient.Info(2, "saved depfile to", depFileName)
 			} else {
@@ -58,6 +108,9 @@ func CompileCppRemotely(daemon *Daemon, cwd string, invocation *Invocation, remo
 
 	// 3. Send all files needed to be uploaded.
 	// If all files were recently uploaded or exist in remote cache, this array would be empty.
+	if daemon.uploadsFile != nil && len(fileIndexesToUpload) != 0 {
+		daemon.uploadsFile.Append(makeUploadsTSVRecords(invocation, remote.remoteHost, requiredFiles, fileIndexesToUpload))
+	}
 	err = remote.UploadFilesToRemote(invocation, requiredFiles, fileIndexesToUpload)
 	if err != nil {
 		return 0, nil, nil, err
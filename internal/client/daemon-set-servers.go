@@ -0,0 +1,195 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// setServersRequestMarker is sent instead of {Cwd} to replace NOCC_SERVERS of a running daemon, see onSetServersRequest.
+const setServersRequestMarker = "SET-SERVERS"
+
+// SetRemoteServers replaces NOCC_SERVERS of a running daemon (`nocc -set-servers 'host1;host2'`).
+// Connections to servers remaining in a list are kept, new servers are connected in parallel (like in MakeDaemon).
+// Invocations in flight keep their current routing: connections to removed servers are closed only after
+// a force interrupt timeout, when all invocations started before are surely done.
+// NOCC_SERVERS_C, NOCC_SERVERS_CXX and NOCC_FORCE_SERVER are not affected.
+func (daemon *Daemon) SetRemoteServers(remoteNoccHosts []string) (nConnected int, err error) {
+	if len(remoteNoccHosts) == 0 {
+		return 0, fmt.Errorf("empty servers list")
+	}
+	for _, remoteHostPort := range remoteNoccHosts {
+		if _, port, err := net.SplitHostPort(remoteHostPort); err != nil {
+			return 0, fmt.Errorf("invalid server %q: %v", remoteHostPort, err)
+		} else if portNum, err := strconv.Atoi(port); err != nil || portNum <= 0 || portNum > 65535 {
+			return 0, fmt.Errorf("invalid server %q: bad port", remoteHostPort)
+		}
+	}
+	remoteNoccHosts = mergeUniqueRemoteHosts(remoteNoccHosts)
+
+	daemon.setServersMu.Lock()
+	defer daemon.setServersMu.Unlock()
+
+	daemon.remotesMu.RLock()
+	prevConnections := daemon.remoteConnections
+	otherPools := [][]*RemoteConnection{daemon.remotesForC, daemon.remotesForCxx}
+	if daemon.remoteForced != nil {
+		otherPools = append(otherPools, []*RemoteConnection{daemon.remoteForced})
+	}
+	daemon.remotesMu.RUnlock()
+
+	// the resulting list of all remotes: new NOCC_SERVERS followed by other pools, the same as in MakeDaemon
+	allNoccHosts := append([]string{}, remoteNoccHosts...)
+	for _, pool := range otherPools {
+		for _, remote := range pool {
+			allNoccHosts = append(allNoccHosts, remote.remoteHostPort)
+		}
+	}
+	allNoccHosts = mergeUniqueRemoteHosts(allNoccHosts)
+	daemon.remotesMu.Lock()
+	daemon.allRemotesDelim = joinRemoteHostsDelim(allNoccHosts) // before connecting, new remotes take it
+	daemon.remotesMu.Unlock()
+
+	remoteConnections := make([]*RemoteConnection, len(allNoccHosts))
+	wg := sync.WaitGroup{}
+	for index, remoteHostPort := range allNoccHosts {
+		if remote := findRemoteConnectionByHostPort(prevConnections, remoteHostPort); remote != nil {
+			remoteConnections[index] = remote
+			continue
+		}
+		wg.Add(1)
+		go func(index int, remoteHostPort string) {
+			remote, err := MakeRemoteConnection(daemon, remoteHostPort)
+			if err != nil {
+				remote.isUnavailable = true
+				logClient.Error("error connecting to", remoteHostPort, err)
+			}
+			remoteConnections[index] = remote
+			wg.Done()
+		}(index, remoteHostPort)
+	}
+	wg.Wait()
+
+	remotesDefault := make([]*RemoteConnection, 0, len(remoteNoccHosts))
+	for _, remoteHostPort := range remoteNoccHosts {
+		remote := findRemoteConnectionByHostPort(remoteConnections, remoteHostPort)
+		remotesDefault = append(remotesDefault, remote)
+		if !remote.isUnavailable {
+			nConnected++
+		}
+	}
+
+	if daemon.sortServers {
+		sortRemotesPoolForRouting(remotesDefault)
+	}
+
+	daemon.remotesMu.Lock()
+	daemon.remoteConnections = remoteConnections
+	daemon.remotesDefault = remotesDefault
+	daemon.remotesMu.Unlock()
+	if daemon.serversAffinity != nil {
+		daemon.serversAffinity.DropRemovedRemotes(remoteConnections)
+	}
+
+	for _, remote := range prevConnections {
+		if findRemoteConnectionByHostPort(remoteConnections, remote.remoteHostPort) == nil {
+			logClient.Info(0, "remote", remote.remoteHostPort, "was removed from servers list")
+			go daemon.closeRemovedRemoteConnection(remote)
+		}
+	}
+	logClient.Info(0, "servers list replaced:", strings.Join(remoteNoccHosts, ";"), "; connected", nConnected, "of", len(remoteNoccHosts))
+	return nConnected, nil
+}
+
+// closeRemovedRemoteConnection closes a connection to a server removed by SetRemoteServers after a force interrupt timeout.
+// If this server was added back meanwhile, a new connection was made with the same clientID:
+// StopClient isn't sent then, otherwise the server would forget a client that is actually in use.
+func (daemon *Daemon) closeRemovedRemoteConnection(remote *RemoteConnection) {
+	select {
+	case <-daemon.quitChan:
+		return
+	case <-time.After(daemon.interruptTimeout):
+	}
+	daemon.setServersMu.Lock() // not to interleave with SetRemoteServers re-adding this server right now
+	defer daemon.setServersMu.Unlock()
+	if findRemoteConnectionByHostPort(daemon.getRemoteConnections(), remote.remoteHostPort) == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		remote.SendStopClient(ctx)
+	} else {
+		logClient.Info(0, "remote", remote.remoteHostPort, "was added back to servers list, keep it registered")
+	}
+	remote.Clear()
+}
+
+func findRemoteConnectionByHostPort(remotes []*RemoteConnection, remoteHostPort string) *RemoteConnection {
+	for _, remote := range remotes {
+		if remote.remoteHostPort == remoteHostPort {
+			return remote
+		}
+	}
+	return nil
+}
+
+// onSetServersRequest handles "SET-SERVERS\b{host1;host2...}\0", see Daemon.SetRemoteServers.
+// A response is in the same format as for an invocation: "{ExitCode}\0{Stdout}\0{Stderr}\0{Outcome}\0".
+func (listener *DaemonUnixSockListener) onSetServersRequest(conn net.Conn, daemon *Daemon, header []string) {
+	if len(header) != 1 {
+		logClient.Error("couldn't parse set servers request", header)
+		listener.respondErr(conn)
+		return
+	}
+
+	var response DaemonSockResponse
+	remoteNoccHosts := parseRemoteHostsDelim(header[0])
+	if nConnected, err := daemon.SetRemoteServers(remoteNoccHosts); err != nil {
+		response.ExitCode = 1
+		response.Stderr = []byte(fmt.Sprintf("can't set servers: %v\n", err))
+	} else {
+		response.Stdout = []byte(fmt.Sprintf("connected %d of %d servers\n", nConnected, len(mergeUniqueRemoteHosts(remoteNoccHosts))))
+	}
+	listener.respondOk(conn, &response)
+}
+
+// parseRemoteHostsDelim splits a list of 'host:port' delimited by ';', like env NOCC_SERVERS.
+func parseRemoteHostsDelim(remoteHostsDelim string) []string {
+	remoteNoccHosts := make([]string, 0)
+	for _, remoteHostPort := range strings.Split(remoteHostsDelim, ";") {
+		if remoteHostPort = strings.TrimSpace(remoteHostPort); remoteHostPort != "" {
+			remoteNoccHosts = append(remoteNoccHosts, remoteHostPort)
+		}
+	}
+	return remoteNoccHosts
+}
+
+// RequestDaemonSetServers sends "SET-SERVERS" to a running daemon via a unix socket and returns its answer.
+// It's `nocc -set-servers 'host1;host2'`, for deployment tooling managing a dynamic fleet of servers.
+func RequestDaemonSetServers(daemonUnixSock string, remoteNoccHosts []string) (exitCode int, stdout []byte, stderr []byte, err error) {
+	conn, err := net.Dial("unix", daemonUnixSock)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("daemon is not running: %v", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(setServersRequestMarker + "\b" + strings.Join(remoteNoccHosts, ";") + "\000")); err != nil {
+		return 0, nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	parts := make([][]byte, 3)
+	for i := range parts {
+		if parts[i], err = reader.ReadBytes(0); err != nil {
+			return 0, nil, nil, fmt.Errorf("couldn't read a response from daemon: %v", err)
+		}
+		parts[i] = bytes.TrimSuffix(parts[i], []byte{0})
+	}
+	if exitCode, err = strconv.Atoi(string(parts[0])); err != nil {
+		return 0, nil, nil, fmt.Errorf("couldn't parse a response from daemon: %q", parts[0])
+	}
+	return exitCode, parts[1], parts[2], nil
+}
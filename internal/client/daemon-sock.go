@@ -6,6 +6,8 @@ import (
 	"io"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -20,15 +22,64 @@ type DaemonUnixSockListener struct {
 	netListener       net.Listener
 }
 
+// batchRequestMarker is sent instead of {Cwd} to start a batch request (a cwd is always absolute, it can't be equal).
+const batchRequestMarker = "BATCH"
+
+// batchOutcomeMarker is sent after {Jobs} in a batch header to receive {Outcome} for every command, see onBatchRequest.
+const batchOutcomeMarker = "OUTCOME"
+
+// recacheRequestMarker is sent before a request (and before stdinRequestMarker) by `NOCC_RECACHE=1 nocc ...`:
+// an obj is compiled always, replacing the one in obj cache, like NOCC_RECACHE_OBJS for a whole daemon.
+const recacheRequestMarker = "RECACHE"
+
+// stdinRequestMarker is sent instead of {Cwd} when a source is read from stdin, it's followed by stdin contents.
+const stdinRequestMarker = "STDIN"
+
+// limits for sizes read from a socket before allocating memory for them: a malformed request must be rejected,
+// not make a daemon allocate gigabytes (or panic) for all invocations it's serving
+const (
+	maxBatchCommands = 1 << 20  // commands in one batch request, far more than a project ever has
+	maxStdinBytes    = 64 << 20 // a source read from stdin, see readStdin
+)
+
 type DaemonSockRequest struct {
 	Cwd     string
 	CmdLine []string
+	Stdin   []byte // nil unless sent with stdinRequestMarker
+	Recache bool   // sent with recacheRequestMarker
+}
+
+// normalizeRequestCwd checks {Cwd} received from a socket, since all relative paths of an invocation are resolved against it.
+// It must be an absolute existing directory; symlinks are resolved, like the kernel does for relative paths after chdir.
+// `nocc` sends getcwd(), which is already canonical, so normally it's returned as is.
+func normalizeRequestCwd(cwd string) (string, error) {
+	if !filepath.IsAbs(cwd) {
+		return "", fmt.Errorf("cwd %q is not an absolute path", cwd)
+	}
+	resolved, err := filepath.EvalSymlinks(cwd) // also cleans it
+	if err != nil {
+		return "", fmt.Errorf("cwd %q doesn't exist", cwd)
+	}
+	if stat, err := os.Stat(resolved); err != nil || !stat.IsDir() {
+		return "", fmt.Errorf("cwd %q is not a directory", cwd)
+	}
+	return resolved, nil
 }
 
+// outcomes of an invocation, sent back to `nocc` for wrappers that want to know whether work was offloaded
+const (
+	OutcomeRemote   = "remote" // "remote {host}": compiled by a server
+	OutcomeObjCache = "cache"  // "cache {host}": taken from obj cache of a server
+	OutcomeLocal    = "local"  // compiled locally (a fallback, linking, etc.)
+
+	OutcomeUnchanged = "unchanged" // not compiled at all: an obj is up to date, see NOCC_SKIP_UNCHANGED
+)
+
 type DaemonSockResponse struct {
 	ExitCode int
 	Stdout   []byte
 	Stderr   []byte
+	Outcome  string // one of Outcome* (followed by a host for remote ones), empty if nothing was compiled
 }
 
 func MakeDaemonRpcListener() *DaemonUnixSockListener {
@@ -81,11 +132,18 @@ func (listener *DaemonUnixSockListener) EnterInfiniteLoopUntilQuit(daemon *Daemo
 // After the request has been fully processed (.o is written), we answer back, and `nocc` client dies.
 // Request message format:
 // "{Cwd} {CmdLine...}\0"
+// {Cwd} must be an absolute existing directory, otherwise an invocation fails, see normalizeRequestCwd.
+// If cmd line reads a source from stdin ("-" input), the request is preceded by stdin captured by `nocc`:
+// "STDIN\b{StdinLen}\0{Stdin}{Cwd} {CmdLine...}\0"
 // Response message format:
-// "{ExitCode}\0{Stdout}\0{Stderr}\0"
+// "{ExitCode}\0{Stdout}\0{Stderr}\0{Outcome}\0"
+// {Outcome} is appended last, older wrappers that read only three parts are not affected (`nocc` prints it with NOCC_PRINT_OUTCOME).
 // See nocc.cpp, write_request_to_go_daemon() and read_response_from_go_daemon()
+// Tools that know all compile commands in advance can send them at once, see onBatchRequest.
+// A list of servers can be replaced at runtime, see onSetServersRequest.
 func (listener *DaemonUnixSockListener) onRequest(conn net.Conn, daemon *Daemon) {
-	slice, err := bufio.NewReader(conn).ReadSlice(0)
+	reader := bufio.NewReader(conn)
+	slice, err := reader.ReadSlice(0)
 	if err != nil {
 		if err != io.EOF { // if launched `nocc start {cxx_name}`, and the daemon was already running — nothing is sent actually
 			logClient.Error("couldn't read from socket", err)
@@ -94,6 +152,35 @@ func (listener *DaemonUnixSockListener) onRequest(conn net.Conn, daemon *Daemon)
 		return
 	}
 	reqParts := strings.Split(string(slice[0:len(slice)-1]), "\b") // -1 to strip off the trailing '\0'
+	if reqParts[0] == batchRequestMarker {
+		listener.onBatchRequest(conn, reader, daemon, reqParts[1:])
+		return
+	}
+	if reqParts[0] == setServersRequestMarker {
+		listener.onSetServersRequest(conn, daemon, reqParts[1:])
+		return
+	}
+	recache := reqParts[0] == recacheRequestMarker
+	if recache {
+		if slice, err = reader.ReadBytes(0); err != nil {
+			logClient.Error("couldn't read from socket", err)
+			listener.respondErr(conn)
+			return
+		}
+		reqParts = strings.Split(string(slice[0:len(slice)-1]), "\b")
+	}
+	var stdin []byte
+	if reqParts[0] == stdinRequestMarker {
+		if stdin, err = listener.readStdin(reader, reqParts[1:]); err == nil {
+			slice, err = reader.ReadBytes(0) // not ReadSlice, a command line may be longer than a buffer
+		}
+		if err != nil {
+			logClient.Error("couldn't read stdin from socket", err)
+			listener.respondErr(conn)
+			return
+		}
+		reqParts = strings.Split(string(slice[0:len(slice)-1]), "\b")
+	}
 	if len(reqParts) < 3 {
 		logClient.Error("couldn't read from socket", reqParts)
 		listener.respondErr(conn)
@@ -102,6 +189,8 @@ func (listener *DaemonUnixSockListener) onRequest(conn net.Conn, daemon *Daemon)
 	request := DaemonSockRequest{
 		Cwd:     reqParts[0],
 		CmdLine: reqParts[1:],
+		Stdin:   stdin,
+		Recache: recache,
 	}
 
 	atomic.AddInt32(&listener.activeConnections, 1)
@@ -112,8 +201,110 @@ func (listener *DaemonUnixSockListener) onRequest(conn net.Conn, daemon *Daemon)
 	listener.respondOk(conn, &response)
 }
 
+// onBatchRequest handles a list of commands within one connection, amortizing the cost of launching `nocc` for each.
+// All commands are executed, even if some of them fail, at most {Jobs} simultaneously.
+// Request message format:
+// "BATCH\b{N}\b{Jobs}\0" followed by N requests "{Cwd}\b{CmdLine...}\0" (in the same format as a single one)
+// Response message format:
+// N responses "{ExitCode}\0{Stdout}\0{Stderr}\0" in the same order as requests (each is written as soon as it's ready)
+// With "BATCH\b{N}\b{Jobs}\bOUTCOME\0", every response is followed by "{Outcome}\0", like a single one.
+func (listener *DaemonUnixSockListener) onBatchRequest(conn net.Conn, reader *bufio.Reader, daemon *Daemon, header []string) {
+	var count, jobs int
+	var err error
+	withOutcome := len(header) == 3 && header[2] == batchOutcomeMarker
+	if withOutcome {
+		header = header[:2]
+	}
+	if len(header) == 2 {
+		if count, err = strconv.Atoi(header[0]); err == nil {
+			jobs, err = strconv.Atoi(header[1])
+		}
+	}
+	if len(header) != 2 || err != nil || count < 0 {
+		logClient.Error("couldn't parse batch header", header)
+		listener.respondErr(conn)
+		return
+	}
+	if count > maxBatchCommands {
+		logClient.Error("batch request rejected: too many commands", count, "max", maxBatchCommands)
+		listener.respondErr(conn)
+		return
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	requests := make([]DaemonSockRequest, count)
+	for i := range requests {
+		slice, err := reader.ReadBytes(0) // not ReadSlice, a command line may be longer than a buffer
+		if err != nil {
+			logClient.Error("couldn't read batch command", i, "of", count, err)
+			listener.respondErr(conn)
+			return
+		}
+		reqParts := strings.Split(string(slice[0:len(slice)-1]), "\b")
+		requests[i] = DaemonSockRequest{Cwd: reqParts[0], CmdLine: reqParts[1:]}
+	}
+	logClient.Info(1, "batch of", count, "commands, jobs", jobs)
+
+	atomic.AddInt32(&listener.activeConnections, 1)
+	responses := make([]DaemonSockResponse, count)
+	done := make([]chan struct{}, count)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	go func() {
+		throttle := make(chan struct{}, jobs)
+		for i := range requests {
+			throttle <- struct{}{}
+			go func(i int) {
+				if len(requests[i].CmdLine) < 2 {
+					responses[i] = DaemonSockResponse{ExitCode: 1, Stderr: []byte(fmt.Sprintf("nocc: invalid batch command %q\n", requests[i].CmdLine))}
+				} else {
+					responses[i] = daemon.HandleInvocation(requests[i])
+				}
+				<-throttle
+				close(done[i])
+			}(i)
+		}
+	}()
+
+	for i := range done {
+		<-done[i]
+		listener.lastTimeAlive = time.Now()
+		listener.writeResponse(conn, &responses[i], withOutcome) // if a client has disconnected, all commands are executed anyway
+	}
+	atomic.AddInt32(&listener.activeConnections, -1)
+	_ = conn.Close()
+}
+
+// readStdin reads "{Stdin}" of a known length after the "STDIN\b{StdinLen}\0" header, see onRequest.
+func (listener *DaemonUnixSockListener) readStdin(reader *bufio.Reader, header []string) ([]byte, error) {
+	if len(header) != 1 {
+		return nil, fmt.Errorf("couldn't parse stdin header %v", header)
+	}
+	stdinLen, err := strconv.Atoi(header[0])
+	if err != nil || stdinLen < 0 {
+		return nil, fmt.Errorf("couldn't parse stdin header %v", header)
+	}
+	if stdinLen > maxStdinBytes {
+		return nil, fmt.Errorf("stdin of %d bytes exceeds %d", stdinLen, maxStdinBytes)
+	}
+	stdin := make([]byte, stdinLen)
+	_, err = io.ReadFull(reader, stdin)
+	return stdin, err
+}
+
+func (listener *DaemonUnixSockListener) writeResponse(conn net.Conn, resp *DaemonSockResponse, withOutcome bool) {
+	if withOutcome {
+		_, _ = conn.Write([]byte(fmt.Sprintf("%d\000%s\000%s\000%s\000", resp.ExitCode, resp.Stdout, resp.Stderr, resp.Outcome)))
+	} else {
+		_, _ = conn.Write([]byte(fmt.Sprintf("%d\000%s\000%s\000", resp.ExitCode, resp.Stdout, resp.Stderr)))
+	}
+}
+
 func (listener *DaemonUnixSockListener) respondOk(conn net.Conn, resp *DaemonSockResponse) {
-	_, _ = conn.Write([]byte(fmt.Sprintf("%d\000%s\000%s\000", resp.ExitCode, resp.Stdout, resp.Stderr)))
+	listener.writeResponse(conn, resp, true)
 	_ = conn.Close()
 }
 
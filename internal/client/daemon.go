@@ -1,16 +1,20 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"hash/fnv"
 	"math/rand"
 	"os"
+	"os/exec"
 	"os/signal"
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -19,7 +23,9 @@ import (
 )
 
 const (
-	timeoutForceInterruptInvocation = 8 * time.Minute
+	defaultForceInterruptTimeout = 8 * time.Minute
+	stateFilesSaveInterval       = 5 * time.Second
+	includesCacheStatsInterval   = time.Minute
 )
 
 // Daemon is created once, in a separate process `nocc-daemon`, which is listening for connections via unix socket.
@@ -32,23 +38,64 @@ type Daemon struct {
 	startTime time.Time
 	quitChan  chan int
 
-	clientID     string
-	hostUserName string
+	clientID          string
+	hostUserName      string
+	objCacheNamespace string // env NOCC_OBJ_CACHE_NAMESPACE, see server.NoccServer.makeObjCacheKey
 
 	listener          *DaemonUnixSockListener
-	remoteConnections []*RemoteConnection
-	allRemotesDelim   string
+	remoteConnections []*RemoteConnection // all unique remotes from all pools
+	remotesDefault    []*RemoteConnection // env NOCC_SERVERS
+	remotesForC       []*RemoteConnection // env NOCC_SERVERS_C, if empty, remotesDefault are used for .c files
+	remotesForCxx     []*RemoteConnection // env NOCC_SERVERS_CXX, if empty, remotesDefault are used for C++ files
+	remoteForced      *RemoteConnection   // env NOCC_FORCE_SERVER, if set, all sources are sent there bypassing hashing
+	sortServers       bool                // env NOCC_SORT_SERVERS, pools are sorted by host:port, see sortRemotesPoolForRouting
+	allRemotesDelim   string              // hosts of remoteConnections, sent to every remote just to be logged
+	remotesMu         sync.RWMutex        // fields above are replaced as a whole by SetRemoteServers, read them under it
+	setServersMu      sync.Mutex          // SetRemoteServers calls are serialized
+	connectAttempts   int
+	connectTimeout    time.Duration
+	interruptTimeout  time.Duration // env NOCC_FORCE_INTERRUPT_TIMEOUT, see PeriodicallyInterruptHangedInvocations
 	localCxxThrottle  chan struct{}
+	transferTuning    TransferTuning
+
+	// env NOCC_LOCAL_CXX_QUEUE_SIZE_PARTIAL_OUTAGE, nil if not set;
+	// while some remotes are still up, a local fallback takes it in addition to localCxxThrottle, see FallbackToLocalCxx
+	localCxxThrottlePartialOutage chan struct{}
 
 	disableObjCache    bool
+	recacheObjs        bool // env NOCC_RECACHE_OBJS: objs are compiled always, replacing existing ones in obj cache
 	disableOwnIncludes bool
+	disableOwnPch      bool
+	compressOwnPch     bool
+	dedupeOwnPch       bool
+	rewriteIncludes    bool // env NOCC_REWRITE_INCLUDES, see Invocation.preprocessRewriteIncludes
+	includesOnServer   bool // env NOCC_COLLECT_INCLUDES_ON_SERVER, see RemoteConnection.CollectDependentIncludesOnServer
 	disableLocalCxx    bool
+	localCxxOverride   string // env NOCC_LOCAL_CXX_OVERRIDE: a compiler (or a dir of wrappers) for local cxx instead of cmdLine[0]
+
+	cacheableIncludeDirs []string             // env NOCC_CACHEABLE_INCLUDE_DIRS, see IncludesCache.cacheableDirs
+	generatedIncludeDirs []string             // env NOCC_GENERATED_INCLUDE_DIRS, see IncludesCache.generatedDirs
+	ownIncludesMaxDepth  int                  // env NOCC_OWN_INCLUDES_MAX_DEPTH, see IncludesCache.ownIncludesMaxDepth
+	ownIncludesMaxFiles  int                  // env NOCC_OWN_INCLUDES_MAX_FILES
+	includesCacheLimit   int64                // env NOCC_INCLUDES_CACHE_LIMIT, see IncludesCache.limitBytes
+	skipObjCacheDirs     []string             // env NOCC_SKIP_OBJ_CACHE_LOOKUP_DIRS, see Invocation.skipObjCacheLookup
+	echoServerCmdLine    bool                 // env NOCC_ECHO_SERVER_CMD_LINE, servers send back cxx cmd lines they launch
+	summaryFile          *SummaryFile         // env NOCC_SUMMARY_FILE, nil if not set
+	uploadsFile          *SummaryFile         // env NOCC_UPLOADS_FILE, nil if not set, see makeUploadsTSVRecords
+	compDB               *CompilationDatabase // env NOCC_COMPDB, nil if not set
+	unchangedObjs        *UnchangedObjs       // env NOCC_SKIP_UNCHANGED, nil if not set
+	serversAffinity      *ServersAffinity     // env NOCC_SERVERS_AFFINITY_FILE, nil if not set
+	stdinDir             string               // for sources read from stdin, see makeStdinDir; empty if it couldn't be created
+
+	seedObjCache         bool // compile locally, but upload .o to the remote's obj cache
+	seedObjCacheThrottle chan struct{}
+	seedObjCacheWg       sync.WaitGroup
 
 	totalInvocations  uint32
 	activeInvocations map[uint32]*Invocation
 	mu                sync.RWMutex
 
-	includesCache map[string]*IncludesCache // map[cxx_name] => cache (support various cxx compilers during a daemon lifetime)
+	includesCache map[string]*IncludesCache // map[cxx_name + -B dirs + -specs + -m32/-arch] => cache (support various cxx compilers and multilibs during a daemon lifetime)
 }
 
 // detectClientID returns a clientID for current daemon launch.
@@ -78,45 +125,203 @@ func detectHostUserName() string {
 	return curUser.Username
 }
 
-func MakeDaemon(remoteNoccHosts []string, disableObjCache bool, disableOwnIncludes bool, maxLocalCxxProcesses int64) (*Daemon, error) {
+// mergeUniqueRemoteHosts returns all hosts from all pools, every host mentioned only once.
+// If a host is listed in several pools (e.g. in NOCC_SERVERS and NOCC_SERVERS_C), only one connection is kept to it.
+func mergeUniqueRemoteHosts(pools ...[]string) []string {
+	uniqueHosts := make([]string, 0, len(pools[0]))
+	for _, pool := range pools {
+		for _, remoteHostPort := range pool {
+			alreadyAdded := false
+			for _, added := range uniqueHosts {
+				if added == remoteHostPort {
+					alreadyAdded = true
+					break
+				}
+			}
+			if !alreadyAdded {
+				uniqueHosts = append(uniqueHosts, remoteHostPort)
+			}
+		}
+	}
+	return uniqueHosts
+}
+
+// DaemonOptions contains settings a daemon is created with; cmd/nocc-daemon fills them from env (NOCC_*), see docs/configuration.md.
+// A zero value of any field means "off" (or "no limit"), except for those having a default noted below.
+type DaemonOptions struct {
+	ClientID string // empty means env NOCC_CLIENT_ID or a random one, see detectClientID
+
+	RemoteNoccHosts    []string // NOCC_SERVERS
+	RemoteNoccHostsC   []string // NOCC_SERVERS_C, optional
+	RemoteNoccHostsCxx []string // NOCC_SERVERS_CXX, optional
+	ForcedNoccHost     string   // NOCC_FORCE_SERVER, optional
+	SortServers        bool     // NOCC_SORT_SERVERS: route over pools sorted by host:port, not in a listed order
+
+	ConnectAttempts  int64          // 0 means 1
+	ConnectTimeout   time.Duration  // 0 means 2 seconds
+	InterruptTimeout time.Duration  // 0 means defaultForceInterruptTimeout
+	TransferTuning   TransferTuning // a zero value means MakeDefaultTransferTuning()
+
+	DisableObjCache        bool
+	SeedObjCache           bool
+	RecacheObjs            bool
+	SkipObjCacheLookupDirs []string
+
+	DisableOwnIncludes   bool
+	DisableOwnPch        bool
+	CompressOwnPch       bool
+	DedupeOwnPch         bool
+	RewriteIncludes      bool
+	IncludesOnServer     bool
+	CacheableIncludeDirs []string
+	GeneratedIncludeDirs []string
+	OwnIncludesMaxDepth  int64
+	OwnIncludesMaxFiles  int64
+	IncludesCacheLimit   int64
+
+	MaxLocalCxxProcesses              int64 // 0 disables falling back to local cxx
+	MaxLocalCxxProcessesPartialOutage int64
+	LocalCxxOverride                  string
+
+	EchoServerCmdLine       bool
+	SummaryFileName         string
+	UploadsFileName         string
+	CompDBFileName          string
+	SkipUnchangedFileName   string
+	ServersAffinityFileName string
+}
+
+// MakeDaemon connects to all remotes and creates a daemon ready to serve invocations.
+// RemoteNoccHostsC and RemoteNoccHostsCxx are optional pools for .c and C++ sources; if a pool is empty,
+// RemoteNoccHosts are used for that language.
+// ForcedNoccHost is optional, it pins all sources to one server (it may be outside of pools), see NOCC_FORCE_SERVER.
+func MakeDaemon(opts DaemonOptions) (*Daemon, error) {
+	if opts.ConnectAttempts <= 0 {
+		opts.ConnectAttempts = 1
+	}
+	if opts.ConnectTimeout == 0 {
+		opts.ConnectTimeout = 2 * time.Second
+	}
+	if opts.InterruptTimeout == 0 {
+		opts.InterruptTimeout = defaultForceInterruptTimeout
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = detectClientID()
+	}
+	if opts.TransferTuning == (TransferTuning{}) {
+		opts.TransferTuning = MakeDefaultTransferTuning()
+	}
+
+	var forcedNoccHosts []string
+	if opts.ForcedNoccHost != "" {
+		forcedNoccHosts = []string{opts.ForcedNoccHost}
+	}
+	allNoccHosts := mergeUniqueRemoteHosts(opts.RemoteNoccHosts, opts.RemoteNoccHostsC, opts.RemoteNoccHostsCxx, forcedNoccHosts)
+
 	// send env NOCC_SERVERS on connect everywhere
 	// this is for debugging purpose: in production, all clients should have the same servers list
 	// to ensure this, just grep server logs: only one unique string should appear
-	allRemotesDelim := ""
-	for _, remoteHostPort := range remoteNoccHosts {
-		if allRemotesDelim != "" {
-			allRemotesDelim += ","
-		}
-		allRemotesDelim += ExtractRemoteHostWithoutPort(remoteHostPort)
-	}
+	allRemotesDelim := joinRemoteHostsDelim(allNoccHosts)
 
 	// env NOCC_SERVERS and others are supposed to be the same between `nocc` invocations
 	// (in practice, this is true, as the first `nocc` invocation has no precedence over any other in a bunch)
 	daemon := &Daemon{
-		startTime:          time.Now(),
-		quitChan:           make(chan int),
-		clientID:           detectClientID(),
-		hostUserName:       detectHostUserName(),
-		remoteConnections:  make([]*RemoteConnection, len(remoteNoccHosts)),
-		allRemotesDelim:    allRemotesDelim,
-		localCxxThrottle:   make(chan struct{}, maxLocalCxxProcesses),
-		disableOwnIncludes: disableOwnIncludes,
-		disableObjCache:    disableObjCache,
-		disableLocalCxx:    maxLocalCxxProcesses == 0,
-		activeInvocations:  make(map[uint32]*Invocation, 300),
-		includesCache:      make(map[string]*IncludesCache, 1),
+		startTime:            time.Now(),
+		quitChan:             make(chan int),
+		clientID:             opts.ClientID,
+		objCacheNamespace:    os.Getenv("NOCC_OBJ_CACHE_NAMESPACE"),
+		hostUserName:         detectHostUserName(),
+		remoteConnections:    make([]*RemoteConnection, len(allNoccHosts)),
+		allRemotesDelim:      allRemotesDelim,
+		connectAttempts:      int(opts.ConnectAttempts),
+		connectTimeout:       opts.ConnectTimeout,
+		interruptTimeout:     opts.InterruptTimeout,
+		transferTuning:       opts.TransferTuning,
+		localCxxThrottle:     make(chan struct{}, opts.MaxLocalCxxProcesses),
+		disableOwnIncludes:   opts.DisableOwnIncludes,
+		disableOwnPch:        opts.DisableOwnPch,
+		compressOwnPch:       opts.CompressOwnPch,
+		dedupeOwnPch:         opts.DedupeOwnPch,
+		rewriteIncludes:      opts.RewriteIncludes,
+		includesOnServer:     opts.IncludesOnServer,
+		cacheableIncludeDirs: opts.CacheableIncludeDirs,
+		generatedIncludeDirs: opts.GeneratedIncludeDirs,
+		ownIncludesMaxDepth:  int(opts.OwnIncludesMaxDepth),
+		ownIncludesMaxFiles:  int(opts.OwnIncludesMaxFiles),
+		includesCacheLimit:   opts.IncludesCacheLimit,
+		skipObjCacheDirs:     opts.SkipObjCacheLookupDirs,
+		echoServerCmdLine:    opts.EchoServerCmdLine,
+		disableObjCache:      opts.DisableObjCache,
+		recacheObjs:          opts.RecacheObjs && !opts.DisableObjCache,
+		disableLocalCxx:      opts.MaxLocalCxxProcesses == 0,
+		localCxxOverride:     opts.LocalCxxOverride,
+		sortServers:          opts.SortServers,
+		seedObjCache:         opts.SeedObjCache && !opts.DisableObjCache,
+		seedObjCacheThrottle: make(chan struct{}, maxSimultaneousObjCacheSeeds),
+		activeInvocations:    make(map[uint32]*Invocation, 300),
+		includesCache:        make(map[string]*IncludesCache, 1),
+	}
+
+	if opts.MaxLocalCxxProcessesPartialOutage > 0 && opts.MaxLocalCxxProcessesPartialOutage < opts.MaxLocalCxxProcesses {
+		daemon.localCxxThrottlePartialOutage = make(chan struct{}, opts.MaxLocalCxxProcessesPartialOutage)
+	}
+
+	if opts.SummaryFileName != "" {
+		var err error
+		if daemon.summaryFile, err = MakeSummaryFile(opts.SummaryFileName); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.UploadsFileName != "" {
+		var err error
+		if daemon.uploadsFile, err = makeTSVFile(opts.UploadsFileName, uploadsTSVHeader); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.CompDBFileName != "" {
+		var err error
+		if daemon.compDB, err = MakeCompilationDatabase(opts.CompDBFileName); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.LocalCxxOverride != "" {
+		if stat, err := os.Stat(opts.LocalCxxOverride); err != nil || !stat.IsDir() {
+			if _, err := exec.LookPath(opts.LocalCxxOverride); err != nil {
+				return nil, fmt.Errorf("invalid NOCC_LOCAL_CXX_OVERRIDE: %v", err)
+			}
+		}
+	}
+
+	if opts.SkipUnchangedFileName != "" {
+		var err error
+		if daemon.unchangedObjs, err = MakeUnchangedObjs(opts.SkipUnchangedFileName); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.ServersAffinityFileName != "" {
+		var err error
+		if daemon.serversAffinity, err = MakeServersAffinity(opts.ServersAffinityFileName); err != nil {
+			return nil, err
+		}
+	}
+
+	if stdinDir, err := makeStdinDir(); err != nil {
+		logClient.Error("stdin input will be compiled locally:", err)
+	} else {
+		daemon.stdinDir = stdinDir
 	}
 
 	// connect to all remotes in parallel
 	wg := sync.WaitGroup{}
-	wg.Add(len(remoteNoccHosts))
-
-	ctxConnect, cancelFunc := context.WithTimeout(context.Background(), 5000*time.Millisecond)
-	defer cancelFunc()
+	wg.Add(len(allNoccHosts))
 
-	for index, remoteHostPort := range remoteNoccHosts {
+	for index, remoteHostPort := range allNoccHosts {
 		go func(index int, remoteHostPort string) {
-			remote, err := MakeRemoteConnection(daemon, remoteHostPort, ctxConnect)
+			remote, err := MakeRemoteConnection(daemon, remoteHostPort)
 			if err != nil {
 				remote.isUnavailable = true
 				logClient.Error("error connecting to", remoteHostPort, err)
@@ -128,9 +333,59 @@ func MakeDaemon(remoteNoccHosts []string, disableObjCache bool, disableOwnInclud
 	}
 	wg.Wait()
 
+	daemon.remotesDefault = daemon.findRemoteConnections(opts.RemoteNoccHosts)
+	daemon.remotesForC = daemon.findRemoteConnections(opts.RemoteNoccHostsC)
+	daemon.remotesForCxx = daemon.findRemoteConnections(opts.RemoteNoccHostsCxx)
+	if forcedRemotes := daemon.findRemoteConnections(forcedNoccHosts); len(forcedRemotes) != 0 {
+		daemon.remoteForced = forcedRemotes[0]
+	}
+	if daemon.serversAffinity != nil {
+		daemon.serversAffinity.DropRemovedRemotes(daemon.remoteConnections)
+	}
+	// state files are written as invocations are handled, even if a daemon isn't serving a socket (selftest, for example)
+	go daemon.PeriodicallySaveStateFiles()
+	go daemon.PeriodicallyLogIncludesCacheStats()
+
 	return daemon, nil
 }
 
+func joinRemoteHostsDelim(allNoccHosts []string) string {
+	allRemotesDelim := ""
+	for _, remoteHostPort := range allNoccHosts {
+		if allRemotesDelim != "" {
+			allRemotesDelim += ","
+		}
+		allRemotesDelim += ExtractRemoteHostWithoutPort(remoteHostPort)
+	}
+	return allRemotesDelim
+}
+
+func (daemon *Daemon) findRemoteConnections(remoteNoccHosts []string) []*RemoteConnection {
+	remotes := make([]*RemoteConnection, 0, len(remoteNoccHosts))
+	for _, remoteHostPort := range remoteNoccHosts {
+		for _, remote := range daemon.remoteConnections {
+			if remote.remoteHostPort == remoteHostPort {
+				remotes = append(remotes, remote)
+				break
+			}
+		}
+	}
+	if daemon.sortServers {
+		sortRemotesPoolForRouting(remotes)
+	}
+	return remotes
+}
+
+// sortRemotesPoolForRouting orders a pool by host:port, so that routing depends on a set of servers, not on their order:
+// machines having NOCC_SERVERS listed differently send the same file to the same server.
+// It's opt-in (NOCC_SORT_SERVERS): for a list not sorted already, it changes a server of most files,
+// so obj caches of all servers get cold once, see "balancing files over servers" in docs.
+func sortRemotesPoolForRouting(remotesPool []*RemoteConnection) {
+	sort.Slice(remotesPool, func(i, j int) bool {
+		return remotesPool[i].remoteHostPort < remotesPool[j].remoteHostPort
+	})
+}
+
 func (daemon *Daemon) StartListeningUnixSocket(daemonUnixSock string) error {
 	daemon.listener = MakeDaemonRpcListener()
 	return daemon.listener.StartListeningUnixSocket(daemonUnixSock)
@@ -153,10 +408,11 @@ func (daemon *Daemon) QuitDaemonGracefully(reason string) {
 
 	defer func() { _ = recover() }()
 	close(daemon.quitChan)
+	daemon.seedObjCacheWg.Wait()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
-	for _, remote := range daemon.remoteConnections {
+	for _, remote := range daemon.getRemoteConnections() {
 		remote.SendStopClient(ctx)
 		remote.Clear()
 	}
@@ -166,10 +422,33 @@ func (daemon *Daemon) QuitDaemonGracefully(reason string) {
 		invocation.ForceInterrupt(fmt.Errorf("daemon quit: %v", reason))
 	}
 	daemon.mu.Unlock()
+	daemon.logIncludesCacheStats(nil)
+
+	if daemon.uploadsFile != nil {
+		daemon.uploadsFile.Close()
+	}
+	if daemon.summaryFile != nil {
+		daemon.summaryFile.Close()
+	}
+	if daemon.compDB != nil {
+		if err := daemon.compDB.Save(); err != nil {
+			logClient.Error("could not save compilation database:", err)
+		}
+	}
+	if daemon.unchangedObjs != nil {
+		if err := daemon.unchangedObjs.Save(); err != nil {
+			logClient.Error("could not save unchanged objs:", err)
+		}
+	}
+	if daemon.serversAffinity != nil {
+		if err := daemon.serversAffinity.Save(); err != nil {
+			logClient.Error("could not save servers affinity:", err)
+		}
+	}
 }
 
 func (daemon *Daemon) OnRemoteBecameUnavailable(remoteHostPost string, reason error) {
-	for _, remote := range daemon.remoteConnections {
+	for _, remote := range daemon.getRemoteConnections() {
 		if remote.remoteHostPort == remoteHostPost && !remote.isUnavailable {
 			remote.isUnavailable = true
 			logClient.Error("remote", remoteHostPost, "became unavailable:", reason)
@@ -177,8 +456,52 @@ func (daemon *Daemon) OnRemoteBecameUnavailable(remoteHostPost string, reason er
 	}
 }
 
-func (daemon *Daemon) HandleInvocation(req DaemonSockRequest) DaemonSockResponse {
+// OnRemoteForgotClient is called when a stream to a remote fails with codes.Unauthenticated (the remote was restarted).
+// The client registers again; only if it fails, the remote is considered unavailable.
+func (daemon *Daemon) OnRemoteForgotClient(remoteHostPost string, streamStartTime time.Time) bool {
+	for _, remote := range daemon.getRemoteConnections() {
+		if remote.remoteHostPort == remoteHostPost {
+			if err := remote.ReRegisterClient(streamStartTime); err != nil {
+				daemon.OnRemoteBecameUnavailable(remoteHostPost, err)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (daemon *Daemon) HandleInvocation(req DaemonSockRequest) (response DaemonSockResponse) {
+	cwd, err := normalizeRequestCwd(req.Cwd)
+	if err != nil {
+		logClient.Error("invalid cwd of invocation", err)
+		return DaemonSockResponse{
+			ExitCode: 1,
+			Stderr:   []byte(fmt.Sprintf("nocc: %v\n", err)),
+		}
+	}
+	req.Cwd = cwd
 	invocation := ParseCmdLineInvocation(daemon, req.Cwd, req.CmdLine)
+	invocation.recacheObj = (daemon.recacheObjs || req.Recache) && !daemon.disableObjCache
+	if invocation.cppInStdin && req.Stdin == nil {
+		// neither remote nor local cxx can read it: stdin belongs to a `nocc` process, not to a daemon
+		return DaemonSockResponse{
+			ExitCode: 1,
+			Stderr:   []byte("nocc: stdin input ('-') wasn't passed to a daemon\n"),
+		}
+	}
+	// whether it's compiled remotely or locally, it's a part of a build; stdin has no file to point to
+	if daemon.compDB != nil && invocation.invokeType == invokedForCompilingCpp && !invocation.cppInStdin && !invocation.syntaxOnly {
+		defer daemon.compDB.Add(req.Cwd, req.CmdLine, invocation.cppInFile, invocation.objOutFile)
+	}
+	if daemon.unchangedObjs != nil && invocation.invokeType == invokedForCompilingCpp && !invocation.cppInStdin && !invocation.syntaxOnly && !invocation.recacheObj {
+		if daemon.unchangedObjs.IsUnchanged(invocation, req.CmdLine) {
+			logClient.Info(1, "skip unchanged", invocation.objOutFile)
+			return DaemonSockResponse{ExitCode: 0, Outcome: OutcomeUnchanged}
+		}
+		defer func() {
+			daemon.unchangedObjs.Remember(invocation, req.CmdLine, response.ExitCode == 0)
+		}()
+	}
 
 	switch invocation.invokeType {
 	default:
@@ -189,26 +512,46 @@ func (daemon *Daemon) HandleInvocation(req DaemonSockRequest) DaemonSockResponse
 		// invocation.err describes a human-readable reason
 		return daemon.FallbackToLocalCxx(req, invocation.err)
 
+	case invokedWithFatalError:
+		// cxx would fail anyway, so respond with an error like cxx does, without uploading files and compiling
+		logClient.Info(0, "fail fast:", invocation.err)
+		return DaemonSockResponse{
+			ExitCode: 1,
+			Stderr:   []byte(fmt.Sprintln(invocation.err)),
+		}
+
+	case invokedForQuerying:
+		// it's not a fallback, so it's quiet and not limited by NOCC_LOCAL_CXX_QUEUE_SIZE (even if it's 0)
+		logClient.Info(1, "query cxx locally", req.CmdLine)
+		localCxx := daemon.makeLocalCxxLaunch(req)
+		reply := DaemonSockResponse{Outcome: OutcomeLocal}
+		reply.ExitCode, reply.Stdout, reply.Stderr = localCxx.RunCxxLocally()
+		return reply
+
 	case invokedForLinking:
 		// generally, linking commands are detected by the C++ wrapper, they aren't sent to daemon at all
 		// (it's a moment of optimization, because linking commands are usually very long)
-		// that's why it's rather strange if this case is true in production, but it's not an error anyway
+		// what reaches here is mostly compile-and-link one-liners like `g++ 1.cpp -o app`, it's not an error anyway
 		logClient.Info(1, "fallback to local cxx for linking")
 		return daemon.FallbackToLocalCxx(req, nil)
 
 	case invokedForCompilingPch:
 		invocation.includesCache.Clear()
-		ownPch, err := GenerateOwnPch(daemon, req.Cwd, invocation)
+		ownPch, err := GenerateOwnPch(daemon, invocation.cwd, invocation)
 		if err != nil {
 			return daemon.FallbackToLocalCxx(req, fmt.Errorf("failed to generate pch file: %v", err))
 		}
 
-		fileSize, err := ownPch.SaveToOwnPchFile()
+		fileSize, err := ownPch.SaveToOwnPchFile(daemon.compressOwnPch, daemon.dedupeOwnPch)
 		if err != nil {
 			return daemon.FallbackToLocalCxx(req, fmt.Errorf("failed to save pch file: %v", err))
 		}
 
-		invocation.includesCache.AddHFileInfo(ownPch.OwnPchFile, fileSize, ownPch.PchHash, []string{})
+		var fileMTime time.Time
+		if stat, err := os.Stat(ownPch.OwnPchFile); err == nil {
+			fileMTime = stat.ModTime()
+		}
+		invocation.includesCache.AddHFileInfo(ownPch.OwnPchFile, fileSize, ownPch.PchHash, fileMTime, []string{})
 		logClient.Info(0, "saved pch file", fileSize, "bytes to", ownPch.OwnPchFile)
 
 		if !daemon.areAllRemotesAvailable() {
@@ -222,34 +565,88 @@ func (daemon *Daemon) HandleInvocation(req DaemonSockRequest) DaemonSockResponse
 		}
 
 	case invokedForCompilingCpp:
-		if len(daemon.remoteConnections) == 0 {
+		remotesPool := daemon.chooseRemotesPoolForCppCompilation(invocation.cppInFile)
+		if len(remotesPool) == 0 && daemon.remoteForced == nil {
 			return daemon.FallbackToLocalCxx(req, fmt.Errorf("no remote hosts set; use NOCC_SERVERS env var to provide servers"))
 		}
 
-		remote := daemon.chooseRemoteConnectionForCppCompilation(invocation.cppInFile)
+		remote := daemon.chooseRemoteConnectionForCppCompilation(remotesPool, invocation.cppInFile)
 		invocation.summary.remoteHost = remote.remoteHost
 
 		if remote.isUnavailable {
 			return daemon.FallbackToLocalCxx(req, fmt.Errorf("remote %s is unavailable", remote.remoteHost))
 		}
 
+		if daemon.seedObjCache && !invocation.cppInStdin && !invocation.skipObjCacheLookup && !invocation.syntaxOnly { // for stdin, there is no file to upload in background
+			// like the remote does, put .o to obj cache along with warnings (unless it has -cache-objs-with-warnings=false)
+			reply := daemon.FallbackToLocalCxx(req, nil)
+			if reply.ExitCode == 0 {
+				daemon.seedObjCacheInBackground(invocation.cwd, invocation, remote, reply.Stdout, reply.Stderr)
+			}
+			return reply
+		}
+
+		// a remote's cxx queue is deep: rather than wait there, use a local cxx if it's idle right now
+		// (but an obj in a remote's obj cache is still cheaper, so it's looked up first, holding a local slot)
+		var releaseLocalSlot func()
+		if remote.IsBusy() && !daemon.disableLocalCxx {
+			var ok bool
+			if releaseLocalSlot, ok = daemon.acquireLocalCxxSlot(false); ok {
+				if daemon.disableObjCache || invocation.skipObjCacheLookup || invocation.syntaxOnly || invocation.recacheObj || invocation.cppInStdin {
+					defer releaseLocalSlot()
+					logClient.Info(1, "remote", remote.remoteHost, "is busy, compiling locally", invocation.cppInFile)
+					return daemon.runLocalCxx(req)
+				}
+				invocation.objCacheOnly = true
+			}
+		}
+
+		if invocation.cppInStdin {
+			if err := invocation.SaveStdinToTempFile(daemon.stdinDir, req.Stdin); err != nil {
+				return daemon.FallbackToLocalCxx(req, fmt.Errorf("failed to save stdin: %v", err))
+			}
+		}
+
 		daemon.mu.Lock()
 		daemon.activeInvocations[invocation.sessionID] = invocation
 		daemon.mu.Unlock()
 
 		var err error
 		var reply DaemonSockResponse
-		reply.ExitCode, reply.Stdout, reply.Stderr, err = CompileCppRemotely(daemon, req.Cwd, invocation, remote)
+		reply.ExitCode, reply.Stdout, reply.Stderr, err = CompileCppRemotely(daemon, invocation.cwd, invocation, remote)
 
 		daemon.mu.Lock()
 		delete(daemon.activeInvocations, invocation.sessionID)
 		daemon.mu.Unlock()
 
+		if invocation.objCacheOnly {
+			if err == errObjCacheMiss {
+				defer releaseLocalSlot()
+				logClient.Info(1, "remote", remote.remoteHost, "is busy, not in obj cache, compiling locally", invocation.cppInFile)
+				return daemon.runLocalCxx(req)
+			}
+			releaseLocalSlot() // an obj was taken from obj cache (or failed), a fallback below waits for a slot itself
+		}
 		if err != nil { // it's not an error in C++ code, it's a network error or remote failure
 			return daemon.FallbackToLocalCxx(req, err)
 		}
+		if invocation.cppInStdin { // show errors like cxx does for stdin, not with a temporary file name
+			reply.Stderr = bytes.ReplaceAll(reply.Stderr, []byte(invocation.cppInFile), []byte("<stdin>"))
+		}
+		if reply.ExitCode != 0 {
+			reply.Stderr = invocation.attributeRemoteStderr(reply.Stderr, remote.remoteHost)
+		}
+
+		if invocation.fromObjCache {
+			reply.Outcome = OutcomeObjCache + " " + remote.remoteHost
+		} else {
+			reply.Outcome = OutcomeRemote + " " + remote.remoteHost
+		}
 
 		logClient.Info(1, "summary:", invocation.summary.ToLogString(invocation))
+		if daemon.summaryFile != nil {
+			daemon.summaryFile.Append(invocation.summary.ToTSVRecord(invocation))
+		}
 		return reply
 	}
 }
@@ -266,23 +663,126 @@ func (daemon *Daemon) FallbackToLocalCxx(req DaemonSockRequest, reason error) Da
 		return reply
 	}
 
-	daemon.localCxxThrottle <- struct{}{}
-	localCxx := LocalCxxLaunch{req.CmdLine, req.Cwd}
-	reply.ExitCode, reply.Stdout, reply.Stderr = localCxx.RunCxxLocally()
-	<-daemon.localCxxThrottle
+	releaseLocalSlot, _ := daemon.acquireLocalCxxSlot(true)
+	defer releaseLocalSlot()
+	return daemon.runLocalCxx(req)
+}
+
+// acquireLocalCxxSlot takes a slot for a local cxx process, limited by NOCC_LOCAL_CXX_QUEUE_SIZE;
+// during a partial outage, most files still go remote, so local compiles are capped lower to leave CPU for other work,
+// that slot is taken before localCxxThrottle (always in this order), so that NOCC_LOCAL_CXX_QUEUE_SIZE is never exceeded.
+// If wait is false, it doesn't wait for free slots: ok is false if there are none right now.
+func (daemon *Daemon) acquireLocalCxxSlot(wait bool) (release func(), ok bool) {
+	var partialThrottle chan struct{}
+	if daemon.localCxxThrottlePartialOutage != nil && daemon.isPartialOutage() {
+		partialThrottle = daemon.localCxxThrottlePartialOutage
+		if !takeThrottleSlot(partialThrottle, wait) {
+			return nil, false
+		}
+	}
+	if !takeThrottleSlot(daemon.localCxxThrottle, wait) {
+		if partialThrottle != nil {
+			<-partialThrottle
+		}
+		return nil, false
+	}
+
+	return func() {
+		<-daemon.localCxxThrottle
+		if partialThrottle != nil {
+			<-partialThrottle
+		}
+	}, true
+}
+
+func takeThrottleSlot(throttle chan struct{}, wait bool) bool {
+	if wait {
+		throttle <- struct{}{}
+		return true
+	}
+	select {
+	case throttle <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
 
+// runLocalCxx launches cxx locally, a slot must be already taken, see acquireLocalCxxSlot.
+func (daemon *Daemon) runLocalCxx(req DaemonSockRequest) (reply DaemonSockResponse) {
+	localCxx := daemon.makeLocalCxxLaunch(req)
+	reply.ExitCode, reply.Stdout, reply.Stderr = localCxx.RunCxxLocally()
+	reply.Outcome = OutcomeLocal
 	return reply
 }
 
-func (daemon *Daemon) GetOrCreateIncludesCache(cxxName string) *IncludesCache {
+// makeLocalCxxLaunch substitutes a compiler with NOCC_LOCAL_CXX_OVERRIDE, e.g. to chain nocc with a local ccache:
+// if it's a dir of wrappers (like /usr/lib/ccache), a compiler with the same name is taken from there.
+func (daemon *Daemon) makeLocalCxxLaunch(req DaemonSockRequest) LocalCxxLaunch {
+	cmdLine := req.CmdLine
+	if daemon.localCxxOverride != "" {
+		cmdLine = append([]string{daemon.localCxxOverride}, req.CmdLine[1:]...)
+		if stat, err := os.Stat(daemon.localCxxOverride); err == nil && stat.IsDir() {
+			cmdLine[0] = filepath.Join(daemon.localCxxOverride, filepath.Base(req.CmdLine[0]))
+		}
+	}
+	return LocalCxxLaunch{cmdLine, req.Cwd, req.Stdin}
+}
+
+// GetOrCreateIncludesCache returns a cache for a compiler along with flags affecting its default include dirs.
+// Several -arch in one cmd line (Apple universal binaries) mean that cxx is launched once per arch:
+// default include dirs are queried per -arch, and if they differ, dependencies can't be collected as one set,
+// so an error is returned, and such an invocation is compiled locally.
+func (daemon *Daemon) GetOrCreateIncludesCache(cxxName string, cxxDirsB []string, cxxSpecsFiles []string, cxxArchFlags []string) (*IncludesCache, error) {
+	var archs []string
+	var otherArchFlags []string
+	for i := 0; i < len(cxxArchFlags); i++ {
+		if cxxArchFlags[i] == "-arch" && i+1 < len(cxxArchFlags) {
+			archs = append(archs, cxxArchFlags[i+1])
+			i++
+		} else {
+			otherArchFlags = append(otherArchFlags, cxxArchFlags[i])
+		}
+	}
+	if len(archs) < 2 {
+		return daemon.getOrCreateIncludesCacheOne(cxxName, cxxDirsB, cxxSpecsFiles, cxxArchFlags), nil
+	}
+
+	var firstCache *IncludesCache
+	for _, arch := range archs {
+		includesCache := daemon.getOrCreateIncludesCacheOne(cxxName, cxxDirsB, cxxSpecsFiles, append(otherArchFlags[:len(otherArchFlags):len(otherArchFlags)], "-arch", arch))
+		if includesCache.cxxDefIDirs.Count() == 0 {
+			return nil, fmt.Errorf("can't detect default include dirs for -arch %s", arch)
+		}
+		if firstCache == nil {
+			firstCache = includesCache
+		} else if strings.Join(includesCache.cxxDefIDirs.AsCxxArgs(), " ") != strings.Join(firstCache.cxxDefIDirs.AsCxxArgs(), " ") {
+			return nil, fmt.Errorf("default include dirs differ for -arch %s and -arch %s", archs[0], arch)
+		}
+	}
+	return firstCache, nil
+}
+
+func (daemon *Daemon) getOrCreateIncludesCacheOne(cxxName string, cxxDirsB []string, cxxSpecsFiles []string, cxxArchFlags []string) *IncludesCache {
+	cacheKey := cxxName
+	for _, dirB := range cxxDirsB {
+		cacheKey += " -B" + dirB
+	}
+	for _, specsFile := range cxxSpecsFiles {
+		cacheKey += " -specs=" + specsFile
+	}
+	for _, archFlag := range cxxArchFlags {
+		cacheKey += " " + archFlag
+	}
+
 	daemon.mu.Lock()
-	includesCache := daemon.includesCache[cxxName]
+	includesCache := daemon.includesCache[cacheKey]
 	if includesCache == nil {
 		var err error
-		if includesCache, err = MakeIncludesCache(cxxName); err != nil {
-			logClient.Error("failed to calc default include dirs for", cxxName, err)
+		if includesCache, err = MakeIncludesCache(cxxName, cxxDirsB, cxxSpecsFiles, cxxArchFlags, daemon.cacheableIncludeDirs, daemon.generatedIncludeDirs, daemon.ownIncludesMaxDepth, daemon.ownIncludesMaxFiles, daemon.includesCacheLimit); err != nil {
+			logClient.Error("failed to calc default include dirs for", cacheKey, err)
 		}
-		daemon.includesCache[cxxName] = includesCache
+		daemon.includesCache[cacheKey] = includesCache
 	}
 	daemon.mu.Unlock()
 	return includesCache
@@ -295,10 +795,18 @@ func (daemon *Daemon) FindBySessionID(sessionID uint32) *Invocation {
 	return invocation
 }
 
+// PeriodicallyInterruptHangedInvocations interrupts invocations lasting more than NOCC_FORCE_INTERRUPT_TIMEOUT,
+// they fall back to local compilation. Checks are done every 10 seconds, or more often for a shorter timeout
+// (with jitter, not to tick in lockstep with other daemons started at the same time).
 func (daemon *Daemon) PeriodicallyInterruptHangedInvocations() {
 	signals := make(chan os.Signal, 2)
 	signal.Notify(signals, syscall.SIGTERM)
 
+	checkInterval := 10 * time.Second
+	if daemon.interruptTimeout/2 < checkInterval {
+		checkInterval = daemon.interruptTimeout / 2
+	}
+
 	for {
 		select {
 		case <-daemon.quitChan:
@@ -313,10 +821,10 @@ func (daemon *Daemon) PeriodicallyInterruptHangedInvocations() {
 				daemon.QuitDaemonGracefully("got sigterm")
 			}
 
-		case <-time.After(10 * time.Second):
+		case <-time.After(common.Jitter(checkInterval, 0.2)):
 			daemon.mu.Lock()
 			for _, invocation := range daemon.activeInvocations {
-				if time.Since(invocation.createTime) > timeoutForceInterruptInvocation {
+				if time.Since(invocation.createTime) > daemon.interruptTimeout {
 					invocation.ForceInterrupt(fmt.Errorf("interrupt sessionID %d (%s) after %d sec timeout", invocation.sessionID, invocation.summary.remoteHost, int(time.Since(invocation.createTime).Seconds())))
 				}
 			}
@@ -325,8 +833,71 @@ func (daemon *Daemon) PeriodicallyInterruptHangedInvocations() {
 	}
 }
 
+// PeriodicallySaveStateFiles saves changed state files while a daemon is alive, not only on graceful quit:
+// a daemon killed (or crashed) in the middle of a long build would lose all of it otherwise.
+func (daemon *Daemon) PeriodicallySaveStateFiles() {
+	if daemon.unchangedObjs == nil && daemon.compDB == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-daemon.quitChan:
+			return
+
+		case <-time.After(common.Jitter(stateFilesSaveInterval, 0.2)):
+			if daemon.compDB != nil {
+				if err := daemon.compDB.SaveIfChanged(); err != nil {
+					logClient.Error("could not save compilation database:", err)
+				}
+			}
+			if daemon.unchangedObjs != nil {
+				if err := daemon.unchangedObjs.SaveIfChanged(); err != nil {
+					logClient.Error("could not save unchanged objs:", err)
+				}
+			}
+		}
+	}
+}
+
+// PeriodicallyLogIncludesCacheStats logs sizes and evictions of includes caches while a daemon is alive:
+// a long-living daemon (on a CI agent, for example) quits rarely, and NOCC_INCLUDES_CACHE_LIMIT should be tuned before it.
+// Only caches changed since the previous time are logged.
+func (daemon *Daemon) PeriodicallyLogIncludesCacheStats() {
+	lastLogged := make(map[string]string)
+
+	for {
+		select {
+		case <-daemon.quitChan:
+			return
+
+		case <-time.After(common.Jitter(includesCacheStatsInterval, 0.2)):
+			daemon.logIncludesCacheStats(lastLogged)
+		}
+	}
+}
+
+// logIncludesCacheStats logs metrics of every includes cache, see IncludesCache.GetStats.
+// If lastLogged is not nil, caches whose metrics are the same as there are skipped, and it's updated.
+func (daemon *Daemon) logIncludesCacheStats(lastLogged map[string]string) {
+	daemon.mu.RLock()
+	defer daemon.mu.RUnlock()
+
+	for cacheKey, includesCache := range daemon.includesCache {
+		nHFiles, nResolves, sizeBytes, evictedCount := includesCache.GetStats()
+		stats := fmt.Sprintf("hFiles %d resolves %d sizeBytes %d evicted %d", nHFiles, nResolves, sizeBytes, evictedCount)
+		if lastLogged != nil {
+			if lastLogged[cacheKey] == stats {
+				continue
+			}
+			lastLogged[cacheKey] = stats
+		}
+		logClient.Info(0, "includes cache", cacheKey, stats)
+	}
+}
+
 func (daemon *Daemon) areAllRemotesAvailable() bool {
-	for _, remote := range daemon.remoteConnections {
+	for _, remote := range daemon.getRemoteConnections() {
 		if remote.isUnavailable {
 			return false
 		}
@@ -334,8 +905,71 @@ func (daemon *Daemon) areAllRemotesAvailable() bool {
 	return true
 }
 
-func (daemon *Daemon) chooseRemoteConnectionForCppCompilation(cppInFile string) *RemoteConnection {
+// isPartialOutage is true when some remotes are unavailable, but others are still up.
+func (daemon *Daemon) isPartialOutage() bool {
+	remoteConnections := daemon.getRemoteConnections()
+	nUnavailable := 0
+	for _, remote := range remoteConnections {
+		if remote.isUnavailable {
+			nUnavailable++
+		}
+	}
+	return nUnavailable > 0 && nUnavailable < len(remoteConnections)
+}
+
+// getRemoteConnections returns all remotes; a returned slice is never modified, it's safe to iterate over it.
+func (daemon *Daemon) getRemoteConnections() []*RemoteConnection {
+	daemon.remotesMu.RLock()
+	defer daemon.remotesMu.RUnlock()
+	return daemon.remoteConnections
+}
+
+func (daemon *Daemon) getAllRemotesDelim() string {
+	daemon.remotesMu.RLock()
+	defer daemon.remotesMu.RUnlock()
+	return daemon.allRemotesDelim
+}
+
+// chooseRemotesPoolForCppCompilation selects servers by the language of an input file:
+// .c/.i files are sent to NOCC_SERVERS_C, others to NOCC_SERVERS_CXX, falling back to NOCC_SERVERS if a pool isn't set.
+func (daemon *Daemon) chooseRemotesPoolForCppCompilation(cppInFile string) []*RemoteConnection {
+	daemon.remotesMu.RLock()
+	defer daemon.remotesMu.RUnlock()
+	remotesPool := daemon.remotesForCxx
+	if strings.HasSuffix(cppInFile, ".c") || strings.HasSuffix(cppInFile, ".i") {
+		remotesPool = daemon.remotesForC
+	}
+	if len(remotesPool) == 0 {
+		remotesPool = daemon.remotesDefault
+	}
+	return remotesPool
+}
+
+// chooseRemoteConnectionForCppCompilation selects a server from a pool by hashing a file name,
+// so that the same file is compiled on the same server (and hits its obj cache).
+// With NOCC_SORT_SERVERS, a pool is sorted by host:port, so a choice doesn't depend on the order servers are listed in.
+// With NOCC_SERVERS_AFFINITY_FILE, a server chosen once is kept for a file while it's in a pool, even if others come and go.
+// If NOCC_FORCE_SERVER is set (for reproducing server-specific issues), that server is chosen while it's available.
+func (daemon *Daemon) chooseRemoteConnectionForCppCompilation(remotesPool []*RemoteConnection, cppInFile string) *RemoteConnection {
+	if daemon.remoteForced != nil {
+		if !daemon.remoteForced.isUnavailable || len(remotesPool) == 0 {
+			return daemon.remoteForced
+		}
+		logClient.Info(0, "NOCC_FORCE_SERVER", daemon.remoteForced.remoteHost, "is unavailable, choosing a remote as usual for", cppInFile)
+	}
+
+	cppBaseName := filepath.Base(cppInFile)
+	if daemon.serversAffinity != nil {
+		if remote := daemon.serversAffinity.ChooseRemote(remotesPool, cppBaseName); remote != nil {
+			return remote
+		}
+	}
+
 	hasher := fnv.New32a()
-	_, _ = hasher.Write([]byte(filepath.Base(cppInFile)))
-	return daemon.remoteConnections[int(hasher.Sum32())%len(daemon.remoteConnections)]
+	_, _ = hasher.Write([]byte(cppBaseName))
+	remote := remotesPool[int(hasher.Sum32())%len(remotesPool)]
+	if daemon.serversAffinity != nil {
+		daemon.serversAffinity.Remember(cppBaseName, remote.remoteHostPort)
+	}
+	return remote
 }
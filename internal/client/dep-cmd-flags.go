@@ -83,7 +83,7 @@ func (deps *DepCmdFlags) GenerateAndSaveDepFile(invocation *Invocation, hFiles [
 		// > This option instructs CPP to add a phony target for each dependency other than the main file,
 		// > causing each to depend on nothing.
 		for idx, depStr := range depListMainTarget {
-			if idx > 0 { // 0 is cppInFile
+			if idx > 0 || invocation.cppInStdin { // 0 is cppInFile
 				depTargets = append(depTargets, DepFileTarget{escapeMakefileSpaces(depStr), nil})
 			}
 		}
@@ -93,6 +93,19 @@ func (deps *DepCmdFlags) GenerateAndSaveDepFile(invocation *Invocation, hFiles [
 		DTargets: depTargets,
 	}
 
+	// -MF /dev/stdout means stdout of a `nocc` process (not of a daemon), it's sent back in a response
+	switch depFileName {
+	case "/dev/stdout", "/dev/fd/1", "/proc/self/fd/1":
+		invocation.depFileStdout = depFile.WriteToBytes()
+		return depFileName, nil
+	case "/dev/stderr", "/dev/fd/2", "/proc/self/fd/2":
+		invocation.depFileStderr = depFile.WriteToBytes()
+		return depFileName, nil
+	}
+	// a relative -MF (or -o) is relative to cwd of `nocc` process, not of a daemon
+	if depFileName[0] != '/' {
+		return depFileName, depFile.WriteToFile(invocation.cwd + "/" + depFileName)
+	}
 	return depFileName, depFile.WriteToFile(depFileName)
 }
 
@@ -135,7 +148,9 @@ func (deps *DepCmdFlags) calcDepListFromHFiles(invocation *Invocation, hFiles []
 	}
 
 	depList := make([]string, 0, 1+len(hFiles))
-	depList = append(depList, quoteMakefileTarget(invocation.cppInFile))
+	if !invocation.cppInStdin { // like cxx does, stdin isn't listed
+		depList = append(depList, quoteMakefileTarget(invocation.cppInFile))
+	}
 	for _, hFile := range hFiles {
 		depList = append(depList, relFileName(hFile.fileName))
 	}
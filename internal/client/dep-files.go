@@ -6,6 +6,8 @@ import (
 	"os"
 	"strings"
 	"unicode"
+
+	"github.com/VKCOM/nocc/internal/common"
 )
 
 // DepFileTarget is one target in .o.d file:
@@ -66,10 +68,22 @@ func (dFile *DepFile) WriteToBytes() []byte {
 	return b.Bytes()
 }
 
-// WriteToFile outputs a filled dFile as .o.d file
+// WriteToFile outputs a filled dFile as .o.d file (via a temp file, so that it never appears partially written).
+// Pipes and devices (-MF /dev/null, a named pipe) can't be replaced by renaming, they are written directly.
 func (dFile *DepFile) WriteToFile(depFileName string) error {
 	asBytes := dFile.WriteToBytes()
-	return os.WriteFile(depFileName, asBytes, os.ModePerm)
+	if stat, err := os.Stat(depFileName); err == nil && !stat.Mode().IsRegular() {
+		f, err := os.OpenFile(depFileName, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(asBytes)
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		return err
+	}
+	return common.WriteFileViaTempFile(depFileName, asBytes)
 }
 
 // parseSkipSpaces moves offset to the first non-space
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isDirectivesOnlySupported detects whether cxx can preprocess with -fdirectives-only (gcc can, clang can't).
+func isDirectivesOnlySupported(cxxName string) bool {
+	return !strings.Contains(path.Base(cxxName), "clang")
+}
+
+// preprocessDirectivesOnly is a middle ground when own includes parser gives up (on #include MACRO):
+// instead of compiling locally, a local preprocessor expands only #include-s (it's much faster than compilation),
+// and the result is sent to a remote as a single file with all dependencies inside, compiled with -fpreprocessed.
+// Macros are left unexpanded (with their #define-s), so an obj is the same as if compiled from sources.
+// A preprocessed file is saved into tmpDir, it's named like cppInFile (its basename is a part of obj cache key).
+func (invocation *Invocation) preprocessDirectivesOnly(cwd string, tmpDir string) (ppFile IncludedFile, depHFiles []*IncludedFile, err error) {
+	ext := ".ii"
+	if strings.HasSuffix(invocation.cppInFile, ".c") {
+		ext = ".i"
+	}
+	ppFileName := filepath.Join(tmpDir, strings.TrimSuffix(path.Base(invocation.cppInFile), path.Ext(invocation.cppInFile))+ext)
+	if ppFile, depHFiles, err = invocation.preprocessLocallyToSingleFile(cwd, ppFileName, "-fdirectives-only"); err != nil {
+		return
+	}
+
+	invocation.directivesOnlyFile = ppFileName
+	return
+}
+
+// preprocessLocallyToSingleFile launches `cxx -E {ppArgs}` locally saving output to ppFileName.
+// It's used when own includes parser gives up, see preprocessDirectivesOnly and preprocessRewriteIncludes.
+// Dependencies reported by the preprocessor are returned for a depfile; they are not uploaded.
+func (invocation *Invocation) preprocessLocallyToSingleFile(cwd string, ppFileName string, ppArgs ...string) (ppFile IncludedFile, depHFiles []*IncludedFile, err error) {
+	depFileName := ppFileName + ".d"
+
+	cxxCmdLine := make([]string, 0, len(invocation.cxxArgs)+2*invocation.cxxIDirs.Count()+len(ppArgs)+10)
+	cxxCmdLine = append(cxxCmdLine, invocation.cxxArgs...)
+	cxxCmdLine = append(cxxCmdLine, invocation.cxxIDirs.AsCxxArgs()...)
+	cxxCmdLine = append(cxxCmdLine, "-E")
+	cxxCmdLine = append(cxxCmdLine, ppArgs...)
+	cxxCmdLine = append(cxxCmdLine, "-MD", "-MF", depFileName, "-MT", "nocc-preprocessed.o", "-o", ppFileName, invocation.GetCppInFileAbs(cwd))
+
+	cxxCommand := exec.Command(invocation.cxxName, cxxCmdLine...)
+	cxxCommand.Dir = cwd
+	var cxxStderr bytes.Buffer
+	cxxCommand.Stderr = &cxxStderr
+	if err = cxxCommand.Run(); err != nil {
+		err = fmt.Errorf("%s -E %s exited with code %d: %s", invocation.cxxName, strings.Join(ppArgs, " "), cxxCommand.ProcessState.ExitCode(), cxxStderr.String())
+		return
+	}
+
+	var contents []byte
+	ppFile.fileName = ppFileName
+	if ppFile.fileSHA256, contents, err = CalcSHA256OfFileName(ppFileName, nil); err != nil {
+		return
+	}
+	ppFile.fileSize = int64(len(contents))
+
+	if depContents, err := os.ReadFile(depFileName); err == nil {
+		for _, hFileName := range extractIncludesFromCxxMStdout(cwd, depContents) {
+			depHFiles = append(depHFiles, &IncludedFile{fileName: hFileName})
+		}
+	}
+	return
+}
@@ -38,7 +38,7 @@ func (fr *FilesReceiving) CreateReceiveStream() error {
 		return err
 	}
 
-	go fr.monitorRemoteStreamForObjReceiving(stream, cancelFunc)
+	go fr.monitorRemoteStreamForObjReceiving(stream, cancelFunc, time.Now())
 	return nil
 }
 
@@ -47,8 +47,17 @@ func (fr *FilesReceiving) RecreateReceiveStreamOrQuit(failedStreamCancelFunc con
 	logClient.Error("recreate recv stream:", err)
 	time.Sleep(100 * time.Millisecond)
 
-	if err := fr.CreateReceiveStream(); err != nil {
-		fr.daemon.OnRemoteBecameUnavailable(fr.grpcClient.remoteHostPort, err)
+	// if the remote is being restarted right now, it will be available soon, so retry a bit, like on daemon start
+	for attempt := 1; ; attempt++ {
+		err := fr.CreateReceiveStream()
+		if err == nil || fr.grpcClient.callContext.Err() != nil {
+			return
+		}
+		if attempt >= fr.daemon.connectAttempts {
+			fr.daemon.OnRemoteBecameUnavailable(fr.grpcClient.remoteHostPort, err)
+			return
+		}
+		time.Sleep(common.Jitter(time.Duration(attempt)*500*time.Millisecond, 0.5))
 	}
 }
 
@@ -57,25 +66,27 @@ func (fr *FilesReceiving) RecreateReceiveStreamOrQuit(failedStreamCancelFunc con
 // One stream is used to receive multiple .o files consecutively.
 // If cxx compilation exits with non-zero code, the same stream is used to send error details.
 // See RemoteConnection.WaitForCompiledObj.
-func (fr *FilesReceiving) monitorRemoteStreamForObjReceiving(stream pb.CompilationService_RecvCompiledObjStreamClient, cancelFunc context.CancelFunc) {
+func (fr *FilesReceiving) monitorRemoteStreamForObjReceiving(stream pb.CompilationService_RecvCompiledObjStreamClient, cancelFunc context.CancelFunc, streamStartTime time.Time) {
 	for {
 		firstChunk, err := stream.Recv()
 
 		// such complexity of error handling prevents hanging sessions and proper stream recreation
 		if err != nil {
-			// when a daemon quits, all streams are automatically closed
+			// when a daemon quits (or a remote is removed from servers list), all streams are automatically closed
 			select {
 			case <-fr.daemon.quitChan:
 				return
+			case <-fr.grpcClient.callContext.Done():
+				return
 			default:
 				break
 			}
 
 			// grpc stream creation doesn't wait for ack, that's why
 			// if a stream couldn't be created at all, we know this only on Recv() failure
+			// if it's because the remote was restarted, register again and recreate the stream below
 			if st, ok := status.FromError(err); ok {
-				if st.Code() == codes.Unauthenticated {
-					fr.daemon.OnRemoteBecameUnavailable(fr.grpcClient.remoteHostPort, err)
+				if st.Code() == codes.Unauthenticated && !fr.daemon.OnRemoteForgotClient(fr.grpcClient.remoteHostPort, streamStartTime) {
 					return
 				}
 			}
@@ -112,19 +123,28 @@ func (fr *FilesReceiving) monitorRemoteStreamForObjReceiving(stream pb.Compilati
 			continue
 		}
 
+		// a crashed compiler on a remote (segfault, OOM killer) says nothing about a source, so an invocation is errored
+		// to be compiled locally, not to show a build system a bogus failure
+		if firstChunk.CxxKilledBySignal != 0 {
+			invocation.DoneRecvObj(fmt.Errorf("compiler killed by signal %d on server %s", firstChunk.CxxKilledBySignal, invocation.summary.remoteHost))
+			continue
+		}
+
 		invocation.cxxExitCode = int(firstChunk.CxxExitCode)
 		invocation.cxxStdout = firstChunk.CxxStdout
 		invocation.cxxStderr = firstChunk.CxxStderr
 		invocation.cxxDuration = firstChunk.CxxDuration
+		invocation.fromObjCache = firstChunk.FromObjCache
 		invocation.summary.nBytesReceived += int(firstChunk.FileSize)
 
 		// non-zero cxxExitCode means a bug in cpp source code and doesn't require local fallback
-		if firstChunk.CxxExitCode != 0 {
+		// (with -fsyntax-only, there is no obj to receive at all)
+		if firstChunk.CxxExitCode != 0 || invocation.syntaxOnly {
 			invocation.DoneRecvObj(nil)
 			continue
 		}
 
-		err, needRecreateStream := receiveObjFileByChunks(stream, firstChunk, invocation.objOutFile)
+		err, needRecreateStream := receiveObjFileByChunks(stream, firstChunk, invocation.GetObjOutFileAbs(invocation.cwd))
 		invocation.DoneRecvObj(err)
 
 		// recreate a stream if it's corrupted, like chunks mismatch
@@ -141,15 +161,20 @@ func (fr *FilesReceiving) monitorRemoteStreamForObjReceiving(stream pb.Compilati
 // receiveObjFileByChunks is an actual implementation of saving a server stream to a local client .o file.
 // See server.sendObjFileByChunks.
 func receiveObjFileByChunks(stream pb.CompilationService_RecvCompiledObjStreamClient, firstChunk *pb.RecvCompiledObjChunkReply, objOutFile string) (error, bool) {
-	receivedBytes := len(firstChunk.ChunkBody)
-	expectedBytes := int(firstChunk.FileSize)
-
 	var errWrite error
 	var errRecv error
 
+	if firstChunk.Gzipped {
+		if firstChunk.ChunkBody, errRecv = common.GunzipTransferChunk(firstChunk.ChunkBody, int(firstChunk.FileSize)); errRecv != nil {
+			return errRecv, true
+		}
+	}
+	receivedBytes := len(firstChunk.ChunkBody)
+	expectedBytes := int(firstChunk.FileSize)
+
 	if receivedBytes >= expectedBytes {
 		// if a dir for objOutFile doesn't exist, it will fail; g++/clang act the same
-		errWrite = os.WriteFile(objOutFile, firstChunk.ChunkBody, os.ModePerm)
+		errWrite = common.WriteFileViaTempFile(objOutFile, firstChunk.ChunkBody)
 		return errWrite, false
 	}
 
@@ -164,6 +189,11 @@ func receiveObjFileByChunks(stream pb.CompilationService_RecvCompiledObjStreamCl
 		if errRecv != nil { // EOF is also unexpected
 			break
 		}
+		if nextChunk.Gzipped {
+			if nextChunk.ChunkBody, errRecv = common.GunzipTransferChunk(nextChunk.ChunkBody, expectedBytes-receivedBytes); errRecv != nil {
+				break
+			}
+		}
 		if errWrite == nil {
 			_, errWrite = fileTmp.Write(nextChunk.ChunkBody)
 		}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"io"
 	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/VKCOM/nocc/internal/common"
 	"github.com/VKCOM/nocc/pb"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -15,6 +17,7 @@ type fileUploadReq struct {
 	invocation *Invocation
 	file       *pb.FileMetadata
 	fileIndex  uint32
+	isRetry    bool // a file is re-uploaded once if a stream was broken by a remote restart
 }
 
 // FilesUploading is a singleton inside Daemon that holds a bunch of grpc streams to upload .cpp/.h files.
@@ -23,13 +26,15 @@ type FilesUploading struct {
 	daemon       *Daemon
 	grpcClient   *GRPCClient
 	chanToUpload chan fileUploadReq
+
+	compress int32 // 1 if a remote agreed to receive gzipped chunks, see RemoteConnection.sendStartClient
 }
 
 func MakeFilesUploading(daemon *Daemon, grpcClient *GRPCClient) *FilesUploading {
 	return &FilesUploading{
 		daemon:       daemon,
 		grpcClient:   grpcClient,
-		chanToUpload: make(chan fileUploadReq, 50),
+		chanToUpload: make(chan fileUploadReq, daemon.transferTuning.UploadQueueSize),
 	}
 }
 
@@ -41,7 +46,7 @@ func (fu *FilesUploading) CreateUploadStream() error {
 		return err
 	}
 
-	go fu.monitorClientChanForFileUploading(stream, cancelFunc)
+	go fu.monitorClientChanForFileUploading(stream, cancelFunc, time.Now())
 	return nil
 }
 
@@ -50,8 +55,17 @@ func (fu *FilesUploading) RecreateUploadStreamOrQuit(failedStreamCancelFunc cont
 	logClient.Error("recreate upload stream:", err)
 	time.Sleep(100 * time.Millisecond)
 
-	if err := fu.CreateUploadStream(); err != nil {
-		fu.daemon.OnRemoteBecameUnavailable(fu.grpcClient.remoteHostPort, err)
+	// if the remote is being restarted right now, it will be available soon, so retry a bit, like on daemon start
+	for attempt := 1; ; attempt++ {
+		err := fu.CreateUploadStream()
+		if err == nil {
+			return
+		}
+		if attempt >= fu.daemon.connectAttempts {
+			fu.daemon.OnRemoteBecameUnavailable(fu.grpcClient.remoteHostPort, err)
+			return
+		}
+		time.Sleep(common.Jitter(time.Duration(attempt)*500*time.Millisecond, 0.5))
 	}
 }
 
@@ -65,14 +79,17 @@ func (fu *FilesUploading) StartUploadingFileToRemote(invocation *Invocation, fil
 
 // monitorClientChanForFileUploading listens to chanToUpload and uploads it via stream.
 // One grpc stream is used to upload multiple files consecutively.
-func (fu *FilesUploading) monitorClientChanForFileUploading(stream pb.CompilationService_UploadFileStreamClient, cancelFunc context.CancelFunc) {
-	chunkBuf := make([]byte, 64*1024) // reusable chunk for file reading, exists until stream close
+func (fu *FilesUploading) monitorClientChanForFileUploading(stream pb.CompilationService_UploadFileStreamClient, cancelFunc context.CancelFunc, streamStartTime time.Time) {
+	chunkBuf := make([]byte, fu.daemon.transferTuning.ChunkSize) // reusable chunk for file reading, exists until stream close
 
 	for {
 		select {
 		case <-fu.daemon.quitChan:
 			return
 
+		case <-fu.grpcClient.callContext.Done(): // a remote was removed from servers list
+			return
+
 		case req := <-fu.chanToUpload:
 			logClient.Info(2, "start uploading", req.file.FileSize, req.file.ClientFileName)
 			if req.file.FileSize > 64*1024 {
@@ -80,7 +97,8 @@ func (fu *FilesUploading) monitorClientChanForFileUploading(stream pb.Compilatio
 			}
 
 			invocation := req.invocation
-			err := uploadFileByChunks(stream, chunkBuf, req.file.ClientFileName, fu.daemon.clientID, invocation.sessionID, req.fileIndex)
+			compress := atomic.LoadInt32(&fu.compress) == 1 && common.ShouldCompressTransfer(req.file.ClientFileName, req.file.FileSize)
+			err := uploadFileByChunks(stream, chunkBuf, req.file.ClientFileName, fu.daemon.clientID, invocation.sessionID, req.fileIndex, compress)
 
 			// such complexity of error handling prevents hanging sessions and proper stream recreation
 			if err != nil {
@@ -92,13 +110,20 @@ func (fu *FilesUploading) monitorClientChanForFileUploading(stream pb.Compilatio
 					break
 				}
 
-				// if something goes completely wrong and stream recreation fails, mark this remote as unavailable
+				// if the remote was restarted, register again; if it fails, mark this remote as unavailable
 				// see FilesReceiving for a comment about this error code
-				if st, ok := status.FromError(err); ok {
-					if st.Code() == codes.Unauthenticated {
-						fu.daemon.OnRemoteBecameUnavailable(fu.grpcClient.remoteHostPort, err)
-						return
-					}
+				code := status.Code(err)
+				if code == codes.Unauthenticated && !fu.daemon.OnRemoteForgotClient(fu.grpcClient.remoteHostPort, streamStartTime) {
+					invocation.DoneUploadFile(err)
+					return
+				}
+
+				// if a stream was broken by a remote restart, upload this file again via a new stream
+				if (code == codes.Unauthenticated || code == codes.Unavailable) && !req.isRetry {
+					fu.RecreateUploadStreamOrQuit(cancelFunc, err)
+					req.isRetry = true
+					fu.chanToUpload <- req
+					return
 				}
 
 				// if some error occurred, the stream could be left in the middle of uploading
@@ -124,7 +149,8 @@ func (fu *FilesUploading) monitorClientChanForFileUploading(stream pb.Compilatio
 
 // uploadFileByChunks is an actual implementation of piping a local client file to a server stream.
 // See server.receiveUploadedFileByChunks.
-func uploadFileByChunks(stream pb.CompilationService_UploadFileStreamClient, chunkBuf []byte, clientFileName string, clientID string, sessionID uint32, fileIndex uint32) error {
+// If compress, chunks are gzipped (those that shrink), see common.ShouldCompressTransfer.
+func uploadFileByChunks(stream pb.CompilationService_UploadFileStreamClient, chunkBuf []byte, clientFileName string, clientID string, sessionID uint32, fileIndex uint32, compress bool) error {
 	fd, err := os.Open(clientFileName)
 	if err != nil {
 		return err
@@ -143,12 +169,21 @@ func uploadFileByChunks(stream pb.CompilationService_UploadFileStreamClient, chu
 		}
 		sentChunks++
 
-		err = stream.Send(&pb.UploadFileChunkRequest{
+		chunk := &pb.UploadFileChunkRequest{
 			ClientID:  clientID,
 			SessionID: sessionID,
 			FileIndex: fileIndex,
 			ChunkBody: chunkBuf[:n],
-		})
+		}
+		if compress {
+			if gzipped, ok := common.GzipTransferChunk(chunkBuf[:n]); ok {
+				chunk.ChunkBody, chunk.Gzipped = gzipped, true
+			}
+		}
+		err = stream.Send(chunk)
+		if err == io.EOF { // the stream was closed, an actual error is returned by Recv
+			_, err = stream.Recv()
+		}
 		if err != nil {
 			return err
 		}
@@ -2,10 +2,17 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"time"
 
+	"github.com/VKCOM/nocc/internal/common"
 	"github.com/VKCOM/nocc/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 )
 
 type GRPCClient struct {
@@ -16,13 +23,116 @@ type GRPCClient struct {
 	pb             pb.CompilationServiceClient
 }
 
-func MakeGRPCClient(remoteHostPort string) (*GRPCClient, error) {
+// TransferTuning contains sizes of buffers and windows for uploading sources and receiving objs.
+// Defaults fit a usual LAN; for 10/25GbE links or WAN, they may be increased, see docs/configuration.md.
+type TransferTuning struct {
+	ChunkSize       int  // files are uploaded by chunks of this size (objs are received by chunks of a server's -chunk-size)
+	UploadQueueSize int  // files waiting for being uploaded to one remote
+	GrpcWindowSize  int  // initial window for a stream and a connection, 0 means a dynamic grpc window
+	GrpcMaxMsgSize  int  // max size of a received message, 0 means a grpc default (4M); should be more than a server's -chunk-size
+	Compress        bool // gzip chunks of sources and objs, if a server supports it, see common.ShouldCompressTransfer
+
+	GrpcKeepalive time.Duration // ping a remote after this idle time, 0 means no pings (a server pings clients anyway)
+}
+
+func MakeDefaultTransferTuning() TransferTuning {
+	return TransferTuning{
+		ChunkSize:       64 * 1024,
+		UploadQueueSize: 50,
+	}
+}
+
+// Validate checks sizes set via env on a daemon start: a mistyped value must fail clearly,
+// not break every upload later (a zero chunk size, for example, would never move a byte).
+func (tuning *TransferTuning) Validate() error {
+	if tuning.ChunkSize <= 0 {
+		return fmt.Errorf("invalid NOCC_CHUNK_SIZE=%d: should be positive", tuning.ChunkSize)
+	}
+	if tuning.UploadQueueSize <= 0 {
+		return fmt.Errorf("invalid NOCC_UPLOAD_QUEUE_SIZE=%d: should be positive", tuning.UploadQueueSize)
+	}
+	// grpc silently ignores a window less than 64K
+	if tuning.GrpcWindowSize != 0 && (tuning.GrpcWindowSize < 64*1024 || tuning.GrpcWindowSize > math.MaxInt32) {
+		return fmt.Errorf("invalid NOCC_GRPC_WINDOW_SIZE=%d: should be 0 (dynamic) or from 64K to 2G", tuning.GrpcWindowSize)
+	}
+	if tuning.GrpcMaxMsgSize < 0 {
+		return fmt.Errorf("invalid NOCC_GRPC_MAX_MSG_SIZE=%d: should be 0 (a grpc default) or positive", tuning.GrpcMaxMsgSize)
+	}
+	// servers usually have the same -chunk-size, objs sent by such chunks would never be received
+	if tuning.GrpcMaxMsgSize > 0 && tuning.GrpcMaxMsgSize < tuning.ChunkSize {
+		return fmt.Errorf("NOCC_GRPC_MAX_MSG_SIZE=%d is less than NOCC_CHUNK_SIZE=%d: it should be more than servers' -chunk-size", tuning.GrpcMaxMsgSize, tuning.ChunkSize)
+	}
+	if tuning.GrpcKeepalive < 0 {
+		return fmt.Errorf("invalid NOCC_GRPC_KEEPALIVE: should be 0 (no pings) or positive")
+	}
+	return nil
+}
+
+func (tuning *TransferTuning) grpcDialOptions() []grpc.DialOption {
+	var opts []grpc.DialOption
+	if tuning.GrpcWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(int32(tuning.GrpcWindowSize)), grpc.WithInitialConnWindowSize(int32(tuning.GrpcWindowSize)))
+	}
+	if tuning.GrpcMaxMsgSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(tuning.GrpcMaxMsgSize)))
+	}
+	if tuning.GrpcKeepalive > 0 {
+		// idle streams are kept alive through NATs, and a dead remote is detected before the next use of a stream
+		// (without an ack in Timeout, a connection is closed, and streams are recreated)
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                tuning.GrpcKeepalive,
+			Timeout:             20 * time.Second,
+			PermitWithoutStream: true,
+		}))
+	}
+	return opts
+}
+
+// transportCredentials are used for all connections to remotes (from a daemon and from -check-servers and similar):
+// plaintext by default, TLS if set up by SetupTLS
+var transportCredentials = insecure.NewCredentials()
+
+// SetupTLS is NOCC_TLS_CA, NOCC_TLS_CLIENT_CERT and NOCC_TLS_CLIENT_KEY: if any is set, remotes are connected via TLS.
+// A server certificate is verified against caFile (system roots if empty); with a client cert, it's mutual TLS,
+// and a server launched with -tls-client-ca rejects connections without a valid one during a handshake.
+// If all are empty, connections are plaintext again.
+func SetupTLS(caFile string, clientCertFile string, clientKeyFile string) error {
+	if caFile == "" && clientCertFile == "" && clientKeyFile == "" {
+		transportCredentials = insecure.NewCredentials()
+		return nil
+	}
+	if (clientCertFile == "") != (clientKeyFile == "") {
+		return fmt.Errorf("NOCC_TLS_CLIENT_CERT and NOCC_TLS_CLIENT_KEY should be set together")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		certPool, err := common.LoadCertPool(caFile)
+		if err != nil {
+			return fmt.Errorf("invalid NOCC_TLS_CA: %v", err)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	if clientCertFile != "" {
+		clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			return fmt.Errorf("invalid NOCC_TLS_CLIENT_CERT/NOCC_TLS_CLIENT_KEY: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	transportCredentials = credentials.NewTLS(tlsConfig)
+	return nil
+}
+
+func MakeGRPCClient(remoteHostPort string, dialOptions ...grpc.DialOption) (*GRPCClient, error) {
 	// this connection is non-blocking: it's created immediately
 	// if the remote is not available, it will fail on request
 	connection, err := grpc.Dial(
 		remoteHostPort,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithDefaultCallOptions(),
+		append([]grpc.DialOption{
+			grpc.WithTransportCredentials(transportCredentials),
+			grpc.WithDefaultCallOptions(),
+		}, dialOptions...)...,
 	)
 	if err != nil {
 		return nil, err
@@ -40,12 +150,10 @@ func MakeGRPCClient(remoteHostPort string) (*GRPCClient, error) {
 
 func (grpcClient *GRPCClient) Clear() {
 	if grpcClient.connection != nil {
+		// callContext and pb are left: goroutines still using them get errors (not nil pointers) and see callContext is done
 		grpcClient.cancelFunc()
 		_ = grpcClient.connection.Close()
 
 		grpcClient.connection = nil
-		grpcClient.callContext = nil
-		grpcClient.cancelFunc = nil
-		grpcClient.pb = nil
 	}
 }
@@ -5,6 +5,7 @@ type IncludeDirs struct {
 	dirsI       []string // -I dir
 	dirsIquote  []string // -iquote dir
 	dirsIsystem []string // -isystem dir
+	dirsAfter   []string // -idirafter dir (and clang's -isystem-after dir), searched after all others, even after default dirs
 	filesI      []string // -include file
 }
 
@@ -18,11 +19,11 @@ func MakeIncludeDirs() IncludeDirs {
 }
 
 func (dirs *IncludeDirs) IsEmpty() bool {
-	return len(dirs.dirsI) == 0 && len(dirs.dirsIquote) == 0 && len(dirs.dirsIsystem) == 0
+	return len(dirs.dirsI) == 0 && len(dirs.dirsIquote) == 0 && len(dirs.dirsIsystem) == 0 && len(dirs.dirsAfter) == 0
 }
 
 func (dirs *IncludeDirs) Count() int {
-	return len(dirs.dirsI) + len(dirs.dirsIquote) + len(dirs.dirsIsystem) + len(dirs.filesI)
+	return len(dirs.dirsI) + len(dirs.dirsIquote) + len(dirs.dirsIsystem) + len(dirs.dirsAfter) + len(dirs.filesI)
 }
 
 func (dirs *IncludeDirs) AsCxxArgs() []string {
@@ -37,6 +38,9 @@ func (dirs *IncludeDirs) AsCxxArgs() []string {
 	for _, dir := range dirs.dirsIsystem {
 		cxxIArgs = append(cxxIArgs, "-isystem", dir)
 	}
+	for _, dir := range dirs.dirsAfter {
+		cxxIArgs = append(cxxIArgs, "-idirafter", dir)
+	}
 	for _, file := range dirs.filesI {
 		cxxIArgs = append(cxxIArgs, "-include", file)
 	}
@@ -48,5 +52,6 @@ func (dirs *IncludeDirs) MergeWith(other IncludeDirs) {
 	dirs.dirsI = append(dirs.dirsI, other.dirsI...)
 	dirs.dirsIquote = append(dirs.dirsIquote, other.dirsIquote...)
 	dirs.dirsIsystem = append(dirs.dirsIsystem, other.dirsIsystem...)
+	dirs.dirsAfter = append(dirs.dirsAfter, other.dirsAfter...)
 	dirs.filesI = append(dirs.filesI, other.filesI...)
 }
@@ -1,15 +1,55 @@
 package client
 
 import (
+	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/VKCOM/nocc/internal/common"
 )
 
 type includeCachedHFile struct {
-	fileSize       int64         // size of file; -1 means that a file doesn't exist
+	fileSize       int64         // size of file; -1 means that a file doesn't exist (or can't be used, like a corrupted pch)
 	fileSHA256     common.SHA256 // hash of contents (but for pch it's a combined hash of dependencies)
+	fileMTime      time.Time     // to detect that a file was changed (e.g. regenerated) while the daemon is running
 	nestedIncludes []string      // [ /abs/path/to/sub/included_file.h, ... ] in order of appearance
+	lastAccess     int64         // unix nanos, updated atomically on every hit, see IncludesCache.evictLeastRecentlyUsed
+}
+
+type includeCachedResolve struct {
+	hFileName  string // /actual/path/to/math.h or "NO"
+	lastAccess int64
+}
+
+// estimated memory for map entries and struct headers, in addition to lengths of strings
+// (it's not exact, but NOCC_INCLUDES_CACHE_LIMIT is a soft budget anyway)
+const (
+	includeCachedResolveOverhead = 96
+	includeCachedHFileOverhead   = 160
+	nestedIncludeOverhead        = 16
+)
+
+func (hFileCached *includeCachedHFile) estimateBytes(hFileName string) int64 {
+	bytes := int64(includeCachedHFileOverhead + len(hFileName))
+	for _, nested := range hFileCached.nestedIncludes {
+		bytes += int64(nestedIncludeOverhead + len(nested))
+	}
+	return bytes
+}
+
+func (resolve *includeCachedResolve) estimateBytes(quotedArg string) int64 {
+	return int64(includeCachedResolveOverhead + len(quotedArg) + len(resolve.hFileName))
+}
+
+// isActual checks that a file wasn't changed since it had been cached: it's much cheaper than reading and hashing.
+func (hFileCached *includeCachedHFile) isActual(hFileName string) bool {
+	stat, err := os.Stat(hFileName)
+	if err != nil {
+		return hFileCached.fileSize == -1
+	}
+	return stat.ModTime().Equal(hFileCached.fileMTime) && (hFileCached.fileSize == -1 || stat.Size() == hFileCached.fileSize)
 }
 
 // IncludesCache represents a structure that is kept in memory while the daemon is running.
@@ -19,22 +59,42 @@ type IncludesCache struct {
 	cxxName string
 	// default include dirs for current cxxName
 	cxxDefIDirs IncludeDirs
+	// prefixes (with a trailing slash) of dirs whose headers are cached like -isystem ones, env NOCC_CACHEABLE_INCLUDE_DIRS
+	cacheableDirs []string
+	// prefixes of dirs with generated headers (Qt's ui_*.h, protobuf's *.pb.h), env NOCC_GENERATED_INCLUDE_DIRS:
+	// their contents are cached (and revalidated by mtime, as they are regenerated), but not how <arg> is resolved to them,
+	// since such dirs are usually inside a build dir, and <ui_form.h> is a different file for another build dir
+	generatedDirs []string
+	// own includes parser guards (0 means no limit), env NOCC_OWN_INCLUDES_MAX_DEPTH / NOCC_OWN_INCLUDES_MAX_FILES
+	ownIncludesMaxDepth int
+	ownIncludesMaxFiles int
 	// how #include <math.h> is resolved to an /actual/path/to/math.h
-	includesResolve map[string]string
+	includesResolve map[string]*includeCachedResolve
 	// properties of /actual/path/to/math.h (file/sha256 and nested #include list)
 	hFilesInfo map[string]*includeCachedHFile
 
+	// a soft budget of estimated memory for entries above, env NOCC_INCLUDES_CACHE_LIMIT (0 means no limit):
+	// on a huge monorepo, a long-living daemon (e.g. on a CI agent) could otherwise grow unbounded
+	limitBytes   int64
+	sizeBytes    int64
+	evictedCount int64
+
 	mu sync.RWMutex
 }
 
-func MakeIncludesCache(cxxName string) (*IncludesCache, error) {
-	cxxDefIDirs, err := GetDefaultCxxIncludeDirsOnLocal(cxxName)
+func MakeIncludesCache(cxxName string, cxxDirsB []string, cxxSpecsFiles []string, cxxArchFlags []string, cacheableDirs []string, generatedDirs []string, ownIncludesMaxDepth int, ownIncludesMaxFiles int, limitBytes int64) (*IncludesCache, error) {
+	cxxDefIDirs, err := GetDefaultCxxIncludeDirsOnLocal(cxxName, cxxDirsB, cxxSpecsFiles, cxxArchFlags)
 
 	return &IncludesCache{
-		cxxName:         cxxName,
-		cxxDefIDirs:     cxxDefIDirs,
-		includesResolve: make(map[string]string),
-		hFilesInfo:      make(map[string]*includeCachedHFile),
+		cxxName:             cxxName,
+		cxxDefIDirs:         cxxDefIDirs,
+		cacheableDirs:       cacheableDirs,
+		generatedDirs:       generatedDirs,
+		ownIncludesMaxDepth: ownIncludesMaxDepth,
+		ownIncludesMaxFiles: ownIncludesMaxFiles,
+		includesResolve:     make(map[string]*includeCachedResolve),
+		hFilesInfo:          make(map[string]*includeCachedHFile),
+		limitBytes:          limitBytes,
 	}, err
 }
 
@@ -44,27 +104,60 @@ func (incCache *IncludesCache) GetIncludeResolve(quotedArg string) (hFileName st
 		return
 	}
 	incCache.mu.RLock()
-	hFileName, exists = incCache.includesResolve[quotedArg]
+	resolve, exists := incCache.includesResolve[quotedArg]
 	incCache.mu.RUnlock()
+	if exists {
+		atomic.StoreInt64(&resolve.lastAccess, time.Now().UnixNano())
+		hFileName = resolve.hFileName
+	}
 	return
 }
 
 func (incCache *IncludesCache) AddIncludeResolve(quotedArg string, hFileName string) {
+	resolve := &includeCachedResolve{hFileName, time.Now().UnixNano()}
 	incCache.mu.Lock()
-	incCache.includesResolve[quotedArg] = hFileName
+	if prev, exists := incCache.includesResolve[quotedArg]; exists {
+		incCache.sizeBytes -= prev.estimateBytes(quotedArg)
+	}
+	incCache.includesResolve[quotedArg] = resolve
+	incCache.sizeBytes += resolve.estimateBytes(quotedArg)
+	incCache.evictIfExceedsLimit()
 	incCache.mu.Unlock()
 }
 
+// GetHFileInfo returns cached info about a file if it wasn't changed since then.
+// If it was, it's removed from cache, and the caller is expected to process it again and call AddHFileInfo.
 func (incCache *IncludesCache) GetHFileInfo(hFileName string) (hFileCached *includeCachedHFile, exists bool) {
 	incCache.mu.RLock()
 	hFileCached, exists = incCache.hFilesInfo[hFileName]
 	incCache.mu.RUnlock()
+
+	if exists && !hFileCached.isActual(hFileName) {
+		logClient.Info(1, "cached file changed, process it again", hFileName)
+		incCache.mu.Lock()
+		if incCache.hFilesInfo[hFileName] == hFileCached {
+			delete(incCache.hFilesInfo, hFileName)
+			incCache.sizeBytes -= hFileCached.estimateBytes(hFileName)
+		}
+		incCache.mu.Unlock()
+		return nil, false
+	}
+	if exists {
+		atomic.StoreInt64(&hFileCached.lastAccess, time.Now().UnixNano())
+	}
 	return
 }
 
-func (incCache *IncludesCache) AddHFileInfo(hFileName string, fileSize int64, fileSHA256 common.SHA256, nestedIncludes []string) {
+// AddHFileInfo saves info about a file; fileMTime should be taken before reading a file, not to miss concurrent changes.
+func (incCache *IncludesCache) AddHFileInfo(hFileName string, fileSize int64, fileSHA256 common.SHA256, fileMTime time.Time, nestedIncludes []string) {
+	hFileCached := &includeCachedHFile{fileSize, fileSHA256, fileMTime, nestedIncludes, time.Now().UnixNano()}
 	incCache.mu.Lock()
-	incCache.hFilesInfo[hFileName] = &includeCachedHFile{fileSize, fileSHA256, nestedIncludes}
+	if prev, exists := incCache.hFilesInfo[hFileName]; exists {
+		incCache.sizeBytes -= prev.estimateBytes(hFileName)
+	}
+	incCache.hFilesInfo[hFileName] = hFileCached
+	incCache.sizeBytes += hFileCached.estimateBytes(hFileName)
+	incCache.evictIfExceedsLimit()
 	incCache.mu.Unlock()
 }
 
@@ -75,9 +168,60 @@ func (incCache *IncludesCache) Count() int {
 	return count
 }
 
+// GetStats returns metrics of a cache: a number of entries, their estimated memory and how many were evicted by the limit.
+func (incCache *IncludesCache) GetStats() (nHFiles int, nResolves int, sizeBytes int64, evictedCount int64) {
+	incCache.mu.RLock()
+	defer incCache.mu.RUnlock()
+	return len(incCache.hFilesInfo), len(incCache.includesResolve), incCache.sizeBytes, incCache.evictedCount
+}
+
 func (incCache *IncludesCache) Clear() {
 	incCache.mu.Lock()
-	incCache.includesResolve = make(map[string]string)
+	incCache.includesResolve = make(map[string]*includeCachedResolve)
 	incCache.hFilesInfo = make(map[string]*includeCachedHFile)
+	incCache.sizeBytes = 0
 	incCache.mu.Unlock()
 }
+
+// evictIfExceedsLimit is called under a locked mutex after adding an entry.
+// When a limit is exceeded, the least recently used entries (of both maps) are evicted until 90% of it is reached,
+// so that sorting all entries happens rarely, not on every next add.
+// Evicted entries are just calculated again when needed, so it's safe to evict anything in the middle of parsing.
+func (incCache *IncludesCache) evictIfExceedsLimit() {
+	if incCache.limitBytes <= 0 || incCache.sizeBytes <= incCache.limitBytes {
+		return
+	}
+
+	type lruEntry struct {
+		key        string
+		isResolve  bool
+		lastAccess int64
+	}
+	entries := make([]lruEntry, 0, len(incCache.hFilesInfo)+len(incCache.includesResolve))
+	for hFileName, hFileCached := range incCache.hFilesInfo {
+		entries = append(entries, lruEntry{hFileName, false, atomic.LoadInt64(&hFileCached.lastAccess)})
+	}
+	for quotedArg, resolve := range incCache.includesResolve {
+		entries = append(entries, lruEntry{quotedArg, true, atomic.LoadInt64(&resolve.lastAccess)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastAccess < entries[j].lastAccess
+	})
+
+	nEvicted := 0
+	for _, entry := range entries {
+		if incCache.sizeBytes <= incCache.limitBytes*9/10 {
+			break
+		}
+		if entry.isResolve {
+			incCache.sizeBytes -= incCache.includesResolve[entry.key].estimateBytes(entry.key)
+			delete(incCache.includesResolve, entry.key)
+		} else {
+			incCache.sizeBytes -= incCache.hFilesInfo[entry.key].estimateBytes(entry.key)
+			delete(incCache.hFilesInfo, entry.key)
+		}
+		nEvicted++
+	}
+	incCache.evictedCount += int64(nEvicted)
+	logClient.Info(1, "includes cache exceeded a limit, evicted", nEvicted, "entries, size now", incCache.sizeBytes, "bytes")
+}
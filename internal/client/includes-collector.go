@@ -1,7 +1,6 @@
 package client
 
 import (
-	"bufio"
 	"bytes"
 	"crypto/sha256"
 	"fmt"
@@ -41,7 +40,7 @@ func (file *IncludedFile) ToPbFileMetadata() *pb.FileMetadata {
 // Since cxx knows nothing about .nocc-pch files, it will output all dependencies regardless of -fpch-preprocess flag.
 // We'll manually add .nocc-pch if found, so the remote is supposed to use it, not its nested dependencies, actually.
 // See https://gcc.gnu.org/onlinedocs/gcc/Preprocessor-Options.html
-func CollectDependentIncludesByCxxM(includesCache *IncludesCache, cwd string, cxxName string, cppInFile string, cxxArgs []string, cxxIDirs IncludeDirs) (hFiles []*IncludedFile, cppFile IncludedFile, err error) {
+func CollectDependentIncludesByCxxM(includesCache *IncludesCache, cwd string, cxxName string, cppInFile string, cxxArgs []string, cxxIDirs IncludeDirs, disableOwnPch bool) (hFiles []*IncludedFile, cppFile IncludedFile, err error) {
 	cxxCmdLine := make([]string, 0, len(cxxArgs)+2*cxxIDirs.Count()+4)
 	cxxCmdLine = append(cxxCmdLine, cxxArgs...)
 	cxxCmdLine = append(cxxCmdLine, cxxIDirs.AsCxxArgs()...)
@@ -69,7 +68,7 @@ func CollectDependentIncludesByCxxM(includesCache *IncludesCache, cwd string, cx
 
 	// -M outputs all dependent file names (we call them ".h files", though the extension is arbitrary).
 	// We also need size and sha256 for every dependency: we'll use them to check whether they were already uploaded.
-	hFilesNames := extractIncludesFromCxxMStdout(cxxMStdout.Bytes())
+	hFilesNames := extractIncludesFromCxxMStdout(cwd, cxxMStdout.Bytes())
 	hFiles = make([]*IncludedFile, 0, len(hFilesNames))
 	preallocatedBuf := make([]byte, 32*1024)
 
@@ -104,7 +103,7 @@ func CollectDependentIncludesByCxxM(includesCache *IncludesCache, cwd string, cx
 
 	// do not parallelize here to fit the system ulimit -n (cause includes collecting is also launched in parallel)
 	// it's slow, but enabling non-own include parser is for testing/bugs searching, so let it be
-	searchForPch := isSourceFileName(cppInFile)
+	searchForPch := isSourceFileName(cppInFile) && !disableOwnPch
 	for _, hFileName := range hFilesNames {
 		err = addHFile(hFileName, searchForPch)
 		if err != nil {
@@ -118,11 +117,26 @@ func CollectDependentIncludesByCxxM(includesCache *IncludesCache, cwd string, cx
 }
 
 // GetDefaultCxxIncludeDirsOnLocal retrieves default include dirs on a local machine.
-// This is done by -Wp,-v option for a no input file.
+// This is done by -Wp,-v option for an empty input passed via stdin.
+// (not /dev/null, as it doesn't exist on Windows and could be unavailable in sandboxes)
+// If cxx is invoked with -B {prefix}, it also looks for headers in {prefix}/include, so we query it with the same -B.
+// The same for -specs={file}: it may add include dirs, so we query cxx with the same specs.
+// The same for -m32 / -target / -arch: a multilib or another target has its own set of builtin headers.
 // This result is cached once nocc-daemon is started.
-func GetDefaultCxxIncludeDirsOnLocal(cxxName string) (IncludeDirs, error) {
-	cxxWpCommand := exec.Command(cxxName, "-Wp,-v", "-x", "c++", "/dev/null", "-fsyntax-only")
+func GetDefaultCxxIncludeDirsOnLocal(cxxName string, cxxDirsB []string, cxxSpecsFiles []string, cxxArchFlags []string) (IncludeDirs, error) {
+	cxxWpArgs := make([]string, 0, len(cxxDirsB)+len(cxxSpecsFiles)+len(cxxArchFlags)+5)
+	cxxWpArgs = append(cxxWpArgs, cxxArchFlags...)
+	for _, dirB := range cxxDirsB {
+		cxxWpArgs = append(cxxWpArgs, "-B"+dirB)
+	}
+	for _, specsFile := range cxxSpecsFiles {
+		cxxWpArgs = append(cxxWpArgs, "-specs="+specsFile)
+	}
+	cxxWpArgs = append(cxxWpArgs, "-Wp,-v", "-x", "c++", "-E", "-")
+	cxxWpCommand := exec.Command(cxxName, cxxWpArgs...)
 	var cxxWpStderr bytes.Buffer
+	cxxWpCommand.Stdin = strings.NewReader("")
+	cxxWpCommand.Stdout = io.Discard // leaving it nil makes exec open /dev/null
 	cxxWpCommand.Stderr = &cxxWpStderr
 	if err := cxxWpCommand.Run(); err != nil {
 		return IncludeDirs{}, err
@@ -175,23 +189,22 @@ func CalcSHA256OfFileName(fileName string, preallocatedBuf []byte) (common.SHA25
 }
 
 // LocateOwnPchFile finds a .nocc-pch file next to .h.
-// The results are cached: if a file doesn't exist, it won't be looked up again until daemon is alive.
+// The results are cached: if a file doesn't exist, it won't be parsed again until it appears.
 func LocateOwnPchFile(hFileName string, includesCache *IncludesCache) *IncludedFile {
 	ownPchFile := hFileName + ".nocc-pch"
 	pchCached, exists := includesCache.GetHFileInfo(ownPchFile)
 	if !exists {
+		pchCached = &includeCachedHFile{fileSize: -1}
 		if stat, err := os.Stat(ownPchFile); err == nil {
+			pchCached.fileMTime = stat.ModTime() // even if it's corrupted, not to parse it again until changed
 			ownPch, err := common.ParseOwnPchFile(ownPchFile)
 			if err == nil {
-				includesCache.AddHFileInfo(ownPchFile, stat.Size(), ownPch.PchHash, []string{})
+				pchCached.fileSize, pchCached.fileSHA256 = stat.Size(), ownPch.PchHash
 			} else {
 				logClient.Error(err)
-				includesCache.AddHFileInfo(ownPchFile, -1, common.SHA256{}, []string{})
 			}
-		} else {
-			includesCache.AddHFileInfo(ownPchFile, -1, common.SHA256{}, []string{})
 		}
-		pchCached, _ = includesCache.GetHFileInfo(ownPchFile)
+		includesCache.AddHFileInfo(ownPchFile, pchCached.fileSize, pchCached.fileSHA256, pchCached.fileMTime, []string{})
 	}
 
 	if pchCached.fileSize == -1 {
@@ -247,46 +260,43 @@ func parseCxxDefaultIncludeDirsFromWpStderr(cxxWpStderr string) IncludeDirs {
 }
 
 // extractIncludesFromCxxMStdout parses output of a C++ compiler with -M option (a dependency list for Makefile).
-func extractIncludesFromCxxMStdout(cxxMStdout []byte) []string {
-	scanner := bufio.NewScanner(bytes.NewReader(cxxMStdout))
-	scanner.Split(bufio.ScanWords)
+// Relative file names there are relative to cwd (cxx was launched in it), they are converted to absolute.
+func extractIncludesFromCxxMStdout(cwd string, cxxMStdout []byte) []string {
+	words := common.SplitCxxMStdoutWords(cxxMStdout)
 	hFilesNames := make([]string, 0, 16)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "#pragma" && scanner.Scan() && scanner.Text() == "GCC" && scanner.Scan() && scanner.Text() == "pch_preprocess" && scanner.Scan() {
-			pchFileName := strings.Trim(scanner.Text(), "\"")
-			pchFileName, _ = filepath.Abs(pchFileName)
-			hFilesNames = append(hFilesNames, pchFileName)
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if word == "#pragma" && i+3 < len(words) && words[i+1] == "GCC" && words[i+2] == "pch_preprocess" {
+			pchFileName := strings.Trim(words[i+3], "\"")
+			hFilesNames = append(hFilesNames, filepath.Clean(pathAbs(cwd, pchFileName)))
+			i += 3
 			continue
 		}
 
-		if line == "\\" || isSourceFileName(line) || strings.HasSuffix(line, ".o") || strings.HasSuffix(line, ".o:") {
+		if word == "\\" || isSourceFileName(word) || isObjFileName(strings.TrimSuffix(word, ":")) {
 			continue
 		}
-		hFileName, _ := filepath.Abs(line)
-		hFilesNames = append(hFilesNames, hFileName)
+		hFilesNames = append(hFilesNames, filepath.Clean(pathAbs(cwd, word)))
 	}
 	return hFilesNames
 }
 
-// CompareOwnIncludesParserAndCxxM is for development purposes.
-// Perform a full-text search for this method call.
-//
-//goland:noinspection GoUnusedExportedFunction
-func CompareOwnIncludesParserAndCxxM(cppInFile string, ownFoundHFiles []IncludedFile, cxxMFoundHFiles []IncludedFile) bool {
-	equal := true
+// CompareOwnIncludesParserAndCxxM checks that own includes parser found all dependencies found by `cxx -M`
+// (it may find more, but not fewer), and returns those missed by own includes parser.
+// It's used in tests; for development, perform a full-text search for this method call.
+func CompareOwnIncludesParserAndCxxM(cppInFile string, ownFoundHFiles []*IncludedFile, cxxMFoundHFiles []*IncludedFile) (notFoundByOwn []string) {
 	for _, incCxx := range cxxMFoundHFiles {
 		found := false
 		for _, incOwn := range ownFoundHFiles {
-			if incOwn == incCxx {
+			if *incOwn == *incCxx {
 				found = true
 				break
 			}
 		}
 		if !found {
-			equal = false
-			logClient.Error("gcc -M found", incCxx, "but not found by own include parser", cppInFile)
+			notFoundByOwn = append(notFoundByOwn, incCxx.fileName)
+			logClient.Error("gcc -M found", incCxx.fileName, "but not found by own include parser", cppInFile)
 		}
 	}
-	return equal
+	return
 }
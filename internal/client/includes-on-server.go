@@ -0,0 +1,154 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/VKCOM/nocc/pb"
+)
+
+// a round is needed for every level of new (not yet sent) nested #include, so it's a guard against endless loops
+const maxCollectDepsOnServerRounds = 50
+
+// CollectDependentIncludesOnServer is an experimental alternative to own includes parser (NOCC_COLLECT_INCLUDES_ON_SERVER):
+// a .cpp file is sent to the remote, which launches `cxx -M -MG` and requests back dependencies it doesn't have.
+// It takes preprocessing CPU off a client at the cost of round trips (at least one, more if the remote lacks headers).
+// A client still calculates sha256 of every dependency, to make sure the remote has the same files.
+//
+// Includes the remote can't find are printed by `cxx -MG` as written in #include, without an including file and quotes,
+// so a client can't tell which file a compiler would pick. That's why all candidates are sent, in the compiler's order:
+// dirs of already found files (for #include "..."), then -iquote, -I, -isystem, default dirs and -idirafter.
+// Having them all, `cxx -M` on the remote picks the right one itself, and only it becomes a dependency.
+// If a round sends nothing new, the remote would never find the rest, and collecting fails immediately.
+func (remote *RemoteConnection) CollectDependentIncludesOnServer(invocation *Invocation, cwd string) (hFiles []*IncludedFile, cppFile IncludedFile, err error) {
+	if remote.isUnavailable {
+		return nil, cppFile, fmt.Errorf("remote %s is unavailable", remote.remoteHost)
+	}
+	if len(invocation.cxxSpecs) != 0 {
+		return nil, cppFile, fmt.Errorf("-specs= is not supported")
+	}
+
+	cppFile.fileName = invocation.GetCppInFileAbs(cwd)
+	var contents []byte
+	if cppFile.fileSHA256, contents, err = CalcSHA256OfFileName(cppFile.fileName, make([]byte, 0)); err != nil {
+		return
+	}
+	cppFile.fileSize = int64(len(contents))
+
+	includeDirs := invocation.cxxIDirs
+	includeDirs.MergeWith(invocation.includesCache.cxxDefIDirs)
+	searchDirs := make([]string, 0, includeDirs.Count())
+	searchDirs = append(searchDirs, includeDirs.dirsIquote...)
+	searchDirs = append(searchDirs, includeDirs.dirsI...)
+	searchDirs = append(searchDirs, includeDirs.dirsIsystem...)
+	searchDirs = append(searchDirs, includeDirs.dirsAfter...)
+
+	known := []*IncludedFile{&cppFile}
+	knownIdx := map[string]int{cppFile.fileName: 0}
+	addKnown := func(fileName string) error {
+		if _, exists := knownIdx[fileName]; exists {
+			return nil
+		}
+		fileSHA256, contents, err := CalcSHA256OfFileName(fileName, make([]byte, 0))
+		if err != nil {
+			return err
+		}
+		hFile := &IncludedFile{fileName, int64(len(contents)), fileSHA256}
+		knownIdx[fileName] = len(known)
+		known = append(known, hFile)
+		return nil
+	}
+	resolveIncludeCandidates := func(includeName string) []string {
+		if includeName[0] == '/' {
+			if isFileExisting(includeName) {
+				return []string{includeName}
+			}
+			return nil
+		}
+		candidateDirs := make([]string, 0, len(known)+len(searchDirs))
+		for _, hFile := range known {
+			candidateDirs = append(candidateDirs, filepath.Dir(hFile.fileName))
+		}
+		candidateDirs = append(candidateDirs, searchDirs...)
+
+		var candidates []string
+		for _, dir := range candidateDirs {
+			if fileName := filepath.Join(dir, includeName); isFileExisting(fileName) {
+				candidates = append(candidates, fileName)
+			}
+		}
+		return candidates
+	}
+
+	request := &pb.CollectDepsOnServerRequest{
+		ClientID:  remote.clientID,
+		Cwd:       cwd,
+		CppInFile: invocation.cppInFile,
+		CxxName:   invocation.cxxName,
+		CxxArgs:   invocation.cxxArgs,
+		CxxIDirs:  append(invocation.cxxIDirs.AsCxxArgs(), invocation.includesCache.cxxDefIDirs.AsCxxArgs()...),
+	}
+	for round := 1; round <= maxCollectDepsOnServerRounds; round++ {
+		for _, hFile := range known[len(request.KnownFiles):] {
+			request.KnownFiles = append(request.KnownFiles, hFile.ToPbFileMetadata())
+		}
+		reply, err := remote.grpcClient.pb.CollectDepsOnServer(remote.grpcClient.callContext, request)
+		if err != nil {
+			return nil, cppFile, err
+		}
+
+		request.FileBodies = nil
+		if len(reply.FileIndexesToUpload) != 0 {
+			for _, fileIndex := range reply.FileIndexesToUpload {
+				if int(fileIndex) >= len(known) {
+					return nil, cppFile, fmt.Errorf("unexpected file index %d", fileIndex)
+				}
+				body, err := os.ReadFile(known[fileIndex].fileName)
+				if err != nil {
+					return nil, cppFile, err
+				}
+				request.FileBodies = append(request.FileBodies, &pb.FileBody{FileIndex: fileIndex, Body: body})
+			}
+			continue
+		}
+
+		for _, dep := range reply.Deps {
+			if err := addKnown(dep); err != nil {
+				return nil, cppFile, err
+			}
+		}
+		nKnownBefore := len(known)
+		for _, includeName := range reply.UnresolvedIncludes {
+			candidates := resolveIncludeCandidates(includeName)
+			if len(candidates) == 0 {
+				return nil, cppFile, fmt.Errorf("can't resolve #include %q", includeName)
+			}
+			for _, fileName := range candidates {
+				if err := addKnown(fileName); err != nil {
+					return nil, cppFile, err
+				}
+			}
+		}
+		if len(reply.UnresolvedIncludes) != 0 && len(known) == nKnownBefore {
+			return nil, cppFile, fmt.Errorf("can't resolve #include %q on remote, though all candidates are sent", reply.UnresolvedIncludes[0])
+		}
+
+		// nothing missing: the remote has all dependencies
+		// (whether they are the same as on a client is checked on session start, like for any dependency)
+		if len(reply.UnresolvedIncludes) == 0 {
+			hFiles = make([]*IncludedFile, 0, len(reply.Deps))
+			for _, dep := range reply.Deps {
+				hFiles = append(hFiles, known[knownIdx[dep]])
+			}
+			logClient.Info(1, "collected", len(hFiles), "includes on remote", remote.remoteHost, "in", round, "rounds", invocation.cppInFile)
+			return hFiles, cppFile, nil
+		}
+	}
+	return nil, cppFile, fmt.Errorf("too many rounds of collecting includes on remote")
+}
+
+func isFileExisting(fileName string) bool {
+	stat, err := os.Stat(fileName)
+	return err == nil && !stat.IsDir()
+}
@@ -16,6 +16,7 @@ type invocationTimingItem struct {
 //
 // It's mostly for developing/debugging purposes: multiple nocc invocations are appended to a single log file,
 // from which we can compute statistics, average and percentiles, either in total or partitioned by hosts.
+// For offline analysis, it's also appended as a TSV record to NOCC_SUMMARY_FILE, see SummaryFile.
 type InvocationSummary struct {
 	remoteHost string
 
@@ -57,3 +58,30 @@ func (s *InvocationSummary) ToLogString(invocation *Invocation) string {
 
 	return b.String()
 }
+
+// summaryTSVHeader is the first line of NOCC_SUMMARY_FILE, columns of ToTSVRecord.
+// phases are "step=ms" separated by ',', each is a duration from the previous step (like in ToLogString).
+const summaryTSVHeader = "time\tsession_id\tcpp_in_file\tremote\tn_includes\tn_files_sent\tn_bytes_sent\tn_bytes_received\tcxx_duration_ms\ttotal_ms\tphases\n"
+
+// ToTSVRecord outputs InvocationSummary as a line with a stable set of columns, see summaryTSVHeader.
+func (s *InvocationSummary) ToTSVRecord(invocation *Invocation) string {
+	duration := time.Since(invocation.createTime).Milliseconds()
+	cppInFile := strings.NewReplacer("\t", " ", "\n", " ").Replace(invocation.cppInFile)
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "%s\t%d\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t",
+		invocation.createTime.Format(time.RFC3339Nano), invocation.sessionID, cppInFile, s.remoteHost,
+		s.nIncludes, s.nFilesSent, s.nBytesSent, s.nBytesReceived, invocation.cxxDuration, duration)
+
+	prevTime := invocation.createTime
+	for i, item := range s.timings {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%d", item.stepName, item.timeEnd.Sub(prevTime).Milliseconds())
+		prevTime = item.timeEnd
+	}
+	b.WriteByte('\n')
+
+	return b.String()
+}
@@ -1,12 +1,18 @@
 package client
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/VKCOM/nocc/internal/common"
 )
 
 const (
@@ -14,6 +20,8 @@ const (
 	invokedForCompilingCpp
 	invokedForCompilingPch
 	invokedForLinking
+	invokedWithFatalError // cmd line is valid, but cxx would fail anyway (e.g. -o dir doesn't exist)
+	invokedForQuerying    // cxx only prints something about itself (-dumpversion, -print-file-name=...), nothing is compiled
 )
 
 // Invocation describes one `nocc` invocation inside a daemon.
@@ -27,13 +35,33 @@ type Invocation struct {
 	sessionID  uint32    // incremental while a daemon is alive
 
 	// cmdLine is parsed to the following fields:
-	cppInFile  string      // input file as specified in cmd line (.cpp for compilation, .h for pch generation)
+	cwd        string      // cwd of `nocc` process, or overridden by clang's -working-directory
+	cppInFile  string      // input file as specified in cmd line (.cpp for compilation, .h for pch generation or -fsyntax-only)
 	objOutFile string      // output file as specified in cmd line (.o for compilation, .gch/.pch for pch generation)
 	cxxName    string      // g++ / clang / etc.
 	cxxArgs    []string    // args like -Wall, -fpch-preprocess and many more, except:
 	cxxIDirs   IncludeDirs // -I / -iquote / -isystem go here
+	cxxDirsB   []string    // -B prefixes: they affect default include dirs, also left in cxxArgs
+	cxxSpecs   []string    // -specs= files: they affect default include dirs, also left in cxxArgs and uploaded
+	cxxArchs   []string    // -m32 / -target / -arch and similar: they select a multilib (default include dirs), also left in cxxArgs
 	depsFlags  DepCmdFlags // -MD -MF file and others, used for .d files generation (not passed to server)
 
+	depFileStdout []byte // -MF /dev/stdout: a depfile is prepended to cxx stdout in a response, not written by a daemon
+	depFileStderr []byte // -MF /dev/stderr: the same
+
+	directivesOnlyFile    string // if set, it's sent instead of cppInFile, see Invocation.preprocessDirectivesOnly
+	rewrittenIncludesFile string // if set, it's sent instead of cppInFile, see Invocation.preprocessRewriteIncludes
+
+	skipObjCacheLookup bool // cppInFile is inside NOCC_SKIP_OBJ_CACHE_LOOKUP_DIRS: always-changing, objs are not reused
+	syntaxOnly         bool // -fsyntax-only (IDE checks, linters): compiled remotely for diagnostics only, no output file
+	recacheObj         bool // NOCC_RECACHE_OBJS for a daemon or NOCC_RECACHE for this invocation: compile, replacing an obj in obj cache
+	objCacheOnly       bool // a remote is busy, and a local cxx slot is taken: only an obj from obj cache is taken remotely, see errObjCacheMiss
+
+	cppInStdin    bool   // cppInFile is "-" in cmd line (a source is read from stdin), see Invocation.SaveStdinToTempFile
+	stdinFileName string // "stdin.cpp" / "stdin.c" detected by -x
+
+	cppInPreprocessed bool // cppInFile is .ii/.i or -x c++-cpp-output/cpp-output: it has no #include, nothing to collect
+
 	waitUploads int32 // files still waiting for upload to finish; 0 releases wgUpload; see Invocation.DoneUploadFile
 	doneRecv    int32 // 1 if o file received or failed receiving; 1 releases wgRecv; see Invocation.DoneRecvObj
 	wgUpload    sync.WaitGroup
@@ -41,15 +69,19 @@ type Invocation struct {
 
 	// when remote compilation starts, the server starts a server.Session (with the same sessionID)
 	// after it finishes, we have these fields filled (and objOutFile saved)
-	cxxExitCode int
-	cxxStdout   []byte
-	cxxStderr   []byte
-	cxxDuration int32
+	cxxExitCode  int
+	cxxStdout    []byte
+	cxxStderr    []byte
+	cxxDuration  int32
+	fromObjCache bool
 
 	summary       *InvocationSummary
-	includesCache *IncludesCache // = Daemon.includesCache[cxxName]
+	includesCache *IncludesCache // = Daemon.includesCache[cxxName + cxxDirsB + cxxSpecs + cxxArchs]
 }
 
+// an absolute path inside a specs file: "/path" or "-I/path", preceded by a whitespace or '='
+var reAbsPathInSpecs = regexp.MustCompile(`(?:^|[\s=])(?:-[A-Za-z]+)?(/[^\s%{}|;:]+)`)
+
 func isSourceFileName(fileName string) bool {
 	return strings.HasSuffix(fileName, ".cpp") ||
 		strings.HasSuffix(fileName, ".cc") ||
@@ -57,6 +89,17 @@ func isSourceFileName(fileName string) bool {
 		strings.HasSuffix(fileName, ".c")
 }
 
+// .ii/.i are outputs of `cxx -E`, compiled in a second step of split pipelines (like ccache does)
+func isPreprocessedFileName(fileName string) bool {
+	return strings.HasSuffix(fileName, ".ii") ||
+		strings.HasSuffix(fileName, ".i")
+}
+
+// -x c++-cpp-output / -x cpp-output declare preprocessed input regardless of its extension
+func isPreprocessedLang(langX string) bool {
+	return langX == "c++-cpp-output" || langX == "cpp-output"
+}
+
 func isHeaderFileName(fileName string) bool {
 	return strings.HasSuffix(fileName, ".h") ||
 		strings.HasSuffix(fileName, ".hh") ||
@@ -64,6 +107,12 @@ func isHeaderFileName(fileName string) bool {
 		strings.HasSuffix(fileName, ".hpp")
 }
 
+// .obj is a Windows/MSVC convention, but it's also used by clang-cl and some cross builds
+func isObjFileName(fileName string) bool {
+	return strings.HasSuffix(fileName, ".o") ||
+		strings.HasSuffix(fileName, ".obj")
+}
+
 func pathAbs(cwd string, relPath string) string {
 	if relPath[0] == '/' {
 		return relPath
@@ -71,15 +120,33 @@ func pathAbs(cwd string, relPath string) string {
 	return filepath.Join(cwd, relPath)
 }
 
+// findWorkingDirectoryArg returns a dir passed as clang's "-working-directory {dir}" or "-working-directory={dir}".
+// It changes how all relative paths are resolved (even those before it in cmd line), so it's searched in advance.
+// Note, that gcc's -fworking-directory is not the same (it only affects line markers), it's passed as is.
+func findWorkingDirectoryArg(cmdLine []string) string {
+	for i := 1; i < len(cmdLine); i++ {
+		if cmdLine[i] == "-working-directory" && i+1 < len(cmdLine) {
+			return cmdLine[i+1]
+		} else if strings.HasPrefix(cmdLine[i], "-working-directory=") {
+			return cmdLine[i][len("-working-directory="):]
+		}
+	}
+	return ""
+}
+
 func ParseCmdLineInvocation(daemon *Daemon, cwd string, cmdLine []string) (invocation *Invocation) {
+	if workingDir := findWorkingDirectoryArg(cmdLine); workingDir != "" {
+		cwd = pathAbs(cwd, workingDir)
+	}
+
 	invocation = &Invocation{
-		createTime:    time.Now(),
-		sessionID:     atomic.AddUint32(&daemon.totalInvocations, 1),
-		cxxName:       cmdLine[0],
-		cxxArgs:       make([]string, 0, 10),
-		cxxIDirs:      MakeIncludeDirs(),
-		summary:       MakeInvocationSummary(),
-		includesCache: daemon.GetOrCreateIncludesCache(cmdLine[0]),
+		createTime: time.Now(),
+		sessionID:  atomic.AddUint32(&daemon.totalInvocations, 1),
+		cwd:        cwd,
+		cxxName:    cmdLine[0],
+		cxxArgs:    make([]string, 0, 10),
+		cxxIDirs:   MakeIncludeDirs(),
+		summary:    MakeInvocationSummary(),
 	}
 
 	parseArgFile := func(key string, arg string, argIndex *int) (string, bool) {
@@ -113,12 +180,47 @@ func ParseCmdLineInvocation(daemon *Daemon, cwd string, cmdLine []string) (invoc
 		return ""
 	}
 
+	stopsBeforeLinking := false // -c or -S
+	hasLinkerArgs := false      // -Wl,{opts}
+	langX := ""                 // -x {lang}, affects inputs after it
+
 	for i := 1; i < len(cmdLine); i++ {
 		arg := cmdLine[i]
 		if len(arg) == 0 {
 			continue
 		}
 		if arg[0] == '-' {
+			if arg == "-c" || arg == "-S" {
+				stopsBeforeLinking = true
+			} else if arg == "-fsyntax-only" {
+				invocation.syntaxOnly = true
+			} else if strings.HasPrefix(arg, "-Wl,") {
+				hasLinkerArgs = true
+			} else if arg == "-x" && i+1 < len(cmdLine) {
+				langX = cmdLine[i+1]
+				invocation.cxxArgs = append(invocation.cxxArgs, arg, langX) // not to treat "c++-cpp-output" as an input
+				i++
+				continue
+			} else if strings.HasPrefix(arg, "-x") {
+				langX = arg[2:]
+			}
+
+			if arg == stdinInputFile {
+				if invocation.cppInFile != "" {
+					invocation.err = fmt.Errorf("unsupported command-line: multiple input source files")
+					return
+				}
+				invocation.cppInFile = arg
+				invocation.cppInStdin = true
+				invocation.stdinFileName = stdinFileNameByLang(langX)
+				invocation.cppInPreprocessed = isPreprocessedLang(langX)
+				if invocation.stdinFileName == "" {
+					invocation.err = fmt.Errorf("unsupported command-line: stdin input with -x '%s'", langX)
+					return
+				}
+				continue
+			}
+
 			if oFile, ok := parseArgFile("-o", arg, &i); ok {
 				invocation.objOutFile = oFile
 				continue
@@ -128,28 +230,102 @@ func ParseCmdLineInvocation(daemon *Daemon, cwd string, cmdLine []string) (invoc
 			} else if dir, ok := parseArgFile("-iquote", arg, &i); ok {
 				invocation.cxxIDirs.dirsIquote = append(invocation.cxxIDirs.dirsIquote, pathAbs(cwd, dir))
 				continue
+			} else if dir, ok := parseArgFile("-idirafter", arg, &i); ok {
+				invocation.cxxIDirs.dirsAfter = append(invocation.cxxIDirs.dirsAfter, pathAbs(cwd, dir))
+				continue
+			} else if dir, ok := parseArgFile("-isystem-after", arg, &i); ok { // clang, must be checked before -isystem
+				invocation.cxxIDirs.dirsAfter = append(invocation.cxxIDirs.dirsAfter, pathAbs(cwd, dir))
+				continue
 			} else if dir, ok := parseArgFile("-isystem", arg, &i); ok {
 				invocation.cxxIDirs.dirsIsystem = append(invocation.cxxIDirs.dirsIsystem, pathAbs(cwd, dir))
 				continue
+			} else if pchFile, ok := parseArgFile("-include-pch", arg, &i); ok { // clang, must be checked before -include
+				hFile, err := locateHeaderOfExplicitPch(pathAbs(cwd, pchFile))
+				if err != nil {
+					invocation.err = err
+					return
+				}
+				invocation.cxxIDirs.filesI = append(invocation.cxxIDirs.filesI, hFile)
+				continue
 			} else if iFile, ok := parseArgFile("-include", arg, &i); ok {
-				invocation.cxxIDirs.filesI = append(invocation.cxxIDirs.filesI, pathAbs(cwd, iFile))
+				iFile = pathAbs(cwd, iFile)
+				if isPrecompiledHeaderFileName(iFile) {
+					hFile, err := locateHeaderOfExplicitPch(iFile)
+					if err != nil {
+						invocation.err = err
+						return
+					}
+					iFile = hFile
+				}
+				invocation.cxxIDirs.filesI = append(invocation.cxxIDirs.filesI, iFile)
 				continue
 			} else if arg == "-march=native" {
 				invocation.err = fmt.Errorf("-march=native can't be launched remotely")
 				return
 			} else if arg == "-I-" || arg == "-E" || arg == "-nostdinc" || arg == "-nostdinc++" ||
-				strings.HasPrefix(arg, "-iprefix") || strings.HasPrefix(arg, "-idirafter") || strings.HasPrefix(arg, "--sysroot") {
+				strings.HasPrefix(arg, "-iprefix") || strings.HasPrefix(arg, "--sysroot") {
 				invocation.err = fmt.Errorf("unsupported option: %s", arg)
 				return
 			} else if arg == "-isysroot" {
 				// an exception for local development when "remote" is also local, but generally unsupported yet
-				if len(daemon.remoteConnections) == 1 && daemon.remoteConnections[0].remoteHost == "127.0.0.1" {
+				if remotes := daemon.getRemoteConnections(); len(remotes) == 1 && remotes[0].remoteHost == "127.0.0.1" {
 					invocation.cxxArgs = append(invocation.cxxArgs, arg, cmdLine[i+1])
 					i++
 					continue
 				}
 				invocation.err = fmt.Errorf("unsupported option: %s", arg)
 				return
+			} else if common.IsCxxArgWritingSideFiles(arg) {
+				invocation.err = fmt.Errorf("unsupported option: %s (it writes files that aren't sent back from a remote)", arg)
+				return
+			} else if common.IsCxxArgIrrelevantForRemote(arg) {
+				continue
+			} else if arg == "-L" && i < len(cmdLine)-1 { // "-L {dir}", the same as -L{dir}
+				i++
+				continue
+			} else if arg == "-working-directory" || strings.HasPrefix(arg, "-working-directory=") {
+				// already applied to cwd, see findWorkingDirectoryArg(); the remote gets this cwd instead
+				if arg == "-working-directory" {
+					i++
+				}
+				continue
+			} else if dirB, ok := parseArgFile("-B", arg, &i); ok {
+				// -B affects where cxx looks for its sub-programs (and {prefix}/include for headers),
+				// we can pass it to the remote only if it's a system toolchain dir, supposed to be equal on a server
+				dirB = pathAbs(cwd, dirB)
+				if !isInsideServerMirroredRoots(dirB) {
+					invocation.err = fmt.Errorf("-B %s can't be mirrored on a remote", dirB)
+					return
+				}
+				invocation.cxxDirsB = append(invocation.cxxDirsB, dirB)
+				invocation.cxxArgs = append(invocation.cxxArgs, "-B"+dirB)
+				continue
+			} else if strings.HasPrefix(arg, "-specs=") || strings.HasPrefix(arg, "--specs=") {
+				// a specs file alters the cxx driver; if it exists locally, it's uploaded like a dependency,
+				// otherwise, it's a name looked up in toolchain dirs, supposed to be equal on a server
+				specsFile := arg[strings.IndexByte(arg, '=')+1:]
+				if _, err := os.Stat(pathAbs(cwd, specsFile)); err != nil {
+					invocation.cxxArgs = append(invocation.cxxArgs, arg)
+					continue
+				}
+				specsFile = pathAbs(cwd, specsFile)
+				invocation.cxxSpecs = append(invocation.cxxSpecs, specsFile)
+				invocation.cxxArgs = append(invocation.cxxArgs, "-specs="+specsFile)
+				continue
+			} else if triple := parseArgStr("-target", arg, &i); triple != "" {
+				// the triple must be sent along with -target, it's a part of obj cache key, see common.ParseCxxTarget
+				invocation.cxxArgs = append(invocation.cxxArgs, arg, triple)
+				invocation.cxxArchs = append(invocation.cxxArchs, arg, triple)
+				continue
+			} else if arg == "-m32" || arg == "-m64" || arg == "-mx32" || arg == "-m16" || strings.HasPrefix(arg, "--target=") {
+				// a multilib has its own builtin headers (e.g., no /usr/include/x86_64-linux-gnu for -m32)
+				invocation.cxxArgs = append(invocation.cxxArgs, arg)
+				invocation.cxxArchs = append(invocation.cxxArchs, arg)
+				continue
+			} else if arch := parseArgStr("-arch", arg, &i); arch != "" {
+				invocation.cxxArgs = append(invocation.cxxArgs, arg, arch)
+				invocation.cxxArchs = append(invocation.cxxArchs, arg, arch)
+				continue
 			} else if arg == "-Xarch_arm64" {
 				// todo if it's placed before -include, it should remain before it after cmd line reconstruction; for now, skip
 				continue
@@ -183,15 +359,28 @@ func ParseCmdLineInvocation(daemon *Daemon, cwd string, cmdLine []string) (invoc
 				invocation.cxxArgs = append(invocation.cxxArgs, "-Xclang", xArg)
 				i++
 				continue
+			} else if arg == "-Xlinker" && i < len(cmdLine)-1 { // "-Xlinker {opt}", like -Wl,{opt}: the linker is never invoked remotely
+				hasLinkerArgs = true
+				i++
+				continue
+			} else if (arg == "-Xassembler" || arg == "-Xpreprocessor") && i < len(cmdLine)-1 {
+				invocation.cxxArgs = append(invocation.cxxArgs, arg, cmdLine[i+1])
+				i++
+				continue
+			} else if common.IsCxxArgQuery(arg) {
+				// checked only for top-level args, after options taking a value: "-Xlinker --print-memory-usage" is not a query
+				invocation.invokeType = invokedForQuerying
+				return
 			}
-		} else if isSourceFileName(arg) || isHeaderFileName(arg) {
+		} else if isSourceFileName(arg) || isHeaderFileName(arg) || isPreprocessedFileName(arg) || (isPreprocessedLang(langX) && !isObjFileName(arg)) {
 			if invocation.cppInFile != "" {
 				invocation.err = fmt.Errorf("unsupported command-line: multiple input source files")
 				return
 			}
 			invocation.cppInFile = arg
+			invocation.cppInPreprocessed = isPreprocessedLang(langX) || (isPreprocessedFileName(arg) && (langX == "" || langX == "none"))
 			continue
-		} else if strings.HasSuffix(arg, ".o") || strings.HasPrefix(arg, ".so") || strings.HasSuffix(arg, ".a") {
+		} else if isObjFileName(arg) || strings.HasPrefix(arg, ".so") || strings.HasSuffix(arg, ".a") {
 			invocation.invokeType = invokedForLinking
 			return
 		}
@@ -202,10 +391,48 @@ func ParseCmdLineInvocation(daemon *Daemon, cwd string, cmdLine []string) (invoc
 		return
 	}
 
+	// `g++ 1.cpp -o app -Wl,-rpath,...` compiles and links at once, whereas linking must be done locally
+	if !stopsBeforeLinking && !invocation.syntaxOnly && (isSourceFileName(invocation.cppInFile) || invocation.cppInPreprocessed) && (hasLinkerArgs || !isObjFileName(invocation.objOutFile)) {
+		invocation.invokeType = invokedForLinking
+		return
+	}
+
+	if invocation.includesCache, invocation.err = daemon.GetOrCreateIncludesCache(invocation.cxxName, invocation.cxxDirsB, invocation.cxxSpecs, invocation.cxxArchs); invocation.err != nil {
+		return
+	}
+	// paths inside a specs file are not remapped by a server, they are checked when default include dirs are known
+	for _, specsFile := range invocation.cxxSpecs {
+		if clientPath := findClientOnlyPathInSpecsFile(specsFile, invocation.includesCache.cxxDefIDirs); clientPath != "" {
+			invocation.err = fmt.Errorf("-specs=%s references %s, which can't be mirrored on a remote", specsFile, clientPath)
+			return
+		}
+	}
+
 	if invocation.cppInFile == "" {
 		invocation.err = fmt.Errorf("unsupported command-line: no input file specified")
-	} else if strings.HasSuffix(invocation.objOutFile, ".o") {
+	} else if invocation.cppInStdin && (!stopsBeforeLinking || !isObjFileName(invocation.objOutFile)) {
+		// stdin is saved to a file right before compilation (see Invocation.SaveStdinToTempFile), other cases are left local
+		invocation.err = fmt.Errorf("unsupported command-line: stdin input not compiled to an obj file")
+	} else if err := checkInputFileReadable(pathAbs(cwd, invocation.cppInFile)); err != nil && !invocation.cppInStdin {
+		// cxx fails immediately if an input doesn't exist; don't upload anything and don't fall back, just report the same
+		invocation.invokeType = invokedWithFatalError
+		invocation.err = makeInputFileErrorLikeCxx(invocation.cxxName, invocation.cppInFile, err)
+	} else if invocation.syntaxOnly && invocation.depsFlags.ShouldGenerateDepFile() {
+		invocation.err = fmt.Errorf("unsupported command-line: -fsyntax-only with a depfile")
+	} else if invocation.syntaxOnly {
+		// -o (if any) is ignored by cxx, nothing is written: an exit code and diagnostics are all the result
 		invocation.invokeType = invokedForCompilingCpp
+	} else if isObjFileName(invocation.objOutFile) {
+		invocation.invokeType = invokedForCompilingCpp
+		for _, dir := range daemon.skipObjCacheDirs {
+			invocation.skipObjCacheLookup = invocation.skipObjCacheLookup || strings.HasPrefix(pathAbs(cwd, invocation.cppInFile), dir)
+		}
+		// g++ reports this only after compilation, when it can't save .o; we detect it before uploading
+		objOutFileAbs := invocation.GetObjOutFileAbs(cwd)
+		if _, err := os.Stat(objOutFileAbs[:strings.LastIndexByte(objOutFileAbs, '/')+1]); err != nil {
+			invocation.invokeType = invokedWithFatalError
+			invocation.err = fmt.Errorf("Assembler messages:\nFatal error: can't create %s: No such file or directory", invocation.objOutFile)
+		}
 	} else if strings.Contains(invocation.objOutFile, ".gch") || strings.Contains(invocation.objOutFile, ".pch") {
 		invocation.invokeType = invokedForCompilingPch
 	} else {
@@ -214,22 +441,159 @@ func ParseCmdLineInvocation(daemon *Daemon, cwd string, cmdLine []string) (invoc
 	return
 }
 
+func isPrecompiledHeaderFileName(fileName string) bool {
+	return strings.HasSuffix(fileName, ".gch") ||
+		strings.HasSuffix(fileName, ".pch")
+}
+
+// locateHeaderOfExplicitPch handles a prebuilt pch passed explicitly, like `-include all-headers.h.gch`.
+// A .gch/.pch compiled on a client is useless for a remote (see "Own precompiled headers" in docs),
+// so a header it was compiled from is included instead: it's equivalent and is collected like any other -include,
+// which means that all-headers.h.nocc-pch is used if it exists, or all-headers.h is uploaded as is.
+// If there is no such header next to a pch, an invocation can't be compiled remotely and falls back to local cxx.
+func locateHeaderOfExplicitPch(pchFile string) (string, error) {
+	hFile := strings.TrimSuffix(strings.TrimSuffix(pchFile, ".gch"), ".pch")
+	if hFile == pchFile || !isHeaderFileName(hFile) {
+		return "", fmt.Errorf("explicit precompiled header %s can't be used remotely: can't detect a header it was compiled from", pchFile)
+	}
+	if _, err := os.Stat(hFile); err != nil {
+		return "", fmt.Errorf("explicit precompiled header %s can't be used remotely: %s not found", pchFile, hFile)
+	}
+	return hFile, nil
+}
+
+// findClientOnlyPathInSpecsFile returns the first absolute path mentioned in a specs file that a server doesn't mirror.
+// A server takes a specs file as is, its paths are not remapped, so every path must be valid there:
+// either it's inside serverMirroredRoots (equal on a client and a server), or it's a default include dir
+// (with -isystem /home/alice/sysroot in a specs file, it's detected as a default one, passed explicitly and mirrored,
+// whereas the path from a specs file just doesn't exist on a server and is ignored by cxx).
+// Other paths (e.g. -include /home/alice/force.h) would be missing on a server, so compilation can't be done remotely.
+func findClientOnlyPathInSpecsFile(specsFile string, cxxDefIDirs IncludeDirs) string {
+	contents, err := os.ReadFile(specsFile)
+	if err != nil {
+		return specsFile
+	}
+	for _, match := range reAbsPathInSpecs.FindAllSubmatch(contents, -1) {
+		absPath := filepath.Clean(string(match[1]))
+		if !isInsideServerMirroredRoots(absPath) && !isDefaultIncludeDir(absPath, cxxDefIDirs) {
+			return absPath
+		}
+	}
+	return ""
+}
+
+// serverMirroredRoots are dirs supposed to be equal on a client and a server: a toolchain and system headers,
+// a server takes files there from its own file system, see server.IsSystemHeaderPath
+var serverMirroredRoots = []string{"/usr/", "/Library/"}
+
+func isInsideServerMirroredRoots(absPath string) bool {
+	for _, root := range serverMirroredRoots {
+		if strings.HasPrefix(absPath, root) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDefaultIncludeDir(absPath string, cxxDefIDirs IncludeDirs) bool {
+	for _, dirs := range [][]string{cxxDefIDirs.dirsI, cxxDefIDirs.dirsIquote, cxxDefIDirs.dirsIsystem, cxxDefIDirs.dirsAfter} {
+		for _, dir := range dirs {
+			if absPath == filepath.Clean(dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkInputFileReadable(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// attributeRemoteStderr makes sure that stderr of a failed remote compilation points to an input file.
+// A file sent instead of cppInFile (see directivesOnlyFile) is named back.
+// Errors of nocc-server itself (e.g. the compiler was killed by timeout) don't mention any file,
+// so a line about cppInFile is appended to them; ordinary compiler output is left as is.
+func (invocation *Invocation) attributeRemoteStderr(stderr []byte, remoteHost string) []byte {
+	inFileName := invocation.cppInFile
+	if invocation.cppInStdin {
+		inFileName = "<stdin>"
+	}
+	for _, sentFile := range []string{invocation.directivesOnlyFile, invocation.rewrittenIncludesFile} {
+		if sentFile != "" {
+			stderr = bytes.ReplaceAll(stderr, []byte(sentFile), []byte(inFileName))
+		}
+	}
+	isServerError := bytes.HasPrefix(stderr, []byte("nocc-server: ")) || bytes.Contains(stderr, []byte("\nnocc-server: "))
+	if isServerError && !bytes.Contains(stderr, []byte(inFileName)) {
+		stderr = append(stderr, fmt.Sprintf("nocc: failed to compile %s on remote %s\n", inFileName, remoteHost)...)
+	}
+	return stderr
+}
+
+// makeInputFileErrorLikeCxx formats an error for a missing/unreadable input file the same way gcc/clang do, e.g.
+// > cc1plus: fatal error: 1.cpp: No such file or directory
+// > compilation terminated.
+func makeInputFileErrorLikeCxx(cxxName string, cppInFile string, err error) error {
+	reason := err.Error()
+	if pathErr, ok := err.(*os.PathError); ok {
+		reason = pathErr.Err.Error()
+	}
+
+	if strings.Contains(filepath.Base(cxxName), "clang") {
+		return fmt.Errorf("%s: error: %s: '%s'", filepath.Base(cxxName), reason, cppInFile)
+	}
+	cc1 := "cc1plus"
+	if strings.HasSuffix(cppInFile, ".c") {
+		cc1 = "cc1"
+	}
+	return fmt.Errorf("%s: fatal error: %s: %s\ncompilation terminated.", cc1, cppInFile, strings.ToUpper(reason[:1])+reason[1:])
+}
+
 // CollectDependentIncludes finds dependencies for an input .cpp file.
 // "dependencies" are typically all reachable .h files at any level, and probably precompiled headers.
 // There are two modes of finding dependencies:
 // 1. Natively: invoke "cxx -M" (it invokes preprocessor only).
 // 2. Own includes parser, which works much faster and theoretically should return the same (or a bit more) results.
-func (invocation *Invocation) CollectDependentIncludes(cwd string, disableOwnIncludes bool) (hFiles []*IncludedFile, cppFile IncludedFile, err error) {
+// Unless disableOwnPch, .nocc-pch files found next to headers are used instead of those headers.
+// A preprocessed input (.ii) has no dependencies, it's sent alone.
+func (invocation *Invocation) CollectDependentIncludes(cwd string, disableOwnIncludes bool, disableOwnPch bool) (hFiles []*IncludedFile, cppFile IncludedFile, err error) {
 	cppInFileAbs := invocation.GetCppInFileAbs(cwd)
 
-	if disableOwnIncludes {
-		return CollectDependentIncludesByCxxM(invocation.includesCache, cwd, invocation.cxxName, cppInFileAbs, invocation.cxxArgs, invocation.cxxIDirs)
+	if invocation.cppInPreprocessed {
+		cppFile.fileName = cppInFileAbs
+		var contents []byte
+		cppFile.fileSHA256, contents, err = CalcSHA256OfFileName(cppInFileAbs, make([]byte, 0))
+		cppFile.fileSize = int64(len(contents))
+	} else if disableOwnIncludes {
+		hFiles, cppFile, err = CollectDependentIncludesByCxxM(invocation.includesCache, cwd, invocation.cxxName, cppInFileAbs, invocation.cxxArgs, invocation.cxxIDirs, disableOwnPch)
+	} else {
+		includeDirs := invocation.cxxIDirs
+		includeDirs.MergeWith(invocation.includesCache.cxxDefIDirs)
+		hFiles, cppFile, err = CollectDependentIncludesByOwnParser(invocation.includesCache, cppInFileAbs, includeDirs, disableOwnPch)
+		var limitExceeded *errOwnIncludesLimitExceeded
+		if errors.As(err, &limitExceeded) {
+			logClient.Info(0, "fallback to cxx -M:", limitExceeded)
+			hFiles, cppFile, err = CollectDependentIncludesByCxxM(invocation.includesCache, cwd, invocation.cxxName, cppInFileAbs, invocation.cxxArgs, invocation.cxxIDirs, disableOwnPch)
+		}
 	}
 
-	includeDirs := invocation.cxxIDirs
-	includeDirs.MergeWith(invocation.includesCache.cxxDefIDirs)
-
-	return CollectDependentIncludesByOwnParser(invocation.includesCache, cppInFileAbs, includeDirs)
+	// -specs= files are not #include-d, but needed on a server for cxx to work the same way
+	for _, specsFile := range invocation.cxxSpecs {
+		if err != nil {
+			break
+		}
+		specsIncluded := &IncludedFile{fileName: specsFile}
+		var contents []byte
+		specsIncluded.fileSHA256, contents, err = CalcSHA256OfFileName(specsFile, make([]byte, 0))
+		specsIncluded.fileSize = int64(len(contents))
+		hFiles = append(hFiles, specsIncluded)
+	}
+	return
 }
 
 // GetCppInFileAbs returns an absolute path to invocation.cppInFile.
@@ -241,6 +605,16 @@ func (invocation *Invocation) GetCppInFileAbs(cwd string) string {
 	return cwd + "/" + invocation.cppInFile
 }
 
+// GetObjOutFileAbs returns an absolute path to invocation.objOutFile (stored as-is from cmd line).
+// Like cxx, it's NOT cleaned: in "build/../out/1.o", ".." is resolved by the OS,
+// which differs from lexical cleaning if "build" is a symlink (or doesn't exist, then it's an error).
+func (invocation *Invocation) GetObjOutFileAbs(cwd string) string {
+	if invocation.objOutFile[0] == '/' {
+		return invocation.objOutFile
+	}
+	return cwd + "/" + invocation.objOutFile
+}
+
 func (invocation *Invocation) DoneRecvObj(err error) {
 	if atomic.SwapInt32(&invocation.doneRecv, 1) == 0 {
 		if err != nil {
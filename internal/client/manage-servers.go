@@ -1,10 +1,14 @@
 package client
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/VKCOM/nocc/pb"
@@ -34,6 +38,14 @@ type rpcDropCachesRes struct {
 	processingTime time.Duration
 }
 
+// rpcPurgeCachesRes is an intermediate structure describing the rpc /PurgeStaleCaches request
+type rpcPurgeCachesRes struct {
+	reply          *pb.PurgeStaleCachesReply
+	err            error
+	remoteHostPort string
+	processingTime time.Duration
+}
+
 func requestRemoteStatusOne(remoteHostPort string, resChannel chan rpcStatusRes) {
 	start := time.Now()
 	grpcClient, err := MakeGRPCClient(remoteHostPort)
@@ -115,6 +127,47 @@ func requestDropAllCachesOne(remoteHostPort string, resChannel chan rpcDropCache
 	}
 }
 
+func requestPurgeStaleCachesOne(remoteHostPort string, olderThan time.Duration, resChannel chan rpcPurgeCachesRes) {
+	start := time.Now()
+	grpcClient, err := MakeGRPCClient(remoteHostPort)
+	if err != nil {
+		resChannel <- rpcPurgeCachesRes{err: err, remoteHostPort: remoteHostPort}
+		return
+	}
+	defer grpcClient.Clear()
+
+	reply, err := grpcClient.pb.PurgeStaleCaches(grpcClient.callContext, &pb.PurgeStaleCachesRequest{OlderThanSeconds: int64(olderThan.Seconds())})
+	resChannel <- rpcPurgeCachesRes{
+		reply:          reply,
+		err:            err,
+		remoteHostPort: remoteHostPort,
+		processingTime: time.Since(start),
+	}
+}
+
+func followRemoteLogsOne(ctx context.Context, remoteHostPort string, onLines func([]byte)) error {
+	grpcClient, err := MakeGRPCClient(remoteHostPort)
+	if err != nil {
+		return err
+	}
+	defer grpcClient.Clear()
+
+	stream, err := grpcClient.pb.FollowLogs(ctx, &pb.FollowLogsRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		reply, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		onLines(reply.Lines)
+	}
+}
+
 // RequestRemoteStatus sends the rpc /Status request for all hosts
 // and outputs brief info about each host ending up with a grouped summary.
 func RequestRemoteStatus(remoteNoccHosts []string) {
@@ -153,6 +206,15 @@ func RequestRemoteStatus(remoteNoccHosts []string) {
 		fmt.Printf("  Processing time: %d ms\n", res.processingTime.Milliseconds())
 		fmt.Printf("  Disk consumption: log %d KB, src cache %d KB, obj cache %d KB\n", r.LogFileSize/1024, r.SrcCacheSize/1024, r.ObjCacheSize/1024)
 		fmt.Printf("  Activity: sessions total %d, active %d\n", r.SessionsTotal, r.SessionsActive)
+		if len(r.LongestActiveSessions) > 0 {
+			fmt.Printf("  Longest active sessions:\n")
+			for _, s := range r.LongestActiveSessions {
+				fmt.Printf("    %s\n", s)
+			}
+		}
+		if nFiles := r.SrcFilesReused + r.SrcFilesUploaded; nFiles > 0 {
+			fmt.Printf("  Src cache: reused %d files (%d KB), uploaded %d files (%d KB), %d%% of files not uploaded\n", r.SrcFilesReused, r.SrcBytesReused/1024, r.SrcFilesUploaded, r.SrcBytesUploaded/1024, r.SrcFilesReused*100/nFiles)
+		}
 		fmt.Printf("  Cxx: calls %d, more10sec %d, more30sec %d\n", r.CxxCalls, r.CxxDurMore10Sec, r.CxxDurMore30Sec)
 
 		if len(r.UniqueRemotes) > 1 {
@@ -276,3 +338,70 @@ func RequestDropAllCaches(remoteNoccHosts []string) {
 		fmt.Printf("\033[31mdropped %d / %d\033[0m\n", nOk, nTotal)
 	}
 }
+
+// RequestPurgeStaleCaches sends the rpc /PurgeStaleCaches request for all hosts:
+// files in src cache and obj cache not accessed within olderThan are evicted, the rest is kept.
+func RequestPurgeStaleCaches(remoteNoccHosts []string, olderThan time.Duration) {
+	resChannel := make(chan rpcPurgeCachesRes)
+	for _, remoteHostPort := range remoteNoccHosts {
+		go requestPurgeStaleCachesOne(remoteHostPort, olderThan, resChannel)
+	}
+
+	nOk := 0
+	nTotal := len(remoteNoccHosts)
+
+	for range remoteNoccHosts {
+		res := <-resChannel
+		var reply *pb.PurgeStaleCachesReply = res.reply
+		remoteHost := ExtractRemoteHostWithoutPort(res.remoteHostPort)
+
+		if res.err != nil {
+			fmt.Printf("Server \033[36m%s\033[0m unavailable: %v\n", remoteHost, res.err)
+			continue
+		}
+
+		fmt.Printf("Server \033[36m%s\033[0m purged %d src files (%d bytes) and %d obj files (%d bytes)\n", remoteHost, reply.PurgedSrcFiles, reply.PurgedSrcBytes, reply.PurgedObjFiles, reply.PurgedObjBytes)
+		nOk++
+	}
+
+	if nOk == nTotal {
+		fmt.Printf("\033[32mpurged %d / %d\033[0m\n", nOk, nTotal)
+	} else {
+		fmt.Printf("\033[31mpurged %d / %d\033[0m\n", nOk, nTotal)
+	}
+}
+
+// FollowRemoteLogs sends the rpc /FollowLogs request for all hosts and prints new lines of their logs as they appear,
+// every line prefixed with a host, until ctx is done (e.g., on Ctrl+C).
+// If some server is unavailable (or isn't launched with `-log-filename`), it's reported, others are still followed.
+func FollowRemoteLogs(ctx context.Context, remoteNoccHosts []string, out io.Writer) {
+	var outMu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, remoteHostPort := range remoteNoccHosts {
+		wg.Add(1)
+		go func(remoteHostPort string) {
+			defer wg.Done()
+			prefix := []byte(fmt.Sprintf("\033[36m%s\033[0m ", ExtractRemoteHostWithoutPort(remoteHostPort)))
+			err := followRemoteLogsOne(ctx, remoteHostPort, func(lines []byte) {
+				var prefixed bytes.Buffer
+				for _, line := range bytes.SplitAfter(lines, []byte{'\n'}) {
+					if len(line) > 0 {
+						prefixed.Write(prefix)
+						prefixed.Write(line)
+					}
+				}
+				outMu.Lock()
+				_, _ = out.Write(prefixed.Bytes())
+				outMu.Unlock()
+			})
+			if err != nil {
+				outMu.Lock()
+				_, _ = fmt.Fprintf(out, "Server \033[36m%s\033[0m unavailable: %v\n", ExtractRemoteHostWithoutPort(remoteHostPort), err)
+				outMu.Unlock()
+			}
+		}(remoteHostPort)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/VKCOM/nocc/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxSimultaneousObjCacheSeeds = 8
+	timeoutObjCacheSeed          = time.Minute
+)
+
+// seedObjCacheInBackground is called in NOCC_SEED_OBJ_CACHE mode after a .cpp was compiled locally.
+// It collects dependencies (like for remote compilation) and uploads the resulting .o to the remote's obj cache,
+// so that other clients compiling the same .cpp with the same dependencies would get it from there.
+// Dependencies are collected and the .o is read before returning (only uploading is done in background):
+// after a reply, a build system is free to go on, e.g. to regenerate a header or to strip the .o,
+// and a seeded obj must match exactly what was compiled.
+// The number of simultaneous uploads is bounded: if a limit is exceeded, this .o is just not uploaded.
+// Cxx output is sent along: a remote stores an obj with warnings unless it's launched with -cache-objs-with-warnings=false.
+// If a remote refuses it once, objs with output are not seeded there anymore: a hit must replay the output, a clean obj is never stored instead.
+func (daemon *Daemon) seedObjCacheInBackground(cwd string, invocation *Invocation, remote *RemoteConnection, cxxStdout []byte, cxxStderr []byte) {
+	hasOutput := len(cxxStdout) != 0 || len(cxxStderr) != 0
+	if hasOutput && atomic.LoadInt32(&remote.refusesSeedsWithOutput) != 0 {
+		logClient.Info(1, "skip seeding obj cache, remote", remote.remoteHost, "doesn't store objs with cxx output", invocation.cppInFile)
+		return
+	}
+
+	select {
+	case daemon.seedObjCacheThrottle <- struct{}{}:
+	default:
+		logClient.Info(1, "skip seeding obj cache, too many uploads in progress", invocation.cppInFile)
+		return
+	}
+
+	firstChunk, objBytes, err := remote.prepareStoreObjToCache(cwd, invocation, daemon.disableOwnIncludes, daemon.disableOwnPch, cxxStdout, cxxStderr)
+	if err != nil {
+		<-daemon.seedObjCacheThrottle
+		logClient.Error("failed to seed obj cache on", remote.remoteHost, invocation.cppInFile, err)
+		return
+	}
+
+	daemon.seedObjCacheWg.Add(1)
+	go func() {
+		defer func() {
+			<-daemon.seedObjCacheThrottle
+			daemon.seedObjCacheWg.Done()
+		}()
+
+		if daemon.uploadsFile != nil {
+			b := strings.Builder{}
+			writeUploadsTSVRecord(&b, invocation, remote.remoteHost, invocation.GetObjOutFileAbs(cwd))
+			daemon.uploadsFile.Append(b.String())
+		}
+		if err := remote.StoreObjToCache(firstChunk, objBytes); err != nil {
+			if status.Code(err) == codes.FailedPrecondition { // e.g. seeding is not accepted by the remote, it's not an error
+				logClient.Info(1, "remote", remote.remoteHost, "refused to seed obj cache", invocation.cppInFile, err)
+				if hasOutput {
+					atomic.StoreInt32(&remote.refusesSeedsWithOutput, 1)
+				}
+			} else {
+				logClient.Error("failed to seed obj cache on", remote.remoteHost, invocation.cppInFile, err)
+			}
+		} else {
+			logClient.Info(1, "seeded obj cache on", remote.remoteHost, invocation.cppInFile)
+		}
+	}()
+}
+
+// prepareStoreObjToCache collects all metadata the remote needs to calculate an obj cache key
+// (the same way as for server.Session) and reads a locally compiled .o (invocation.objOutFile).
+func (remote *RemoteConnection) prepareStoreObjToCache(cwd string, invocation *Invocation, disableOwnIncludes bool, disableOwnPch bool, cxxStdout []byte, cxxStderr []byte) (*pb.StoreObjChunkRequest, []byte, error) {
+	hFiles, cppFile, err := invocation.CollectDependentIncludes(cwd, disableOwnIncludes, disableOwnPch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to collect depencies: %v", err)
+	}
+	requiredFiles := make([]*pb.FileMetadata, 0, len(hFiles)+1)
+	for _, hFile := range hFiles {
+		requiredFiles = append(requiredFiles, hFile.ToPbFileMetadata())
+	}
+	requiredFiles = append(requiredFiles, cppFile.ToPbFileMetadata())
+
+	objBytes, err := os.ReadFile(invocation.GetObjOutFileAbs(cwd))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	firstChunk := &pb.StoreObjChunkRequest{
+		ClientID:      remote.clientID,
+		Cwd:           cwd,
+		CppInFile:     invocation.cppInFile,
+		CxxName:       invocation.cxxName,
+		CxxArgs:       invocation.cxxArgs,
+		RequiredFiles: requiredFiles,
+		FileSize:      int64(len(objBytes)),
+		CxxStdout:     cxxStdout,
+		CxxStderr:     cxxStderr,
+	}
+	return firstChunk, objBytes, nil
+}
+
+// StoreObjToCache uploads a .o prepared by prepareStoreObjToCache to the remote's obj cache.
+// See server.receiveStoredObjFileByChunks.
+func (remote *RemoteConnection) StoreObjToCache(firstChunk *pb.StoreObjChunkRequest, objBytes []byte) error {
+	if remote.isUnavailable {
+		return fmt.Errorf("remote %s is unavailable", remote.remoteHost)
+	}
+
+	ctx, cancelFunc := context.WithTimeout(remote.grpcClient.callContext, timeoutObjCacheSeed)
+	defer cancelFunc()
+	stream, err := remote.grpcClient.pb.StoreObjToCache(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the first chunk is sent even for an empty .o, it contains all metadata
+	chunk := firstChunk
+	for offset := 0; offset == 0 || offset < len(objBytes); offset += remote.chunkSize {
+		end := offset + remote.chunkSize
+		if end > len(objBytes) {
+			end = len(objBytes)
+		}
+		chunk.ChunkBody = objBytes[offset:end]
+		if err := stream.Send(chunk); err != nil {
+			break // the real error will be returned by CloseAndRecv()
+		}
+		chunk = &pb.StoreObjChunkRequest{}
+	}
+
+	_, err = stream.CloseAndRecv()
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/VKCOM/nocc/internal/common"
 )
@@ -34,6 +35,12 @@ type ownIncludesParser struct {
 	includeDirs   IncludeDirs // -I and others for current invocation
 	includesCache *IncludesCache
 
+	// all dirs in order of searching: -iquote, -I, -isystem, -idirafter (<arg> starts from nQuoteDirs)
+	// for every found file, we store an index of a dir it was found in, #include_next continues searching after it
+	searchDirs    []string
+	nQuoteDirs    int
+	foundInDirIdx map[string]int
+
 	err             error
 	preallocatedBuf []byte // to read small files (one buffer is ok: includes are processed consecutively)
 
@@ -43,6 +50,33 @@ type ownIncludesParser struct {
 	// * a value is either nil (a file doesn't exist) or a pointer (a file exists and is a dependency)
 	uniqSeen map[string]*IncludedFile
 	hFiles   []*IncludedFile // dependent includes, in order of appearance (= keys of non-nil uniqSeen)
+
+	gaveUp *errOwnIncludesGaveUp // the first #include MACRO in a non-system file, see onMacroInclude
+
+	depth         int                          // current #include nesting, see onHashInclude
+	limitExceeded *errOwnIncludesLimitExceeded // if set, parsing is aborted, and all the rest #include are ignored
+}
+
+// errOwnIncludesGaveUp is returned when own includes parser meets #include MACRO it can't resolve.
+// Then dependencies are incomplete, and a file is preprocessed locally, see Invocation.preprocessDirectivesOnly.
+type errOwnIncludesGaveUp struct {
+	fileName     string
+	macroInclude string // "#include MACRO(args)" as is
+}
+
+func (err *errOwnIncludesGaveUp) Error() string {
+	return fmt.Sprintf("%s: own includes parser can't resolve %s", err.fileName, err.macroInclude)
+}
+
+// errOwnIncludesLimitExceeded is returned when own includes parser exceeds max nesting depth or max files count.
+// It's a guard against pathological sources (and bugs in own parser), then dependencies are collected by cxx -M.
+type errOwnIncludesLimitExceeded struct {
+	fileName string
+	reason   string
+}
+
+func (err *errOwnIncludesLimitExceeded) Error() string {
+	return fmt.Sprintf("%s: own includes parser aborted: %s", err.fileName, err.reason)
 }
 
 func strChr(buffer []byte, chr byte, bufferSize int, offset int) int {
@@ -67,18 +101,34 @@ func (included ownIncludedArg) String() string {
 // shouldCacheHFile detects for <foo.h> resolved as /usr/includes/somewhere/foo.h
 // whether we should keep its size/sha256 in memory for future invocations in IncludesCache.
 func (inc *ownIncludesParser) shouldCacheHFile(hFileName string) bool {
+	// 0) dirs declared stable by a user (env NOCC_CACHEABLE_INCLUDE_DIRS), even if they are passed via "-I"
+	// (as well as generated ones, env NOCC_GENERATED_INCLUDE_DIRS, but see shouldCacheIncludeResolve)
+	for _, dir := range inc.includesCache.cacheableDirs {
+		if strings.HasPrefix(hFileName, dir) {
+			return true
+		}
+	}
+	if inc.isInGeneratedDir(hFileName) {
+		return true
+	}
+
 	// 1) cache angle includes: <foo.h>, since they would probably be included again
 	// BUT! we cache only files in /usr/include and other "-isystem" dirs:
 	// these locations don't depend on command-line invocation, they are cxx built-ins for local machine
 	// we do NOT cache if <foo.h> is placed in "-I" directories, as -I can change between invocations
 	// (for example, <php.h> for "-I /usr/include/php/20190902" and for "-I /usr/include/php/20170718" are different)
 	// (various -I dirs are common for cmake usage)
-	// the first loop is needed, as "-I" can be subdirs of "-isystem"
+	// the first loops are needed, as "-I" and "-idirafter" can be subdirs of "-isystem"
 	for _, dir := range inc.includeDirs.dirsI {
 		if strings.HasPrefix(hFileName, dir) {
 			return false
 		}
 	}
+	for _, dir := range inc.includeDirs.dirsAfter {
+		if strings.HasPrefix(hFileName, dir) {
+			return false
+		}
+	}
 	for _, dir := range inc.includeDirs.dirsIsystem {
 		if strings.HasPrefix(hFileName, dir) {
 			return true
@@ -96,12 +146,70 @@ func (inc *ownIncludesParser) shouldCacheHFile(hFileName string) bool {
 	return false
 }
 
+// isInGeneratedDir detects whether a file (or a dir) is inside NOCC_GENERATED_INCLUDE_DIRS.
+func (inc *ownIncludesParser) isInGeneratedDir(fileName string) bool {
+	for _, dir := range inc.includesCache.generatedDirs {
+		if strings.HasPrefix(fileName, dir) || fileName == strings.TrimSuffix(dir, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldCacheIncludeResolve detects whether #include <arg> resolved to hFileName can be reused by future invocations.
+// Headers in generated dirs are cached themselves, but they are looked up every time: another build dir has its own ones.
+func (inc *ownIncludesParser) shouldCacheIncludeResolve(hFileName string) bool {
+	return !inc.isInGeneratedDir(hFileName) && inc.shouldCacheHFile(hFileName)
+}
+
+// isInCxxDefaultDir detects whether a file is a system header: in /usr/ or in cxx default include dirs
+// (a toolchain installed elsewhere, /opt/rh/devtoolset-9/root/usr/include or a custom clang prefix, for example).
+func (inc *ownIncludesParser) isInCxxDefaultDir(fileName string) bool {
+	if strings.HasPrefix(fileName, "/usr/") {
+		return true
+	}
+	// dirs outside /usr/ are listed in dirsI of default ones, see parseCxxDefaultIncludeDirsFromWpStderr
+	cxxDefIDirs := &inc.includesCache.cxxDefIDirs
+	for _, dirs := range [][]string{cxxDefIDirs.dirsIsystem, cxxDefIDirs.dirsI} {
+		for _, sysDir := range dirs {
+			// not just a prefix: /usr/include2 is not inside /usr/include
+			if strings.HasPrefix(fileName, strings.TrimSuffix(sysDir, "/")+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// onMacroInclude is a handler when we reached #include MACRO in a file; it returns whether own includes parser gives up.
+// Macro includes in system headers are ignored: in practice, they are surrounded with #ifdef or resolved to files
+// that also exist on a server (freetype's #include FT_FREETYPE_H, for example).
+func (inc *ownIncludesParser) onMacroInclude(fileName string, macroInclude string) bool {
+	if macroInclude == "" || inc.isInCxxDefaultDir(fileName) {
+		return false
+	}
+	if inc.gaveUp == nil {
+		inc.gaveUp = &errOwnIncludesGaveUp{fileName, macroInclude}
+	}
+	return true
+}
+
 // onHashInclude is a handler when we reached #include "arg"
 // it finds what full path "arg" actually points to and processes that file recursively
 func (inc *ownIncludesParser) onHashInclude(currentFileName string, includedArg *ownIncludedArg, tryPchInstead bool) *IncludedFile {
 	var hFile *IncludedFile = nil
+	if inc.limitExceeded != nil {
+		return nil
+	}
+
+	inc.depth++
+	defer func() { inc.depth-- }()
+	if maxDepth := inc.includesCache.ownIncludesMaxDepth; maxDepth > 0 && inc.depth > maxDepth {
+		inc.limitExceeded = &errOwnIncludesLimitExceeded{currentFileName, fmt.Sprintf("#include nesting depth exceeds %d", maxDepth)}
+		return nil
+	}
 
-	inc.resolveIncludedArg(currentFileName, includedArg, func(hFileName string) bool {
+	inc.resolveIncludedArg(currentFileName, includedArg, func(hFileName string, dirIndex int) bool {
 		var seen bool
 
 		hFile, seen = inc.uniqSeen[hFileName]
@@ -156,7 +264,17 @@ func (inc *ownIncludesParser) onHashInclude(currentFileName string, includedArg
 
 		hFile = &IncludedFile{hFileName, fileSize, fileSHA256}
 		inc.uniqSeen[hFileName] = hFile
+		if dirIndex != -1 {
+			inc.foundInDirIdx[hFileName] = dirIndex
+		}
 		inc.hFiles = append(inc.hFiles, hFile)
+		if maxFiles := inc.includesCache.ownIncludesMaxFiles; maxFiles > 0 && len(inc.hFiles) > maxFiles {
+			inc.limitExceeded = &errOwnIncludesLimitExceeded{hFileName, fmt.Sprintf("more than %d files resolved", maxFiles)}
+			if file != nil {
+				_ = file.Close()
+			}
+			return true
+		}
 
 		if cachedItem != nil {
 			_ = file.Close()
@@ -176,78 +294,92 @@ func (inc *ownIncludesParser) onHashInclude(currentFileName string, includedArg
 // resolveIncludedArg enumerates all possible paths for #include "arg"
 // depending on "-I" options, whether it's "arg" or <arg> and so on.
 // For each theoretically available full path, it invokes onEachResolveAttempt that returns whether a file exists.
-func (inc *ownIncludesParser) resolveIncludedArg(currentFileName string, includedArg *ownIncludedArg, onEachResolveAttempt func(hFileName string) bool) {
-	eachFn := onEachResolveAttempt
+// dirIndex is an index in searchDirs, or -1 if it's unknown (a dir of a current file, an absolute path, a cached resolve).
+func (inc *ownIncludesParser) resolveIncludedArg(currentFileName string, includedArg *ownIncludedArg, onEachResolveAttempt func(hFileName string, dirIndex int) bool) {
+	if includedArg.insideStr[0] == '/' { // #include "/abs/path" — the only option, don't traverse dirs
+		onEachResolveAttempt(includedArg.insideStr, -1)
+		return
+	}
 
 	if includedArg.isIncludeNext {
 		// example: currentFileName = "/usr/include/c++/8/cstdlib", includedArg = #include_next <stdlib.h>
-		// includeDirs = [ 0 "/home", 1 "/usr/include/c++/8", 2 "/usr/include/c++/8/backward", 3 "/usr/include" ]
-		// we try to locate stdlib.h in 2 and 3 (onEachResolveAttempt will be called for 2/stdlib.h and 3/stdlib.h)
-		curIncludeDirSeen := false
-		eachFn = func(hFileName string) bool {
-			if !curIncludeDirSeen {
-				includeDir := hFileName[0 : len(hFileName)-len(includedArg.insideStr)]
-				if strings.HasPrefix(currentFileName, includeDir) {
-					curIncludeDirSeen = true
-				}
-				return false
+		// searchDirs = [ 0 "/home", 1 "/usr/include/c++/8", 2 "/usr/include/c++/8/backward", 3 "/usr/include" ]
+		// cstdlib was found in 1, so we try to locate stdlib.h in 2 and 3
+		firstDirIndex := inc.findDirIndexOfFile(currentFileName) + 1
+		if firstDirIndex == 0 && !includedArg.isQuote {
+			firstDirIndex = inc.nQuoteDirs
+		}
+		for dirIndex := firstDirIndex; dirIndex < len(inc.searchDirs); dirIndex++ {
+			if onEachResolveAttempt(path.Join(inc.searchDirs[dirIndex], includedArg.insideStr), dirIndex) {
+				return
 			}
-			return onEachResolveAttempt(hFileName)
 		}
+		return
 	}
 
-	isAngle := !includedArg.isQuote && !includedArg.isIncludeNext
-	if isAngle {
+	eachFn := onEachResolveAttempt
+	firstDirIndex := 0
+	if includedArg.isQuote {
+		if eachFn(path.Join(path.Dir(currentFileName), includedArg.insideStr), -1) {
+			return
+		}
+	} else {
+		firstDirIndex = inc.nQuoteDirs
 		hFileName, exists := inc.includesCache.GetIncludeResolve(includedArg.insideStr)
 		if exists {
 			if hFileName != "NO" {
-				onEachResolveAttempt(hFileName)
+				onEachResolveAttempt(hFileName, -1)
 			}
 			return
 		}
-		eachFn = func(hFileName string) bool {
-			fileExists := onEachResolveAttempt(hFileName)
-			if fileExists && inc.shouldCacheHFile(hFileName) {
+		eachFn = func(hFileName string, dirIndex int) bool {
+			fileExists := onEachResolveAttempt(hFileName, dirIndex)
+			if fileExists && inc.shouldCacheIncludeResolve(hFileName) {
 				inc.includesCache.AddIncludeResolve(includedArg.insideStr, hFileName)
 			}
 			return fileExists
 		}
 	}
 
-	if includedArg.insideStr[0] == '/' { // #include "/abs/path" — the only option, don't traverse dirs
-		eachFn(includedArg.insideStr)
-		return
-	}
-	if includedArg.isQuote {
-		if eachFn(path.Join(path.Dir(currentFileName), includedArg.insideStr)) {
+	for dirIndex := firstDirIndex; dirIndex < len(inc.searchDirs); dirIndex++ {
+		if eachFn(path.Join(inc.searchDirs[dirIndex], includedArg.insideStr), dirIndex) {
 			return
 		}
-		for _, dir := range inc.includeDirs.dirsIquote {
-			if eachFn(path.Join(dir, includedArg.insideStr)) {
+	}
+
+	if !includedArg.isQuote {
+		// even for not found, store that fact in cache, so that nocc won't try to find them on the next invocation
+		// (unless a header may be generated later, and another invocation would find it)
+		for dirIndex := firstDirIndex; dirIndex < len(inc.searchDirs); dirIndex++ {
+			if inc.isInGeneratedDir(inc.searchDirs[dirIndex]) {
 				return
 			}
 		}
+		inc.includesCache.AddIncludeResolve(includedArg.insideStr, "NO")
 	}
-	for _, dir := range inc.includeDirs.dirsI {
-		if eachFn(path.Join(dir, includedArg.insideStr)) {
-			return
-		}
+}
+
+// findDirIndexOfFile returns an index in searchDirs where a file was found, for #include_next.
+// It's stored when a file is resolved by searching dirs. If it's unknown (a file was taken from IncludesCache, for example),
+// the longest dir containing a file is taken (not the first one: with -I inc -I inc/sub, inc/sub/a.h is found in inc/sub).
+// If no dir contains a file, -1 is returned: #include_next works like #include then, like in gcc.
+func (inc *ownIncludesParser) findDirIndexOfFile(fileName string) int {
+	if dirIndex, exists := inc.foundInDirIdx[fileName]; exists {
+		return dirIndex
 	}
-	for _, dir := range inc.includeDirs.dirsIsystem {
-		if eachFn(path.Join(dir, includedArg.insideStr)) {
-			return
+	found := -1
+	for dirIndex, dir := range inc.searchDirs {
+		if strings.HasPrefix(fileName, strings.TrimSuffix(dir, "/")+"/") && (found == -1 || len(dir) > len(inc.searchDirs[found])) {
+			found = dirIndex
 		}
 	}
-
-	if isAngle {
-		// even for not found, store that fact in cache, so that nocc won't try to find them on the next invocation
-		inc.includesCache.AddIncludeResolve(includedArg.insideStr, "NO")
-	}
+	return found
 }
 
 // collectIncludeStatementsInFile finds all #include "arg" in a file, in order of appearance
 // C and C++ style comments are respected, includes aren't found within them
-func (inc *ownIncludesParser) collectIncludeStatementsInFile(buffer []byte) (includes []*ownIncludedArg) {
+// If there is #include MACRO, it can't be resolved, the first one is returned as macroInclude.
+func (inc *ownIncludesParser) collectIncludeStatementsInFile(buffer []byte) (includes []*ownIncludedArg, macroInclude string) {
 	const (
 		stateNone = iota
 		stateAfterHash
@@ -262,7 +394,7 @@ func (inc *ownIncludesParser) collectIncludeStatementsInFile(buffer []byte) (inc
 	offset := 0
 	lastHash := bytes.LastIndexByte(buffer, '#')
 	if lastHash != -1 {
-		if string(buffer[lastHash:lastHash+6]) == "#endif" {
+		if bytes.HasPrefix(buffer[lastHash:], []byte("#endif")) {
 			lastHash = bytes.LastIndexByte(buffer[:lastHash-1], '#')
 		}
 		if lastHash != -1 {
@@ -291,8 +423,14 @@ Loop:
 			}
 			if nextSlash != -1 && nextSlash < nextHash {
 				offset = nextSlash
+				if offset+1 == bufferSize {
+					break Loop
+				}
 				if buffer[offset+1] == '/' {
 					offset = strChr(buffer, '\n', bufferSize, offset)
+					if offset == -1 { // a comment at the end of a file without a trailing newline
+						break Loop
+					}
 				} else if buffer[offset+1] == '*' {
 					for ok := true; ok; ok = buffer[offset-1] != '*' { // do while
 						offset = strChr(buffer, '/', bufferSize, offset+1)
@@ -337,12 +475,19 @@ Loop:
 				start = offset + 1
 				state = stateInsideQuoteBrackets
 			default:
-				state = stateNone // buggy code
+				if c := buffer[offset]; macroInclude == "" && (c == '_' || (c|0x20) >= 'a' && (c|0x20) <= 'z') {
+					end := strChr(buffer, '\n', bufferSize, offset)
+					if end == -1 {
+						end = bufferSize
+					}
+					macroInclude = "#include " + strings.TrimSpace(string(buffer[offset:end]))
+				}
+				state = stateNone // buggy code or #include MACRO
 			}
 
 		case stateInsideAngleBrackets:
 			switch buffer[offset] {
-			case '\n':
+			case '\n', '\r': // CRLF files are parsed the same as LF ones, a bare \r also ends a line for cxx
 				state = stateNone // buggy code
 			case '>':
 				includes = append(includes, &ownIncludedArg{string(buffer[start:offset]), false, isInsideIncludeNext})
@@ -351,7 +496,7 @@ Loop:
 
 		case stateInsideQuoteBrackets:
 			switch buffer[offset] {
-			case '\n':
+			case '\n', '\r':
 				state = stateNone // buggy code
 			case '"':
 				includes = append(includes, &ownIncludedArg{string(buffer[start:offset]), true, isInsideIncludeNext})
@@ -366,6 +511,10 @@ Loop:
 }
 
 func (inc *ownIncludesParser) processHFile(hFile *IncludedFile, file *os.File, shouldCache bool) {
+	var fileMTime time.Time
+	if stat, err := file.Stat(); err == nil {
+		fileMTime = stat.ModTime()
+	}
 	fileSHA256, buffer, err := CalcSHA256OfFile(file, hFile.fileSize, inc.preallocatedBuf)
 	_ = file.Close() // close a file before digging into nested .h, not to keep open descriptors
 	if err != nil {
@@ -374,7 +523,10 @@ func (inc *ownIncludesParser) processHFile(hFile *IncludedFile, file *os.File, s
 	}
 
 	hFile.fileSHA256 = fileSHA256
-	includeStatements := inc.collectIncludeStatementsInFile(buffer)
+	includeStatements, macroInclude := inc.collectIncludeStatementsInFile(buffer)
+	if inc.onMacroInclude(hFile.fileName, macroInclude) {
+		shouldCache = false // not to miss it on next invocations
+	}
 
 	if !shouldCache {
 		for _, includedArg := range includeStatements {
@@ -387,7 +539,10 @@ func (inc *ownIncludesParser) processHFile(hFile *IncludedFile, file *os.File, s
 				nestedIncludes = append(nestedIncludes, hNested.fileName)
 			}
 		}
-		inc.includesCache.AddHFileInfo(hFile.fileName, hFile.fileSize, hFile.fileSHA256, nestedIncludes)
+		if inc.limitExceeded != nil { // nestedIncludes are incomplete, they mustn't be cached
+			return
+		}
+		inc.includesCache.AddHFileInfo(hFile.fileName, hFile.fileSize, hFile.fileSHA256, fileMTime, nestedIncludes)
 	}
 }
 
@@ -409,7 +564,8 @@ func (inc *ownIncludesParser) processCppInFile(cppInFile string, searchForPch bo
 		return IncludedFile{}, err
 	}
 	cppFile := IncludedFile{cppInFile, int64(len(buffer)), fileSHA256}
-	includes := inc.collectIncludeStatementsInFile(buffer)
+	includes, macroInclude := inc.collectIncludeStatementsInFile(buffer)
+	inc.onMacroInclude(cppInFile, macroInclude)
 
 	for idx, includedArg := range includes {
 		// according to .gch search rules, an #include can be replaced with a precompiled header
@@ -423,19 +579,31 @@ func (inc *ownIncludesParser) processCppInFile(cppInFile string, searchForPch bo
 
 // CollectDependentIncludesByOwnParser executes the own includes parser.
 // It should return the same results (or a bit more) as "cxx -M".
-func CollectDependentIncludesByOwnParser(includesCache *IncludesCache, cppInFile string, includeDirs IncludeDirs) (hFiles []*IncludedFile, cppFile IncludedFile, err error) {
+func CollectDependentIncludesByOwnParser(includesCache *IncludesCache, cppInFile string, includeDirs IncludeDirs, disableOwnPch bool) (hFiles []*IncludedFile, cppFile IncludedFile, err error) {
 	inc := ownIncludesParser{
 		includeDirs:     includeDirs,
 		includesCache:   includesCache,
 		preallocatedBuf: make([]byte, 32*1024), // most .h files are less than 32k, they'll use the same buffer
 		uniqSeen:        make(map[string]*IncludedFile, 20),
 		hFiles:          make([]*IncludedFile, 0, 8),
+		foundInDirIdx:   make(map[string]int, 20),
 	}
+	inc.searchDirs = append(inc.searchDirs, includeDirs.dirsIquote...)
+	inc.searchDirs = append(inc.searchDirs, includeDirs.dirsI...)
+	inc.searchDirs = append(inc.searchDirs, includeDirs.dirsIsystem...)
+	inc.searchDirs = append(inc.searchDirs, includeDirs.dirsAfter...)
+	inc.nQuoteDirs = len(includeDirs.dirsIquote)
 
 	// we'll try to search for precompiled headers regardless of -fpch-preprocess and -include options
-	searchForPch := isSourceFileName(cppInFile)
+	// (unless it's explicitly disabled, so that a stale .nocc-pch lying nearby won't be picked up)
+	searchForPch := isSourceFileName(cppInFile) && !disableOwnPch
 	cppFile, err = inc.processCppInFile(cppInFile, searchForPch, inc.includeDirs.filesI)
 	hFiles = inc.hFiles
+	if err == nil && inc.limitExceeded != nil {
+		err = inc.limitExceeded
+	} else if err == nil && inc.gaveUp != nil {
+		err = inc.gaveUp
+	}
 
 	// sorting is not needed, since there is no parallelization while collecting includes for a cpp file
 	// it means, that the result is stable from time to time: includes are listed in order of appearance
@@ -11,17 +11,19 @@ import (
 // This file is later discovered as a dependency, and after being uploaded, is compiled to real .gch/.pch on remote.
 // See comments above common.OwnPch.
 func GenerateOwnPch(daemon *Daemon, cwd string, invocation *Invocation) (*common.OwnPch, error) {
+	// paths are absolute: a relative -o is relative to an invocation cwd, not to a daemon one, and a server needs full paths
+	objOutFileAbs := invocation.GetObjOutFileAbs(cwd)
 	ownPch := &common.OwnPch{
-		OwnPchFile:  common.ReplaceFileExt(invocation.objOutFile, ".nocc-pch"),
-		OrigHFile:   invocation.cppInFile,
-		OrigPchFile: invocation.objOutFile,
+		OwnPchFile:  common.ReplaceFileExt(objOutFileAbs, ".nocc-pch"),
+		OrigHFile:   invocation.GetCppInFileAbs(cwd),
+		OrigPchFile: objOutFileAbs,
 		CxxName:     invocation.cxxName,
 		CxxArgs:     invocation.cxxArgs,
 		CxxIDirs:    append(invocation.cxxIDirs.AsCxxArgs(), invocation.includesCache.cxxDefIDirs.AsCxxArgs()...),
 	}
 	_ = os.Remove(ownPch.OwnPchFile) // if a previous version exists
 
-	hFiles, inHFile, err := invocation.CollectDependentIncludes(cwd, daemon.disableOwnIncludes)
+	hFiles, inHFile, err := invocation.CollectDependentIncludes(cwd, daemon.disableOwnIncludes, daemon.disableOwnPch)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/VKCOM/nocc/pb"
+)
+
+// RunPreflight checks all remotes right after a daemon started (env NOCC_PREFLIGHT) and logs a one-line verdict per server.
+// Without it, misconfigured NOCC_SERVERS are discovered only when compilations start falling back to local.
+// A remote is reachable if it accepted StartClient on connection and responds to a /Status request.
+// If no remote is reachable, and local fallback is disabled, an error is returned: a daemon shouldn't start then.
+func (daemon *Daemon) RunPreflight() error {
+	verdicts := make([]string, len(daemon.remoteConnections))
+	reachable := make([]bool, len(daemon.remoteConnections))
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(daemon.remoteConnections))
+	for index, remote := range daemon.remoteConnections {
+		go func(index int, remote *RemoteConnection) {
+			defer wg.Done()
+			if remote.isUnavailable || remote.grpcClient == nil {
+				verdicts[index] = "unreachable: can't connect (see errors above)"
+				return
+			}
+			ctx, cancelFunc := context.WithTimeout(context.Background(), daemon.connectTimeout)
+			defer cancelFunc()
+			reply, err := remote.grpcClient.pb.Status(ctx, &pb.StatusRequest{})
+			if err != nil {
+				verdicts[index] = fmt.Sprintf("unreachable: %v", err)
+				return
+			}
+			reachable[index] = true
+			verdicts[index] = fmt.Sprintf("reachable, version %s, %s, %s", reply.ServerVersion, reply.GccVersion, reply.ClangVersion)
+		}(index, remote)
+	}
+	wg.Wait()
+
+	nReachable := 0
+	for index, remote := range daemon.remoteConnections {
+		if reachable[index] {
+			nReachable++
+			logClient.Info(0, "preflight:", remote.remoteHostPort, verdicts[index])
+		} else {
+			logClient.Error("preflight:", remote.remoteHostPort, verdicts[index])
+		}
+	}
+
+	if nReachable == 0 {
+		servers := make([]string, 0, len(daemon.remoteConnections))
+		for _, remote := range daemon.remoteConnections {
+			servers = append(servers, remote.remoteHostPort)
+		}
+		if daemon.disableLocalCxx {
+			return fmt.Errorf("preflight: no nocc servers are reachable (%s), and local compilation is disabled (NOCC_LOCAL_CXX_QUEUE_SIZE=0); check NOCC_SERVERS and that nocc-server is running there", strings.Join(servers, ";"))
+		}
+		logClient.Error("preflight: no nocc servers are reachable (" + strings.Join(servers, ";") + "), everything will be compiled locally")
+	}
+	return nil
+}
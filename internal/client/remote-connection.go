@@ -2,11 +2,24 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/VKCOM/nocc/internal/common"
 	"github.com/VKCOM/nocc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxStartSessionAttempts   = 3
+	maxReRegisterAttempts     = 10               // if a remote forgets this client more often, something is wrong, stop using it
+	reRegisterCountResetAfter = 10 * time.Minute // a registration that stayed up that long resets the counter
 )
 
 // RemoteConnection represents a state of a current process related to remote execution.
@@ -23,9 +36,24 @@ type RemoteConnection struct {
 	filesUploading *FilesUploading
 	filesReceiving *FilesReceiving
 
-	clientID        string // = Daemon.clientID
-	hostUserName    string // = Daemon.hostUserName
-	disableObjCache bool
+	clientID          string // = Daemon.clientID
+	hostUserName      string // = Daemon.hostUserName
+	objCacheNamespace string // = Daemon.objCacheNamespace
+	disableObjCache   bool
+	echoServerCmdLine bool // = Daemon.echoServerCmdLine
+	allRemotesDelim   string
+	connectTimeout    time.Duration
+	chunkSize         int  // = Daemon.transferTuning.ChunkSize
+	compressTransfers bool // = Daemon.transferTuning.Compress
+
+	// after a server restart, it doesn't know this client, and the client registers again, see ReRegisterClient
+	reRegisterMu    sync.Mutex
+	lastRegistered  time.Time
+	reRegisterCount int
+
+	busyUntilNano int64 // a server hinted it's busy (its cxx queue is deep) until this time, see IsBusy
+
+	refusesSeedsWithOutput int32 // a server refused to store a seeded obj along with cxx output, see seedObjCacheInBackground
 }
 
 func ExtractRemoteHostWithoutPort(remoteHostPort string) (remoteHost string) {
@@ -36,33 +64,43 @@ func ExtractRemoteHostWithoutPort(remoteHostPort string) (remoteHost string) {
 	return
 }
 
-func MakeRemoteConnection(daemon *Daemon, remoteHostPort string, ctxWithTimeout context.Context) (*RemoteConnection, error) {
-	grpcClient, err := MakeGRPCClient(remoteHostPort)
+// MakeRemoteConnection connects to a remote and registers this daemon as a client there.
+// If a remote is not available (e.g., it's being restarted right now), connecting is retried a few times with backoff,
+// see env NOCC_CONNECT_ATTEMPTS and NOCC_CONNECT_TIMEOUT_MS; after that, a remote is considered unavailable.
+// Backoff has jitter: after a server restart, all its clients reconnect, but not simultaneously.
+func MakeRemoteConnection(daemon *Daemon, remoteHostPort string) (*RemoteConnection, error) {
+	grpcClient, err := MakeGRPCClient(remoteHostPort, daemon.transferTuning.grpcDialOptions()...)
 
 	remote := &RemoteConnection{
-		remoteHostPort:  remoteHostPort,
-		remoteHost:      ExtractRemoteHostWithoutPort(remoteHostPort),
-		grpcClient:      grpcClient,
-		filesUploading:  MakeFilesUploading(daemon, grpcClient),
-		filesReceiving:  MakeFilesReceiving(daemon, grpcClient),
-		clientID:        daemon.clientID,
-		hostUserName:    daemon.hostUserName,
-		disableObjCache: daemon.disableObjCache,
+		remoteHostPort:    remoteHostPort,
+		remoteHost:        ExtractRemoteHostWithoutPort(remoteHostPort),
+		grpcClient:        grpcClient,
+		filesUploading:    MakeFilesUploading(daemon, grpcClient),
+		filesReceiving:    MakeFilesReceiving(daemon, grpcClient),
+		clientID:          daemon.clientID,
+		hostUserName:      daemon.hostUserName,
+		objCacheNamespace: daemon.objCacheNamespace,
+		disableObjCache:   daemon.disableObjCache,
+		echoServerCmdLine: daemon.echoServerCmdLine,
+		allRemotesDelim:   daemon.getAllRemotesDelim(),
+		connectTimeout:    daemon.connectTimeout,
+		chunkSize:         daemon.transferTuning.ChunkSize,
+		compressTransfers: daemon.transferTuning.Compress,
 	}
 
 	if err != nil {
 		return remote, err
 	}
 
-	_, err = grpcClient.pb.StartClient(ctxWithTimeout, &pb.StartClientRequest{
-		ClientID:        daemon.clientID,
-		HostUserName:    daemon.hostUserName,
-		ClientVersion:   common.GetVersion(),
-		DisableObjCache: daemon.disableObjCache,
-		AllRemotesDelim: daemon.allRemotesDelim, // just to log on a server-side
-	})
-	if err != nil {
-		return remote, err
+	for attempt := 1; ; attempt++ {
+		if err = remote.sendStartClient(); err == nil {
+			break
+		}
+		if attempt >= daemon.connectAttempts {
+			return remote, err
+		}
+		logClient.Info(0, "retry connecting to", remoteHostPort, "attempt", attempt, err)
+		time.Sleep(common.Jitter(time.Duration(attempt)*500*time.Millisecond, 0.5))
 	}
 
 	if err := remote.filesUploading.CreateUploadStream(); err != nil {
@@ -76,32 +114,152 @@ func MakeRemoteConnection(daemon *Daemon, remoteHostPort string, ctxWithTimeout
 	return remote, nil
 }
 
+func (remote *RemoteConnection) sendStartClient() error {
+	ctxConnect, cancelFunc := context.WithTimeout(context.Background(), remote.connectTimeout)
+	defer cancelFunc()
+
+	reply, err := remote.grpcClient.pb.StartClient(ctxConnect, &pb.StartClientRequest{
+		ClientID:          remote.clientID,
+		HostUserName:      remote.hostUserName,
+		ClientVersion:     common.GetVersion(),
+		DisableObjCache:   remote.disableObjCache,
+		EchoServerCmdLine: remote.echoServerCmdLine,
+		AllRemotesDelim:   remote.allRemotesDelim, // just to log on a server-side
+		CompressTransfers: remote.compressTransfers,
+		ObjCacheNamespace: remote.objCacheNamespace,
+	}, grpc.WaitForReady(true))
+	if err == nil {
+		remote.lastRegistered = time.Now()
+		// an older server doesn't know about compression and replies false, so chunks are sent as is
+		var compress int32
+		if reply.CompressTransfers {
+			compress = 1
+		}
+		atomic.StoreInt32(&remote.filesUploading.compress, compress)
+	}
+	return err
+}
+
+// ReRegisterClient is called when the remote responds with codes.Unauthenticated:
+// it doesn't know this client, because the server was restarted, so the client just registers again.
+// Many invocations (and streams) face this simultaneously, but StartClient is sent only once:
+// if the client was registered after a rejected request had been sent, there is nothing to do, just retry.
+func (remote *RemoteConnection) ReRegisterClient(rejectedRequestTime time.Time) error {
+	remote.reRegisterMu.Lock()
+	defer remote.reRegisterMu.Unlock()
+
+	if remote.lastRegistered.After(rejectedRequestTime) {
+		return nil // someone has just done it
+	}
+	// servers are restarted on deploy, and a long-living daemon would reach the limit sooner or later,
+	// so only frequent re-registrations are counted: a registration that stayed up for a while resets the counter
+	if time.Since(remote.lastRegistered) > reRegisterCountResetAfter {
+		remote.reRegisterCount = 0
+	}
+	if remote.reRegisterCount >= maxReRegisterAttempts {
+		return fmt.Errorf("remote %s forgot this client %d times", remote.remoteHost, remote.reRegisterCount)
+	}
+	remote.reRegisterCount++
+	logClient.Info(0, "remote", remote.remoteHost, "doesn't know this client (it was restarted?), register again")
+	return remote.sendStartClient()
+}
+
+// errObjCacheMiss is returned by StartCompilationSession for invocation.objCacheOnly: a session wasn't started.
+var errObjCacheMiss = errors.New("not found in obj cache")
+
 // StartCompilationSession starts a session on the remote:
 // one `nocc` Invocation for cpp compilation == one server.Session, by design.
 // As an input, we send metadata about all dependencies needed for a .cpp to be compiled (.h/.nocc-pch/etc.).
 // As an output, the remote responds with files that are missing and needed to be uploaded.
+// If the remote responds with codes.Aborted (a dependency was modified, but its previous version is used by active sessions), starting is retried a bit later;
+// if with codes.Unavailable (the remote is being restarted), it's retried waiting for a connection (bounded by a timeout);
+// if with codes.Unauthenticated (the remote was restarted), the client registers again and retries;
+// if with codes.NotFound (a compiler isn't installed there), an error says so, to be logged on falling back to local;
+// other errors are returned immediately (and lead to local compilation).
 func (remote *RemoteConnection) StartCompilationSession(invocation *Invocation, cwd string, requiredFiles []*pb.FileMetadata) ([]uint32, error) {
 	if remote.isUnavailable {
 		return nil, fmt.Errorf("remote %s is unavailable", remote.remoteHost)
 	}
 
-	startSessionReply, err := remote.grpcClient.pb.StartCompilationSession(
-		remote.grpcClient.callContext,
-		&pb.StartCompilationSessionRequest{
-			ClientID:      remote.clientID,
-			SessionID:     invocation.sessionID,
-			Cwd:           cwd,
-			CppInFile:     invocation.cppInFile,
-			CxxName:       invocation.cxxName,
-			CxxArgs:       invocation.cxxArgs,
-			CxxIDirs:      append(invocation.cxxIDirs.AsCxxArgs(), invocation.includesCache.cxxDefIDirs.AsCxxArgs()...),
-			RequiredFiles: requiredFiles,
-		})
-	if err != nil {
-		return nil, err
+	request := &pb.StartCompilationSessionRequest{
+		ClientID:      remote.clientID,
+		SessionID:     invocation.sessionID,
+		Cwd:           cwd,
+		CppInFile:     invocation.cppInFile,
+		CxxName:       invocation.cxxName,
+		CxxArgs:       invocation.cxxArgs,
+		CxxIDirs:      append(invocation.cxxIDirs.AsCxxArgs(), invocation.includesCache.cxxDefIDirs.AsCxxArgs()...),
+		RequiredFiles: requiredFiles,
+
+		SkipObjCacheLookup: invocation.skipObjCacheLookup,
+		SyntaxOnly:         invocation.syntaxOnly,
+		RecacheObj:         invocation.recacheObj,
+		ObjCacheOnly:       invocation.objCacheOnly,
+	}
+	if invocation.directivesOnlyFile != "" { // all #include-s are already expanded, only macros are left
+		request.CppInFile = invocation.directivesOnlyFile
+		request.CxxArgs = append(append(make([]string, 0, len(invocation.cxxArgs)+2), invocation.cxxArgs...), "-fpreprocessed", "-fdirectives-only")
+		request.CxxIDirs = nil
+	}
+	if invocation.rewrittenIncludesFile != "" { // all #include-s are already inlined, it's a regular source file
+		request.CppInFile = invocation.rewrittenIncludesFile
+		request.CxxIDirs = nil
+	}
+
+	waitForReady := false
+	for attempt := 1; ; attempt++ {
+		requestTime := time.Now()
+		startSessionReply, err := remote.sendStartCompilationSession(request, waitForReady)
+		if err == nil {
+			if startSessionReply.ServerCmdLine != "" {
+				logClient.Info(0, "remote", remote.remoteHost, "sessionID", invocation.sessionID, "server cmd line:", startSessionReply.ServerCmdLine)
+			}
+			if startSessionReply.BusyRetryAfterMs > 0 {
+				logClient.Info(1, "remote", remote.remoteHost, "is busy for", startSessionReply.BusyRetryAfterMs, "ms")
+				atomic.StoreInt64(&remote.busyUntilNano, time.Now().Add(time.Duration(startSessionReply.BusyRetryAfterMs)*time.Millisecond).UnixNano())
+			}
+			if startSessionReply.ObjCacheMiss {
+				return nil, errObjCacheMiss
+			}
+			return startSessionReply.FileIndexesToUpload, nil
+		}
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("compiler %s not found on server %s", invocation.cxxName, remote.remoteHost)
+		}
+		if attempt == maxStartSessionAttempts {
+			return nil, err
+		}
+		if status.Code(err) == codes.Unavailable && !waitForReady {
+			waitForReady = true
+			continue
+		}
+		if status.Code(err) == codes.Unauthenticated {
+			if err := remote.ReRegisterClient(requestTime); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if status.Code(err) != codes.Aborted {
+			return nil, err
+		}
+		logClient.Info(0, "retry starting session", "sessionID", invocation.sessionID, invocation.cppInFile, err)
+		time.Sleep(common.Jitter(time.Duration(attempt)*200*time.Millisecond, 0.5))
+	}
+}
+
+// IsBusy is true while a busy hint from a server lasts; it's advisory, a remote is still available.
+func (remote *RemoteConnection) IsBusy() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&remote.busyUntilNano)
+}
+
+func (remote *RemoteConnection) sendStartCompilationSession(request *pb.StartCompilationSessionRequest, waitForReady bool) (*pb.StartCompilationSessionReply, error) {
+	if !waitForReady {
+		return remote.grpcClient.pb.StartCompilationSession(remote.grpcClient.callContext, request)
 	}
 
-	return startSessionReply.FileIndexesToUpload, nil
+	ctxConnect, cancelFunc := context.WithTimeout(remote.grpcClient.callContext, remote.connectTimeout)
+	defer cancelFunc()
+	return remote.grpcClient.pb.StartCompilationSession(ctxConnect, request, grpc.WaitForReady(true))
 }
 
 // UploadFilesToRemote uploads files to the remote in parallel and finishes after all of them are done.
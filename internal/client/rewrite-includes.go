@@ -0,0 +1,26 @@
+package client
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isRewriteIncludesSupported detects whether cxx can preprocess with -frewrite-includes (clang can, gcc can't).
+func isRewriteIncludesSupported(cxxName string) bool {
+	return strings.Contains(path.Base(cxxName), "clang")
+}
+
+// preprocessRewriteIncludes is the same middle ground as preprocessDirectivesOnly, but for clang (env NOCC_REWRITE_INCLUDES):
+// `clang -E -frewrite-includes` inlines all #include-s (leaving them under #if 0 with line markers),
+// whereas macros and #pragma-s are left as is, so the result is a regular self-contained source file.
+// That's why it's named exactly as cppInFile (with the same extension) and compiled remotely with the same args.
+func (invocation *Invocation) preprocessRewriteIncludes(cwd string, tmpDir string) (ppFile IncludedFile, depHFiles []*IncludedFile, err error) {
+	ppFileName := filepath.Join(tmpDir, path.Base(invocation.cppInFile))
+	if ppFile, depHFiles, err = invocation.preprocessLocallyToSingleFile(cwd, ppFileName, "-frewrite-includes"); err != nil {
+		return
+	}
+
+	invocation.rewrittenIncludesFile = ppFileName
+	return
+}
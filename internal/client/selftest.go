@@ -0,0 +1,90 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// selftestSources are compiled by `nocc -selftest`: a header is included to exercise includes collection and uploading,
+// main() is to check that a resulting .o links.
+var selftestSources = map[string]string{
+	"selftest.h":   "#pragma once\n#define SELFTEST_RESULT 42\n",
+	"selftest.cpp": "#include <cstdio>\n#include \"selftest.h\"\nint main() { std::printf(\"%d\\n\", SELFTEST_RESULT); return 0; }\n",
+}
+
+// RunSelftest compiles a tiny .cpp end-to-end against every server: collecting includes, uploading, compiling, downloading.
+// Then a resulting .o is linked and launched locally. It prints pass/fail per server with a round-trip time.
+// It's a one-command "is nocc actually working?" diagnostic for new setups.
+func RunSelftest(remoteNoccHosts []string, cxxName string) (nFailed int) {
+	// a selftest must not interfere with a running daemon: the same clientID would re-create it on servers
+	clientID := ""
+	if envClientID := os.Getenv("NOCC_CLIENT_ID"); envClientID != "" {
+		clientID = envClientID + "-selftest"
+	}
+
+	for _, remoteHostPort := range remoteNoccHosts {
+		remoteHost := ExtractRemoteHostWithoutPort(remoteHostPort)
+		roundTrip, err := runSelftestOne(remoteHostPort, cxxName, clientID)
+		if err != nil {
+			fmt.Printf("Server \033[36m%s\033[0m \033[31mfailed\033[0m: %v\n", remoteHost, err)
+			nFailed++
+		} else {
+			fmt.Printf("Server \033[36m%s\033[0m \033[32mok\033[0m (round-trip %d ms)\n", remoteHost, roundTrip.Milliseconds())
+		}
+	}
+	return
+}
+
+func runSelftestOne(remoteHostPort string, cxxName string, clientID string) (time.Duration, error) {
+	tmpDir, err := os.MkdirTemp("", "nocc-selftest-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+	for fileName, contents := range selftestSources {
+		if err := os.WriteFile(filepath.Join(tmpDir, fileName), []byte(contents), 0644); err != nil {
+			return 0, err
+		}
+	}
+
+	// obj cache is disabled to make a server actually compile; local cxx is disabled not to fall back silently
+	daemon, err := MakeDaemon(DaemonOptions{
+		ClientID:        clientID,
+		RemoteNoccHosts: []string{remoteHostPort},
+		DisableObjCache: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer daemon.QuitDaemonGracefully("selftest done")
+	if remote := daemon.remoteConnections[0]; remote.isUnavailable {
+		return 0, fmt.Errorf("can't connect")
+	}
+
+	start := time.Now()
+	response := daemon.HandleInvocation(DaemonSockRequest{
+		Cwd:     tmpDir,
+		CmdLine: []string{cxxName, "-c", "selftest.cpp", "-o", "selftest.o"},
+	})
+	roundTrip := time.Since(start)
+	if response.ExitCode != 0 {
+		return 0, fmt.Errorf("compilation exited with code %d: %s", response.ExitCode, strings.TrimSpace(string(response.Stderr)))
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "selftest.o")); err != nil {
+		return 0, fmt.Errorf("obj was not saved: %v", err)
+	}
+
+	linkCmd := exec.Command(cxxName, "selftest.o", "-o", "selftest")
+	linkCmd.Dir = tmpDir
+	if output, err := linkCmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("obj doesn't link: %v %s", err, strings.TrimSpace(string(output)))
+	}
+	if output, err := exec.Command(filepath.Join(tmpDir, "selftest")).Output(); err != nil || strings.TrimSpace(string(output)) != "42" {
+		return 0, fmt.Errorf("a linked binary works wrong: %v %q", err, output)
+	}
+	return roundTrip, nil
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ServersAffinity is NOCC_SERVERS_AFFINITY_FILE: a file is sent to the same server it was sent to last time,
+// even if a servers list has changed since then, so that its obj cache stays warm there.
+// Without it, adding a server to NOCC_SERVERS reroutes some files (hashing a basename depends on a pool size),
+// which is noticeable in iterative development, when a few files are recompiled again and again.
+// Keys are basenames, the same as for hashing. Entries pointing to servers no longer listed are dropped.
+// Entries not used for serversAffinityEntryTTL are dropped too, and the oldest ones are evicted above serversAffinityMaxEntries,
+// so that a file doesn't grow forever with basenames of branches and projects long gone.
+// Like NOCC_SKIP_UNCHANGED, entries are written on daemon quit and loaded on start.
+type ServersAffinity struct {
+	fileName string
+
+	mu      sync.Mutex
+	entries map[string]serversAffinityEntry // a basename of a cpp file -> a remote it was sent to
+}
+
+type serversAffinityEntry struct {
+	RemoteHostPort string `json:"remote"`
+	LastUsed       int64  `json:"last_used"` // unix seconds, for eviction
+}
+
+const (
+	serversAffinityEntryTTL   = 30 * 24 * time.Hour
+	serversAffinityMaxEntries = 100000
+)
+
+func MakeServersAffinity(fileName string) (*ServersAffinity, error) {
+	serversAffinity := &ServersAffinity{
+		fileName: fileName,
+		entries:  make(map[string]serversAffinityEntry, 1024),
+	}
+
+	if ok, err := loadStateFile(fileName, &serversAffinity.entries); err != nil {
+		return nil, err
+	} else if !ok {
+		serversAffinity.entries = make(map[string]serversAffinityEntry, 1024)
+	}
+	serversAffinity.evictStale(time.Now())
+	return serversAffinity, nil
+}
+
+// ChooseRemote returns a remote from a pool that a file was sent to last time, or nil if none.
+// If that remote is not in a pool anymore, an entry is dropped, and the caller is expected to call Remember.
+func (serversAffinity *ServersAffinity) ChooseRemote(remotesPool []*RemoteConnection, cppBaseName string) *RemoteConnection {
+	serversAffinity.mu.Lock()
+	defer serversAffinity.mu.Unlock()
+
+	entry, exists := serversAffinity.entries[cppBaseName]
+	if !exists {
+		return nil
+	}
+	if remote := findRemoteConnectionByHostPort(remotesPool, entry.RemoteHostPort); remote != nil {
+		entry.LastUsed = time.Now().Unix()
+		serversAffinity.entries[cppBaseName] = entry
+		return remote
+	}
+	delete(serversAffinity.entries, cppBaseName)
+	return nil
+}
+
+func (serversAffinity *ServersAffinity) Remember(cppBaseName string, remoteHostPort string) {
+	serversAffinity.mu.Lock()
+	serversAffinity.entries[cppBaseName] = serversAffinityEntry{remoteHostPort, time.Now().Unix()}
+	if len(serversAffinity.entries) > serversAffinityMaxEntries {
+		serversAffinity.evictStale(time.Now())
+	}
+	serversAffinity.mu.Unlock()
+}
+
+// evictStale drops entries not used for a long time; if there are still too many, the least recently used ones
+// are evicted, with a margin, so that it's not done on every Remember. It's called under a mutex (or on creation).
+func (serversAffinity *ServersAffinity) evictStale(now time.Time) {
+	staleBefore := now.Add(-serversAffinityEntryTTL).Unix()
+	for cppBaseName, entry := range serversAffinity.entries {
+		if entry.LastUsed < staleBefore {
+			delete(serversAffinity.entries, cppBaseName)
+		}
+	}
+	if len(serversAffinity.entries) <= serversAffinityMaxEntries {
+		return
+	}
+
+	cppBaseNames := make([]string, 0, len(serversAffinity.entries))
+	for cppBaseName := range serversAffinity.entries {
+		cppBaseNames = append(cppBaseNames, cppBaseName)
+	}
+	sort.Slice(cppBaseNames, func(i, j int) bool {
+		return serversAffinity.entries[cppBaseNames[i]].LastUsed < serversAffinity.entries[cppBaseNames[j]].LastUsed
+	})
+	for _, cppBaseName := range cppBaseNames[:len(cppBaseNames)-serversAffinityMaxEntries*9/10] {
+		delete(serversAffinity.entries, cppBaseName)
+	}
+}
+
+// DropRemovedRemotes invalidates entries for servers that are not in a list, it's called when servers change.
+func (serversAffinity *ServersAffinity) DropRemovedRemotes(remoteConnections []*RemoteConnection) {
+	serversAffinity.mu.Lock()
+	for cppBaseName, entry := range serversAffinity.entries {
+		if findRemoteConnectionByHostPort(remoteConnections, entry.RemoteHostPort) == nil {
+			delete(serversAffinity.entries, cppBaseName)
+		}
+	}
+	serversAffinity.mu.Unlock()
+}
+
+// Save writes all entries as JSON; a file is replaced atomically, so it's never seen partially written.
+func (serversAffinity *ServersAffinity) Save() error {
+	serversAffinity.mu.Lock()
+	contents, err := json.Marshal(serversAffinity.entries)
+	serversAffinity.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return saveStateFile(serversAffinity.fileName, contents)
+}
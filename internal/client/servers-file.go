@@ -0,0 +1,44 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseNoccServersFileContents parses a servers list: 'host:port', one per line, with optional comments starting with '#'.
+// It's a format of NOCC_SERVERS_FILENAME and NOCC_SERVERS_FD.
+func ParseNoccServersFileContents(contents []byte) (remoteNoccHosts []string) {
+	lines := bytes.Split(contents, []byte{'\n'})
+	remoteNoccHosts = make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		hostAndComment := bytes.SplitN(bytes.TrimSpace(line), []byte{'#'}, 2)
+		if len(hostAndComment) > 0 && len(hostAndComment[0]) > 0 {
+			trimmedHost := string(bytes.Trim(hostAndComment[0], " ;,"))
+			remoteNoccHosts = append(remoteNoccHosts, trimmedHost)
+		}
+	}
+	return
+}
+
+// ReadNoccServersFiles is NOCC_SERVERS_FILENAME: one or several files separated by ',' or ':' (like a base pool and an overlay).
+// Every file is parsed on its own, and hosts are merged in order of appearance, every host mentioned only once.
+func ReadNoccServersFiles(envNoccServersFilename string) (remoteNoccHosts []string, err error) {
+	var pools [][]string
+	for _, fileName := range strings.FieldsFunc(envNoccServersFilename, func(c rune) bool { return c == ',' || c == ':' }) {
+		if fileName = strings.TrimSpace(fileName); fileName == "" {
+			continue
+		}
+		contents, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, ParseNoccServersFileContents(contents))
+	}
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("invalid NOCC_SERVERS_FILENAME: %q, no files", envNoccServersFilename)
+	}
+	return mergeUniqueRemoteHosts(pools...), nil
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// loadStateFile reads a JSON state that a daemon keeps between launches
+// (NOCC_SKIP_UNCHANGED, NOCC_SERVERS_AFFINITY_FILE, NOCC_COMPDB), it's saved on daemon quit (some also periodically), see saveStateFile.
+// A missing file is not an error, it's just an empty state. An invalid file is logged and ignored (ok = false),
+// and the caller should start from scratch, as state may be filled partially.
+func loadStateFile(fileName string, state interface{}) (ok bool, err error) {
+	contents, err := os.ReadFile(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if len(contents) == 0 {
+		return true, nil
+	}
+	if err := json.Unmarshal(contents, state); err != nil {
+		logClient.Error("ignoring invalid", fileName, err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// saveStateFile replaces a file atomically, so it's never seen partially written,
+// even if several daemons (of different users, for example) save the same file simultaneously.
+func saveStateFile(fileName string, contents []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(contents)
+	if errClose := tmpFile.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0644) // os.CreateTemp makes it 0600
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), fileName)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+	}
+	return err
+}
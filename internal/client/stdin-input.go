@@ -0,0 +1,105 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/VKCOM/nocc/internal/common"
+)
+
+// stdinInputFile is an input file name in cmd line meaning "read a source from stdin", e.g. `g++ -x c++ -c - -o 1.o`.
+// It's common for configure-like probes. Stdin is captured by the `nocc` wrapper and sent along with cmd line.
+const stdinInputFile = "-"
+
+// stdinFileNameByLang returns a name of a file to save stdin to, its extension is detected by cxx as -x does.
+// Empty for languages that can't be compiled remotely.
+func stdinFileNameByLang(langX string) string {
+	switch langX {
+	case "c":
+		return "stdin.c"
+	case "c++":
+		return "stdin.cpp"
+	case "cpp-output":
+		return "stdin.i"
+	case "c++-cpp-output":
+		return "stdin.ii"
+	default:
+		return ""
+	}
+}
+
+// stdinFilesMaxAge is how long a file saved by SaveStdinToTempFile is kept unused, see makeStdinDir
+const stdinFilesMaxAge = 24 * time.Hour
+
+// makeStdinDir prepares a dir for SaveStdinToTempFile: /tmp/nocc-stdin-{uid}, accessible only by this user.
+// /tmp is shared, so if that path exists, it's checked to be a real dir of this user, not a dir or a symlink
+// placed by another user in advance: otherwise, they could replace a source before it's compiled, poisoning obj cache.
+// Files not used for stdinFilesMaxAge are removed.
+func makeStdinDir() (string, error) {
+	stdinDir := filepath.Join(os.TempDir(), fmt.Sprintf("nocc-stdin-%d", os.Getuid()))
+	if err := os.Mkdir(stdinDir, 0700); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	stat, err := os.Lstat(stdinDir)
+	if err != nil {
+		return "", err
+	}
+	if sysStat, ok := stat.Sys().(*syscall.Stat_t); !ok || !stat.IsDir() || int(sysStat.Uid) != os.Getuid() || stat.Mode().Perm() != 0700 {
+		return "", fmt.Errorf("%s is not a dir accessible only by this user", stdinDir)
+	}
+
+	entries, _ := os.ReadDir(stdinDir)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > stdinFilesMaxAge {
+			_ = os.Remove(filepath.Join(stdinDir, entry.Name()))
+		}
+	}
+	return stdinDir, nil
+}
+
+// SaveStdinToTempFile replaces "-" input with a file in stdinDir containing stdin (see makeStdinDir),
+// so that it's uploaded and compiled remotely like any other source.
+// For stdin, cxx resolves #include "..." starting from cwd, that's why cwd becomes the first -iquote dir.
+// A file name depends only on stdin contents: configure-like probes compile the same stdin again and again,
+// and a stable name lets a server reuse an uploaded file and obj cache instead of getting a new file every time.
+// That's why a file is left after compilation; it's touched on reuse, and unused ones are removed on daemon start.
+func (invocation *Invocation) SaveStdinToTempFile(stdinDir string, stdin []byte) error {
+	if stdinDir == "" {
+		return fmt.Errorf("no dir to save stdin to")
+	}
+	hasher := sha256.New()
+	_, _ = hasher.Write(stdin)
+	stdinSHA256 := common.MakeSHA256Struct(hasher)
+
+	cppInFile := filepath.Join(stdinDir, stdinSHA256.ToLongHexString()+"-"+invocation.stdinFileName)
+	if existing, err := os.ReadFile(cppInFile); err == nil && bytes.Equal(existing, stdin) {
+		now := time.Now()
+		_ = os.Chtimes(cppInFile, now, now)
+	} else {
+		// another invocation may be reading this file right now, so it's replaced atomically
+		tmpFile, err := os.CreateTemp(stdinDir, "stdin-*.tmp")
+		if err != nil {
+			return err
+		}
+		_, err = tmpFile.Write(stdin)
+		if errClose := tmpFile.Close(); err == nil {
+			err = errClose
+		}
+		if err == nil {
+			err = os.Rename(tmpFile.Name(), cppInFile)
+		}
+		if err != nil {
+			_ = os.Remove(tmpFile.Name())
+			return err
+		}
+	}
+
+	invocation.cppInFile = cppInFile
+	invocation.cxxIDirs.dirsIquote = append([]string{invocation.cwd}, invocation.cxxIDirs.dirsIquote...)
+	return nil
+}
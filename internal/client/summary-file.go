@@ -0,0 +1,70 @@
+package client
+
+import (
+	"os"
+)
+
+// SummaryFile is NOCC_SUMMARY_FILE: every invocation compiled remotely appends a TSV record there,
+// so that timings could be analyzed offline without parsing a log, see InvocationSummary.ToTSVRecord.
+// Invocations are handled concurrently, that's why records are written by a single goroutine.
+// NOCC_UPLOADS_FILE is written the same way, with its own columns, see makeUploadsTSVRecords.
+type SummaryFile struct {
+	file    *os.File
+	records chan string
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+func MakeSummaryFile(fileName string) (*SummaryFile, error) {
+	return makeTSVFile(fileName, summaryTSVHeader)
+}
+
+// makeTSVFile opens a file for appending records, a header is written only to a new (empty) file.
+func makeTSVFile(fileName string, tsvHeader string) (*SummaryFile, error) {
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if stat, err := file.Stat(); err == nil && stat.Size() == 0 {
+		_, _ = file.WriteString(tsvHeader)
+	}
+
+	summaryFile := &SummaryFile{
+		file:    file,
+		records: make(chan string, 256),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go summaryFile.writeRecordsUntilClosed()
+	return summaryFile, nil
+}
+
+func (summaryFile *SummaryFile) writeRecordsUntilClosed() {
+	defer close(summaryFile.done)
+	for {
+		select {
+		case record := <-summaryFile.records:
+			_, _ = summaryFile.file.WriteString(record)
+		case <-summaryFile.stopped:
+			for len(summaryFile.records) > 0 {
+				_, _ = summaryFile.file.WriteString(<-summaryFile.records)
+			}
+			_ = summaryFile.file.Close()
+			return
+		}
+	}
+}
+
+// Append enqueues a record to be written; after Close, records are dropped.
+func (summaryFile *SummaryFile) Append(record string) {
+	select {
+	case summaryFile.records <- record:
+	case <-summaryFile.stopped:
+	}
+}
+
+// Close writes all enqueued records and closes a file.
+func (summaryFile *SummaryFile) Close() {
+	close(summaryFile.stopped)
+	<-summaryFile.done
+}
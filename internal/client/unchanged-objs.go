@@ -0,0 +1,209 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UnchangedObjs is NOCC_SKIP_UNCHANGED: an invocation is skipped (not compiled at all) if its obj is up to date.
+// Normally, a build system decides what to recompile, but some builds (or wrappers) re-run everything.
+// It's conservative: an obj is considered up to date only if it was compiled by nocc with the same cwd and cmd line,
+// it wasn't touched since then, and all files listed in its depfile have the same sizes and mtimes as right after that.
+// The compiler itself (a resolved binary, its size and mtime) is a part of a cmd line fingerprint, so upgrading it recompiles all.
+// Only complete depfiles (-MD, not -MMD, which omits system headers) are trusted.
+// Entries are loaded on start and saved periodically while changed (and on daemon quit), so a killed daemon loses little.
+type UnchangedObjs struct {
+	fileName string
+	saveMu   sync.Mutex // saves are serialized, so that an older snapshot never replaces a newer one
+
+	mu      sync.Mutex
+	entries map[string]unchangedObjEntry // an absolute obj file -> its state after the last compilation
+	changed bool                         // entries were modified after the last Save()
+}
+
+// mtimes of dependencies must be older than an invocation by this, to tolerate coarse mtime granularity and clock skew
+const unchangedDepsMTimeSlack = 2 * time.Second
+
+type unchangedObjEntry struct {
+	CmdLine  string `json:"cmd_line"` // sha256 of cwd, cmd line and compiler identity: flags or compiler changed => recompile
+	Deps     string `json:"deps"`     // sha256 of names, sizes and mtimes of files listed in a depfile
+	ObjSize  int64  `json:"obj_size"`
+	ObjMTime int64  `json:"obj_mtime"` // unix nanos
+}
+
+func MakeUnchangedObjs(fileName string) (*UnchangedObjs, error) {
+	unchangedObjs := &UnchangedObjs{
+		fileName: fileName,
+		entries:  make(map[string]unchangedObjEntry, 1024),
+	}
+
+	if ok, err := loadStateFile(fileName, &unchangedObjs.entries); err != nil {
+		return nil, err
+	} else if !ok {
+		unchangedObjs.entries = make(map[string]unchangedObjEntry, 1024)
+	}
+	return unchangedObjs, nil
+}
+
+// IsUnchanged is called before compiling: if true, an obj is up to date, and nothing should be done.
+func (unchangedObjs *UnchangedObjs) IsUnchanged(invocation *Invocation, cmdLine []string) bool {
+	objOutFileAbs := invocation.GetObjOutFileAbs(invocation.cwd)
+	unchangedObjs.mu.Lock()
+	entry, exists := unchangedObjs.entries[objOutFileAbs]
+	unchangedObjs.mu.Unlock()
+	if !exists || entry.CmdLine != calcCmdLineFingerprint(invocation.cwd, cmdLine) {
+		return false
+	}
+
+	stat, err := os.Stat(objOutFileAbs)
+	if err != nil || stat.Size() != entry.ObjSize || stat.ModTime().UnixNano() != entry.ObjMTime {
+		return false
+	}
+	depsFingerprint, _, ok := calcDepsFingerprint(invocation)
+	return ok && depsFingerprint == entry.Deps
+}
+
+// Remember is called after compiling (remotely or locally): a successfully compiled obj could be skipped next time.
+func (unchangedObjs *UnchangedObjs) Remember(invocation *Invocation, cmdLine []string, compiledOk bool) {
+	objOutFileAbs := invocation.GetObjOutFileAbs(invocation.cwd)
+	entry := unchangedObjEntry{CmdLine: calcCmdLineFingerprint(invocation.cwd, cmdLine)}
+
+	var maxDepMTime int64
+	ok := compiledOk
+	if ok {
+		entry.Deps, maxDepMTime, ok = calcDepsFingerprint(invocation)
+	}
+	// a dependency was modified while compiling (or its mtime is in the future because of clock skew):
+	// an obj may be built from its old contents, so it'll be compiled again next time
+	ok = ok && maxDepMTime < invocation.createTime.Add(-unchangedDepsMTimeSlack).UnixNano()
+	if ok {
+		stat, err := os.Stat(objOutFileAbs)
+		if ok = err == nil; ok {
+			entry.ObjSize, entry.ObjMTime = stat.Size(), stat.ModTime().UnixNano()
+		}
+	}
+
+	unchangedObjs.mu.Lock()
+	if ok {
+		unchangedObjs.entries[objOutFileAbs] = entry
+	} else {
+		delete(unchangedObjs.entries, objOutFileAbs)
+	}
+	unchangedObjs.changed = true
+	unchangedObjs.mu.Unlock()
+}
+
+// Save writes all entries as JSON; a file is replaced atomically, so it's never seen partially written.
+func (unchangedObjs *UnchangedObjs) Save() error {
+	unchangedObjs.saveMu.Lock()
+	defer unchangedObjs.saveMu.Unlock()
+
+	unchangedObjs.mu.Lock()
+	contents, err := json.Marshal(unchangedObjs.entries)
+	unchangedObjs.changed = false
+	unchangedObjs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = saveStateFile(unchangedObjs.fileName, contents); err != nil {
+		unchangedObjs.mu.Lock()
+		unchangedObjs.changed = true
+		unchangedObjs.mu.Unlock()
+	}
+	return err
+}
+
+// SaveIfChanged is called periodically by a daemon, so that the state survives a crash or a kill.
+func (unchangedObjs *UnchangedObjs) SaveIfChanged() error {
+	unchangedObjs.mu.Lock()
+	changed := unchangedObjs.changed
+	unchangedObjs.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return unchangedObjs.Save()
+}
+
+func calcCmdLineFingerprint(cwd string, cmdLine []string) string {
+	hasher := sha256.New()
+	_, _ = fmt.Fprintf(hasher, "%s\000", cwd)
+	if len(cmdLine) > 0 {
+		_, _ = fmt.Fprintf(hasher, "%s\000", calcCompilerIdentity(cwd, cmdLine[0]))
+	}
+	for _, arg := range cmdLine {
+		_, _ = fmt.Fprintf(hasher, "%s\000", arg)
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// calcCompilerIdentity returns a resolved compiler binary with its size and mtime, they change on a compiler upgrade.
+// (`--version` is more precise, but it's a process launch per invocation, whereas a package upgrade rewrites a binary anyway)
+// If a compiler can't be found, just its name is returned: such an invocation will fail, it won't be remembered.
+func calcCompilerIdentity(cwd string, cxxName string) string {
+	cxxPath := cxxName
+	if strings.ContainsRune(cxxName, '/') {
+		cxxPath = pathAbs(cwd, cxxName)
+	} else if lookedUp, err := exec.LookPath(cxxName); err == nil {
+		cxxPath = lookedUp
+	}
+	if resolved, err := filepath.EvalSymlinks(cxxPath); err == nil {
+		cxxPath = resolved
+	}
+	stat, err := os.Stat(cxxPath)
+	if err != nil {
+		return cxxName
+	}
+	return fmt.Sprintf("%s\000%d\000%d", cxxPath, stat.Size(), stat.ModTime().UnixNano())
+}
+
+// readDepsOfDepFile returns absolute names of files listed in a depfile written along with an obj.
+func readDepsOfDepFile(invocation *Invocation) ([]string, bool) {
+	if !invocation.depsFlags.ShouldGenerateDepFile() || invocation.depsFlags.flagMMD {
+		return nil, false
+	}
+	depFileName := invocation.depsFlags.calcOutputDepFileName(invocation)
+	if strings.HasPrefix(depFileName, "/dev/") || strings.HasPrefix(depFileName, "/proc/") {
+		return nil, false
+	}
+	depFile, err := MakeDepFileFromFile(pathAbs(invocation.cwd, depFileName))
+	if err != nil {
+		return nil, false
+	}
+
+	var deps []string
+	for _, dTarget := range depFile.DTargets {
+		for _, dep := range dTarget.TargetDepList {
+			deps = append(deps, pathAbs(invocation.cwd, dep))
+		}
+	}
+	return deps, len(deps) > 0
+}
+
+// calcDepsFingerprint hashes names, sizes and mtimes of all dependencies; if any of them doesn't exist, ok is false.
+func calcDepsFingerprint(invocation *Invocation) (fingerprint string, maxMTime int64, ok bool) {
+	deps, ok := readDepsOfDepFile(invocation)
+	if !ok {
+		return "", 0, false
+	}
+	hasher := sha256.New()
+	for _, dep := range deps {
+		stat, err := os.Stat(dep)
+		if err != nil {
+			return "", 0, false
+		}
+		_, _ = fmt.Fprintf(hasher, "%s\000%d\000%d\000", dep, stat.Size(), stat.ModTime().UnixNano())
+		if stat.ModTime().UnixNano() > maxMTime {
+			maxMTime = stat.ModTime().UnixNano()
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), maxMTime, true
+}
@@ -0,0 +1,31 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VKCOM/nocc/pb"
+)
+
+// NOCC_UPLOADS_FILE is an audit of what leaves a machine: a record for every file uploaded to a remote.
+// Only paths are written, never contents. A file is listed when its upload starts (even if it fails later),
+// and only if a remote asked for it: files already uploaded by this daemon or found in src cache aren't sent again.
+// Note, that a source preprocessed locally (see Invocation.preprocessDirectivesOnly) is a temporary file with all headers inside.
+// uploadsTSVHeader is the first line of it, columns of makeUploadsTSVRecords.
+const uploadsTSVHeader = "time\tsession_id\tcpp_in_file\tremote\tuploaded_file\n"
+
+// makeUploadsTSVRecords outputs a line for every file in fileIndexesToUpload, see uploadsTSVHeader.
+func makeUploadsTSVRecords(invocation *Invocation, remoteHost string, requiredFiles []*pb.FileMetadata, fileIndexesToUpload []uint32) string {
+	b := strings.Builder{}
+	for _, fileIndex := range fileIndexesToUpload {
+		writeUploadsTSVRecord(&b, invocation, remoteHost, requiredFiles[fileIndex].ClientFileName)
+	}
+	return b.String()
+}
+
+func writeUploadsTSVRecord(b *strings.Builder, invocation *Invocation, remoteHost string, uploadedFile string) {
+	replacer := strings.NewReplacer("\t", " ", "\n", " ")
+	fmt.Fprintf(b, "%s\t%d\t%s\t%s\t%s\n",
+		time.Now().Format(time.RFC3339Nano), invocation.sessionID, replacer.Replace(invocation.cppInFile), remoteHost, replacer.Replace(uploadedFile))
+}
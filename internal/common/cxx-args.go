@@ -0,0 +1,102 @@
+package common
+
+import "strings"
+
+// IsCxxArgIrrelevantForRemote detects options that have no effect on a resulting .o file when compiling remotely.
+// Such options are stripped off by a client before sending cxxArgs to a server,
+// and they are ignored by a server when calculating obj cache key (in case an older client still sends them).
+// Otherwise, two clients that differ only in, say, `-pipe` would never share obj cache.
+//
+// The list is:
+// * -pipe: use pipes instead of temporary files between compilation stages, it's purely local
+// * -Wl,{opts} and -L{dir}: linker options, the linker is never invoked for `-c`
+func IsCxxArgIrrelevantForRemote(cxxArg string) bool {
+	return cxxArg == "-pipe" ||
+		strings.HasPrefix(cxxArg, "-Wl,") ||
+		(strings.HasPrefix(cxxArg, "-L") && len(cxxArg) > 2)
+}
+
+// IsCxxArgWritingSideFiles detects options that make cxx write files besides .o, typically next to it:
+// -fdump-* (except modifiers like -fdump-noaddr), -save-temps, -fstack-usage (.su), -gsplit-dwarf (.dwo),
+// --coverage / -ftest-coverage (.gcno), -fcallgraph-info, -aux-info, clang's -ftime-trace and -fsave-optimization-record.
+// Only .o is sent back from a remote, so such files would be lost; these invocations are compiled locally.
+// Options that print to stdout/stderr (-ftime-report, -fdump-passes, -fopt-info without =file) work remotely.
+func IsCxxArgWritingSideFiles(cxxArg string) bool {
+	if strings.HasPrefix(cxxArg, "-fdump-") {
+		return cxxArg != "-fdump-noaddr" && cxxArg != "-fdump-passes" && !strings.HasPrefix(cxxArg, "-fdump-unnumbered")
+	}
+	if strings.HasPrefix(cxxArg, "-fopt-info") {
+		return strings.Contains(cxxArg, "=")
+	}
+	return strings.HasPrefix(cxxArg, "-save-temps") ||
+		cxxArg == "-fstack-usage" ||
+		cxxArg == "-gsplit-dwarf" ||
+		cxxArg == "--coverage" ||
+		cxxArg == "-ftest-coverage" ||
+		strings.HasPrefix(cxxArg, "-fcallgraph-info") ||
+		cxxArg == "-aux-info" ||
+		strings.HasPrefix(cxxArg, "-ftime-trace") ||
+		strings.HasPrefix(cxxArg, "-fsave-optimization-record")
+}
+
+// IsCxxArgQuery detects options that make cxx print something about itself instead of compiling:
+// -dumpversion, -dumpmachine, -print-file-name=libstdc++.a, -print-prog-name=ld, --version and so on.
+// Build systems call them a lot while configuring; there is nothing to compile, they are executed locally.
+// Note, that -dumpbase / -dumpdir are not queries: they are output naming options taking an argument.
+func IsCxxArgQuery(cxxArg string) bool {
+	if strings.HasPrefix(cxxArg, "-dump") {
+		return cxxArg != "-dumpbase" && cxxArg != "-dumpbase-ext" && cxxArg != "-dumpdir"
+	}
+	return strings.HasPrefix(cxxArg, "-print-") ||
+		strings.HasPrefix(cxxArg, "--print-") ||
+		cxxArg == "--version"
+}
+
+// ParseCxxTarget resolves a target cxx compiles for: a triple from clang's -target {triple} / --target={triple}
+// and a data model from -m32 / -m64 / -mx32 / -m16; like in cxx, the last one wins.
+// It's empty if cxxArgs contain none of them (the default target of cxx is used).
+// It's a part of obj cache key, so that objs for different targets never alias, see ObjFileCache.MakeObjCacheKey.
+func ParseCxxTarget(cxxArgs []string) string {
+	triple, model := "", ""
+	for i := 0; i < len(cxxArgs); i++ {
+		arg := cxxArgs[i]
+		if (arg == "-target" || arg == "--target") && i+1 < len(cxxArgs) {
+			triple = cxxArgs[i+1]
+			i++
+		} else if strings.HasPrefix(arg, "--target=") {
+			triple = arg[len("--target="):]
+		} else if arg == "-m32" || arg == "-m64" || arg == "-mx32" || arg == "-m16" {
+			model = arg[1:]
+		}
+	}
+	return strings.TrimSpace(triple + " " + model)
+}
+
+// SplitCxxMStdoutWords splits output of -M by whitespace, like bufio.ScanWords, but respects escaping in file names:
+// "dir\ with\ space/1.h" is one word "dir with space/1.h", "\#" is "#", "$$" is "$".
+func SplitCxxMStdoutWords(cxxMStdout []byte) []string {
+	words := make([]string, 0, 64)
+	word := make([]byte, 0, 128)
+	for i := 0; i < len(cxxMStdout); i++ {
+		c := cxxMStdout[i]
+		switch {
+		case c == '\\' && i+1 < len(cxxMStdout) && (cxxMStdout[i+1] == ' ' || cxxMStdout[i+1] == '#'):
+			i++
+			word = append(word, cxxMStdout[i])
+		case c == '$' && i+1 < len(cxxMStdout) && cxxMStdout[i+1] == '$':
+			i++
+			word = append(word, '$')
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = word[:0]
+			}
+		default:
+			word = append(word, c)
+		}
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
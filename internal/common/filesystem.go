@@ -6,6 +6,7 @@ import (
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 func MkdirForFile(fileName string) error {
@@ -15,11 +16,49 @@ func MkdirForFile(fileName string) error {
 	return nil
 }
 
+// IsSafeClientFileName checks a file name sent by a client inside a file (not as a request field), like a dependency of .nocc-pch:
+// it must be absolute and have no ".." elements, so that rootDir + name can't point outside rootDir.
+// Unlike server.Client.MapClientFileNameToServerAbs, such names are not cleaned into rootDir, but rejected:
+// they are produced by nocc clients from absolute paths, so anything else is a corrupted (or crafted) file.
+func IsSafeClientFileName(fileName string) bool {
+	if !strings.HasPrefix(fileName, "/") {
+		return false
+	}
+	for _, part := range strings.Split(fileName, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
 func OpenTempFile(fullPath string) (f *os.File, err error) {
 	fileNameTmp := fullPath + "." + strconv.Itoa(rand.Int())
 	return os.OpenFile(fileNameTmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, os.ModePerm)
 }
 
+// WriteFileViaTempFile is like os.WriteFile, but fullPath never appears partially written:
+// contents are written to a temporary file nearby, which is then renamed.
+// Build systems watching for output existence rely on this.
+func WriteFileViaTempFile(fullPath string, contents []byte) error {
+	fileTmp, err := OpenTempFile(fullPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = fileTmp.Write(contents)
+	if errClose := fileTmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(fileTmp.Name(), fullPath)
+	}
+	if err != nil {
+		_ = os.Remove(fileTmp.Name())
+	}
+	return err
+}
+
 func ReplaceFileExt(fileName string, newExt string) string {
 	logExt := path.Ext(fileName)
 	return fileName[0:len(fileName)-len(logExt)] + newExt
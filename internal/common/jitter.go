@@ -0,0 +1,16 @@
+package common
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Jitter randomly spreads d by ±fraction of it (e.g. 0.2 for ±20%).
+// It's used for periodic loops and retry backoffs: when a whole fleet restarts (or a server recovers) at once,
+// clients drift apart instead of ticking and reconnecting in lockstep, which would hit recovering servers like a storm.
+func Jitter(d time.Duration, fraction float64) time.Duration {
+	if d <= 0 || fraction <= 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*fraction*float64(d))
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	"bytes"
+	"compress/gzip"
 	"crypto/sha256"
 	"fmt"
 	"io"
@@ -13,6 +14,15 @@ import (
 
 const (
 	pchContentsDepIncludesSeparator = "#=======#'\"\\/\"'#=======#"
+
+	// 1: no FORMAT= line, dependencies are in plain text
+	// 2: DEPS_COMPRESSION= line, dependencies may be gzipped (then old servers fail to extract them)
+	// 3: dependencies with equal contents may reference the first one ("\\=" instead of "\\" before size)
+	// a file is saved with the minimal format needed to read it, so that older servers would accept it if possible
+	ownPchFormatVersion = 3
+
+	// a header is usually a few KB, but may be larger with lots of cxx args and include dirs
+	maxOwnPchHeaderSize = 1024 * 1024
 )
 
 type ownPchDepInclude struct {
@@ -58,6 +68,11 @@ type OwnPch struct {
 	CxxArgs     []string
 	CxxIDirs    []string
 	DepIncludes []ownPchDepInclude
+
+	FormatVersion   int
+	DepsCompression string // "gzip" or "none"
+	DepsCount       int    // expected on extracting, -1 if unknown (a file generated by an older client)
+	DepsSize        int64  // the same
 }
 
 func (ownPch *OwnPch) AddDepInclude(fileName string, fileSize int64, fileSHA256 SHA256) {
@@ -94,7 +109,9 @@ func (ownPch *OwnPch) CalcPchHash() {
 }
 
 // SaveToOwnPchFile is invoked on the client side to create a .nocc-pch file.
-func (ownPch *OwnPch) SaveToOwnPchFile() (int64, error) {
+// If compressDeps, the dependencies section is gzipped (it's the biggest part), whereas the header is left plain text.
+// If dedupeDeps, dependencies with equal contents are saved once (FORMAT=3, older servers fail to extract such files).
+func (ownPch *OwnPch) SaveToOwnPchFile(compressDeps bool, dedupeDeps bool) (int64, error) {
 	f, err := OpenTempFile(ownPch.OwnPchFile)
 	if err != nil {
 		return 0, err
@@ -105,7 +122,30 @@ func (ownPch *OwnPch) SaveToOwnPchFile() (int64, error) {
 		depsSize += dep.fileSize
 	}
 
+	// generated headers are often byte-identical: their contents are saved once, others just reference it
+	hasEqualDeps := false
+	if dedupeDeps {
+		uniqueDeps := make(map[SHA256]bool, len(ownPch.DepIncludes))
+		for _, dep := range ownPch.DepIncludes {
+			hasEqualDeps = hasEqualDeps || uniqueDeps[dep.fileSHA256]
+			uniqueDeps[dep.fileSHA256] = true
+		}
+	}
+
+	ownPch.FormatVersion = 2
+	if hasEqualDeps {
+		ownPch.FormatVersion = 3
+	}
+	ownPch.DepsCompression = "none"
+	if compressDeps {
+		ownPch.DepsCompression = "gzip"
+	}
+
 	fmt.Fprintf(f, "PCH_HASH=%s\n\n", ownPch.PchHash.ToLongHexString())
+	fmt.Fprintf(f, "FORMAT=%d\n", ownPch.FormatVersion)
+	fmt.Fprintf(f, "DEPS_COMPRESSION=%s\n", ownPch.DepsCompression)
+	fmt.Fprintf(f, "DEPS_COUNT=%d\n", len(ownPch.DepIncludes))
+	fmt.Fprintf(f, "DEPS_SIZE=%d\n\n", depsSize)
 
 	fmt.Fprintf(f, "# this is a nocc precompiled header generated from\n")
 	fmt.Fprintf(f, "ORIG_HDR=%s\n", ownPch.OrigHFile)
@@ -125,19 +165,39 @@ func (ownPch *OwnPch) SaveToOwnPchFile() (int64, error) {
 	fmt.Fprintf(f, "# make sure to manually regenerate this file whenever dependent files are changed\n")
 	fmt.Fprintf(f, "\n")
 
+	// a compressed section starts after a separator line, so that ParseOwnPchFile finds the header end as usual
+	var depsWriter io.Writer = f
+	var gzWriter *gzip.Writer
+	if compressDeps {
+		fmt.Fprintf(f, "%s gzip\n", pchContentsDepIncludesSeparator)
+		gzWriter = gzip.NewWriter(f)
+		depsWriter = gzWriter
+	}
+
 	var contents []byte
+	savedDeps := make(map[SHA256]bool, len(ownPch.DepIncludes))
 	for _, dep := range ownPch.DepIncludes {
-		fmt.Fprintf(f, "%s %s \\%d %s\n", pchContentsDepIncludesSeparator, dep.fileName, dep.fileSize, dep.fileSHA256.ToLongHexString())
+		if hasEqualDeps && savedDeps[dep.fileSHA256] {
+			fmt.Fprintf(depsWriter, "%s %s \\=%d %s\n", pchContentsDepIncludesSeparator, dep.fileName, dep.fileSize, dep.fileSHA256.ToLongHexString())
+			continue
+		}
+		savedDeps[dep.fileSHA256] = true
+		fmt.Fprintf(depsWriter, "%s %s \\%d %s\n", pchContentsDepIncludesSeparator, dep.fileName, dep.fileSize, dep.fileSHA256.ToLongHexString())
 
 		contents, err = os.ReadFile(dep.fileName)
 		if err != nil {
 			break
 		}
-		_, err = f.Write(contents)
+		_, err = depsWriter.Write(contents)
 		if err != nil {
 			break
 		}
 	}
+	if gzWriter != nil {
+		if errClose := gzWriter.Close(); err == nil {
+			err = errClose
+		}
+	}
 
 	stat, _ := f.Stat()
 	_ = f.Close()
@@ -150,7 +210,13 @@ func (ownPch *OwnPch) SaveToOwnPchFile() (int64, error) {
 }
 
 // ExtractAllDepsToRootDir is called on the server side to recreate a client file structure.
+// File names inside a .nocc-pch come from a client as is, so every name is checked not to escape rootDir.
 func (ownPch *OwnPch) ExtractAllDepsToRootDir(rootDir string) error {
+	for _, fileName := range []string{ownPch.OrigHFile, ownPch.OrigPchFile} {
+		if !IsSafeClientFileName(fileName) {
+			return fmt.Errorf("corrupted pch file %q: invalid file name %q", ownPch.OwnPchFile, fileName)
+		}
+	}
 	_ = os.MkdirAll(rootDir, os.ModePerm)
 
 	ownPchFile := ownPch.OwnPchFile
@@ -159,25 +225,62 @@ func (ownPch *OwnPch) ExtractAllDepsToRootDir(rootDir string) error {
 		return err
 	}
 
+	if ownPch.DepsCompression == "gzip" {
+		if contents, err = decompressOwnPchDeps(contents); err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("pch file %q is truncated: compressed dependencies end unexpectedly", ownPchFile)
+		} else if err != nil {
+			return fmt.Errorf("corrupted pch file %q: %v", ownPchFile, err)
+		}
+	}
+
+	// a truncated file (an interrupted upload, a full disk) is distinguished from a format error
+	// by checking sizes of every dependency and their count, see SaveToOwnPchFile
+	onTruncated := func() error {
+		var depsSize int64
+		for _, dep := range ownPch.DepIncludes {
+			depsSize += dep.fileSize
+		}
+		if ownPch.DepsCount == -1 {
+			return fmt.Errorf("pch file %q is truncated: %d dependencies (%d bytes) found", ownPchFile, len(ownPch.DepIncludes), depsSize)
+		}
+		return fmt.Errorf("pch file %q is truncated: %d of %d dependencies (%d of %d bytes) found", ownPchFile, len(ownPch.DepIncludes), ownPch.DepsCount, depsSize, ownPch.DepsSize)
+	}
+
 	ownPch.DepIncludes = make([]ownPchDepInclude, 0, 64)
+	depsContents := make(map[SHA256][]byte, 64)
 
 	sepPos := bytes.Index(contents, []byte(pchContentsDepIncludesSeparator))
 	for sepPos != -1 {
 		dep := ownPchDepInclude{}
 		namePos := sepPos + len(pchContentsDepIncludesSeparator) + 1
+		if namePos > len(contents) {
+			return onTruncated()
+		}
 		sizeOffset := bytes.IndexByte(contents[namePos:], '\\')
 		nlOffset := bytes.IndexByte(contents[namePos:], '\n')
-		if nlOffset == -1 || sizeOffset == -1 || sizeOffset > nlOffset {
-			return fmt.Errorf("corrupted pch file %q", ownPchFile)
+		if nlOffset == -1 {
+			return onTruncated()
+		}
+		// a line is "{name} \{size} {sha256}\n": a name is not empty, and a byte after '\\' is before '\n' (both are read below)
+		if sizeOffset < 2 || sizeOffset >= nlOffset {
+			return fmt.Errorf("corrupted pch file %q: invalid dependency line at offset %d", ownPchFile, sepPos)
 		}
 
 		dep.fileName = string(contents[namePos : namePos+sizeOffset-1])
+		isReference := contents[namePos+sizeOffset+1] == '='
+		sizeFormat := "\\%d %s\n"
+		if isReference {
+			sizeFormat = "\\=%d %s\n"
+		}
 		pchHexStr := ""
-		if n, _ := fmt.Sscanf(string(contents[namePos+sizeOffset:namePos+nlOffset+1]), "\\%d %s\n", &dep.fileSize, &pchHexStr); n != 2 {
-			return fmt.Errorf("corrupted pch file %q", ownPchFile)
+		if n, _ := fmt.Sscanf(string(contents[namePos+sizeOffset:namePos+nlOffset+1]), sizeFormat, &dep.fileSize, &pchHexStr); n != 2 {
+			return fmt.Errorf("corrupted pch file %q: invalid size of %s", ownPchFile, dep.fileName)
 		}
 		if dep.fileSHA256.FromLongHexString(pchHexStr); dep.fileSHA256.IsEmpty() {
-			return fmt.Errorf("corrupted pch file %q", ownPchFile)
+			return fmt.Errorf("corrupted pch file %q: invalid sha256 of %s", ownPchFile, dep.fileName)
+		}
+		if !IsSafeClientFileName(dep.fileName) {
+			return fmt.Errorf("corrupted pch file %q: invalid file name %q", ownPchFile, dep.fileName)
 		}
 		ownPch.DepIncludes = append(ownPch.DepIncludes, dep)
 
@@ -193,6 +296,22 @@ func (ownPch *OwnPch) ExtractAllDepsToRootDir(rootDir string) error {
 			sepPos = startCPos + endOffset
 		}
 
+		if isReference {
+			var exists bool
+			if depC, exists = depsContents[dep.fileSHA256]; !exists {
+				return fmt.Errorf("corrupted pch file %q: no contents for %s", ownPchFile, dep.fileName)
+			}
+		} else {
+			depsContents[dep.fileSHA256] = depC
+		}
+		if int64(len(depC)) != dep.fileSize {
+			if sepPos == -1 && int64(len(depC)) < dep.fileSize {
+				ownPch.DepIncludes[len(ownPch.DepIncludes)-1].fileSize = int64(len(depC))
+				return onTruncated()
+			}
+			return fmt.Errorf("corrupted pch file %q: %s has %d bytes, expected %d", ownPchFile, dep.fileName, len(depC), dep.fileSize)
+		}
+
 		serverFileName := path.Join(rootDir, dep.fileName)
 		if err = MkdirForFile(serverFileName); err != nil {
 			return err
@@ -202,9 +321,31 @@ func (ownPch *OwnPch) ExtractAllDepsToRootDir(rootDir string) error {
 		}
 	}
 
+	if ownPch.DepsCount != -1 && len(ownPch.DepIncludes) < ownPch.DepsCount {
+		return onTruncated()
+	}
 	return MkdirForFile(path.Join(rootDir, ownPch.OrigPchFile))
 }
 
+// decompressOwnPchDeps returns a gzipped dependencies section of a .nocc-pch file, see SaveToOwnPchFile.
+func decompressOwnPchDeps(contents []byte) ([]byte, error) {
+	sepPos := bytes.Index(contents, []byte(pchContentsDepIncludesSeparator))
+	if sepPos == -1 {
+		return nil, fmt.Errorf("no compressed section")
+	}
+	nlOffset := bytes.IndexByte(contents[sepPos:], '\n')
+	if nlOffset == -1 {
+		return nil, fmt.Errorf("no compressed section")
+	}
+
+	gzReader, err := gzip.NewReader(bytes.NewReader(contents[sepPos+nlOffset+1:]))
+	if err != nil {
+		return nil, err
+	}
+	defer gzReader.Close()
+	return io.ReadAll(gzReader)
+}
+
 func (ownPch *OwnPch) DebugDepsStr() string {
 	pchDepsStr := ""
 	for _, dep := range ownPch.DepIncludes {
@@ -220,15 +361,34 @@ func ParseOwnPchFile(ownPchFile string) (*OwnPch, error) {
 	}
 	defer file.Close()
 
-	headContents := make([]byte, 32*1024)
-	_, _ = io.ReadFull(file, headContents)
-	sepPos := bytes.Index(headContents, []byte(pchContentsDepIncludesSeparator))
-	if sepPos == -1 {
-		return nil, fmt.Errorf("corrupted pch file %q", ownPchFile)
+	// read the header till the first dependency
+	headContents := make([]byte, 0, 32*1024)
+	chunk := make([]byte, 32*1024)
+	sepPos := -1
+	for sepPos == -1 {
+		n, err := file.Read(chunk)
+		headContents = append(headContents, chunk[:n]...)
+		sepPos = bytes.Index(headContents, []byte(pchContentsDepIncludesSeparator))
+		if sepPos != -1 {
+			break
+		}
+		if err == io.EOF {
+			return nil, fmt.Errorf("pch file %q is truncated: no dependencies found in %d bytes", ownPchFile, len(headContents))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(headContents) > maxOwnPchHeaderSize {
+			return nil, fmt.Errorf("corrupted pch file %q: no dependencies found in the first %d bytes", ownPchFile, maxOwnPchHeaderSize)
+		}
 	}
 
 	ownPch := OwnPch{
-		OwnPchFile: ownPchFile,
+		OwnPchFile:      ownPchFile,
+		FormatVersion:   1,
+		DepsCompression: "none",
+		DepsCount:       -1,
+		DepsSize:        -1,
 	}
 
 	headLines := strings.Split(string(headContents[:sepPos]), "\n")
@@ -251,10 +411,40 @@ func ParseOwnPchFile(ownPchFile string) (*OwnPch, error) {
 		if strings.HasPrefix(line, "CXX_DIRS=") {
 			ownPch.CxxIDirs = strings.Split(line[9:], " ")
 		}
+		if strings.HasPrefix(line, "FORMAT=") {
+			ownPch.FormatVersion, _ = strconv.Atoi(line[7:])
+		}
+		if strings.HasPrefix(line, "DEPS_COMPRESSION=") {
+			ownPch.DepsCompression = line[17:]
+		}
+		if strings.HasPrefix(line, "DEPS_COUNT=") {
+			ownPch.DepsCount, _ = strconv.Atoi(line[11:])
+		}
+		if strings.HasPrefix(line, "DEPS_SIZE=") {
+			ownPch.DepsSize, _ = strconv.ParseInt(line[10:], 10, 64)
+		}
+	}
+
+	// a file generated by a newer nocc client: fail clearly instead of compiling garbage
+	if ownPch.FormatVersion > ownPchFormatVersion || (ownPch.DepsCompression != "none" && ownPch.DepsCompression != "gzip") {
+		return nil, fmt.Errorf("pch file %q has format %d (deps compression %q), it's unsupported by nocc %s; upgrade nocc or regenerate the file", ownPchFile, ownPch.FormatVersion, ownPch.DepsCompression, GetVersion())
 	}
 
-	if len(ownPch.CxxName) == 0 || len(ownPch.CxxArgs) == 0 || len(ownPch.OrigPchFile) == 0 || ownPch.PchHash.IsEmpty() {
-		return nil, fmt.Errorf("corrupted pch file %q", ownPchFile)
+	var missingFields []string
+	if ownPch.PchHash.IsEmpty() {
+		missingFields = append(missingFields, "PCH_HASH")
+	}
+	if len(ownPch.OrigPchFile) == 0 {
+		missingFields = append(missingFields, "ORIG_PCH")
+	}
+	if len(ownPch.CxxName) == 0 {
+		missingFields = append(missingFields, "CXX_NAME")
+	}
+	if len(ownPch.CxxArgs) == 0 {
+		missingFields = append(missingFields, "CXX_ARGS")
+	}
+	if len(missingFields) != 0 {
+		return nil, fmt.Errorf("corrupted pch file %q: missing %s in the header", ownPchFile, strings.Join(missingFields, ", "))
 	}
 	return &ownPch, nil
 }
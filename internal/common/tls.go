@@ -0,0 +1,21 @@
+package common
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// LoadCertPool reads PEM certificates (a CA bundle) from a file, for verifying a peer's certificate.
+// It's used by a client for NOCC_TLS_CA and by a server for -tls-client-ca.
+func LoadCertPool(fileName string) (*x509.CertPool, error) {
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(contents) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", fileName)
+	}
+	return certPool, nil
+}
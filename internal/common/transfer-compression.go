@@ -0,0 +1,81 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// files smaller than this are sent as is: a gzip header and CPU aren't worth a few saved bytes
+const minCompressedTransferSize = 4 * 1024
+
+// already compressed files: gzipping them again wastes CPU and even grows them
+var incompressibleTransferExtensions = []string{
+	".nocc-pch", // its dependencies section is gzipped (NOCC_COMPRESS_OWN_PCH), the rest is small
+	".gz", ".xz", ".zst", ".bz2", ".zip",
+}
+
+// ShouldCompressTransfer is a policy whether a file is gzipped while sent over a network (NOCC_COMPRESS_TRANSFERS).
+// It's used by both sides: by a client for uploaded sources and headers, by a server for objs sent back.
+// Text files and objs are compressed several times; tiny and already compressed ones are sent as is.
+func ShouldCompressTransfer(fileName string, fileSize int64) bool {
+	if fileSize < minCompressedTransferSize {
+		return false
+	}
+	for _, ext := range incompressibleTransferExtensions {
+		if strings.HasSuffix(fileName, ext) {
+			return false
+		}
+	}
+	return true
+}
+
+var gzipWritersPool = sync.Pool{
+	New: func() any {
+		gzWriter, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return gzWriter
+	},
+}
+
+// GzipTransferChunk compresses one chunk of a file being sent; every chunk is compressed separately,
+// so that a receiver doesn't keep a state between chunks.
+// If a chunk doesn't shrink, ok is false, and it should be sent as is.
+func GzipTransferChunk(chunk []byte) (gzipped []byte, ok bool) {
+	var buf bytes.Buffer
+	buf.Grow(len(chunk) / 2)
+	gzWriter := gzipWritersPool.Get().(*gzip.Writer)
+	gzWriter.Reset(&buf)
+	_, err := gzWriter.Write(chunk)
+	if err == nil {
+		err = gzWriter.Close()
+	}
+	gzipWritersPool.Put(gzWriter)
+	if err != nil || buf.Len() >= len(chunk) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// GunzipTransferChunk decompresses a chunk compressed by GzipTransferChunk.
+// A chunk can't be larger than the rest of a file (maxBytes): a few KB of gzip could otherwise inflate
+// into gigabytes in memory (a gzip bomb), that's why reading is limited, and a larger chunk is an error.
+func GunzipTransferChunk(gzipped []byte, maxBytes int) ([]byte, error) {
+	gzReader, err := gzip.NewReader(bytes.NewReader(gzipped))
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	chunk, err := io.ReadAll(io.LimitReader(gzReader, int64(maxBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(chunk) > maxBytes {
+		return nil, fmt.Errorf("gzipped chunk exceeds %d bytes left of a file", maxBytes)
+	}
+	return chunk, nil
+}
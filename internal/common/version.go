@@ -1,11 +1,47 @@
 package common
 
+import (
+	"encoding/json"
+	"runtime"
+)
+
 // version is provided by `go build`, see Makefile (same for client and server)
 var version string
 
+// release, buildCommit and buildDate are also provided by `go build`; version is composed of them
+var release string
+var buildCommit string
+var buildDate string
+
 func GetVersion() string {
 	if len(version) == 0 {
 		return "Unknown"
 	}
 	return version
 }
+
+// VersionInfo is build metadata printed by `-version -json`, for tooling that inventories nocc across machines.
+type VersionInfo struct {
+	Version     string `json:"version"`
+	Release     string `json:"release"`
+	BuildCommit string `json:"build_commit"`
+	BuildDate   string `json:"build_date"`
+	GoVersion   string `json:"go_version"`
+	Platform    string `json:"platform"`
+}
+
+func GetVersionInfo() VersionInfo {
+	return VersionInfo{
+		Version:     GetVersion(),
+		Release:     release,
+		BuildCommit: buildCommit,
+		BuildDate:   buildDate,
+		GoVersion:   runtime.Version(),
+		Platform:    runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+func GetVersionJSON() string {
+	out, _ := json.Marshal(GetVersionInfo())
+	return string(out)
+}
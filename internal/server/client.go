@@ -11,6 +11,8 @@ import (
 
 	"github.com/VKCOM/nocc/internal/common"
 	"github.com/VKCOM/nocc/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -40,6 +42,8 @@ type fileInClientDir struct {
 	uploadStartTime time.Time
 
 	serverFileName string // abs path, see Client.MapClientFileNameToServerAbs
+
+	usedBySessions int32 // atomic; while it's not 0, a file isn't replaced by another sha256, see StartUsingFileInSession
 }
 
 // Client represents a client machine that has set up a connection to server.
@@ -59,6 +63,15 @@ type Client struct {
 	chanDisconnected  chan struct{}
 	chanReadySessions chan *Session
 	disableObjCache   bool
+	echoServerCmdLine bool   // send back a server cmd line on session start, see Session.ServerCmdLineForDebug
+	compressTransfers bool   // a client receives gzipped chunks of .o files, see common.ShouldCompressTransfer
+	objCacheNamespace string // env NOCC_OBJ_CACHE_NAMESPACE of a client, see NoccServer.makeObjCacheKey
+
+	// atomics, how many dependencies were taken from src cache instead of uploading, see Client.SrcCacheUsageInfo
+	srcFilesReused   int64
+	srcBytesReused   int64
+	srcFilesUploaded int64
+	srcBytesUploaded int64
 }
 
 func (client *Client) makeNewFile(clientFileName string, fileSize int64, fileSHA256 common.SHA256) *fileInClientDir {
@@ -71,18 +84,44 @@ func (client *Client) makeNewFile(clientFileName string, fileSize int64, fileSHA
 	}
 }
 
+// OnSrcFileReused is called when a file required by a session was found in src cache (hard linked, not uploaded).
+func (client *Client) OnSrcFileReused(stats *Statsd, file *fileInClientDir) {
+	atomic.AddInt64(&client.srcFilesReused, 1)
+	atomic.AddInt64(&client.srcBytesReused, file.fileSize)
+	atomic.AddInt64(&stats.srcFilesReused, 1)
+	atomic.AddInt64(&stats.srcBytesReused, file.fileSize)
+}
+
+// OnSrcFileUploaded is called when a file required by a session was uploaded by a client.
+func (client *Client) OnSrcFileUploaded(stats *Statsd, file *fileInClientDir) {
+	atomic.AddInt64(&client.srcFilesUploaded, 1)
+	atomic.AddInt64(&client.srcBytesUploaded, file.fileSize)
+	atomic.AddInt64(&stats.srcFilesUploaded, 1)
+	atomic.AddInt64(&stats.srcBytesUploaded, file.fileSize)
+}
+
+// SrcCacheUsageInfo describes how much dependency traffic src cache has eliminated for this client, for logs.
+func (client *Client) SrcCacheUsageInfo() string {
+	filesReused, bytesReused := atomic.LoadInt64(&client.srcFilesReused), atomic.LoadInt64(&client.srcBytesReused)
+	filesUploaded, bytesUploaded := atomic.LoadInt64(&client.srcFilesUploaded), atomic.LoadInt64(&client.srcBytesUploaded)
+	return fmt.Sprintf("reused %d files (%d KB) from src cache, uploaded %d files (%d KB)", filesReused, bytesReused/1024, filesUploaded, bytesUploaded/1024)
+}
+
 // MapClientFileNameToServerAbs converts a client file name to an absolute path on server.
 // For example, /proj/1.cpp maps to /tmp/nocc/cpp/clients/{clientID}/proj/1.cpp.
 // Note, that system files like /usr/local/include are required to be equal on both sides.
 // (if not, a server session will fail to start, and a client will fall back to local compilation)
+//
+// A name passed here can't make a server write outside client.workingDir: it's cleaned as if it were relative to "/",
+// so "/proj/../../etc/passwd" or "../../etc/passwd" map to {workingDir}/etc/passwd.
+// (symlinks can't help escaping either: a server never creates them inside workingDir)
+// Names inside uploaded files (dependencies of .nocc-pch) don't go through here, see common.IsSafeClientFileName.
 func (client *Client) MapClientFileNameToServerAbs(clientFileName string) string {
-	if clientFileName[0] == '/' {
-		if IsSystemHeaderPath(clientFileName) {
-			return clientFileName
-		}
-		return client.workingDir + clientFileName
+	cleanFileName := path.Clean("/" + clientFileName)
+	if clientFileName[0] == '/' && IsSystemHeaderPath(cleanFileName) {
+		return cleanFileName
 	}
-	return path.Join(client.workingDir, clientFileName)
+	return client.workingDir + cleanFileName
 }
 
 // MapServerAbsToClientFileName converts an absolute path on server relatively to the client working dir.
@@ -92,25 +131,35 @@ func (client *Client) MapServerAbsToClientFileName(serverFileName string) string
 	return strings.TrimPrefix(serverFileName, client.workingDir)
 }
 
-func (client *Client) CreateNewSession(in *pb.StartCompilationSessionRequest) (*Session, error) {
+func (client *Client) CreateNewSession(in *pb.StartCompilationSessionRequest, maxDeps int) (*Session, error) {
+	// a sanity check before allocating anything: a buggy (or crafted) request mustn't exhaust server resources
+	if maxDeps > 0 && len(in.RequiredFiles) > maxDeps {
+		return nil, status.Errorf(codes.FailedPrecondition, "too many dependencies: %d, max %d", len(in.RequiredFiles), maxDeps)
+	}
+
 	newSession := &Session{
-		sessionID: in.SessionID,
-		files:     make([]*fileInClientDir, len(in.RequiredFiles)),
-		cxxName:   in.CxxName,
-		cppInFile: in.CppInFile, // as specified in a client cmd line invocation (relative to in.Cwd or abs on a client file system)
-		client:    client,
+		sessionID:       in.SessionID,
+		files:           make([]*fileInClientDir, len(in.RequiredFiles)),
+		cxxName:         in.CxxName,
+		cppInFile:       in.CppInFile, // as specified in a client cmd line invocation (relative to in.Cwd or abs on a client file system)
+		clientCppInFile: clientCppInFileAbs(in.Cwd, in.CppInFile),
+		client:          client,
+		startTime:       time.Now(),
+		syntaxOnly:      in.SyntaxOnly,
+		recacheObj:      in.RecacheObj,
 		// objOutFile is filled only in cxx is required to be called, see Session.PrepareServerCxxCmdLine()
 	}
 
 	for index, meta := range in.RequiredFiles {
 		fileSHA256 := common.SHA256{B0_7: meta.SHA256_B0_7, B8_15: meta.SHA256_B8_15, B16_23: meta.SHA256_B16_23, B24_31: meta.SHA256_B24_31}
 		file, err := client.StartUsingFileInSession(meta.ClientFileName, meta.FileSize, fileSHA256)
-		newSession.files[index] = file
 		// the only reason why a session can't be created is a dependency conflict:
-		// previously, a client reported that clientFileName has sha256=v1, and now it sends sha256=v2
+		// previously, a client reported that clientFileName has sha256=v1, and now it sends sha256=v2, while v1 is still in use
 		if err != nil {
+			client.StopUsingFilesInSession(newSession.files[:index])
 			return nil, err
 		}
+		newSession.files[index] = file
 	}
 
 	// note, that we don't add newSession to client.sessions: it's just created, not registered
@@ -130,9 +179,10 @@ func (client *Client) CloseSession(session *Session) {
 	delete(client.sessions, session.sessionID)
 	client.mu.Unlock()
 
-	if !session.objCacheExists { // delete /tmp/nocc/obj/cxx-out/this.o (already hard linked to obj cache)
+	if !session.objCacheExists && session.objOutFile != "" { // delete /tmp/nocc/obj/cxx-out/this.o (already hard linked to obj cache)
 		_ = os.Remove(session.objOutFile)
 	}
+	client.StopUsingFilesInSession(session.files)
 	session.files = nil
 }
 
@@ -167,34 +217,50 @@ func (client *Client) GetSessionsNotStartedCompilation() []*Session {
 // StartUsingFileInSession is called on a session creation for a .cpp file and all dependencies.
 // If it's the first time we see clientFileName, it's created (we start waiting for it to be uploaded).
 // If it already exists, compare client sha256 with what we have (if equal, don't need to upload this file again).
+// A file is marked as used until a session is closed, see StopUsingFilesInSession.
 //
-// The only reason why we can return an error here is a dependency conflict:
-// previously, a client reported that clientFileName has sha256=v1, and now it sends sha256=v2.
+// If sha256 differs, a file was modified on a client-side (e.g. a generated header was regenerated).
+// If no session uses a previous version, it's replaced (and uploaded again).
+// Otherwise, it's a dependency conflict, the only reason why we can return an error here:
+// sessions using a previous version will finish soon, that's why it's codes.Aborted, "a client may retry".
 func (client *Client) StartUsingFileInSession(clientFileName string, fileSize int64, fileSHA256 common.SHA256) (*fileInClientDir, error) {
 	client.mu.RLock()
 	file := client.files[clientFileName]
+	if file != nil && file.fileSHA256 == fileSHA256 {
+		atomic.AddInt32(&file.usedBySessions, 1) // under a lock, not to interleave with replacing below
+		client.mu.RUnlock()
+		return file, nil
+	}
 	client.mu.RUnlock()
 
-	if file == nil {
-		client.mu.Lock()
-		file = client.files[clientFileName]
-		if file != nil {
-			client.mu.Unlock()
-			return file, nil
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	file = client.files[clientFileName]
+	if file != nil && file.fileSHA256 != fileSHA256 {
+		if atomic.LoadInt32(&file.usedBySessions) != 0 {
+			return nil, status.Errorf(codes.Aborted, "file %s is used by active sessions, but now got another sha256 from client", clientFileName)
 		}
-		newFile := client.makeNewFile(clientFileName, fileSize, fileSHA256)
-		client.files[clientFileName] = newFile
-		client.mu.Unlock()
-		return newFile, nil
+		logServer.Info(1, "file", clientFileName, "was modified on a client, replace it")
+		_ = os.Remove(file.serverFileName) // otherwise, a hard link from src cache would fail, leaving an old version
+		file = nil
 	}
-
-	if file.fileSHA256 != fileSHA256 {
-		return nil, fmt.Errorf("file %s was already uploaded, but now got another sha256 from client", clientFileName)
+	if file == nil {
+		file = client.makeNewFile(clientFileName, fileSize, fileSHA256)
+		client.files[clientFileName] = file
 	}
-
+	atomic.AddInt32(&file.usedBySessions, 1)
 	return file, nil
 }
 
+// StopUsingFilesInSession is called when a session is closed (or failed to be created), see StartUsingFileInSession.
+func (client *Client) StopUsingFilesInSession(files []*fileInClientDir) {
+	for _, file := range files {
+		if file != nil {
+			atomic.AddInt32(&file.usedBySessions, -1)
+		}
+	}
+}
+
 // MkdirAllForSession ensures that all directories for saving files from session exist
 // (they mirror client directory structure in client.workingDir).
 // Instead of calling os.MkdirAll for every uploaded or hard linked file, they are created in advance.
@@ -251,14 +317,20 @@ func (client *Client) IsFileUploadHanged(fileWithStateUploading *fileInClientDir
 	return passedSec > 15
 }
 
-func (client *Client) RemoveWorkingDir() {
-	workingDirRenamed := fmt.Sprintf("%s.old.%d", client.workingDir, time.Now().Unix())
+// RemoveWorkingDir renames a working dir to {workingDir}.old.{unixNano} and removes it in the background.
+// With keep (-keep-client-dirs), it's left on disk for post-mortem, see ClientsStorage.DeleteExpiredKeptClientDirs.
+func (client *Client) RemoveWorkingDir(keep bool) {
+	workingDirRenamed := fmt.Sprintf("%s.old.%d", client.workingDir, time.Now().UnixNano())
 
 	client.mu.Lock()
 	_ = os.Rename(client.workingDir, workingDirRenamed)
 	client.files = make(map[string]*fileInClientDir)
 	client.mu.Unlock()
 
+	if keep {
+		logServer.Info(0, "keep client working dir", "clientID", client.clientID, workingDirRenamed)
+		return
+	}
 	go func() {
 		if err := os.RemoveAll(workingDirRenamed); err != nil {
 			logServer.Error("could not remove client working dir", "clientID", client.clientID, workingDirRenamed, err)
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,18 +18,21 @@ type ClientsStorage struct {
 	table map[string]*Client
 	mu    sync.RWMutex
 
-	clientsDir string // /tmp/nocc/cpp/clients
+	clientsDir     string        // /tmp/nocc/cpp/clients
+	keepClientDirs time.Duration // -keep-client-dirs: working dirs of deleted clients are left for post-mortem for this time
 
 	completedCount int64
 	lastPurgeTime  time.Time
+	lastKeptPurge  time.Time
 
 	uniqueRemotesList map[string]string
 }
 
-func MakeClientsStorage(clientsDir string) (*ClientsStorage, error) {
+func MakeClientsStorage(clientsDir string, keepClientDirs time.Duration) (*ClientsStorage, error) {
 	return &ClientsStorage{
 		table:             make(map[string]*Client, 1024),
 		clientsDir:        clientsDir,
+		keepClientDirs:    keepClientDirs,
 		uniqueRemotesList: make(map[string]string, 1),
 	}, nil
 }
@@ -40,7 +45,20 @@ func (allClients *ClientsStorage) GetClient(clientID string) *Client {
 	return client
 }
 
-func (allClients *ClientsStorage) OnClientConnected(clientID string, disableObjCache bool) (*Client, error) {
+// ClientOptions are settings a client sends on registering, see pb.StartClientRequest.
+type ClientOptions struct {
+	DisableObjCache   bool
+	EchoServerCmdLine bool
+	CompressTransfers bool
+	ObjCacheNamespace string
+}
+
+func (allClients *ClientsStorage) OnClientConnected(clientID string, opts ClientOptions) (*Client, error) {
+	// clientID is a name of a working dir, it mustn't point outside clientsDir
+	if clientID == "" || clientID == "." || clientID == ".." || strings.ContainsAny(clientID, "/\x00") {
+		return nil, fmt.Errorf("invalid clientID %q", clientID)
+	}
+
 	allClients.mu.RLock()
 	client := allClients.table[clientID]
 	allClients.mu.RUnlock()
@@ -67,7 +85,10 @@ func (allClients *ClientsStorage) OnClientConnected(clientID string, disableObjC
 		dirs:              make(map[string]bool, 100),
 		chanDisconnected:  make(chan struct{}),
 		chanReadySessions: make(chan *Session, 200),
-		disableObjCache:   disableObjCache,
+		disableObjCache:   opts.DisableObjCache,
+		echoServerCmdLine: opts.EchoServerCmdLine,
+		compressTransfers: opts.CompressTransfers,
+		objCacheNamespace: opts.ObjCacheNamespace,
 	}
 
 	allClients.mu.Lock()
@@ -81,10 +102,41 @@ func (allClients *ClientsStorage) DeleteClient(client *Client) {
 	delete(allClients.table, client.clientID)
 	allClients.mu.Unlock()
 	atomic.AddInt64(&allClients.completedCount, 1)
+	logServer.Info(0, "client", client.clientID, client.SrcCacheUsageInfo())
 
 	close(client.chanDisconnected)
 	// don't close chanReadySessions intentionally, it's not a leak
-	client.RemoveWorkingDir()
+	client.RemoveWorkingDir(allClients.keepClientDirs > 0)
+}
+
+// DeleteExpiredKeptClientDirs removes working dirs left by -keep-client-dirs after their retention period.
+// A time a client was deleted is a suffix of a dir name, see Client.RemoveWorkingDir.
+func (allClients *ClientsStorage) DeleteExpiredKeptClientDirs() {
+	now := time.Now()
+	if allClients.keepClientDirs <= 0 || now.Sub(allClients.lastKeptPurge) < time.Second {
+		return
+	}
+	allClients.lastKeptPurge = now
+
+	entries, err := os.ReadDir(allClients.clientsDir)
+	if err != nil {
+		logServer.Error("could not list clients dir", err)
+		return
+	}
+	for _, entry := range entries {
+		idx := strings.LastIndex(entry.Name(), ".old.")
+		if idx == -1 {
+			continue
+		}
+		deletedNano, err := strconv.ParseInt(entry.Name()[idx+5:], 10, 64)
+		if err != nil || now.Sub(time.Unix(0, deletedNano)) < allClients.keepClientDirs {
+			continue
+		}
+		logServer.Info(0, "remove kept client working dir", entry.Name())
+		if err := os.RemoveAll(path.Join(allClients.clientsDir, entry.Name())); err != nil {
+			logServer.Error("could not remove kept client working dir", entry.Name(), err)
+		}
+	}
 }
 
 func (allClients *ClientsStorage) DeleteInactiveClients() {
@@ -144,6 +196,35 @@ func (allClients *ClientsStorage) ActiveSessionsCount() int64 {
 	return int64(sessionsCount)
 }
 
+// GetLongestActiveSessionsInfo returns up to n sessions running for the longest time (uploading, compiling, etc.).
+// It's for debug purposes — when a server is backed up, to find out which .cpp files are dragging it down.
+func (allClients *ClientsStorage) GetLongestActiveSessionsInfo(n int) (sessionsInfo []string) {
+	activeSessions := make([]*Session, 0)
+	allClients.mu.RLock()
+	for _, client := range allClients.table {
+		client.mu.RLock()
+		for _, session := range client.sessions {
+			activeSessions = append(activeSessions, session)
+		}
+		client.mu.RUnlock()
+	}
+	allClients.mu.RUnlock()
+
+	sort.Slice(activeSessions, func(i, j int) bool {
+		return activeSessions[i].startTime.Before(activeSessions[j].startTime)
+	})
+	if len(activeSessions) > n {
+		activeSessions = activeSessions[:n]
+	}
+
+	sessionsInfo = make([]string, 0, len(activeSessions))
+	for _, session := range activeSessions {
+		elapsed := time.Since(session.startTime).Truncate(time.Millisecond)
+		sessionsInfo = append(sessionsInfo, fmt.Sprintf("%s clientID %s sessionID %d : %s", elapsed, session.client.clientID, session.sessionID, session.cppInFile))
+	}
+	return
+}
+
 func (allClients *ClientsStorage) TotalFilesCountInDirs() int64 {
 	var filesCount int64 = 0
 	allClients.mu.RLock()
@@ -24,3 +24,16 @@ func FilePrefixMapOption(cxxArg string, replaced string) string {
 	}
 	return cxxArg
 }
+
+// SpecsFileOption remaps -specs={file} (or --specs={file}) to a server path.
+// A specs file found on a client is uploaded like a dependency (see client.Invocation.CollectDependentIncludes),
+// but a cxx driver reads it from the path in cmd line, so it must point to an uploaded file.
+// Relative names (like -specs=nano.specs) are looked up by cxx in its own dirs, they are left as is.
+func SpecsFileOption(cxxArg string, mapClientFileNameToServer func(string) string) string {
+	for _, specsOption := range []string{"-specs=", "--specs="} {
+		if strings.HasPrefix(cxxArg, specsOption) && path.IsAbs(cxxArg[len(specsOption):]) {
+			return specsOption + mapClientFileNameToServer(cxxArg[len(specsOption):])
+		}
+	}
+	return cxxArg
+}
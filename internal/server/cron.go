@@ -5,6 +5,8 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/VKCOM/nocc/internal/common"
 )
 
 // Cron calls doCron(), which ticks in the background and used to write stats, delete inactive clients, etc.
@@ -26,13 +28,19 @@ func (c *Cron) doCron() {
 
 	for !c.stopFlag {
 		cronStartTime := time.Now()
+		// with jitter, servers started at once don't evict inactive clients (which then register again) in lockstep
+		tickInterval := common.Jitter(cronTickInterval, 0.1)
 
 		c.noccServer.Stats.SendToStatsd(c.noccServer)
 		c.noccServer.SrcFileCache.PurgeLastElementsIfRequired()
 		c.noccServer.ObjFileCache.PurgeLastElementsIfRequired()
+		c.noccServer.SrcFileCache.ReconcileSizeOnDiskIfRequired()
+		c.noccServer.ObjFileCache.ReconcileSizeOnDiskIfRequired()
 		c.noccServer.ActiveClients.DeleteInactiveClients()
+		c.noccServer.ActiveClients.DeleteExpiredKeptClientDirs()
+		c.noccServer.LogUnauthenticatedClientsSummary()
 
-		sleepTime := cronTickInterval - time.Since(cronStartTime)
+		sleepTime := tickInterval - time.Since(cronStartTime)
 		if sleepTime <= 0 {
 			sleepTime = time.Nanosecond
 		}
@@ -48,18 +56,22 @@ func (c *Cron) doCron() {
 					}
 				} else if sig == syscall.SIGTERM {
 					go c.noccServer.QuitServerGracefully()
+				} else if sig == syscall.SIGINT {
+					// not graceful (like without a handler), but don't leave running cxx behind
+					c.noccServer.CxxLauncher.KillAllRunningCxx()
+					os.Exit(1)
 				}
 			case <-time.After(sleepTime):
 				break
 			}
-			sleepTime = cronTickInterval - time.Since(cronStartTime)
+			sleepTime = tickInterval - time.Since(cronStartTime)
 		}
 	}
 }
 
 func (c *Cron) StartCron() {
 	c.signals = make(chan os.Signal, 2)
-	signal.Notify(c.signals, syscall.SIGUSR1, syscall.SIGTERM)
+	signal.Notify(c.signals, syscall.SIGUSR1, syscall.SIGTERM, syscall.SIGINT)
 	c.doCron()
 }
 
@@ -2,17 +2,29 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type CxxLauncher struct {
 	serverCxxThrottle chan struct{}
+	maxCxxDuration    time.Duration     // cxx is killed after it, 0 means no limit
+	compilerMap       map[string]string // client cxxName (or its basename) -> a compiler to launch on a server, see MapCxxName
+
+	mu          sync.Mutex
+	runningPids map[int]struct{} // pids (= pgids) of launched cxx, to kill them on shutdown
 
 	nSessionsReadyButWaiting int64
 	nSessionsNowCompiling    int64
@@ -22,18 +34,86 @@ type CxxLauncher struct {
 	more10secCount       int64
 	more30secCount       int64
 	nonZeroExitCodeCount int64
+	killedByTimeoutCount int64
+	killedBySignalCount  int64
 }
 
-func MakeCxxLauncher(maxParallelCxxProcesses int64) (*CxxLauncher, error) {
+func MakeCxxLauncher(maxParallelCxxProcesses int64, maxCxxDuration time.Duration, compilerMap map[string]string) (*CxxLauncher, error) {
 	if maxParallelCxxProcesses <= 0 {
 		return nil, fmt.Errorf("invalid maxParallelCxxProcesses %d", maxParallelCxxProcesses)
 	}
+	if maxCxxDuration < 0 {
+		return nil, fmt.Errorf("invalid maxCxxDuration %v", maxCxxDuration)
+	}
+	for clientCxxName, serverCxxName := range compilerMap {
+		if _, err := exec.LookPath(serverCxxName); err != nil {
+			return nil, fmt.Errorf("invalid compiler mapping %s=%s: %v", clientCxxName, serverCxxName, err)
+		}
+	}
 
 	return &CxxLauncher{
 		serverCxxThrottle: make(chan struct{}, maxParallelCxxProcesses),
+		maxCxxDuration:    maxCxxDuration,
+		compilerMap:       compilerMap,
+		runningPids:       make(map[int]struct{}),
 	}, nil
 }
 
+// ParseCompilerMap parses a `-compiler-map` option: "g++=/opt/gcc-12/bin/g++,gcc=/opt/gcc-12/bin/gcc".
+func ParseCompilerMap(compilerMapStr string) (map[string]string, error) {
+	compilerMap := make(map[string]string)
+	for _, pair := range strings.Split(compilerMapStr, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		clientCxxName, serverCxxName, ok := strings.Cut(pair, "=")
+		if !ok || clientCxxName == "" || serverCxxName == "" {
+			return nil, fmt.Errorf("invalid compiler mapping %q, expected client=server", pair)
+		}
+		compilerMap[clientCxxName] = serverCxxName
+	}
+	return compilerMap, nil
+}
+
+// MapCxxName returns a compiler to be launched on a server for cxxName sent by a client.
+// In some fleets, compilers are installed at different paths on servers than on clients, e.g. g++ vs /opt/gcc-12/bin/g++.
+// An exact match is looked up first, then a basename ("g++" also maps "/usr/bin/g++"); if none, cxxName is left as is.
+func (cxxLauncher *CxxLauncher) MapCxxName(cxxName string) string {
+	if serverCxxName, ok := cxxLauncher.compilerMap[cxxName]; ok {
+		return serverCxxName
+	}
+	if serverCxxName, ok := cxxLauncher.compilerMap[path.Base(cxxName)]; ok {
+		return serverCxxName
+	}
+	return cxxName
+}
+
+// CheckCxxExists returns codes.NotFound if a compiler isn't installed on a server (a common fleet misconfiguration),
+// so that a client falls back to local compilation with a clear reason instead of a cryptic exec error.
+// A relative path like ./bin/g++ is resolved against a session cwd on launch, it's not checked here.
+func (cxxLauncher *CxxLauncher) CheckCxxExists(cxxName string) error {
+	serverCxxName := cxxLauncher.MapCxxName(cxxName)
+	if strings.Contains(serverCxxName, "/") && !path.IsAbs(serverCxxName) {
+		return nil
+	}
+	if _, err := exec.LookPath(serverCxxName); err != nil {
+		return status.Errorf(codes.NotFound, "compiler %s not found on server", serverCxxName)
+	}
+	return nil
+}
+
+// KillAllRunningCxx kills all cxx processes launched by a server along with their children (cc1plus, etc.).
+// It's called on server shutdown: since cxx are launched in their own process groups,
+// they would otherwise remain running after a server exits.
+func (cxxLauncher *CxxLauncher) KillAllRunningCxx() {
+	cxxLauncher.mu.Lock()
+	defer cxxLauncher.mu.Unlock()
+
+	for pid := range cxxLauncher.runningPids {
+		_ = syscall.Kill(-pid, syscall.SIGKILL)
+	}
+}
+
 // LaunchCxxWhenPossible launches the C++ compiler on a server managing a waiting queue.
 // The purpose of a waiting queue is not to over-utilize server resources at peak times.
 // Currently, amount of max parallel C++ processes is an option provided at start up
@@ -72,6 +152,23 @@ func (cxxLauncher *CxxLauncher) GetWaitingInQueueSessionsCount() int64 {
 	return atomic.LoadInt64(&cxxLauncher.nSessionsReadyButWaiting)
 }
 
+// EstimateQueueWaitMilliseconds estimates how long a session just pushed to a queue waits for cxx,
+// based on an average cxx duration; it's used for a busy hint and limited to 100ms..10s.
+func (cxxLauncher *CxxLauncher) EstimateQueueWaitMilliseconds() int64 {
+	avgDurationMs := int64(1000)
+	if totalCalls := cxxLauncher.GetTotalCxxCallsCount(); totalCalls > 0 {
+		avgDurationMs = cxxLauncher.GetTotalCxxDurationMilliseconds() / totalCalls
+	}
+	waitMs := avgDurationMs * cxxLauncher.GetWaitingInQueueSessionsCount() / int64(cap(cxxLauncher.serverCxxThrottle))
+	if waitMs < 100 {
+		return 100
+	}
+	if waitMs > 10000 {
+		return 10000
+	}
+	return waitMs
+}
+
 func (cxxLauncher *CxxLauncher) GetTotalCxxCallsCount() int64 {
 	return atomic.LoadInt64(&cxxLauncher.totalCalls)
 }
@@ -92,22 +189,83 @@ func (cxxLauncher *CxxLauncher) GetNonZeroExitCodeCount() int64 {
 	return atomic.LoadInt64(&cxxLauncher.nonZeroExitCodeCount)
 }
 
+func (cxxLauncher *CxxLauncher) GetKilledByTimeoutCount() int64 {
+	return atomic.LoadInt64(&cxxLauncher.killedByTimeoutCount)
+}
+
+func (cxxLauncher *CxxLauncher) GetKilledBySignalCount() int64 {
+	return atomic.LoadInt64(&cxxLauncher.killedBySignalCount)
+}
+
+// getCxxKillSignal returns a signal that cxx was killed by (ExitCode() is -1 then), or 0 if it exited by itself.
+func getCxxKillSignal(processState *os.ProcessState) syscall.Signal {
+	if processState == nil {
+		return 0
+	}
+	if waitStatus, ok := processState.Sys().(syscall.WaitStatus); ok && waitStatus.Signaled() {
+		return waitStatus.Signal()
+	}
+	return 0
+}
+
 func (cxxLauncher *CxxLauncher) launchServerCxxForCpp(session *Session, noccServer *NoccServer) {
-	cxxCommand := exec.Command(session.cxxName, session.cxxCmdLine...)
+	ctx := context.Background()
+	if cxxLauncher.maxCxxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cxxLauncher.maxCxxDuration)
+		defer cancel()
+	}
+	cxxCmdLine, removeRspFile, err := MoveIncludeDirsToResponseFile(session.cxxCmdLine, session.objOutFile+".rsp", getMaxCxxCmdLineBytes())
+	if err != nil {
+		cxxCmdLine, removeRspFile = session.cxxCmdLine, func() {} // let exec fail with a clear error
+	}
+	defer removeRspFile()
+	cxxCommand := exec.CommandContext(ctx, cxxLauncher.MapCxxName(session.cxxName), cxxCmdLine...)
 	cxxCommand.Dir = session.cxxCwd
+	// on timeout, kill not only g++/clang driver, but cc1plus and others (they are in the same process group)
+	cxxCommand.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cxxCommand.Cancel = func() error {
+		return syscall.Kill(-cxxCommand.Process.Pid, syscall.SIGKILL)
+	}
+	cxxCommand.WaitDelay = 5 * time.Second // if something still holds stdout/stderr after killing, don't wait for it
 	var cxxStdout, cxxStderr bytes.Buffer
 	cxxCommand.Stderr = &cxxStderr
 	cxxCommand.Stdout = &cxxStdout
 
+	logServer.Info(2, "launch cxx", "sessionID", session.sessionID, "\ncxxCwd:", session.cxxCwd, "\ncxxCmdLine:", cxxCommand.Path, session.cxxCmdLine)
 	start := time.Now()
-	err := cxxCommand.Run()
+	err = cxxCommand.Start()
+	if err == nil {
+		pid := cxxCommand.Process.Pid
+		cxxLauncher.mu.Lock()
+		cxxLauncher.runningPids[pid] = struct{}{}
+		cxxLauncher.mu.Unlock()
+		err = cxxCommand.Wait()
+		cxxLauncher.mu.Lock()
+		delete(cxxLauncher.runningPids, pid)
+		cxxLauncher.mu.Unlock()
+	}
 
 	session.cxxDuration = int32(time.Since(start).Milliseconds())
 	session.cxxExitCode = int32(cxxCommand.ProcessState.ExitCode())
 	session.cxxStdout = cxxStdout.Bytes()
 	session.cxxStderr = cxxStderr.Bytes()
-	if len(session.cxxStderr) == 0 && err != nil {
-		session.cxxStderr = []byte(fmt.Sprintln(err))
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) { // removed after a session has started
+		session.cxxStderr = []byte(fmt.Sprintf("nocc-server: compiler %s not found on server\n", cxxCommand.Path))
+	} else if len(session.cxxStderr) == 0 && err != nil {
+		session.cxxStderr = []byte(fmt.Sprintf("nocc-server: the C++ compiler failed: %v\n", err))
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		atomic.AddInt64(&cxxLauncher.killedByTimeoutCount, 1)
+		session.cxxExitCode = 1
+		session.cxxStderr = append(session.cxxStderr, fmt.Sprintf("nocc-server: the C++ compiler was killed after %v (see -max-cxx-duration)\n", cxxLauncher.maxCxxDuration)...)
+	} else if signal := getCxxKillSignal(cxxCommand.ProcessState); signal != 0 {
+		// not an error in a source: a crash or the OOM killer, that's why it isn't reported with a bogus exit code -1
+		// a client gets cxxKilledBySignal and compiles a file locally (it's a server problem, it should be logged)
+		atomic.AddInt64(&cxxLauncher.killedBySignalCount, 1)
+		session.cxxExitCode = 1
+		session.cxxKilledBySignal = int32(signal)
+		session.cxxStderr = append(session.cxxStderr, fmt.Sprintf("nocc-server: the C++ compiler was killed by signal %d (%v)\n", signal, signal)...)
 	}
 
 	if session.cxxExitCode != 0 {
@@ -116,21 +274,34 @@ func (cxxLauncher *CxxLauncher) launchServerCxxForCpp(session *Session, noccServ
 		logServer.Info(0, "compiled very heavy file", "sessionID", session.sessionID, "cxxDuration", session.cxxDuration, session.cppInFile)
 	}
 
-	// save to obj cache (to be safe, only if cxx output is empty)
-	if !session.objCacheKey.IsEmpty() {
-		if session.cxxExitCode == 0 && len(session.cxxStdout) == 0 && len(session.cxxStderr) == 0 {
+	session.cxxStdout = cxxLauncher.patchStdoutDropServerPaths(session.client, session.cxxStdout)
+	session.cxxStderr = cxxLauncher.patchStdoutDropServerPaths(session.client, session.cxxStderr)
+
+	// save to obj cache (with cxx output to be replayed, or only if it is empty with -cache-objs-with-warnings=false)
+	if !session.objCacheKey.IsEmpty() && session.cxxExitCode == 0 {
+		if session.recacheObj {
+			noccServer.ObjFileCache.RemoveObjFromCache(session.objCacheKey, session.clientCppInFile)
+		}
+		if len(session.cxxStdout) == 0 && len(session.cxxStderr) == 0 {
 			if stat, err := os.Stat(session.objOutFile); err == nil {
 				_ = noccServer.ObjFileCache.SaveFileToCache(session.objOutFile, path.Base(session.cppInFile)+".o", session.objCacheKey, stat.Size())
 			}
+		} else if noccServer.CacheObjsWithWarnings {
+			if stat, err := os.Stat(session.objOutFile); err == nil {
+				_ = noccServer.ObjFileCache.SaveObjWithDiagnosticsToCache(session.objOutFile, session.clientCppInFile, session.objCacheKey, stat.Size(), session.cxxStdout, session.cxxStderr)
+			}
 		}
 	}
-
-	session.cxxStdout = cxxLauncher.patchStdoutDropServerPaths(session.client, session.cxxStdout)
-	session.cxxStderr = cxxLauncher.patchStdoutDropServerPaths(session.client, session.cxxStderr)
 }
 
 func (cxxLauncher *CxxLauncher) launchServerCxxForPch(cxxName string, cxxCmdLine []string, rootDir string, noccServer *NoccServer) error {
-	cxxCommand := exec.Command(cxxName, cxxCmdLine...)
+	// cxxCmdLine ends with "-o {pchFile} {hFile}"
+	cxxCmdLine, removeRspFile, err := MoveIncludeDirsToResponseFile(cxxCmdLine, cxxCmdLine[len(cxxCmdLine)-2]+".rsp", getMaxCxxCmdLineBytes())
+	if err != nil {
+		return err
+	}
+	defer removeRspFile()
+	cxxCommand := exec.Command(cxxLauncher.MapCxxName(cxxName), cxxCmdLine...)
 	cxxCommand.Dir = rootDir
 	var cxxStdout, cxxStderr bytes.Buffer
 	cxxCommand.Stderr = &cxxStderr
@@ -142,6 +313,11 @@ func (cxxLauncher *CxxLauncher) launchServerCxxForPch(cxxName string, cxxCmdLine
 
 	cxxExitCode := cxxCommand.ProcessState.ExitCode()
 
+	if signal := getCxxKillSignal(cxxCommand.ProcessState); signal != 0 {
+		atomic.AddInt64(&noccServer.Stats.pchCompilationsFailed, 1)
+		logServer.Error("the C++ compiler was killed by signal pch", signal, "\ncmdLine:", cxxName, cxxCmdLine)
+		return fmt.Errorf("could not compile pch: the C++ compiler was killed by signal %d (%v)", signal, signal)
+	}
 	if cxxExitCode != 0 {
 		atomic.AddInt64(&noccServer.Stats.pchCompilationsFailed, 1)
 		logServer.Error("the C++ compiler exited with code pch", cxxExitCode, "\ncmdLine:", cxxName, cxxCmdLine, "\ncxxStdout:", strings.TrimSpace(cxxStdout.String()), "\ncxxStderr:", strings.TrimSpace(cxxStderr.String()))
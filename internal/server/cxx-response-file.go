@@ -0,0 +1,85 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var (
+	maxCxxCmdLineBytes     int
+	maxCxxCmdLineBytesOnce sync.Once
+)
+
+// getMaxCxxCmdLineBytes detects how long a command line may be before it's moved to a response file.
+// On Linux, ARG_MAX is a quarter of a stack limit (but not less than 128K), and it's shared with environment,
+// so a half of it is left for environment and safety.
+func getMaxCxxCmdLineBytes() int {
+	maxCxxCmdLineBytesOnce.Do(func() {
+		argMax := 128 * 1024
+		var rLimit syscall.Rlimit
+		if err := syscall.Getrlimit(syscall.RLIMIT_STACK, &rLimit); err == nil && rLimit.Cur != ^uint64(0) && rLimit.Cur/4 > uint64(argMax) {
+			argMax = int(rLimit.Cur / 4)
+		}
+		maxCxxCmdLineBytes = argMax / 2
+	})
+	return maxCxxCmdLineBytes
+}
+
+// isIncludeDirArg is true for args followed by a dir/file, that come from a client IncludeDirs, see PrepareServerCxxCmdLine.
+func isIncludeDirArg(arg string) bool {
+	return arg == "-I" || arg == "-iquote" || arg == "-isystem" || arg == "-idirafter" || arg == "-include"
+}
+
+// MoveIncludeDirsToResponseFile is for TUs with thousands of -I dirs: mapped to server paths, they may exceed ARG_MAX,
+// and exec fails with "argument list too long". If a command line is too long, include dirs are written to
+// rspFileName, and "@rspFileName" is passed to cxx instead of them (gcc and clang both support response files).
+// If it's short enough (almost always), it's returned as is, and removeRspFile does nothing.
+// Note, that gcc also passes all options to cc1plus via COLLECT_GCC_OPTIONS env, which can't exceed 128K,
+// so for gcc it's a limit anyway, the same as on a client machine.
+func MoveIncludeDirsToResponseFile(cxxCmdLine []string, rspFileName string, maxBytes int) (newCmdLine []string, removeRspFile func(), err error) {
+	cmdLineBytes := 0
+	for _, arg := range cxxCmdLine {
+		cmdLineBytes += len(arg) + 1
+	}
+	if cmdLineBytes <= maxBytes {
+		return cxxCmdLine, func() {}, nil
+	}
+
+	var rspContents strings.Builder
+	newCmdLine = make([]string, 0, len(cxxCmdLine))
+	for i := 0; i < len(cxxCmdLine); i++ {
+		if isIncludeDirArg(cxxCmdLine[i]) && i+1 < len(cxxCmdLine) {
+			rspContents.WriteString(cxxCmdLine[i])
+			rspContents.WriteByte(' ')
+			rspContents.WriteString(escapeResponseFileArg(cxxCmdLine[i+1]))
+			rspContents.WriteByte('\n')
+			i++
+			continue
+		}
+		newCmdLine = append(newCmdLine, cxxCmdLine[i])
+	}
+	if err := os.WriteFile(rspFileName, []byte(rspContents.String()), 0644); err != nil {
+		return nil, nil, err
+	}
+	logServer.Info(1, "cxx cmd line is", cmdLineBytes, "bytes, include dirs are passed via", rspFileName)
+
+	newCmdLine = append([]string{"@" + rspFileName}, newCmdLine...)
+	return newCmdLine, func() { _ = os.Remove(rspFileName) }, nil
+}
+
+// escapeResponseFileArg escapes whitespace, quotes and backslashes, as gcc and clang parse response files.
+func escapeResponseFileArg(arg string) string {
+	if !strings.ContainsAny(arg, " \t\n\r\v\f'\"\\") {
+		return arg
+	}
+	var escaped strings.Builder
+	for i := 0; i < len(arg); i++ {
+		if strings.IndexByte(" \t\n\r\v\f'\"\\", arg[i]) != -1 {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteByte(arg[i])
+	}
+	return escaped.String()
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/VKCOM/nocc/internal/common"
+	"github.com/VKCOM/nocc/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// collectDepsOnServer is one round of collecting dependencies of a .cpp file on a server instead of a client.
+// A client sends a .cpp and all dependencies found so far (along with contents of those requested by a previous round).
+// They are placed into a client working dir, the same as uploaded files, and `cxx -M -MG` is launched there:
+// found dependencies are already on a server (a client completes them with sha256 and sends them on the next round),
+// whereas missing ones (printed as written in #include) are resolved by a client and sent on the next round.
+// When a client has nothing more to send, all dependencies are found, and a regular session is started.
+func collectDepsOnServer(noccServer *NoccServer, client *Client, in *pb.CollectDepsOnServerRequest) (*pb.CollectDepsOnServerReply, error) {
+	if err := noccServer.CxxLauncher.CheckCxxExists(in.CxxName); err != nil {
+		return nil, err
+	}
+
+	files := make([]*fileInClientDir, len(in.KnownFiles))
+	for index, meta := range in.KnownFiles {
+		fileSHA256 := common.SHA256{B0_7: meta.SHA256_B0_7, B8_15: meta.SHA256_B8_15, B16_23: meta.SHA256_B16_23, B24_31: meta.SHA256_B24_31}
+		file, err := client.StartUsingFileInSession(meta.ClientFileName, meta.FileSize, fileSHA256)
+		if err != nil {
+			client.StopUsingFilesInSession(files[:index])
+			return nil, err
+		}
+		files[index] = file
+	}
+	defer client.StopUsingFilesInSession(files)
+
+	// it's not a real session: it's not registered and never compiled, it's just to reuse cmd line mapping
+	session := &Session{
+		files:     files,
+		cxxName:   in.CxxName,
+		cppInFile: in.CppInFile,
+		client:    client,
+	}
+	cppInFile := session.MapCppInFileToServer(in.Cwd)
+	client.MkdirAllForSession(session)
+
+	bodies := make(map[uint32][]byte, len(in.FileBodies))
+	for _, fileBody := range in.FileBodies {
+		bodies[fileBody.FileIndex] = fileBody.Body
+	}
+
+	reply := &pb.CollectDepsOnServerReply{}
+	for index, file := range files {
+		if file.state == fsFileStateUploaded {
+			continue
+		}
+		if body, ok := bodies[uint32(index)]; ok && int64(len(body)) == file.fileSize {
+			if err := saveFileBodyToClientDir(noccServer, client, file, body); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if file.state == fsFileStateJustCreated && IsSystemHeaderPath(file.serverFileName) {
+			if !noccServer.SystemHeaders.IsSystemHeader(file.serverFileName, file.fileSize, file.fileSHA256) {
+				return nil, status.Errorf(codes.FailedPrecondition, "system file %s differs between a client and a server", file.serverFileName)
+			}
+			file.state = fsFileStateUploaded
+			continue
+		}
+		if file.state == fsFileStateJustCreated && noccServer.SrcFileCache.CreateHardLinkFromCache(file.serverFileName, file.fileSHA256) {
+			file.state = fsFileStateUploaded
+			client.OnSrcFileReused(noccServer.Stats, file)
+			continue
+		}
+		reply.FileIndexesToUpload = append(reply.FileIndexesToUpload, uint32(index))
+	}
+	if len(reply.FileIndexesToUpload) > 0 {
+		return reply, nil
+	}
+
+	cxxCmdLine := append(session.MapCxxArgsToServer(in.CxxArgs, in.CxxIDirs), "-o", "/dev/stdout", "-M", "-MG", cppInFile)
+	// a response file is named uniquely: sessionID is 0 for all rounds of a client,
+	// whereas several .cpp files of a client are collected concurrently
+	rspFile, err := os.CreateTemp(client.workingDir, "deps-*.rsp")
+	if err != nil {
+		return nil, err
+	}
+	_ = rspFile.Close()
+	defer os.Remove(rspFile.Name())
+	cxxCmdLine, _, err = MoveIncludeDirsToResponseFile(cxxCmdLine, rspFile.Name(), getMaxCxxCmdLineBytes())
+	if err != nil {
+		return nil, err
+	}
+	cxxMCommand := exec.Command(noccServer.CxxLauncher.MapCxxName(in.CxxName), cxxCmdLine...)
+	cxxMCommand.Dir = session.cxxCwd
+	var cxxMStdout, cxxMStderr bytes.Buffer
+	cxxMCommand.Stdout = &cxxMStdout
+	cxxMCommand.Stderr = &cxxMStderr
+	if err := cxxMCommand.Run(); err != nil {
+		return nil, fmt.Errorf("%s -M exited with code %d: %s", in.CxxName, cxxMCommand.ProcessState.ExitCode(), strings.TrimSpace(cxxMStderr.String()))
+	}
+
+	for _, word := range common.SplitCxxMStdoutWords(cxxMStdout.Bytes()) {
+		if word == "\\" || word == cppInFile || strings.HasSuffix(word, ":") {
+			continue
+		}
+		serverFileName := word
+		if serverFileName[0] != '/' {
+			serverFileName = path.Join(session.cxxCwd, serverFileName)
+		}
+		if _, err := os.Stat(serverFileName); err != nil { // with -MG, a missing file is printed as written in #include
+			reply.UnresolvedIncludes = append(reply.UnresolvedIncludes, word)
+			continue
+		}
+		reply.Deps = append(reply.Deps, client.MapServerAbsToClientFileName(path.Clean(serverFileName)))
+	}
+	return reply, nil
+}
+
+// saveFileBodyToClientDir saves a file sent inline along with a request, like it was uploaded by a stream.
+func saveFileBodyToClientDir(noccServer *NoccServer, client *Client, file *fileInClientDir, body []byte) error {
+	if err := common.WriteFileViaTempFile(file.serverFileName, body); err != nil {
+		file.state = fsFileStateUploadError
+		return err
+	}
+	file.state = fsFileStateUploaded
+	_ = noccServer.SrcFileCache.SaveFileToCache(file.serverFileName, path.Base(file.serverFileName), file.fileSHA256, file.fileSize)
+
+	atomic.AddInt64(&noccServer.Stats.bytesReceived, file.fileSize)
+	atomic.AddInt64(&noccServer.Stats.filesReceived, 1)
+	client.OnSrcFileUploaded(noccServer.Stats, file)
+	return nil
+}
@@ -1,11 +1,17 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/VKCOM/nocc/internal/common"
 )
@@ -19,6 +25,7 @@ type cachedFile struct {
 type lruNode struct {
 	next, prev *lruNode
 	key        common.SHA256
+	lastAccess int64 // unix nanos, updated under a mutex along with moving to lru head, see PurgeOlderThan
 }
 
 // FileCache is a base for ObjFileCache and SrcFileCache, see comments for them.
@@ -30,15 +37,37 @@ type FileCache struct {
 	lruTail, lruHead *lruNode
 	mu               sync.RWMutex
 
+	fsync      bool  // fsync files and dirs on saving, for crash durability (off by default: it's slower, and cache dirs are often on tmpfs)
+	fsyncCount int64 // nb! atomic, files and dirs flushed, see syncFile
+
 	lastIndex   int64 // nb! atomic
 	purgedCount int64 // nb! atomic
+	purgedBytes int64 // nb! atomic
 	cacheDir    string
 
+	// evicted by PurgeOlderThan (on request, not because of limits), they are not counted in purgedCount
+	purgedByTTLCount int64 // nb! atomic
+	purgedByTTLBytes int64 // nb! atomic
+
 	totalSizeOnDisk int64 // nb! atomic
 	hardLimit       int64
 	softLimit       int64
+
+	// cache pressure: if files are often evicted on saving (reaching the hard limit),
+	// or the cache stays above the soft limit for long, the cache is thrashing, and its limit should be increased
+	purgedOnHardLimit    int64     // nb! atomic
+	aboveSoftLimitMillis int64     // nb! atomic
+	lastSoftLimitCheck   time.Time // accessed only from cron
+
+	// totalSizeOnDisk may drift from reality (files removed out-of-band, etc.), it's periodically recalculated
+	reconcileInProgress int32     // nb! atomic
+	lastReconcileNanos  int64     // nb! atomic
+	nextReconcileTime   time.Time // accessed only from cron
 }
 
+// the minimum interval between walking a cache dir to reconcile its size, see ReconcileSizeOnDiskIfRequired
+const minReconcileInterval = 10 * time.Minute
+
 const shardsDirCount = 256
 
 func createSubdirsForFileCache(cacheDir string) error {
@@ -51,7 +80,7 @@ func createSubdirsForFileCache(cacheDir string) error {
 	return nil
 }
 
-func MakeFileCache(cacheDir string, limitBytes int64) (*FileCache, error) {
+func MakeFileCache(cacheDir string, limitBytes int64, fsync bool) (*FileCache, error) {
 	if err := createSubdirsForFileCache(cacheDir); err != nil {
 		return nil, err
 	}
@@ -61,12 +90,16 @@ func MakeFileCache(cacheDir string, limitBytes int64) (*FileCache, error) {
 		cacheDir:  cacheDir,
 		hardLimit: limitBytes,
 		softLimit: int64(80.0 * (float64(limitBytes) / 100.0)),
+		fsync:     fsync,
 	}, nil
 }
 
 func (cache *FileCache) LookupInCache(key common.SHA256) string {
 	cache.mu.Lock()
 	cachedFile := cache.table[key]
+	if cachedFile.lruNode != nil {
+		cachedFile.lruNode.lastAccess = time.Now().UnixNano()
+	}
 	if cachedFile.lruNode != nil && cachedFile.lruNode != cache.lruHead {
 		// cachedFile.lruNode != cache.lruHead => cachedFile.lruNode.prev != nil
 		cachedFile.lruNode.prev.next = cachedFile.lruNode.next
@@ -95,19 +128,108 @@ func (cache *FileCache) CreateHardLinkFromCache(serverFileName string, key commo
 	}
 
 	// path.Dir(serverFileName) must be created in advance
-	err := os.Link(pathInCache, serverFileName)
+	err := linkOrCopyFile(pathInCache, serverFileName)
 	return err == nil || os.IsExist(err)
 }
 
+// linkOrCopyFile creates a hard link, but if src and dst are on different filesystems (EXDEV),
+// it falls back to copying (via a tmp file, to be atomic like os.Link).
+// It's for deployments where a cache dir and a working dir are placed on different mounts.
+func linkOrCopyFile(srcPath string, dstPath string) error {
+	err := os.Link(srcPath, dstPath)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	logServer.Info(1, "cross-device link, copying", srcPath, "to", dstPath)
+	if _, err := os.Stat(dstPath); err == nil {
+		return os.ErrExist
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dstTmp, err := common.OpenTempFile(dstPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dstTmp, src)
+	_ = dstTmp.Close()
+	if err == nil {
+		err = os.Rename(dstTmp.Name(), dstPath)
+	}
+	if err != nil {
+		_ = os.Remove(dstTmp.Name())
+	}
+	return err
+}
+
+// CheckHardLinks is a startup self-check that files can be hard linked from srcDir to dstDir (a cache dir and a working dir).
+// If they are on different filesystems (EXDEV), it's not an error (files are copied, see linkOrCopyFile), but it's slower,
+// so it's just logged. Any other error means that a filesystem doesn't support hard links at all,
+// and every cache restore would fail, so a server shouldn't start.
+func CheckHardLinks(srcDir string, dstDir string) error {
+	probeFile, err := common.OpenTempFile(path.Join(srcDir, "nocc-link-probe"))
+	if err != nil {
+		return err
+	}
+	_ = probeFile.Close()
+	defer func() { _ = os.Remove(probeFile.Name()) }()
+
+	linkedFile := path.Join(dstDir, path.Base(probeFile.Name()))
+	err = os.Link(probeFile.Name(), linkedFile)
+	if err == nil {
+		_ = os.Remove(linkedFile)
+		return nil
+	}
+	if errors.Is(err, syscall.EXDEV) {
+		logServer.Error(srcDir, "and", dstDir, "are on different filesystems: files will be copied instead of hard linked, which is slower")
+		return nil
+	}
+	return fmt.Errorf("can't hard link files from %s to %s: %v", srcDir, dstDir, err)
+}
+
+// syncFileAndDir flushes a file contents and its directory entry to disk.
+func (cache *FileCache) syncFileAndDir(fileName string) error {
+	if err := cache.syncPath(fileName); err != nil {
+		return err
+	}
+	return cache.syncPath(path.Dir(fileName))
+}
+
+func (cache *FileCache) syncPath(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	err = cache.syncFile(f)
+	_ = f.Close()
+	return err
+}
+
+// syncFile is the only place where files of a cache are flushed (with -cache-fsync), they are counted for stats.
+func (cache *FileCache) syncFile(f *os.File) error {
+	atomic.AddInt64(&cache.fsyncCount, 1)
+	return f.Sync()
+}
+
 func (cache *FileCache) SaveFileToCache(srcPath string, fileNameInCacheDir string, key common.SHA256, fileSize int64) error {
 	uniqueID := atomic.AddInt64(&cache.lastIndex, 1)
 	pathInCache := fmt.Sprintf("%s/%X/%s.%X", cache.cacheDir, uniqueID%shardsDirCount, fileNameInCacheDir, uniqueID)
 
-	if err := os.Link(srcPath, pathInCache); err != nil {
+	if err := linkOrCopyFile(srcPath, pathInCache); err != nil {
 		return err
 	}
+	// a file becomes visible via cache.table only after it's flushed (a hard link shares data with srcPath, a copy doesn't)
+	if cache.fsync {
+		if err := cache.syncFileAndDir(pathInCache); err != nil {
+			_ = os.Remove(pathInCache)
+			return err
+		}
+	}
 
-	newHead := &lruNode{key: key}
+	newHead := &lruNode{key: key, lastAccess: time.Now().UnixNano()}
 	value := cachedFile{pathInCache, fileSize, newHead}
 	cache.mu.Lock()
 	_, exists := cache.table[key]
@@ -129,14 +251,104 @@ func (cache *FileCache) SaveFileToCache(srcPath string, fileNameInCacheDir strin
 		_ = os.Remove(pathInCache)
 	}
 
-	cache.purgeLastElementsTillLimit(cache.hardLimit)
+	if purged := cache.purgeLastElementsTillLimit(cache.hardLimit); purged > 0 {
+		atomic.AddInt64(&cache.purgedOnHardLimit, purged)
+	}
 	return nil
 }
 
+// PurgeLastElementsIfRequired is called periodically to keep the cache within the soft limit.
+// Along the way, it accounts time the cache was above the soft limit (with the precision of a call interval).
 func (cache *FileCache) PurgeLastElementsIfRequired() {
+	now := time.Now()
+	if atomic.LoadInt64(&cache.totalSizeOnDisk) > cache.softLimit && !cache.lastSoftLimitCheck.IsZero() {
+		atomic.AddInt64(&cache.aboveSoftLimitMillis, now.Sub(cache.lastSoftLimitCheck).Milliseconds())
+	}
+	cache.lastSoftLimitCheck = now
+
 	cache.purgeLastElementsTillLimit(cache.softLimit)
 }
 
+// ReconcileSizeOnDiskIfRequired is called periodically to launch ReconcileSizeOnDisk in background.
+// Walking a huge cache dir is expensive, so it's done not more often than minReconcileInterval,
+// and for huge caches even less often: the interval is at least 100 times longer than the last walking took.
+func (cache *FileCache) ReconcileSizeOnDiskIfRequired() {
+	now := time.Now()
+	if now.Before(cache.nextReconcileTime) || !atomic.CompareAndSwapInt32(&cache.reconcileInProgress, 0, 1) {
+		return
+	}
+	interval := 100 * time.Duration(atomic.LoadInt64(&cache.lastReconcileNanos))
+	if interval < minReconcileInterval {
+		interval = minReconcileInterval
+	}
+	cache.nextReconcileTime = now.Add(interval)
+
+	go func() {
+		cache.ReconcileSizeOnDisk()
+		atomic.StoreInt64(&cache.lastReconcileNanos, int64(time.Since(now)))
+		atomic.StoreInt32(&cache.reconcileInProgress, 0)
+	}()
+}
+
+// ReconcileSizeOnDisk walks a cache dir and recalculates totalSizeOnDisk from actual file sizes.
+// Files that disappeared from disk are dropped from the cache.
+// Files on disk not tracked by the cache are left untouched (they may be saving right now).
+func (cache *FileCache) ReconcileSizeOnDisk() {
+	sizesOnDisk := make(map[string]int64, cache.GetFilesCount())
+	_ = filepath.WalkDir(cache.cacheDir, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				sizesOnDisk[fullPath] = info.Size()
+			}
+		}
+		return nil
+	})
+
+	cache.mu.Lock()
+	var newTotal int64
+	var droppedCount int
+	for key, cachedFile := range cache.table {
+		fileSize, exists := sizesOnDisk[cachedFile.pathInCache]
+		if !exists { // it could have been saved after walking, check once again
+			if stat, err := os.Stat(cachedFile.pathInCache); err == nil {
+				fileSize, exists = stat.Size(), true
+			}
+		}
+		if !exists {
+			cache.removeFromLru(cachedFile.lruNode)
+			delete(cache.table, key)
+			droppedCount++
+			continue
+		}
+		if fileSize != cachedFile.fileSize {
+			cachedFile.fileSize = fileSize
+			cache.table[key] = cachedFile
+		}
+		newTotal += fileSize
+	}
+	oldTotal := atomic.SwapInt64(&cache.totalSizeOnDisk, newTotal)
+	cache.mu.Unlock()
+
+	if delta := newTotal - oldTotal; delta != 0 || droppedCount != 0 {
+		logServer.Info(0, "reconciled cache size", cache.cacheDir, "delta", delta, "bytes, dropped", droppedCount, "missing files")
+	}
+}
+
+// removeFromLru unlinks a node from lru list, it's called under a locked mutex.
+func (cache *FileCache) removeFromLru(node *lruNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		cache.lruHead = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		cache.lruTail = node.prev
+	}
+	node.prev, node.next = nil, nil
+}
+
 func (cache *FileCache) GetFilesCount() int64 {
 	cache.mu.Lock()
 	elements := len(cache.table)
@@ -152,6 +364,30 @@ func (cache *FileCache) GetPurgedFilesCount() int64 {
 	return atomic.LoadInt64(&cache.purgedCount)
 }
 
+func (cache *FileCache) GetPurgedBytes() int64 {
+	return atomic.LoadInt64(&cache.purgedBytes)
+}
+
+func (cache *FileCache) GetFsyncCount() int64 {
+	return atomic.LoadInt64(&cache.fsyncCount)
+}
+
+func (cache *FileCache) GetPurgedByTTLCount() int64 {
+	return atomic.LoadInt64(&cache.purgedByTTLCount)
+}
+
+func (cache *FileCache) GetPurgedByTTLBytes() int64 {
+	return atomic.LoadInt64(&cache.purgedByTTLBytes)
+}
+
+func (cache *FileCache) GetPurgedOnHardLimitCount() int64 {
+	return atomic.LoadInt64(&cache.purgedOnHardLimit)
+}
+
+func (cache *FileCache) GetAboveSoftLimitMillis() int64 {
+	return atomic.LoadInt64(&cache.aboveSoftLimitMillis)
+}
+
 func (cache *FileCache) DropAll() {
 	cache.mu.Lock()
 	atomic.AddInt64(&cache.purgedCount, int64(len(cache.table)))
@@ -166,7 +402,50 @@ func (cache *FileCache) DropAll() {
 	cache.mu.Unlock()
 }
 
-func (cache *FileCache) purgeLastElementsTillLimit(cacheLimit int64) {
+// RemoveFromCache removes a file by a key (if it exists), so that a next SaveFileToCache replaces it.
+func (cache *FileCache) RemoveFromCache(key common.SHA256) bool {
+	cache.mu.Lock()
+	removingFile, exists := cache.table[key]
+	if exists {
+		cache.removeFromLru(removingFile.lruNode)
+		delete(cache.table, key)
+		atomic.AddInt64(&cache.totalSizeOnDisk, -removingFile.fileSize)
+	}
+	cache.mu.Unlock()
+
+	if exists {
+		_ = os.Remove(removingFile.pathInCache)
+	}
+	return exists
+}
+
+// PurgeOlderThan evicts files not accessed (neither saved nor looked up) within ttl, leaving a warm set untouched.
+// Since lru is ordered by access time, it's enough to walk from the tail till the first fresh node.
+func (cache *FileCache) PurgeOlderThan(ttl time.Duration) (purgedCount int64, purgedBytes int64) {
+	staleBefore := time.Now().Add(-ttl).UnixNano()
+	var removingFiles []cachedFile
+
+	cache.mu.Lock()
+	for tail := cache.lruTail; tail != nil && tail.lastAccess < staleBefore; tail = cache.lruTail {
+		removingFile := cache.table[tail.key]
+		cache.removeFromLru(tail)
+		delete(cache.table, tail.key)
+		atomic.AddInt64(&cache.totalSizeOnDisk, -removingFile.fileSize)
+		removingFiles = append(removingFiles, removingFile)
+	}
+	cache.mu.Unlock()
+
+	for _, removingFile := range removingFiles {
+		_ = os.Remove(removingFile.pathInCache)
+		purgedCount++
+		purgedBytes += removingFile.fileSize
+	}
+	atomic.AddInt64(&cache.purgedByTTLCount, purgedCount)
+	atomic.AddInt64(&cache.purgedByTTLBytes, purgedBytes)
+	return
+}
+
+func (cache *FileCache) purgeLastElementsTillLimit(cacheLimit int64) (purged int64) {
 	for atomic.LoadInt64(&cache.totalSizeOnDisk) > cacheLimit {
 		var removingFile cachedFile
 		cache.mu.Lock()
@@ -175,13 +454,17 @@ func (cache *FileCache) purgeLastElementsTillLimit(cacheLimit int64) {
 			cache.lruTail.next = nil
 			removingFile = cache.table[tail.key]
 			delete(cache.table, tail.key)
+			// decremented under a mutex, so that totalSizeOnDisk always matches the table, see ReconcileSizeOnDisk
+			atomic.AddInt64(&cache.totalSizeOnDisk, -removingFile.fileSize)
 		}
 		cache.mu.Unlock()
 
 		if removingFile.lruNode != nil {
 			_ = os.Remove(removingFile.pathInCache)
-			atomic.AddInt64(&cache.totalSizeOnDisk, -removingFile.fileSize)
 			atomic.AddInt64(&cache.purgedCount, 1)
+			atomic.AddInt64(&cache.purgedBytes, removingFile.fileSize)
+			purged++
 		}
 	}
+	return
 }
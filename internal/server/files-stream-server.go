@@ -1,22 +1,33 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"time"
 
+	"github.com/VKCOM/nocc/internal/common"
 	"github.com/VKCOM/nocc/pb"
 )
 
 // receiveUploadedFileByChunks is an actual implementation of piping a client stream to a local server file.
 // See client.uploadFileByChunks.
 func receiveUploadedFileByChunks(noccServer *NoccServer, stream pb.CompilationService_UploadFileStreamServer, firstChunk *pb.UploadFileChunkRequest, expectedBytes int, serverFileName string) (err error) {
+	if firstChunk.Gzipped {
+		firstChunk.ChunkBody, err = common.GunzipTransferChunk(firstChunk.ChunkBody, expectedBytes)
+	}
 	receivedBytes := len(firstChunk.ChunkBody)
 
 	// we write to a tmp file and rename it to serverFileName after saving
 	// it prevents races from concurrent writing to the same file
 	// (this situation is possible on a slow network when a file was requested several times)
-	fileTmp, err := noccServer.SrcFileCache.MakeTempFileForUploadSaving(serverFileName)
+	var fileTmp *os.File
+	if err == nil {
+		fileTmp, err = noccServer.SrcFileCache.MakeTempFileForUploadSaving(serverFileName)
+	}
 	if err == nil {
 		_, err = fileTmp.Write(firstChunk.ChunkBody)
 	}
@@ -27,6 +38,11 @@ func receiveUploadedFileByChunks(noccServer *NoccServer, stream pb.CompilationSe
 		if err != nil { // EOF is also unexpected
 			break
 		}
+		if nextChunk.Gzipped {
+			if nextChunk.ChunkBody, err = common.GunzipTransferChunk(nextChunk.ChunkBody, expectedBytes-receivedBytes); err != nil {
+				break
+			}
+		}
 		_, err = fileTmp.Write(nextChunk.ChunkBody)
 		if nextChunk.SessionID != firstChunk.SessionID || nextChunk.FileIndex != firstChunk.FileIndex {
 			err = fmt.Errorf("inconsistent stream, chunks mismatch")
@@ -35,10 +51,16 @@ func receiveUploadedFileByChunks(noccServer *NoccServer, stream pb.CompilationSe
 	}
 
 	if fileTmp != nil {
+		if err == nil && noccServer.SrcFileCache.fsync {
+			err = noccServer.SrcFileCache.syncFile(fileTmp)
+		}
 		_ = fileTmp.Close()
 		if err == nil {
 			err = os.Rename(fileTmp.Name(), serverFileName)
 		}
+		if err == nil && noccServer.SrcFileCache.fsync {
+			err = noccServer.SrcFileCache.syncPath(path.Dir(serverFileName))
+		}
 		if err != nil {
 			_ = os.Remove(fileTmp.Name())
 		}
@@ -46,6 +68,41 @@ func receiveUploadedFileByChunks(noccServer *NoccServer, stream pb.CompilationSe
 	return
 }
 
+// receiveStoredObjFileByChunks pipes an .o file compiled by a client to a tmp file inside obj dir.
+// It returns the tmp file name, which is then hard linked to obj cache.
+// See client.storeObjFileByChunks.
+func receiveStoredObjFileByChunks(noccServer *NoccServer, stream pb.CompilationService_StoreObjToCacheServer, firstChunk *pb.StoreObjChunkRequest, client *Client) (string, error) {
+	fileTmp, err := common.OpenTempFile(path.Join(noccServer.ObjFileCache.objTmpDir, client.clientID+".stored.o"))
+	if err != nil {
+		return "", err
+	}
+	receivedBytes := int64(len(firstChunk.ChunkBody))
+	_, err = fileTmp.Write(firstChunk.ChunkBody)
+
+	var nextChunk *pb.StoreObjChunkRequest
+	for receivedBytes < firstChunk.FileSize && err == nil {
+		nextChunk, err = stream.Recv()
+		if err != nil { // EOF is also unexpected
+			break
+		}
+		_, err = fileTmp.Write(nextChunk.ChunkBody)
+		receivedBytes += int64(len(nextChunk.ChunkBody))
+	}
+	if err == nil && receivedBytes != firstChunk.FileSize {
+		err = fmt.Errorf("inconsistent stream, received %d bytes instead of %d", receivedBytes, firstChunk.FileSize)
+	}
+	if err == nil && noccServer.ObjFileCache.fsync {
+		err = noccServer.ObjFileCache.syncFile(fileTmp)
+	}
+
+	_ = fileTmp.Close()
+	if err != nil {
+		_ = os.Remove(fileTmp.Name())
+		return "", err
+	}
+	return fileTmp.Name(), nil
+}
+
 // sendObjFileByChunks is an actual implementation of piping a local server file to a client stream.
 // See client.receiveObjFileByChunks.
 func sendObjFileByChunks(stream pb.CompilationService_RecvCompiledObjStreamServer, chunkBuf []byte, session *Session) (int64, error) {
@@ -58,25 +115,36 @@ func sendObjFileByChunks(stream pb.CompilationService_RecvCompiledObjStreamServe
 	if err != nil {
 		return 0, err
 	}
+	compress := session.client.compressTransfers && common.ShouldCompressTransfer(session.objOutFile, stat.Size())
 
 	var n int
+	var sentChunks = 0 // an empty obj is sent as one empty chunk, otherwise a client would wait for it forever
 	for {
 		n, err = fd.Read(chunkBuf)
-		if err == io.EOF {
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if err == io.EOF && sentChunks != 0 {
 			break
 		}
-		if err != nil {
-			return 0, err
+		sentChunks++
+
+		chunk := &pb.RecvCompiledObjChunkReply{
+			SessionID:    session.sessionID,
+			CxxExitCode:  session.cxxExitCode,
+			CxxStdout:    session.cxxStdout,
+			CxxStderr:    session.cxxStderr,
+			CxxDuration:  session.cxxDuration,
+			FileSize:     stat.Size(),
+			ChunkBody:    chunkBuf[:n],
+			FromObjCache: session.objCacheExists,
 		}
-		err = stream.Send(&pb.RecvCompiledObjChunkReply{
-			SessionID:   session.sessionID,
-			CxxExitCode: session.cxxExitCode,
-			CxxStdout:   session.cxxStdout,
-			CxxStderr:   session.cxxStderr,
-			CxxDuration: session.cxxDuration,
-			FileSize:    stat.Size(),
-			ChunkBody:   chunkBuf[:n],
-		})
+		if compress {
+			if gzipped, ok := common.GzipTransferChunk(chunkBuf[:n]); ok {
+				chunk.ChunkBody, chunk.Gzipped = gzipped, true
+			}
+		}
+		err = stream.Send(chunk)
 		if err != nil {
 			return 0, err
 		}
@@ -87,6 +155,58 @@ func sendObjFileByChunks(stream pb.CompilationService_RecvCompiledObjStreamServe
 	return stat.Size(), nil
 }
 
+// followLogFile pushes lines appended to a log file (only complete ones) until ctx is done.
+// Log rotation is handled: when a file at serverLogFileName is replaced (logrotate + SIGUSR1) or truncated,
+// the rest of an old file is sent, and a new one is followed from its start.
+func followLogFile(ctx context.Context, serverLogFileName string, sendLines func([]byte) error) error {
+	fd, err := os.Open(serverLogFileName)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fd.Close() }()
+	offset, err := fd.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	chunkBuf := make([]byte, 64*1024)
+	var partialLine []byte
+	for {
+		n, err := fd.Read(chunkBuf)
+		if n > 0 {
+			offset += int64(n)
+			partialLine = append(partialLine, chunkBuf[:n]...)
+			if lastNL := bytes.LastIndexByte(partialLine, '\n'); lastNL != -1 {
+				if err := sendLines(partialLine[:lastNL+1]); err != nil {
+					return err
+				}
+				partialLine = append(partialLine[:0], partialLine[lastNL+1:]...)
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		// reached the end: check for rotation, and wait for new lines
+		if stat, errStat := os.Stat(serverLogFileName); errStat == nil {
+			fdStat, errFdStat := fd.Stat()
+			if errFdStat != nil || !os.SameFile(stat, fdStat) || stat.Size() < offset {
+				if newFd, errOpen := os.Open(serverLogFileName); errOpen == nil {
+					_ = fd.Close()
+					fd, offset, partialLine = newFd, 0, partialLine[:0]
+					continue
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
 // sendLogFileByChunks streams a local server log file, for debugging purposes
 // (implementation is similar to streaming obj file, but made simpler).
 // See client.receiveLogFileByChunks.
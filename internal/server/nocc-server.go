@@ -31,6 +31,14 @@ type NoccServer struct {
 	GRPCServer *grpc.Server
 
 	StartTime time.Time
+	ChunkSize int // objs are sent to clients by chunks of this size
+
+	DisableObjCacheLookup bool  // server-wide in.SkipObjCacheLookup, for workloads with near-zero obj cache hit rate
+	CacheObjsWithWarnings bool  // cache objs even if cxx output is non-empty (default), replaying it on a hit, see ObjFileCache.SaveObjWithDiagnosticsToCache
+	ObjCachePerClient     bool  // objs are never shared between clients, see NoccServer.makeObjCacheKey
+	AcceptObjCacheSeeds   bool  // store objs compiled by clients (NOCC_SEED_OBJ_CACHE), trusting them, see StoreObjToCache
+	MaxSessionDeps        int   // sessions with more required files are rejected (0 means no limit), see Client.CreateNewSession
+	BusyQueueSize         int64 // if more sessions wait for cxx, clients are hinted that a server is busy (0 means never)
 
 	Cron  *Cron
 	Stats *Statsd
@@ -77,6 +85,7 @@ func (s *NoccServer) QuitServerGracefully() {
 	s.Stats.Close()
 	s.Cron.StopCron()
 	s.ActiveClients.StopAllClients()
+	s.CxxLauncher.KillAllRunningCxx()
 	s.GRPCServer.GracefulStop()
 }
 
@@ -84,8 +93,30 @@ func (s *NoccServer) QuitServerGracefully() {
 // When a nocc-daemon starts, it sends this query — before starting any session.
 // So, one client == one running nocc-daemon. All clients have unique clientID.
 // When a nocc-daemon exits, it sends StopClient (or when it dies unexpectedly, a client is deleted after timeout).
+// onUnauthenticatedClient is called when a request comes from an unknown clientID.
+// It happens to every connected client after a server restart (until it calls StartClient again),
+// so such requests are not logged one by one, but summarized periodically, see LogUnauthenticatedClientsSummary.
+func (s *NoccServer) onUnauthenticatedClient(where string, clientID string) {
+	atomic.AddInt64(&s.Stats.clientsUnauthenticated, 1)
+	logServer.Info(2, "unauthenticated client", where, "clientID", clientID)
+}
+
+// LogUnauthenticatedClientsSummary is called by cron to log how many requests were rejected since the last call.
+func (s *NoccServer) LogUnauthenticatedClientsSummary() {
+	total := atomic.LoadInt64(&s.Stats.clientsUnauthenticated)
+	if rejected := total - s.Stats.clientsUnauthenticatedLogged; rejected > 0 {
+		logServer.Error(rejected, "requests from unknown clients rejected (probably, after a restart); clients should reconnect")
+	}
+	s.Stats.clientsUnauthenticatedLogged = total
+}
+
 func (s *NoccServer) StartClient(_ context.Context, in *pb.StartClientRequest) (*pb.StartClientReply, error) {
-	client, err := s.ActiveClients.OnClientConnected(in.ClientID, in.DisableObjCache)
+	client, err := s.ActiveClients.OnClientConnected(in.ClientID, ClientOptions{
+		DisableObjCache:   in.DisableObjCache,
+		EchoServerCmdLine: in.EchoServerCmdLine,
+		CompressTransfers: in.CompressTransfers,
+		ObjCacheNamespace: in.ObjCacheNamespace,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -96,22 +127,44 @@ func (s *NoccServer) StartClient(_ context.Context, in *pb.StartClientRequest) (
 		logServer.Info(0, "new remotes list", strings.Count(in.AllRemotesDelim, ",")+1, "clientID", client.clientID, in.AllRemotesDelim)
 	}
 
-	return &pb.StartClientReply{}, nil
+	return &pb.StartClientReply{
+		CompressTransfers: in.CompressTransfers,
+	}, nil
+}
+
+// makeObjCacheKey is ObjFileCache.MakeObjCacheKey, but with -obj-cache-per-client, a key is namespaced by a client:
+// by its NOCC_OBJ_CACHE_NAMESPACE, or by clientID if it's not set. Then objs are never shared between clients,
+// which rules out any cross-client aliasing at the cost of cache hits across agents.
+func (s *NoccServer) makeObjCacheKey(client *Client, cxxName string, cxxArgs []string, sessionFiles []*fileInClientDir, cppInFile string) common.SHA256 {
+	key := s.ObjFileCache.MakeObjCacheKey(cxxName, cxxArgs, sessionFiles, cppInFile)
+	if !s.ObjCachePerClient {
+		return key
+	}
+	namespace := client.objCacheNamespace
+	if namespace == "" {
+		namespace = client.clientID
+	}
+	return NamespaceObjCacheKey(key, namespace)
 }
 
 // StartCompilationSession is a grpc handler.
 // A client sends this request providing sha256 of a .cpp file name and all its dependencies (.h/.nocc-pch/etc.).
 // A server responds, what dependencies are missing (needed to be uploaded from the client).
 // See comments in server.Session.
+// On failure, grpc status codes let a client decide what to do:
+// * codes.Unauthenticated — a client is unknown (the server was restarted), it should connect again
+// * codes.Aborted — a dependency was modified on a client, but active sessions still use a previous version, a client may retry a bit later
+// * codes.FailedPrecondition — a client and a server environments differ (or too many dependencies), a client should compile locally
+// * codes.NotFound — a compiler is not installed on a server, a client should compile locally
+// * others — unexpected errors, a client should compile locally
 func (s *NoccServer) StartCompilationSession(_ context.Context, in *pb.StartCompilationSessionRequest) (*pb.StartCompilationSessionReply, error) {
 	client := s.ActiveClients.GetClient(in.ClientID)
 	if client == nil {
-		atomic.AddInt64(&s.Stats.clientsUnauthenticated, 1)
-		logServer.Error("unauthenticated client on session start", "clientID", in.ClientID)
+		s.onUnauthenticatedClient("on session start", in.ClientID)
 		return nil, status.Errorf(codes.Unauthenticated, "clientID %s not found; probably, the server was restarted just now", in.ClientID)
 	}
 
-	session, err := client.CreateNewSession(in)
+	session, err := client.CreateNewSession(in, s.MaxSessionDeps)
 	if err != nil {
 		atomic.AddInt64(&s.Stats.sessionsFailedOpen, 1)
 		logServer.Error("failed to open session", "clientID", in.ClientID, "sessionID", in.SessionID, err)
@@ -123,9 +176,17 @@ func (s *NoccServer) StartCompilationSession(_ context.Context, in *pb.StartComp
 	// then we don't need to upload files from the client (and even don't need to link them from src cache)
 	// respond that we are waiting 0 files, and the client would immediately request for a compiled obj
 	// it's mostly a moment of optimization: avoid calling os.Link from src cache to working dir
-	if !client.disableObjCache {
-		session.objCacheKey = s.ObjFileCache.MakeObjCacheKey(in.CxxName, in.CxxArgs, session.files, in.CppInFile)
-		if pathInObjCache := s.ObjFileCache.LookupInCache(session.objCacheKey); len(pathInObjCache) != 0 {
+	// for always-changing sources, it's just an overhead (a key is sha256 of all args and deps), it can be skipped
+	if !client.disableObjCache && !in.SkipObjCacheLookup && !in.SyntaxOnly && !s.DisableObjCacheLookup {
+		session.objCacheKey = s.makeObjCacheKey(client, in.CxxName, in.CxxArgs, session.files, in.CppInFile)
+		pathInObjCache := ""
+		if !in.RecacheObj { // otherwise, it's compiled and replaces an existing one, see launchServerCxxForCpp
+			pathInObjCache = s.ObjFileCache.LookupInCache(session.objCacheKey)
+		}
+		if len(pathInObjCache) == 0 && s.CacheObjsWithWarnings && !in.RecacheObj {
+			pathInObjCache, session.cxxStdout, session.cxxStderr = s.ObjFileCache.LookupObjWithDiagnostics(session.objCacheKey, session.clientCppInFile)
+		}
+		if len(pathInObjCache) != 0 {
 			session.objCacheExists = true
 			session.objOutFile = pathInObjCache // stream back this file directly
 			session.compilationStarted = 1      // client.GetSessionsNotStartedCompilation() will not return it
@@ -138,6 +199,20 @@ func (s *NoccServer) StartCompilationSession(_ context.Context, in *pb.StartComp
 			return &pb.StartCompilationSessionReply{}, nil
 		}
 	}
+	// a client asked only for an obj cache lookup (it's going to compile locally otherwise): nothing to upload then
+	if in.ObjCacheOnly {
+		client.CloseSession(session)
+		logServer.Info(1, "obj cache miss", "sessionID", session.sessionID, "clientID", client.clientID, in.CppInFile)
+		return &pb.StartCompilationSessionReply{ObjCacheMiss: true, BusyRetryAfterMs: s.calcBusyRetryAfterMs(client)}, nil
+	}
+	// a compiler missing on a server is detected before uploading anything (an obj from cache is fine without it)
+	if err := s.CxxLauncher.CheckCxxExists(in.CxxName); err != nil {
+		client.CloseSession(session)
+		atomic.AddInt64(&s.Stats.sessionsFailedOpen, 1)
+		logServer.Error("failed to open session", "clientID", in.ClientID, "sessionID", in.SessionID, err)
+		return nil, err
+	}
+
 	// otherwise, we detect files that don't exist in src cache and request a client to upload them
 	// before restoring from src cache, ensure that all client dirs structure is mirrored to workingDir
 	session.PrepareServerCxxCmdLine(s, in.Cwd, in.CxxArgs, in.CxxIDirs)
@@ -149,6 +224,7 @@ func (s *NoccServer) StartCompilationSession(_ context.Context, in *pb.StartComp
 	// the first session is responded "need X to be uploaded", whereas other sessions just wait
 	// note, that if X is in src-cache, it's just hard linked from there to serverFileName
 	fileIndexesToUpload := make([]uint32, 0, len(session.files))
+	nReusedFromSrcCache := 0
 	for index, file := range session.files {
 		switch file.state {
 		case fsFileStateJustCreated:
@@ -157,7 +233,8 @@ func (s *NoccServer) StartCompilationSession(_ context.Context, in *pb.StartComp
 
 			isSystemFile := IsSystemHeaderPath(file.serverFileName) // inside /usr/local/include
 			if isSystemFile && !s.SystemHeaders.IsSystemHeader(file.serverFileName, file.fileSize, file.fileSHA256) {
-				return nil, fmt.Errorf("system file %s differs between a client and a server", file.serverFileName)
+				client.CloseSession(session)
+				return nil, status.Errorf(codes.FailedPrecondition, "system file %s differs between a client and a server", file.serverFileName)
 			}
 			if isSystemFile {
 				logServer.Info(2, "file", file.serverFileName, "is a system file, no need to upload")
@@ -167,6 +244,8 @@ func (s *NoccServer) StartCompilationSession(_ context.Context, in *pb.StartComp
 			if s.SrcFileCache.CreateHardLinkFromCache(file.serverFileName, file.fileSHA256) {
 				logServer.Info(2, "file", file.serverFileName, "is in src-cache, no need to upload")
 				file.state = fsFileStateUploaded
+				client.OnSrcFileReused(s.Stats, file)
+				nReusedFromSrcCache++
 
 				if strings.HasSuffix(file.serverFileName, ".nocc-pch") {
 					_ = s.PchCompilation.CreateHardLinkFromRealPch(file.serverFileName, file.fileSHA256)
@@ -199,13 +278,52 @@ func (s *NoccServer) StartCompilationSession(_ context.Context, in *pb.StartComp
 		}
 	}
 
-	logServer.Info(0, "started", "sessionID", session.sessionID, "clientID", client.clientID, "waiting", len(fileIndexesToUpload), "uploads", in.CppInFile)
+	logServer.Info(0, "started", "sessionID", session.sessionID, "clientID", client.clientID, "waiting", len(fileIndexesToUpload), "uploads", "reused", nReusedFromSrcCache, "from src-cache", in.CppInFile)
 	client.RegisterCreatedSession(session)
 	launchCxxOnServerOnReadySessions(s, client) // other sessions could also be waiting for files in src-cache
 
-	return &pb.StartCompilationSessionReply{
+	reply := &pb.StartCompilationSessionReply{
 		FileIndexesToUpload: fileIndexesToUpload,
-	}, nil
+	}
+	if client.echoServerCmdLine {
+		reply.ServerCmdLine = session.ServerCmdLineForDebug(s.CxxLauncher)
+	}
+	// this session is accepted anyway; the hint is for next ones, clients not knowing it just ignore it
+	reply.BusyRetryAfterMs = s.calcBusyRetryAfterMs(client)
+	return reply, nil
+}
+
+// calcBusyRetryAfterMs is a busy hint for a client (0 if a server is not busy), see -busy-queue-size.
+func (s *NoccServer) calcBusyRetryAfterMs(client *Client) int32 {
+	nWaiting := s.CxxLauncher.GetWaitingInQueueSessionsCount()
+	if s.BusyQueueSize <= 0 || nWaiting <= s.BusyQueueSize {
+		return 0
+	}
+	retryAfterMs := int32(s.CxxLauncher.EstimateQueueWaitMilliseconds())
+	logServer.Info(1, "busy hint", "clientID", client.clientID, "waiting", nWaiting, "retry after ms", retryAfterMs)
+	return retryAfterMs
+}
+
+// CollectDepsOnServer is a grpc handler for an experimental mode, when dependencies are collected by a server.
+// A client sends this request several times for one .cpp file, see collectDepsOnServer.
+func (s *NoccServer) CollectDepsOnServer(_ context.Context, in *pb.CollectDepsOnServerRequest) (*pb.CollectDepsOnServerReply, error) {
+	client := s.ActiveClients.GetClient(in.ClientID)
+	if client == nil {
+		s.onUnauthenticatedClient("on collecting deps", in.ClientID)
+		return nil, status.Errorf(codes.Unauthenticated, "clientID %s not found; probably, the server was restarted just now", in.ClientID)
+	}
+	client.lastSeen = time.Now()
+	if s.MaxSessionDeps > 0 && len(in.KnownFiles) > s.MaxSessionDeps {
+		return nil, status.Errorf(codes.FailedPrecondition, "too many dependencies: %d, max %d", len(in.KnownFiles), s.MaxSessionDeps)
+	}
+
+	reply, err := collectDepsOnServer(s, client, in)
+	if err != nil {
+		logServer.Error("failed to collect deps", "clientID", client.clientID, in.CppInFile, err)
+		return nil, err
+	}
+	logServer.Info(1, "collected deps", "clientID", client.clientID, "known", len(in.KnownFiles), "uploaded", len(in.FileBodies), "unresolved", len(reply.UnresolvedIncludes), in.CppInFile)
+	return reply, nil
 }
 
 // UploadFileStream handles a grpc stream created on a client start.
@@ -225,8 +343,7 @@ func (s *NoccServer) UploadFileStream(stream pb.CompilationService_UploadFileStr
 
 		client := s.ActiveClients.GetClient(firstChunk.ClientID)
 		if client == nil {
-			atomic.AddInt64(&s.Stats.clientsUnauthenticated, 1)
-			logServer.Error("unauthenticated client on upload stream", "clientID", firstChunk.ClientID)
+			s.onUnauthenticatedClient("on upload stream", firstChunk.ClientID)
 			return status.Errorf(codes.Unauthenticated, "client %s not found", firstChunk.ClientID)
 		}
 		client.lastSeen = time.Now()
@@ -273,6 +390,7 @@ func (s *NoccServer) UploadFileStream(stream pb.CompilationService_UploadFileStr
 
 		atomic.AddInt64(&s.Stats.bytesReceived, file.fileSize)
 		atomic.AddInt64(&s.Stats.filesReceived, 1)
+		session.client.OnSrcFileUploaded(s.Stats, file)
 		// start waiting for the next file over the same stream
 	}
 }
@@ -285,11 +403,10 @@ func (s *NoccServer) UploadFileStream(stream pb.CompilationService_UploadFileStr
 func (s *NoccServer) RecvCompiledObjStream(in *pb.OpenReceiveStreamRequest, stream pb.CompilationService_RecvCompiledObjStreamServer) error {
 	client := s.ActiveClients.GetClient(in.ClientID)
 	if client == nil {
-		atomic.AddInt64(&s.Stats.clientsUnauthenticated, 1)
-		logServer.Error("unauthenticated client on recv stream", "clientID", in.ClientID)
+		s.onUnauthenticatedClient("on recv stream", in.ClientID)
 		return status.Errorf(codes.Unauthenticated, "client %s not found", in.ClientID)
 	}
-	chunkBuf := make([]byte, 64*1024) // reusable chunk for file reading, exists until stream close
+	chunkBuf := make([]byte, s.ChunkSize) // reusable chunk for file reading, exists until stream close
 
 	// errors occur very rarely (if a client disconnects or something strange happens)
 	// the easiest solution is just to close this stream
@@ -312,13 +429,15 @@ func (s *NoccServer) RecvCompiledObjStream(in *pb.OpenReceiveStreamRequest, stre
 		case session := <-client.chanReadySessions:
 			client.lastSeen = time.Now()
 
-			if session.cxxExitCode != 0 {
+			// with -fsyntax-only, there is no obj, diagnostics are all the output
+			if session.cxxExitCode != 0 || session.syntaxOnly {
 				err := stream.Send(&pb.RecvCompiledObjChunkReply{
-					SessionID:   session.sessionID,
-					CxxExitCode: session.cxxExitCode,
-					CxxStdout:   session.cxxStdout,
-					CxxStderr:   session.cxxStderr,
-					CxxDuration: session.cxxDuration,
+					SessionID:         session.sessionID,
+					CxxExitCode:       session.cxxExitCode,
+					CxxStdout:         session.cxxStdout,
+					CxxStderr:         session.cxxStderr,
+					CxxDuration:       session.cxxDuration,
+					CxxKilledBySignal: session.cxxKilledBySignal,
 				})
 				if err != nil {
 					return onError(session.sessionID, "can't send obj non-0 reply sessionID %d clientID %s %v", session.sessionID, client.clientID, err)
@@ -340,6 +459,77 @@ func (s *NoccServer) RecvCompiledObjStream(in *pb.OpenReceiveStreamRequest, stre
 	}
 }
 
+// StoreObjToCache is a grpc handler.
+// A client launched with NOCC_SEED_OBJ_CACHE compiles a .cpp locally and then uploads the resulting .o here,
+// along with all metadata needed to calculate an obj cache key. Nothing is compiled on a server, it's "store only":
+// later, other clients compiling the same .cpp with the same dependencies will take this .o from obj cache.
+// Unlike compiled on a server, such an obj is fully trusted: a server can't check that it matches sources and sha256
+// reported by a client, so a malicious (or buggy) client could poison obj cache for all others.
+// That's why it's opt-in, -accept-obj-cache-seeds, for setups where every client is trusted (e.g. restricted by mutual TLS).
+func (s *NoccServer) StoreObjToCache(stream pb.CompilationService_StoreObjToCacheServer) error {
+	firstChunk, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	client := s.ActiveClients.GetClient(firstChunk.ClientID)
+	if client == nil {
+		s.onUnauthenticatedClient("on storing obj", firstChunk.ClientID)
+		return status.Errorf(codes.Unauthenticated, "client %s not found", firstChunk.ClientID)
+	}
+	client.lastSeen = time.Now()
+
+	if !s.AcceptObjCacheSeeds {
+		return status.Errorf(codes.FailedPrecondition, "objs seeded by clients are not accepted by this server (see -accept-obj-cache-seeds)")
+	}
+	if client.disableObjCache {
+		return status.Errorf(codes.FailedPrecondition, "obj cache is disabled for client %s", client.clientID)
+	}
+	if s.DisableObjCacheLookup {
+		return status.Errorf(codes.FailedPrecondition, "obj cache lookup is disabled on this server")
+	}
+	hasDiagnostics := len(firstChunk.CxxStdout) != 0 || len(firstChunk.CxxStderr) != 0
+	if hasDiagnostics && !s.CacheObjsWithWarnings {
+		return status.Errorf(codes.FailedPrecondition, "objs with warnings are not cached on this server")
+	}
+	// old clients that don't send cwd: a relative cppInFile can't be resolved to key diagnostics by it
+	// todo delete later, after upgrading all clients
+	clientCppInFile := clientCppInFileAbs(firstChunk.Cwd, firstChunk.CppInFile)
+	if hasDiagnostics && !path.IsAbs(clientCppInFile) {
+		return status.Errorf(codes.FailedPrecondition, "objs with warnings are not cached without cwd")
+	}
+
+	sessionFiles := make([]*fileInClientDir, len(firstChunk.RequiredFiles))
+	for index, meta := range firstChunk.RequiredFiles {
+		sessionFiles[index] = &fileInClientDir{
+			fileSize:   meta.FileSize,
+			fileSHA256: common.SHA256{B0_7: meta.SHA256_B0_7, B8_15: meta.SHA256_B8_15, B16_23: meta.SHA256_B16_23, B24_31: meta.SHA256_B24_31},
+		}
+	}
+	objCacheKey := s.makeObjCacheKey(client, firstChunk.CxxName, firstChunk.CxxArgs, sessionFiles, firstChunk.CppInFile)
+
+	objTmpFileName, err := receiveStoredObjFileByChunks(s, stream, firstChunk, client)
+	if err != nil {
+		logServer.Error("can't receive obj to store", "clientID", client.clientID, firstChunk.CppInFile, err)
+		return err
+	}
+	if hasDiagnostics {
+		err = s.ObjFileCache.SaveObjWithDiagnosticsToCache(objTmpFileName, clientCppInFile, objCacheKey, firstChunk.FileSize, firstChunk.CxxStdout, firstChunk.CxxStderr)
+	} else {
+		err = s.ObjFileCache.SaveFileToCache(objTmpFileName, path.Base(firstChunk.CppInFile)+".o", objCacheKey, firstChunk.FileSize)
+	}
+	_ = os.Remove(objTmpFileName) // it's hard linked to obj cache (or was already there)
+	if err != nil {
+		logServer.Error("can't save obj to cache", "clientID", client.clientID, firstChunk.CppInFile, err)
+		return err
+	}
+
+	logServer.Info(1, "stored obj to cache", "clientID", client.clientID, firstChunk.FileSize, "bytes", firstChunk.CppInFile)
+	atomic.AddInt64(&s.Stats.objFilesStored, 1)
+	atomic.AddInt64(&s.Stats.bytesReceived, firstChunk.FileSize)
+	return stream.SendAndClose(&pb.StoreObjReply{})
+}
+
 // StopClient is a grpc handler. See StartClient for comments.
 func (s *NoccServer) StopClient(_ context.Context, in *pb.StopClientRequest) (*pb.StopClientReply, error) {
 	client := s.ActiveClients.GetClient(in.ClientID)
@@ -375,22 +565,27 @@ func (s *NoccServer) Status(context.Context, *pb.StatusRequest) (*pb.StatusReply
 	_ = syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rLimit)
 
 	return &pb.StatusReply{
-		ServerVersion:   common.GetVersion(),
-		ServerArgs:      os.Args,
-		ServerUptime:    int64(time.Since(s.StartTime)),
-		GccVersion:      detectVersionFromConsoleOutput(gccRawOut),
-		ClangVersion:    detectVersionFromConsoleOutput(clangRawOut),
-		LogFileSize:     logServer.GetFileSize(),
-		SrcCacheSize:    s.SrcFileCache.GetBytesOnDisk(),
-		ObjCacheSize:    s.ObjFileCache.GetBytesOnDisk(),
-		ULimit:          int64(rLimit.Cur),
-		UName:           strings.TrimSpace(string(uNameRV)),
-		SessionsTotal:   atomic.LoadInt64(&s.Stats.sessionsCount),
-		SessionsActive:  s.ActiveClients.ActiveSessionsCount(),
-		CxxCalls:        s.CxxLauncher.GetTotalCxxCallsCount(),
-		CxxDurMore10Sec: s.CxxLauncher.GetMore10secCount(),
-		CxxDurMore30Sec: s.CxxLauncher.GetMore30secCount(),
-		UniqueRemotes:   s.ActiveClients.GetUniqueRemotesListInfo(),
+		ServerVersion:         common.GetVersion(),
+		ServerArgs:            os.Args,
+		ServerUptime:          int64(time.Since(s.StartTime)),
+		GccVersion:            detectVersionFromConsoleOutput(gccRawOut),
+		ClangVersion:          detectVersionFromConsoleOutput(clangRawOut),
+		LogFileSize:           logServer.GetFileSize(),
+		SrcCacheSize:          s.SrcFileCache.GetBytesOnDisk(),
+		ObjCacheSize:          s.ObjFileCache.GetBytesOnDisk(),
+		ULimit:                int64(rLimit.Cur),
+		UName:                 strings.TrimSpace(string(uNameRV)),
+		SessionsTotal:         atomic.LoadInt64(&s.Stats.sessionsCount),
+		SessionsActive:        s.ActiveClients.ActiveSessionsCount(),
+		LongestActiveSessions: s.ActiveClients.GetLongestActiveSessionsInfo(10),
+		SrcFilesReused:        atomic.LoadInt64(&s.Stats.srcFilesReused),
+		SrcBytesReused:        atomic.LoadInt64(&s.Stats.srcBytesReused),
+		SrcFilesUploaded:      atomic.LoadInt64(&s.Stats.srcFilesUploaded),
+		SrcBytesUploaded:      atomic.LoadInt64(&s.Stats.srcBytesUploaded),
+		CxxCalls:              s.CxxLauncher.GetTotalCxxCallsCount(),
+		CxxDurMore10Sec:       s.CxxLauncher.GetMore10secCount(),
+		CxxDurMore30Sec:       s.CxxLauncher.GetMore30secCount(),
+		UniqueRemotes:         s.ActiveClients.GetUniqueRemotesListInfo(),
 	}, nil
 }
 
@@ -418,6 +613,22 @@ func (s *NoccServer) DumpLogs(_ *pb.DumpLogsRequest, stream pb.CompilationServic
 	return stream.Send(&pb.DumpLogsReply{LogFileExt: ""})
 }
 
+// FollowLogs is a grpc handler.
+// A client launched with the `-follow-server-logs` cmd flag sends this request to all servers.
+// Like `tail -f`, new lines of a log file are pushed until a client cancels the stream.
+func (s *NoccServer) FollowLogs(_ *pb.FollowLogsRequest, stream pb.CompilationService_FollowLogsServer) error {
+	logServer.Info(0, "requested to follow logs")
+
+	currentLog := logServer.GetFileName()
+	if currentLog == "" || currentLog == "stderr" {
+		return errors.New("can't follow logs, as they aren't being saved to file")
+	}
+
+	return followLogFile(stream.Context(), currentLog, func(lines []byte) error {
+		return stream.Send(&pb.FollowLogsReply{Lines: lines})
+	})
+}
+
 // DropAllCaches drops src and obj caches without restarting a server.
 // Used primarily for development purposes.
 func (s *NoccServer) DropAllCaches(context.Context, *pb.DropAllCachesRequest) (*pb.DropAllCachesReply, error) {
@@ -433,3 +644,23 @@ func (s *NoccServer) DropAllCaches(context.Context, *pb.DropAllCachesRequest) (*
 		DroppedObjFiles: droppedObjFiles,
 	}, nil
 }
+
+// PurgeStaleCaches evicts src and obj cache files not accessed for a given time, keeping a warm set.
+// Unlike DropAllCaches, it's for regular cache hygiene on long-lived servers.
+func (s *NoccServer) PurgeStaleCaches(_ context.Context, in *pb.PurgeStaleCachesRequest) (*pb.PurgeStaleCachesReply, error) {
+	if in.OlderThanSeconds <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid ttl %d seconds", in.OlderThanSeconds)
+	}
+	ttl := time.Duration(in.OlderThanSeconds) * time.Second
+
+	purgedSrcFiles, purgedSrcBytes := s.SrcFileCache.PurgeOlderThan(ttl)
+	purgedObjFiles, purgedObjBytes := s.ObjFileCache.PurgeOlderThan(ttl)
+	logServer.Info(0, "requested to purge caches older than", ttl, "purged", purgedSrcFiles, "src files", purgedSrcBytes, "bytes and", purgedObjFiles, "obj files", purgedObjBytes, "bytes")
+
+	return &pb.PurgeStaleCachesReply{
+		PurgedSrcFiles: purgedSrcFiles,
+		PurgedSrcBytes: purgedSrcBytes,
+		PurgedObjFiles: purgedObjFiles,
+		PurgedObjBytes: purgedObjBytes,
+	}, nil
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"os"
 	"path"
 	"strings"
 
@@ -23,8 +25,8 @@ type ObjFileCache struct {
 	objTmpDir string
 }
 
-func MakeObjFileCache(cacheDir string, objTmpDir string, limitBytes int64) (*ObjFileCache, error) {
-	cache, err := MakeFileCache(cacheDir, limitBytes)
+func MakeObjFileCache(cacheDir string, objTmpDir string, limitBytes int64, fsync bool) (*ObjFileCache, error) {
+	cache, err := MakeFileCache(cacheDir, limitBytes, fsync)
 	if err != nil {
 		return nil, err
 	}
@@ -42,30 +44,146 @@ func MakeObjFileCache(cacheDir string, objTmpDir string, limitBytes int64) (*Obj
 // * the .cpp file is the same (its name and sha256)
 // * all dependent .h/.nocc-pch/etc. are the same (their count, order, size, sha256)
 // * all C++ compiler options are the same
+// * the target is the same (-target {triple} / --target= / -m32 / -m64), it's resolved explicitly, see common.ParseCxxTarget
 //
 // The problem is with the last point. cxxCmdLine contains -I and other options that vary between clients:
 // > -iquote /tmp/nocc/cpp/clients/{clientID}/home/{username}/proj -I /tmp/gch/{random_hash} -o ...{random_int}.o
 // These are different options, but in fact, they should be considered the same.
 // That's why we don't take include paths into account when calculating a hash from cxxCmdLine.
 // The assumption is: if all deps are equal, their actual paths/names don't matter.
+// Options like -pipe also don't matter, see common.IsCxxArgIrrelevantForRemote.
+//
+// Everything is fed into a single sha256 stream (not XOR-folded), so the key is sensitive to order:
+// two deps swapping their contents, or args being split differently, produce different keys.
 func (cache *ObjFileCache) MakeObjCacheKey(cxxName string, cxxArgs []string, sessionFiles []*fileInClientDir, cppInFile string) common.SHA256 {
 	hasher := sha256.New()
+	var buf [8 * 5]byte
 
 	hasher.Write([]byte(cxxName))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(common.ParseCxxTarget(cxxArgs)))
+	hasher.Write([]byte{0})
 	for _, arg := range cxxArgs {
-		hasher.Write([]byte(arg))
+		if !common.IsCxxArgIrrelevantForRemote(arg) {
+			hasher.Write([]byte(arg))
+			hasher.Write([]byte{0})
+		}
 	}
 	hasher.Write([]byte(path.Base(cppInFile))) // not a full path, as it varies between clients
+	hasher.Write([]byte{0})
 
-	sha256xor := common.MakeSHA256Struct(hasher)
-	sha256xor.B8_15 ^= uint64(len(cxxArgs))
-	sha256xor.B16_23 ^= uint64(len(sessionFiles))
+	binary.BigEndian.PutUint64(buf[0:8], uint64(len(sessionFiles)))
+	hasher.Write(buf[0:8])
 	for _, file := range sessionFiles {
-		sha256xor.XorWith(&file.fileSHA256)
-		sha256xor.B0_7 ^= uint64(file.fileSize)
+		binary.BigEndian.PutUint64(buf[0:8], uint64(file.fileSize))
+		binary.BigEndian.PutUint64(buf[8:16], file.fileSHA256.B0_7)
+		binary.BigEndian.PutUint64(buf[16:24], file.fileSHA256.B8_15)
+		binary.BigEndian.PutUint64(buf[24:32], file.fileSHA256.B16_23)
+		binary.BigEndian.PutUint64(buf[32:40], file.fileSHA256.B24_31)
+		hasher.Write(buf[:])
+	}
+
+	return common.MakeSHA256Struct(hasher)
+}
+
+// MakeSessionFilesForTesting creates deps of a session (only sizes and sha256 are filled) to calculate MakeObjCacheKey
+// outside of a server, for tests and benchmarks.
+func MakeSessionFilesForTesting(fileSizes []int64, fileSHA256s []common.SHA256) []*fileInClientDir {
+	sessionFiles := make([]*fileInClientDir, len(fileSizes))
+	for i := range sessionFiles {
+		sessionFiles[i] = &fileInClientDir{fileSize: fileSizes[i], fileSHA256: fileSHA256s[i]}
+	}
+	return sessionFiles
+}
+
+// NamespaceObjCacheKey derives a key from MakeObjCacheKey that is valid only within a namespace, see -obj-cache-per-client.
+func NamespaceObjCacheKey(key common.SHA256, namespace string) common.SHA256 {
+	hasher := sha256.New()
+	var buf [8 * 4]byte
+
+	hasher.Write([]byte(namespace))
+	hasher.Write([]byte{0})
+	binary.BigEndian.PutUint64(buf[0:8], key.B0_7)
+	binary.BigEndian.PutUint64(buf[8:16], key.B8_15)
+	binary.BigEndian.PutUint64(buf[16:24], key.B16_23)
+	binary.BigEndian.PutUint64(buf[24:32], key.B24_31)
+	hasher.Write(buf[:])
+
+	return common.MakeSHA256Struct(hasher)
+}
+
+// makeKeysWithDiagnostics derives keys for an obj compiled with warnings and for its diagnostics from MakeObjCacheKey.
+// Such objs are stored under another key than clean ones: a lookup by a clean key never returns an obj
+// whose diagnostics should have been replayed.
+// Unlike a clean obj, they are keyed by a full client path of a .cpp file (see clientCppInFileAbs):
+// diagnostics contain client paths, and a client compiling the same sources elsewhere must not see paths of another one.
+// Both are hashed with a domain tag ("obj" / "diagnostics"), so they never coincide with each other or with a clean key.
+func makeKeysWithDiagnostics(key common.SHA256, clientCppInFile string) (objKey common.SHA256, diagnosticsKey common.SHA256) {
+	objKey = NamespaceObjCacheKey(key, "obj\x00"+clientCppInFile)
+	diagnosticsKey = NamespaceObjCacheKey(key, "diagnostics\x00"+clientCppInFile)
+	return
+}
+
+// clientCppInFileAbs is a full path of a .cpp file on a client file system, diagnostics in obj cache are keyed by it.
+// A cppInFile from a client cmd line can be relative to clientCwd (old clients that don't send cwd send it absolute).
+func clientCppInFileAbs(clientCwd string, cppInFile string) string {
+	if path.IsAbs(cppInFile) || clientCwd == "" {
+		return cppInFile
+	}
+	return path.Join(clientCwd, cppInFile)
+}
+
+// SaveObjWithDiagnosticsToCache saves an obj compiled with non-empty cxx output (warnings, typically),
+// along with that output, so that it's replayed on a cache hit, see LookupObjWithDiagnostics.
+// Diagnostics contain client paths (server ones are already dropped), that's why they are replayed only
+// for the same clientCppInFile, see makeKeysWithDiagnostics.
+func (cache *ObjFileCache) SaveObjWithDiagnosticsToCache(objOutFile string, clientCppInFile string, key common.SHA256, objSize int64, cxxStdout []byte, cxxStderr []byte) error {
+	objKey, diagnosticsKey := makeKeysWithDiagnostics(key, clientCppInFile)
+
+	// stdout length, stdout, stderr
+	diagnostics := make([]byte, 8, 8+len(cxxStdout)+len(cxxStderr))
+	binary.BigEndian.PutUint64(diagnostics, uint64(len(cxxStdout)))
+	diagnostics = append(append(diagnostics, cxxStdout...), cxxStderr...)
+	diagnosticsTmpFile := objOutFile + ".diagnostics"
+	if err := os.WriteFile(diagnosticsTmpFile, diagnostics, 0644); err != nil {
+		return err
+	}
+	defer os.Remove(diagnosticsTmpFile)
+
+	// an obj is saved first: being more recent in LRU, diagnostics are purged after it
+	if err := cache.SaveFileToCache(objOutFile, path.Base(clientCppInFile)+".o", objKey, objSize); err != nil {
+		return err
 	}
+	return cache.SaveFileToCache(diagnosticsTmpFile, path.Base(clientCppInFile)+".o.diagnostics", diagnosticsKey, int64(len(diagnostics)))
+}
+
+// RemoveObjFromCache removes an obj saved by a key, either clean or with diagnostics, to be replaced by a new one.
+func (cache *ObjFileCache) RemoveObjFromCache(key common.SHA256, clientCppInFile string) {
+	objKey, diagnosticsKey := makeKeysWithDiagnostics(key, clientCppInFile)
+	cache.RemoveFromCache(key)
+	cache.RemoveFromCache(objKey)
+	cache.RemoveFromCache(diagnosticsKey)
+}
+
+// LookupObjWithDiagnostics finds an obj saved by SaveObjWithDiagnosticsToCache and reads its cxx output.
+// If diagnostics are missing (purged), it's a cache miss: an obj must not be served without warnings.
+func (cache *ObjFileCache) LookupObjWithDiagnostics(key common.SHA256, clientCppInFile string) (pathInCache string, cxxStdout []byte, cxxStderr []byte) {
+	objKey, diagnosticsKey := makeKeysWithDiagnostics(key, clientCppInFile)
 
-	return sha256xor
+	pathInCache = cache.LookupInCache(objKey)
+	if pathInCache == "" {
+		return "", nil, nil
+	}
+	diagnosticsPath := cache.LookupInCache(diagnosticsKey)
+	if diagnosticsPath == "" {
+		return "", nil, nil
+	}
+	diagnostics, err := os.ReadFile(diagnosticsPath)
+	if err != nil || len(diagnostics) < 8 || binary.BigEndian.Uint64(diagnostics) > uint64(len(diagnostics)-8) {
+		return "", nil, nil
+	}
+	stdoutLen := binary.BigEndian.Uint64(diagnostics)
+	return pathInCache, diagnostics[8 : 8+stdoutLen], diagnostics[8+stdoutLen:]
 }
 
 // GenerateObjOutFileName generates session.objOutFile (destination for C++ compiler launched on a server)
@@ -42,8 +42,11 @@ func (pchCompilation *PchCompilation) PrepareServerCxxCmdLine(ownPch *common.Own
 		serverIdir := rootDir + ownPch.CxxIDirs[i+1]
 		cxxCmdLine = append(cxxCmdLine, arg, serverIdir)
 	}
-	// append -Wall and other cxx args
-	cxxCmdLine = append(cxxCmdLine, ownPch.CxxArgs...)
+	// append -Wall and other cxx args (a specs file, if any, was extracted to rootDir along with other deps)
+	for _, cxxArg := range ownPch.CxxArgs {
+		cxxArg = SpecsFileOption(cxxArg, func(clientFileName string) string { return rootDir + clientFileName })
+		cxxCmdLine = append(cxxCmdLine, cxxArg)
+	}
 	// append output (.gch/.pch) and input (a header generated from)
 	return append(cxxCmdLine, "-o", rootDir+ownPch.OrigPchFile, rootDir+ownPch.OrigHFile)
 }
@@ -131,6 +134,6 @@ func (pchCompilation *PchCompilation) CreateHardLinkFromRealPch(ownPchName strin
 	clientHFile := path.Join(path.Dir(ownPchName), path.Base(compiledPch.ownPch.OrigHFile))
 	clientPchFile := path.Join(path.Dir(ownPchName), path.Base(compiledPch.ownPch.OrigPchFile))
 
-	_ = os.Link(compiledPch.realHFile, clientHFile)
-	return os.Link(compiledPch.realPchFile, clientPchFile)
+	_ = linkOrCopyFile(compiledPch.realHFile, clientHFile)
+	return linkOrCopyFile(compiledPch.realPchFile, clientPchFile)
 }
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"path"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/VKCOM/nocc/internal/common"
 )
@@ -18,23 +21,29 @@ import (
 type Session struct {
 	sessionID uint32
 
-	cppInFile  string // as-is from a client cmd line (relative to cxxCwd on a server-side)
-	objOutFile string // inside /tmp/nocc/obj/cxx-out, or directly in /tmp/nocc/obj/obj-cache if taken from cache
-	cxxCwd     string // cwd for the C++ compiler on a server-side (= client.workingDir + clientCwd)
-	cxxName    string // g++ / clang / etc.
-	cxxCmdLine []string
+	cppInFile       string // as-is from a client cmd line (relative to cxxCwd on a server-side)
+	clientCppInFile string // cppInFile on a client file system, diagnostics in obj cache are keyed by it
+	objOutFile      string // inside /tmp/nocc/obj/cxx-out, or directly in /tmp/nocc/obj/obj-cache if taken from cache
+	cxxCwd          string // cwd for the C++ compiler on a server-side (= client.workingDir + clientCwd)
+	cxxName         string // g++ / clang / etc.
+	cxxCmdLine      []string
 
 	client *Client
 	files  []*fileInClientDir
 
+	syntaxOnly         bool // -fsyntax-only, no obj is produced (and nothing is saved to obj cache)
+	recacheObj         bool // obj cache isn't looked up, but an obj replaces an existing one after compilation
 	objCacheKey        common.SHA256
 	objCacheExists     bool
 	compilationStarted int32
 
-	cxxExitCode int32
-	cxxStdout   []byte
-	cxxStderr   []byte
-	cxxDuration int32
+	cxxExitCode       int32
+	cxxKilledBySignal int32 // not 0 if cxx crashed (segfault, OOM killer): a client compiles such a file locally
+	cxxStdout         []byte
+	cxxStderr         []byte
+	cxxDuration       int32
+
+	startTime time.Time // to find sessions that stuck for a long time, see ClientsStorage.GetLongestActiveSessionsInfo
 }
 
 // PrepareServerCxxCmdLine prepares a command line for cxx invocation.
@@ -42,28 +51,38 @@ type Session struct {
 // but include dirs like /home/alice/headers need to be remapped to point to server dir.
 func (session *Session) PrepareServerCxxCmdLine(noccServer *NoccServer, clientCwd string, cxxArgs []string, cxxIDirs []string) {
 	session.objOutFile = noccServer.ObjFileCache.GenerateObjOutFileName(session)
+	cppInFile := session.MapCppInFileToServer(clientCwd)
+	session.cxxCmdLine = append(session.MapCxxArgsToServer(cxxArgs, cxxIDirs), "-o", session.objOutFile, cppInFile)
+}
 
-	var cppInFile string
+// MapCppInFileToServer detects session.cxxCwd and a .cpp file name for a server cxx cmd line.
+func (session *Session) MapCppInFileToServer(clientCwd string) (cppInFile string) {
 	// old clients that don't send this field (they send abs cppInFile)
 	// todo delete later, after upgrading all clients
 	if clientCwd == "" {
-		cppInFile = session.client.MapClientFileNameToServerAbs(session.cppInFile)
 		session.cxxCwd = session.client.workingDir
-	} else {
-		// session.cppInFile is as-is from a client cmd line:
-		// * "/abs/path" becomes "client.workingDir/abs/path"
-		//    (except for system files, /usr/include left unchanged)
-		// * "rel/path" (relative to clientCwd) is left as-is (becomes relative to session.cxxCwd)
-		//    (for correct __FILE__ expansion and other minor specifics)
-		if session.cppInFile[0] == '/' {
-			cppInFile = session.client.MapClientFileNameToServerAbs(session.cppInFile)
-		} else {
-			cppInFile = session.cppInFile
-		}
-		session.cxxCwd = session.client.MapClientFileNameToServerAbs(clientCwd)
+		return session.client.MapClientFileNameToServerAbs(session.cppInFile)
+	}
+
+	// session.cppInFile is as-is from a client cmd line:
+	// * "/abs/path" becomes "client.workingDir/abs/path"
+	//    (except for system files, /usr/include left unchanged)
+	// * "rel/path" (relative to clientCwd) is left as-is (becomes relative to session.cxxCwd)
+	//    (for correct __FILE__ expansion and other minor specifics)
+	session.cxxCwd = session.client.MapClientFileNameToServerAbs(clientCwd)
+	if session.cppInFile[0] == '/' {
+		return session.client.MapClientFileNameToServerAbs(session.cppInFile)
+	} else if !strings.HasPrefix(path.Join(session.cxxCwd, session.cppInFile), session.client.workingDir+"/") {
+		// "../../../etc/file.cpp" would escape the client working dir, make it absolute (cleaned)
+		return session.client.MapClientFileNameToServerAbs(path.Join(clientCwd, session.cppInFile))
 	}
+	return session.cppInFile
+}
 
-	cxxCmdLine := make([]string, 0, len(cxxIDirs)+len(cxxArgs)+3)
+// MapCxxArgsToServer converts client include dirs and options referencing client files to server paths.
+// It's a server cxx cmd line without output and input files, they are appended by the caller.
+func (session *Session) MapCxxArgsToServer(cxxArgs []string, cxxIDirs []string) []string {
+	cxxCmdLine := make([]string, 0, len(cxxIDirs)+len(cxxArgs)+5)
 
 	// loop through -I {dir} / -include {file} / etc. (format is guaranteed), converting client {dir} to server path
 	for i := 0; i < len(cxxIDirs); i += 2 {
@@ -74,11 +93,17 @@ func (session *Session) PrepareServerCxxCmdLine(noccServer *NoccServer, clientCw
 
 	for i := 0; i < len(cxxArgs); i++ {
 		cxxArg := FilePrefixMapOption(cxxArgs[i], session.client.workingDir)
+		cxxArg = SpecsFileOption(cxxArg, session.client.MapClientFileNameToServerAbs)
 
 		cxxCmdLine = append(cxxCmdLine, cxxArg)
 	}
-	// build final string
-	session.cxxCmdLine = append(cxxCmdLine, "-o", session.objOutFile, cppInFile)
+	return cxxCmdLine
+}
+
+// ServerCmdLineForDebug returns a shell-like representation of cxx launched for this session.
+// Server paths are left as-is: it's for debugging "it compiles locally but fails remotely".
+func (session *Session) ServerCmdLineForDebug(cxxLauncher *CxxLauncher) string {
+	return "cd " + session.cxxCwd + " && " + cxxLauncher.MapCxxName(session.cxxName) + " " + strings.Join(session.cxxCmdLine, " ")
 }
 
 // StartCompilingObjIfPossible executes cxx if all dependent files (.cpp/.h/.nocc-pch/etc.) are ready.
@@ -1,26 +1,45 @@
 package server
 
 import (
+	"compress/gzip"
+	"io"
 	"math/rand"
 	"os"
+	"path"
 	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/VKCOM/nocc/internal/common"
 )
 
 // SrcFileCache is a /tmp/nocc/cpp/src-cache directory, where uploaded .cpp/.h/etc. files are saved.
 // It's supposed that sha256 uniquely identifies the file, that's why a map key doesn't contain size/mtime.
 // It's useful to share files across clients (if one client has uploaded a file, the second takes it from cache).
 // Also, it helps reuse files across the same client after it was considered inactive and deleted, but launched again.
+//
+// With compression enabled (-src-cache-compress), files are stored gzipped, and the cache limit fits several times more
+// of text headers. The price is that a file can't be hard linked from cache into a client dir anymore:
+// every cache hit decompresses a file into a new copy (CPU + disk space of a copy, which is shared with cache otherwise).
+// Stats (src_cache.compress_*) show a ratio and time spent, to decide whether it's worth it for a workload.
 type SrcFileCache struct {
 	*FileCache
+
+	compress bool
+
+	compressBytesIn   int64 // nb! atomic
+	compressBytesOut  int64 // nb! atomic
+	decompressedCount int64 // nb! atomic
+	decompressNanos   int64 // nb! atomic
 }
 
-func MakeSrcFileCache(cacheDir string, limitBytes int64) (*SrcFileCache, error) {
-	cache, err := MakeFileCache(cacheDir, limitBytes)
+func MakeSrcFileCache(cacheDir string, limitBytes int64, fsync bool, compress bool) (*SrcFileCache, error) {
+	cache, err := MakeFileCache(cacheDir, limitBytes, fsync)
 	if err != nil {
 		return nil, err
 	}
 
-	return &SrcFileCache{cache}, nil
+	return &SrcFileCache{FileCache: cache, compress: compress}, nil
 }
 
 func (cache *SrcFileCache) MakeTempFileForUploadSaving(serverFileName string) (*os.File, error) {
@@ -28,3 +47,117 @@ func (cache *SrcFileCache) MakeTempFileForUploadSaving(serverFileName string) (*
 	fileNameTmp := serverFileName + "." + strconv.Itoa(rand.Int())
 	return os.OpenFile(fileNameTmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, os.ModePerm)
 }
+
+// SaveFileToCache is FileCache.SaveFileToCache, but if compression is enabled, a gzipped copy is saved instead of a link.
+// The cache limit is applied to compressed sizes, that's the point.
+func (cache *SrcFileCache) SaveFileToCache(srcPath string, fileNameInCacheDir string, key common.SHA256, fileSize int64) error {
+	if !cache.compress {
+		return cache.FileCache.SaveFileToCache(srcPath, fileNameInCacheDir, key, fileSize)
+	}
+
+	gzFileName, gzSize, err := cache.compressToTempFile(srcPath, fileNameInCacheDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(gzFileName) // it's hard linked into a cache shard dir (the same filesystem)
+	atomic.AddInt64(&cache.compressBytesIn, fileSize)
+	atomic.AddInt64(&cache.compressBytesOut, gzSize)
+	return cache.FileCache.SaveFileToCache(gzFileName, fileNameInCacheDir+".gz", key, gzSize)
+}
+
+// CreateHardLinkFromCache is FileCache.CreateHardLinkFromCache, but if compression is enabled,
+// a file is decompressed into serverFileName (via a tmp file, to be atomic like a link) instead of linking.
+func (cache *SrcFileCache) CreateHardLinkFromCache(serverFileName string, key common.SHA256) bool {
+	if !cache.compress {
+		return cache.FileCache.CreateHardLinkFromCache(serverFileName, key)
+	}
+
+	pathInCache := cache.LookupInCache(key)
+	if len(pathInCache) == 0 {
+		return false
+	}
+	if _, err := os.Stat(serverFileName); err == nil {
+		return true // like os.IsExist after os.Link
+	}
+
+	start := time.Now()
+	err := decompressToFile(pathInCache, serverFileName)
+	atomic.AddInt64(&cache.decompressNanos, int64(time.Since(start)))
+	atomic.AddInt64(&cache.decompressedCount, 1)
+	return err == nil
+}
+
+func (cache *SrcFileCache) compressToTempFile(srcPath string, fileNameInCacheDir string) (string, int64, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer src.Close()
+	gzFile, err := common.OpenTempFile(path.Join(cache.cacheDir, fileNameInCacheDir+".gz"))
+	if err != nil {
+		return "", 0, err
+	}
+
+	gzWriter, _ := gzip.NewWriterLevel(gzFile, gzip.BestSpeed)
+	_, err = io.Copy(gzWriter, src)
+	if errClose := gzWriter.Close(); err == nil {
+		err = errClose
+	}
+	var gzSize int64
+	if err == nil {
+		gzSize, err = gzFile.Seek(0, io.SeekCurrent)
+	}
+	_ = gzFile.Close()
+	if err != nil {
+		_ = os.Remove(gzFile.Name())
+		return "", 0, err
+	}
+	return gzFile.Name(), gzSize, nil
+}
+
+func decompressToFile(gzPath string, dstPath string) error {
+	gzFile, err := os.Open(gzPath)
+	if err != nil {
+		return err
+	}
+	defer gzFile.Close()
+	gzReader, err := gzip.NewReader(gzFile)
+	if err != nil {
+		return err
+	}
+
+	// path.Dir(dstPath) must be created in advance
+	dstTmp, err := common.OpenTempFile(dstPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dstTmp, gzReader)
+	if errClose := dstTmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(dstTmp.Name(), dstPath)
+	}
+	if err != nil {
+		_ = os.Remove(dstTmp.Name())
+	}
+	return err
+}
+
+// GetCompressBytesIn and GetCompressBytesOut are total sizes of files saved to cache before and after compression,
+// their ratio is how many times more files fit the cache limit.
+func (cache *SrcFileCache) GetCompressBytesIn() int64 {
+	return atomic.LoadInt64(&cache.compressBytesIn)
+}
+
+func (cache *SrcFileCache) GetCompressBytesOut() int64 {
+	return atomic.LoadInt64(&cache.compressBytesOut)
+}
+
+func (cache *SrcFileCache) GetDecompressedCount() int64 {
+	return atomic.LoadInt64(&cache.decompressedCount)
+}
+
+func (cache *SrcFileCache) GetDecompressMillis() int64 {
+	return atomic.LoadInt64(&cache.decompressNanos) / int64(time.Millisecond)
+}
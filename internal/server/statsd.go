@@ -23,9 +23,16 @@ type Statsd struct {
 	sessionsCount          int64
 	sessionsFailedOpen     int64
 	sessionsFromObjCache   int64
+	objFilesStored         int64
+	srcFilesReused         int64 // not uploaded, since found in src cache
+	srcBytesReused         int64
+	srcFilesUploaded       int64 // uploaded by clients, unlike filesReceived, doesn't include objs stored to obj cache
+	srcBytesUploaded       int64
 	pchCompilations        int64
 	pchCompilationsFailed  int64
 
+	clientsUnauthenticatedLogged int64 // accessed only from cron, see NoccServer.LogUnauthenticatedClientsSummary
+
 	statsdConnection net.Conn
 	statsdBuffer     bytes.Buffer
 }
@@ -59,6 +66,7 @@ func (cs *Statsd) fillBufferWithStats(noccServer *NoccServer) {
 	cs.writeStat("sessions.total", atomic.LoadInt64(&cs.sessionsCount))
 	cs.writeStat("sessions.failed_open", atomic.LoadInt64(&cs.sessionsFailedOpen))
 	cs.writeStat("sessions.from_obj_cache", atomic.LoadInt64(&cs.sessionsFromObjCache))
+	cs.writeStat("sessions.obj_stored", atomic.LoadInt64(&cs.objFilesStored))
 
 	cs.writeStat("clients.active", noccServer.ActiveClients.ActiveCount())
 	cs.writeStat("clients.completed", noccServer.ActiveClients.CompletedCount())
@@ -72,6 +80,8 @@ func (cs *Statsd) fillBufferWithStats(noccServer *NoccServer) {
 	cs.writeStat("cxx.more10sec", noccServer.CxxLauncher.GetMore10secCount())
 	cs.writeStat("cxx.more30sec", noccServer.CxxLauncher.GetMore30secCount())
 	cs.writeStat("cxx.nonzero", noccServer.CxxLauncher.GetNonZeroExitCodeCount())
+	cs.writeStat("cxx.killed_by_timeout", noccServer.CxxLauncher.GetKilledByTimeoutCount())
+	cs.writeStat("cxx.killed_by_signal", noccServer.CxxLauncher.GetKilledBySignalCount())
 
 	cs.writeStat("pch.calls", atomic.LoadInt64(&cs.pchCompilations))
 	cs.writeStat("pch.failed", atomic.LoadInt64(&cs.pchCompilationsFailed))
@@ -84,10 +94,30 @@ func (cs *Statsd) fillBufferWithStats(noccServer *NoccServer) {
 
 	cs.writeStat("src_cache.count", noccServer.SrcFileCache.GetFilesCount())
 	cs.writeStat("src_cache.purged", noccServer.SrcFileCache.GetPurgedFilesCount())
+	cs.writeStat("src_cache.purged_bytes", noccServer.SrcFileCache.GetPurgedBytes())
+	cs.writeStat("src_cache.purged_by_ttl", noccServer.SrcFileCache.GetPurgedByTTLCount())
+	cs.writeStat("src_cache.purged_by_ttl_bytes", noccServer.SrcFileCache.GetPurgedByTTLBytes())
+	cs.writeStat("src_cache.purged_on_hard_limit", noccServer.SrcFileCache.GetPurgedOnHardLimitCount())
+	cs.writeStat("src_cache.above_soft_limit_ms", noccServer.SrcFileCache.GetAboveSoftLimitMillis())
+	cs.writeStat("src_cache.fsync_count", noccServer.SrcFileCache.GetFsyncCount())
 	cs.writeStat("src_cache.disk_bytes", noccServer.SrcFileCache.GetBytesOnDisk())
+	cs.writeStat("src_cache.reused_files", atomic.LoadInt64(&cs.srcFilesReused))
+	cs.writeStat("src_cache.reused_bytes", atomic.LoadInt64(&cs.srcBytesReused))
+	cs.writeStat("src_cache.uploaded_files", atomic.LoadInt64(&cs.srcFilesUploaded))
+	cs.writeStat("src_cache.uploaded_bytes", atomic.LoadInt64(&cs.srcBytesUploaded))
+	cs.writeStat("src_cache.compress_in_bytes", noccServer.SrcFileCache.GetCompressBytesIn())
+	cs.writeStat("src_cache.compress_out_bytes", noccServer.SrcFileCache.GetCompressBytesOut())
+	cs.writeStat("src_cache.compress_decompressed_files", noccServer.SrcFileCache.GetDecompressedCount())
+	cs.writeStat("src_cache.compress_decompress_ms", noccServer.SrcFileCache.GetDecompressMillis())
 
 	cs.writeStat("obj_cache.count", noccServer.ObjFileCache.GetFilesCount())
 	cs.writeStat("obj_cache.purged", noccServer.ObjFileCache.GetPurgedFilesCount())
+	cs.writeStat("obj_cache.purged_bytes", noccServer.ObjFileCache.GetPurgedBytes())
+	cs.writeStat("obj_cache.purged_by_ttl", noccServer.ObjFileCache.GetPurgedByTTLCount())
+	cs.writeStat("obj_cache.purged_by_ttl_bytes", noccServer.ObjFileCache.GetPurgedByTTLBytes())
+	cs.writeStat("obj_cache.purged_on_hard_limit", noccServer.ObjFileCache.GetPurgedOnHardLimitCount())
+	cs.writeStat("obj_cache.above_soft_limit_ms", noccServer.ObjFileCache.GetAboveSoftLimitMillis())
+	cs.writeStat("obj_cache.fsync_count", noccServer.ObjFileCache.GetFsyncCount())
 	cs.writeStat("obj_cache.disk_bytes", noccServer.ObjFileCache.GetBytesOnDisk())
 
 	var mem runtime.MemStats
@@ -112,11 +112,14 @@ type StartClientRequest struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	ClientID        string `protobuf:"bytes,1,opt,name=ClientID,proto3" json:"ClientID,omitempty"`
-	HostUserName    string `protobuf:"bytes,2,opt,name=HostUserName,proto3" json:"HostUserName,omitempty"`
-	ClientVersion   string `protobuf:"bytes,3,opt,name=ClientVersion,proto3" json:"ClientVersion,omitempty"`
-	DisableObjCache bool   `protobuf:"varint,10,opt,name=DisableObjCache,proto3" json:"DisableObjCache,omitempty"`
-	AllRemotesDelim string `protobuf:"bytes,20,opt,name=AllRemotesDelim,proto3" json:"AllRemotesDelim,omitempty"`
+	ClientID          string `protobuf:"bytes,1,opt,name=ClientID,proto3" json:"ClientID,omitempty"`
+	HostUserName      string `protobuf:"bytes,2,opt,name=HostUserName,proto3" json:"HostUserName,omitempty"`
+	ClientVersion     string `protobuf:"bytes,3,opt,name=ClientVersion,proto3" json:"ClientVersion,omitempty"`
+	DisableObjCache   bool   `protobuf:"varint,10,opt,name=DisableObjCache,proto3" json:"DisableObjCache,omitempty"`
+	EchoServerCmdLine bool   `protobuf:"varint,11,opt,name=EchoServerCmdLine,proto3" json:"EchoServerCmdLine,omitempty"`
+	CompressTransfers bool   `protobuf:"varint,12,opt,name=CompressTransfers,proto3" json:"CompressTransfers,omitempty"` // a client wants files to be gzipped while sent, see common.ShouldCompressTransfer
+	ObjCacheNamespace string `protobuf:"bytes,13,opt,name=ObjCacheNamespace,proto3" json:"ObjCacheNamespace,omitempty"`  // env NOCC_OBJ_CACHE_NAMESPACE, used only by a server with -obj-cache-per-client
+	AllRemotesDelim   string `protobuf:"bytes,20,opt,name=AllRemotesDelim,proto3" json:"AllRemotesDelim,omitempty"`
 }
 
 func (x *StartClientRequest) Reset() {
@@ -179,6 +182,27 @@ func (x *StartClientRequest) GetDisableObjCache() bool {
 	return false
 }
 
+func (x *StartClientRequest) GetEchoServerCmdLine() bool {
+	if x != nil {
+		return x.EchoServerCmdLine
+	}
+	return false
+}
+
+func (x *StartClientRequest) GetCompressTransfers() bool {
+	if x != nil {
+		return x.CompressTransfers
+	}
+	return false
+}
+
+func (x *StartClientRequest) GetObjCacheNamespace() string {
+	if x != nil {
+		return x.ObjCacheNamespace
+	}
+	return ""
+}
+
 func (x *StartClientRequest) GetAllRemotesDelim() string {
 	if x != nil {
 		return x.AllRemotesDelim
@@ -190,6 +214,8 @@ type StartClientReply struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
+
+	CompressTransfers bool `protobuf:"varint,1,opt,name=CompressTransfers,proto3" json:"CompressTransfers,omitempty"` // a server supports gzipped chunks (old servers don't, then nothing is compressed)
 }
 
 func (x *StartClientReply) Reset() {
@@ -224,19 +250,30 @@ func (*StartClientReply) Descriptor() ([]byte, []int) {
 	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{2}
 }
 
+func (x *StartClientReply) GetCompressTransfers() bool {
+	if x != nil {
+		return x.CompressTransfers
+	}
+	return false
+}
+
 type StartCompilationSessionRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	ClientID      string          `protobuf:"bytes,1,opt,name=ClientID,proto3" json:"ClientID,omitempty"`
-	SessionID     uint32          `protobuf:"varint,2,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
-	Cwd           string          `protobuf:"bytes,3,opt,name=Cwd,proto3" json:"Cwd,omitempty"`
-	CppInFile     string          `protobuf:"bytes,10,opt,name=CppInFile,proto3" json:"CppInFile,omitempty"`
-	CxxName       string          `protobuf:"bytes,11,opt,name=CxxName,proto3" json:"CxxName,omitempty"`
-	CxxArgs       []string        `protobuf:"bytes,12,rep,name=CxxArgs,proto3" json:"CxxArgs,omitempty"`
-	CxxIDirs      []string        `protobuf:"bytes,13,rep,name=CxxIDirs,proto3" json:"CxxIDirs,omitempty"`
-	RequiredFiles []*FileMetadata `protobuf:"bytes,14,rep,name=RequiredFiles,proto3" json:"RequiredFiles,omitempty"`
+	ClientID           string          `protobuf:"bytes,1,opt,name=ClientID,proto3" json:"ClientID,omitempty"`
+	SessionID          uint32          `protobuf:"varint,2,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
+	Cwd                string          `protobuf:"bytes,3,opt,name=Cwd,proto3" json:"Cwd,omitempty"`
+	CppInFile          string          `protobuf:"bytes,10,opt,name=CppInFile,proto3" json:"CppInFile,omitempty"`
+	CxxName            string          `protobuf:"bytes,11,opt,name=CxxName,proto3" json:"CxxName,omitempty"`
+	CxxArgs            []string        `protobuf:"bytes,12,rep,name=CxxArgs,proto3" json:"CxxArgs,omitempty"`
+	CxxIDirs           []string        `protobuf:"bytes,13,rep,name=CxxIDirs,proto3" json:"CxxIDirs,omitempty"`
+	RequiredFiles      []*FileMetadata `protobuf:"bytes,14,rep,name=RequiredFiles,proto3" json:"RequiredFiles,omitempty"`
+	SkipObjCacheLookup bool            `protobuf:"varint,15,opt,name=SkipObjCacheLookup,proto3" json:"SkipObjCacheLookup,omitempty"` // for always-changing sources: obj cache is neither looked up nor filled
+	SyntaxOnly         bool            `protobuf:"varint,16,opt,name=SyntaxOnly,proto3" json:"SyntaxOnly,omitempty"`                 // -fsyntax-only: only diagnostics and an exit code are sent back, there is no obj
+	RecacheObj         bool            `protobuf:"varint,17,opt,name=RecacheObj,proto3" json:"RecacheObj,omitempty"`                 // an obj isn't taken from obj cache, but compiled and saved there, replacing an existing one
+	ObjCacheOnly       bool            `protobuf:"varint,18,opt,name=ObjCacheOnly,proto3" json:"ObjCacheOnly,omitempty"`             // a session is started only if an obj is in obj cache, otherwise ObjCacheMiss is replied
 }
 
 func (x *StartCompilationSessionRequest) Reset() {
@@ -327,12 +364,43 @@ func (x *StartCompilationSessionRequest) GetRequiredFiles() []*FileMetadata {
 	return nil
 }
 
+func (x *StartCompilationSessionRequest) GetSkipObjCacheLookup() bool {
+	if x != nil {
+		return x.SkipObjCacheLookup
+	}
+	return false
+}
+
+func (x *StartCompilationSessionRequest) GetSyntaxOnly() bool {
+	if x != nil {
+		return x.SyntaxOnly
+	}
+	return false
+}
+
+func (x *StartCompilationSessionRequest) GetRecacheObj() bool {
+	if x != nil {
+		return x.RecacheObj
+	}
+	return false
+}
+
+func (x *StartCompilationSessionRequest) GetObjCacheOnly() bool {
+	if x != nil {
+		return x.ObjCacheOnly
+	}
+	return false
+}
+
 type StartCompilationSessionReply struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
 	FileIndexesToUpload []uint32 `protobuf:"varint,1,rep,packed,name=FileIndexesToUpload,proto3" json:"FileIndexesToUpload,omitempty"`
+	ServerCmdLine       string   `protobuf:"bytes,2,opt,name=ServerCmdLine,proto3" json:"ServerCmdLine,omitempty"`        // filled only if a client started with EchoServerCmdLine
+	BusyRetryAfterMs    int32    `protobuf:"varint,3,opt,name=BusyRetryAfterMs,proto3" json:"BusyRetryAfterMs,omitempty"` // advisory: a server's cxx queue is deep, a client may compile elsewhere for this time
+	ObjCacheMiss        bool     `protobuf:"varint,4,opt,name=ObjCacheMiss,proto3" json:"ObjCacheMiss,omitempty"`         // for ObjCacheOnly: an obj is not in obj cache, a session was not started
 }
 
 func (x *StartCompilationSessionReply) Reset() {
@@ -374,6 +442,27 @@ func (x *StartCompilationSessionReply) GetFileIndexesToUpload() []uint32 {
 	return nil
 }
 
+func (x *StartCompilationSessionReply) GetServerCmdLine() string {
+	if x != nil {
+		return x.ServerCmdLine
+	}
+	return ""
+}
+
+func (x *StartCompilationSessionReply) GetBusyRetryAfterMs() int32 {
+	if x != nil {
+		return x.BusyRetryAfterMs
+	}
+	return 0
+}
+
+func (x *StartCompilationSessionReply) GetObjCacheMiss() bool {
+	if x != nil {
+		return x.ObjCacheMiss
+	}
+	return false
+}
+
 type UploadFileChunkRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -383,6 +472,7 @@ type UploadFileChunkRequest struct {
 	SessionID uint32 `protobuf:"varint,2,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
 	FileIndex uint32 `protobuf:"varint,3,opt,name=FileIndex,proto3" json:"FileIndex,omitempty"`
 	ChunkBody []byte `protobuf:"bytes,4,opt,name=ChunkBody,proto3" json:"ChunkBody,omitempty"`
+	Gzipped   bool   `protobuf:"varint,5,opt,name=Gzipped,proto3" json:"Gzipped,omitempty"` // ChunkBody is gzipped (every chunk separately)
 }
 
 func (x *UploadFileChunkRequest) Reset() {
@@ -445,6 +535,13 @@ func (x *UploadFileChunkRequest) GetChunkBody() []byte {
 	return nil
 }
 
+func (x *UploadFileChunkRequest) GetGzipped() bool {
+	if x != nil {
+		return x.Gzipped
+	}
+	return false
+}
+
 type UploadFileReply struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -535,13 +632,16 @@ type RecvCompiledObjChunkReply struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	SessionID   uint32 `protobuf:"varint,1,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
-	CxxExitCode int32  `protobuf:"varint,2,opt,name=CxxExitCode,proto3" json:"CxxExitCode,omitempty"`
-	CxxStdout   []byte `protobuf:"bytes,3,opt,name=CxxStdout,proto3" json:"CxxStdout,omitempty"`
-	CxxStderr   []byte `protobuf:"bytes,4,opt,name=CxxStderr,proto3" json:"CxxStderr,omitempty"`
-	CxxDuration int32  `protobuf:"varint,5,opt,name=CxxDuration,proto3" json:"CxxDuration,omitempty"`
-	FileSize    int64  `protobuf:"varint,6,opt,name=FileSize,proto3" json:"FileSize,omitempty"`
-	ChunkBody   []byte `protobuf:"bytes,7,opt,name=ChunkBody,proto3" json:"ChunkBody,omitempty"`
+	SessionID         uint32 `protobuf:"varint,1,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
+	CxxExitCode       int32  `protobuf:"varint,2,opt,name=CxxExitCode,proto3" json:"CxxExitCode,omitempty"`
+	CxxStdout         []byte `protobuf:"bytes,3,opt,name=CxxStdout,proto3" json:"CxxStdout,omitempty"`
+	CxxStderr         []byte `protobuf:"bytes,4,opt,name=CxxStderr,proto3" json:"CxxStderr,omitempty"`
+	CxxDuration       int32  `protobuf:"varint,5,opt,name=CxxDuration,proto3" json:"CxxDuration,omitempty"`
+	FileSize          int64  `protobuf:"varint,6,opt,name=FileSize,proto3" json:"FileSize,omitempty"`
+	ChunkBody         []byte `protobuf:"bytes,7,opt,name=ChunkBody,proto3" json:"ChunkBody,omitempty"`
+	FromObjCache      bool   `protobuf:"varint,8,opt,name=FromObjCache,proto3" json:"FromObjCache,omitempty"`            // cxx wasn't launched, an obj was taken from obj cache
+	Gzipped           bool   `protobuf:"varint,9,opt,name=Gzipped,proto3" json:"Gzipped,omitempty"`                      // ChunkBody is gzipped (every chunk separately)
+	CxxKilledBySignal int32  `protobuf:"varint,10,opt,name=CxxKilledBySignal,proto3" json:"CxxKilledBySignal,omitempty"` // cxx crashed (e.g. by OOM killer), it's not an error in a source, a client compiles it locally
 }
 
 func (x *RecvCompiledObjChunkReply) Reset() {
@@ -625,6 +725,27 @@ func (x *RecvCompiledObjChunkReply) GetChunkBody() []byte {
 	return nil
 }
 
+func (x *RecvCompiledObjChunkReply) GetFromObjCache() bool {
+	if x != nil {
+		return x.FromObjCache
+	}
+	return false
+}
+
+func (x *RecvCompiledObjChunkReply) GetGzipped() bool {
+	if x != nil {
+		return x.Gzipped
+	}
+	return false
+}
+
+func (x *RecvCompiledObjChunkReply) GetCxxKilledBySignal() int32 {
+	if x != nil {
+		return x.CxxKilledBySignal
+	}
+	return 0
+}
+
 type StopClientRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -710,14 +831,26 @@ func (*StopClientReply) Descriptor() ([]byte, []int) {
 	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{10}
 }
 
-type StatusRequest struct {
+type StoreObjChunkRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
-}
 
-func (x *StatusRequest) Reset() {
-	*x = StatusRequest{}
+	// all fields except ChunkBody are filled only in the first chunk
+	ClientID      string          `protobuf:"bytes,1,opt,name=ClientID,proto3" json:"ClientID,omitempty"`
+	CppInFile     string          `protobuf:"bytes,2,opt,name=CppInFile,proto3" json:"CppInFile,omitempty"`
+	CxxName       string          `protobuf:"bytes,3,opt,name=CxxName,proto3" json:"CxxName,omitempty"`
+	CxxArgs       []string        `protobuf:"bytes,4,rep,name=CxxArgs,proto3" json:"CxxArgs,omitempty"`
+	RequiredFiles []*FileMetadata `protobuf:"bytes,5,rep,name=RequiredFiles,proto3" json:"RequiredFiles,omitempty"`
+	FileSize      int64           `protobuf:"varint,6,opt,name=FileSize,proto3" json:"FileSize,omitempty"`
+	ChunkBody     []byte          `protobuf:"bytes,7,opt,name=ChunkBody,proto3" json:"ChunkBody,omitempty"`
+	CxxStdout     []byte          `protobuf:"bytes,8,opt,name=CxxStdout,proto3" json:"CxxStdout,omitempty"` // warnings of local compilation, replayed on a cache hit (see -cache-objs-with-warnings)
+	CxxStderr     []byte          `protobuf:"bytes,9,opt,name=CxxStderr,proto3" json:"CxxStderr,omitempty"`
+	Cwd           string          `protobuf:"bytes,10,opt,name=Cwd,proto3" json:"Cwd,omitempty"` // diagnostics are replayed only for the same full path of CppInFile
+}
+
+func (x *StoreObjChunkRequest) Reset() {
+	*x = StoreObjChunkRequest{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_pb_nocc_protobuf_proto_msgTypes[11]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -725,13 +858,13 @@ func (x *StatusRequest) Reset() {
 	}
 }
 
-func (x *StatusRequest) String() string {
+func (x *StoreObjChunkRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*StatusRequest) ProtoMessage() {}
+func (*StoreObjChunkRequest) ProtoMessage() {}
 
-func (x *StatusRequest) ProtoReflect() protoreflect.Message {
+func (x *StoreObjChunkRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_pb_nocc_protobuf_proto_msgTypes[11]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -743,201 +876,104 @@ func (x *StatusRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
-func (*StatusRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use StoreObjChunkRequest.ProtoReflect.Descriptor instead.
+func (*StoreObjChunkRequest) Descriptor() ([]byte, []int) {
 	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{11}
 }
 
-type StatusReply struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
-	unknownFields protoimpl.UnknownFields
-
-	ServerVersion   string   `protobuf:"bytes,1,opt,name=ServerVersion,proto3" json:"ServerVersion,omitempty"`
-	ServerArgs      []string `protobuf:"bytes,2,rep,name=ServerArgs,proto3" json:"ServerArgs,omitempty"`
-	ServerUptime    int64    `protobuf:"varint,3,opt,name=ServerUptime,proto3" json:"ServerUptime,omitempty"`
-	GccVersion      string   `protobuf:"bytes,4,opt,name=GccVersion,proto3" json:"GccVersion,omitempty"`
-	ClangVersion    string   `protobuf:"bytes,5,opt,name=ClangVersion,proto3" json:"ClangVersion,omitempty"`
-	LogFileSize     int64    `protobuf:"varint,6,opt,name=LogFileSize,proto3" json:"LogFileSize,omitempty"`
-	SrcCacheSize    int64    `protobuf:"varint,7,opt,name=SrcCacheSize,proto3" json:"SrcCacheSize,omitempty"`
-	ObjCacheSize    int64    `protobuf:"varint,8,opt,name=ObjCacheSize,proto3" json:"ObjCacheSize,omitempty"`
-	ULimit          int64    `protobuf:"varint,9,opt,name=ULimit,proto3" json:"ULimit,omitempty"`
-	UName           string   `protobuf:"bytes,10,opt,name=UName,proto3" json:"UName,omitempty"`
-	SessionsTotal   int64    `protobuf:"varint,11,opt,name=SessionsTotal,proto3" json:"SessionsTotal,omitempty"`
-	SessionsActive  int64    `protobuf:"varint,12,opt,name=SessionsActive,proto3" json:"SessionsActive,omitempty"`
-	CxxCalls        int64    `protobuf:"varint,20,opt,name=CxxCalls,proto3" json:"CxxCalls,omitempty"`
-	CxxDurMore10Sec int64    `protobuf:"varint,21,opt,name=CxxDurMore10sec,proto3" json:"CxxDurMore10sec,omitempty"`
-	CxxDurMore30Sec int64    `protobuf:"varint,22,opt,name=CxxDurMore30sec,proto3" json:"CxxDurMore30sec,omitempty"`
-	UniqueRemotes   []string `protobuf:"bytes,30,rep,name=UniqueRemotes,proto3" json:"UniqueRemotes,omitempty"`
-}
-
-func (x *StatusReply) Reset() {
-	*x = StatusReply{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_pb_nocc_protobuf_proto_msgTypes[12]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
-}
-
-func (x *StatusReply) String() string {
-	return protoimpl.X.MessageStringOf(x)
-}
-
-func (*StatusReply) ProtoMessage() {}
-
-func (x *StatusReply) ProtoReflect() protoreflect.Message {
-	mi := &file_pb_nocc_protobuf_proto_msgTypes[12]
-	if protoimpl.UnsafeEnabled && x != nil {
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		if ms.LoadMessageInfo() == nil {
-			ms.StoreMessageInfo(mi)
-		}
-		return ms
-	}
-	return mi.MessageOf(x)
-}
-
-// Deprecated: Use StatusReply.ProtoReflect.Descriptor instead.
-func (*StatusReply) Descriptor() ([]byte, []int) {
-	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{12}
-}
-
-func (x *StatusReply) GetServerVersion() string {
+func (x *StoreObjChunkRequest) GetClientID() string {
 	if x != nil {
-		return x.ServerVersion
+		return x.ClientID
 	}
 	return ""
 }
 
-func (x *StatusReply) GetServerArgs() []string {
-	if x != nil {
-		return x.ServerArgs
-	}
-	return nil
-}
-
-func (x *StatusReply) GetServerUptime() int64 {
-	if x != nil {
-		return x.ServerUptime
-	}
-	return 0
-}
-
-func (x *StatusReply) GetGccVersion() string {
+func (x *StoreObjChunkRequest) GetCppInFile() string {
 	if x != nil {
-		return x.GccVersion
+		return x.CppInFile
 	}
 	return ""
 }
 
-func (x *StatusReply) GetClangVersion() string {
+func (x *StoreObjChunkRequest) GetCxxName() string {
 	if x != nil {
-		return x.ClangVersion
+		return x.CxxName
 	}
 	return ""
 }
 
-func (x *StatusReply) GetLogFileSize() int64 {
-	if x != nil {
-		return x.LogFileSize
-	}
-	return 0
-}
-
-func (x *StatusReply) GetSrcCacheSize() int64 {
-	if x != nil {
-		return x.SrcCacheSize
-	}
-	return 0
-}
-
-func (x *StatusReply) GetObjCacheSize() int64 {
-	if x != nil {
-		return x.ObjCacheSize
-	}
-	return 0
-}
-
-func (x *StatusReply) GetULimit() int64 {
-	if x != nil {
-		return x.ULimit
-	}
-	return 0
-}
-
-func (x *StatusReply) GetUName() string {
+func (x *StoreObjChunkRequest) GetCxxArgs() []string {
 	if x != nil {
-		return x.UName
+		return x.CxxArgs
 	}
-	return ""
+	return nil
 }
 
-func (x *StatusReply) GetSessionsTotal() int64 {
+func (x *StoreObjChunkRequest) GetRequiredFiles() []*FileMetadata {
 	if x != nil {
-		return x.SessionsTotal
+		return x.RequiredFiles
 	}
-	return 0
+	return nil
 }
 
-func (x *StatusReply) GetSessionsActive() int64 {
+func (x *StoreObjChunkRequest) GetFileSize() int64 {
 	if x != nil {
-		return x.SessionsActive
+		return x.FileSize
 	}
 	return 0
 }
 
-func (x *StatusReply) GetCxxCalls() int64 {
+func (x *StoreObjChunkRequest) GetChunkBody() []byte {
 	if x != nil {
-		return x.CxxCalls
+		return x.ChunkBody
 	}
-	return 0
+	return nil
 }
 
-func (x *StatusReply) GetCxxDurMore10Sec() int64 {
+func (x *StoreObjChunkRequest) GetCxxStdout() []byte {
 	if x != nil {
-		return x.CxxDurMore10Sec
+		return x.CxxStdout
 	}
-	return 0
+	return nil
 }
 
-func (x *StatusReply) GetCxxDurMore30Sec() int64 {
+func (x *StoreObjChunkRequest) GetCxxStderr() []byte {
 	if x != nil {
-		return x.CxxDurMore30Sec
+		return x.CxxStderr
 	}
-	return 0
+	return nil
 }
 
-func (x *StatusReply) GetUniqueRemotes() []string {
+func (x *StoreObjChunkRequest) GetCwd() string {
 	if x != nil {
-		return x.UniqueRemotes
+		return x.Cwd
 	}
-	return nil
+	return ""
 }
 
-type DumpLogsRequest struct {
+type StoreObjReply struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 }
 
-func (x *DumpLogsRequest) Reset() {
-	*x = DumpLogsRequest{}
+func (x *StoreObjReply) Reset() {
+	*x = StoreObjReply{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_pb_nocc_protobuf_proto_msgTypes[13]
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[12]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *DumpLogsRequest) String() string {
+func (x *StoreObjReply) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DumpLogsRequest) ProtoMessage() {}
+func (*StoreObjReply) ProtoMessage() {}
 
-func (x *DumpLogsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_pb_nocc_protobuf_proto_msgTypes[13]
+func (x *StoreObjReply) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[12]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -948,37 +984,43 @@ func (x *DumpLogsRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DumpLogsRequest.ProtoReflect.Descriptor instead.
-func (*DumpLogsRequest) Descriptor() ([]byte, []int) {
-	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{13}
+// Deprecated: Use StoreObjReply.ProtoReflect.Descriptor instead.
+func (*StoreObjReply) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{12}
 }
 
-type DumpLogsReply struct {
+type CollectDepsOnServerRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	LogFileExt string `protobuf:"bytes,1,opt,name=LogFileExt,proto3" json:"LogFileExt,omitempty"`
-	ChunkBody  []byte `protobuf:"bytes,2,opt,name=ChunkBody,proto3" json:"ChunkBody,omitempty"`
+	ClientID   string          `protobuf:"bytes,1,opt,name=ClientID,proto3" json:"ClientID,omitempty"`
+	Cwd        string          `protobuf:"bytes,3,opt,name=Cwd,proto3" json:"Cwd,omitempty"`
+	CppInFile  string          `protobuf:"bytes,10,opt,name=CppInFile,proto3" json:"CppInFile,omitempty"`
+	CxxName    string          `protobuf:"bytes,11,opt,name=CxxName,proto3" json:"CxxName,omitempty"`
+	CxxArgs    []string        `protobuf:"bytes,12,rep,name=CxxArgs,proto3" json:"CxxArgs,omitempty"`
+	CxxIDirs   []string        `protobuf:"bytes,13,rep,name=CxxIDirs,proto3" json:"CxxIDirs,omitempty"`
+	KnownFiles []*FileMetadata `protobuf:"bytes,14,rep,name=KnownFiles,proto3" json:"KnownFiles,omitempty"` // a cpp file and all dependencies found by previous rounds
+	FileBodies []*FileBody     `protobuf:"bytes,15,rep,name=FileBodies,proto3" json:"FileBodies,omitempty"` // contents of KnownFiles requested by a previous round
 }
 
-func (x *DumpLogsReply) Reset() {
-	*x = DumpLogsReply{}
+func (x *CollectDepsOnServerRequest) Reset() {
+	*x = CollectDepsOnServerRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_pb_nocc_protobuf_proto_msgTypes[14]
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[13]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *DumpLogsReply) String() string {
+func (x *CollectDepsOnServerRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DumpLogsReply) ProtoMessage() {}
+func (*CollectDepsOnServerRequest) ProtoMessage() {}
 
-func (x *DumpLogsReply) ProtoReflect() protoreflect.Message {
-	mi := &file_pb_nocc_protobuf_proto_msgTypes[14]
+func (x *CollectDepsOnServerRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[13]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -989,35 +1031,618 @@ func (x *DumpLogsReply) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DumpLogsReply.ProtoReflect.Descriptor instead.
-func (*DumpLogsReply) Descriptor() ([]byte, []int) {
-	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{14}
+// Deprecated: Use CollectDepsOnServerRequest.ProtoReflect.Descriptor instead.
+func (*CollectDepsOnServerRequest) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{13}
 }
 
-func (x *DumpLogsReply) GetLogFileExt() string {
+func (x *CollectDepsOnServerRequest) GetClientID() string {
 	if x != nil {
-		return x.LogFileExt
+		return x.ClientID
 	}
 	return ""
 }
 
-func (x *DumpLogsReply) GetChunkBody() []byte {
+func (x *CollectDepsOnServerRequest) GetCwd() string {
 	if x != nil {
-		return x.ChunkBody
+		return x.Cwd
 	}
-	return nil
+	return ""
 }
 
-type DropAllCachesRequest struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
-	unknownFields protoimpl.UnknownFields
+func (x *CollectDepsOnServerRequest) GetCppInFile() string {
+	if x != nil {
+		return x.CppInFile
+	}
+	return ""
+}
+
+func (x *CollectDepsOnServerRequest) GetCxxName() string {
+	if x != nil {
+		return x.CxxName
+	}
+	return ""
+}
+
+func (x *CollectDepsOnServerRequest) GetCxxArgs() []string {
+	if x != nil {
+		return x.CxxArgs
+	}
+	return nil
+}
+
+func (x *CollectDepsOnServerRequest) GetCxxIDirs() []string {
+	if x != nil {
+		return x.CxxIDirs
+	}
+	return nil
+}
+
+func (x *CollectDepsOnServerRequest) GetKnownFiles() []*FileMetadata {
+	if x != nil {
+		return x.KnownFiles
+	}
+	return nil
+}
+
+func (x *CollectDepsOnServerRequest) GetFileBodies() []*FileBody {
+	if x != nil {
+		return x.FileBodies
+	}
+	return nil
+}
+
+type FileBody struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	FileIndex uint32 `protobuf:"varint,1,opt,name=FileIndex,proto3" json:"FileIndex,omitempty"`
+	Body      []byte `protobuf:"bytes,2,opt,name=Body,proto3" json:"Body,omitempty"`
+}
+
+func (x *FileBody) Reset() {
+	*x = FileBody{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FileBody) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileBody) ProtoMessage() {}
+
+func (x *FileBody) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileBody.ProtoReflect.Descriptor instead.
+func (*FileBody) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *FileBody) GetFileIndex() uint32 {
+	if x != nil {
+		return x.FileIndex
+	}
+	return 0
+}
+
+func (x *FileBody) GetBody() []byte {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+type CollectDepsOnServerReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	FileIndexesToUpload []uint32 `protobuf:"varint,1,rep,packed,name=FileIndexesToUpload,proto3" json:"FileIndexesToUpload,omitempty"` // KnownFiles missing on a server, cxx -M is launched after they are sent
+	Deps                []string `protobuf:"bytes,2,rep,name=Deps,proto3" json:"Deps,omitempty"`                                       // client file names found by cxx -M, in order of appearance, without a cpp file
+	UnresolvedIncludes  []string `protobuf:"bytes,3,rep,name=UnresolvedIncludes,proto3" json:"UnresolvedIncludes,omitempty"`           // #include names not found by cxx -MG, a client should resolve them
+}
+
+func (x *CollectDepsOnServerReply) Reset() {
+	*x = CollectDepsOnServerReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CollectDepsOnServerReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CollectDepsOnServerReply) ProtoMessage() {}
+
+func (x *CollectDepsOnServerReply) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CollectDepsOnServerReply.ProtoReflect.Descriptor instead.
+func (*CollectDepsOnServerReply) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *CollectDepsOnServerReply) GetFileIndexesToUpload() []uint32 {
+	if x != nil {
+		return x.FileIndexesToUpload
+	}
+	return nil
+}
+
+func (x *CollectDepsOnServerReply) GetDeps() []string {
+	if x != nil {
+		return x.Deps
+	}
+	return nil
+}
+
+func (x *CollectDepsOnServerReply) GetUnresolvedIncludes() []string {
+	if x != nil {
+		return x.UnresolvedIncludes
+	}
+	return nil
+}
+
+type StatusRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *StatusRequest) Reset() {
+	*x = StatusRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[16]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusRequest) ProtoMessage() {}
+
+func (x *StatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[16]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{16}
+}
+
+type StatusReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ServerVersion         string   `protobuf:"bytes,1,opt,name=ServerVersion,proto3" json:"ServerVersion,omitempty"`
+	ServerArgs            []string `protobuf:"bytes,2,rep,name=ServerArgs,proto3" json:"ServerArgs,omitempty"`
+	ServerUptime          int64    `protobuf:"varint,3,opt,name=ServerUptime,proto3" json:"ServerUptime,omitempty"`
+	GccVersion            string   `protobuf:"bytes,4,opt,name=GccVersion,proto3" json:"GccVersion,omitempty"`
+	ClangVersion          string   `protobuf:"bytes,5,opt,name=ClangVersion,proto3" json:"ClangVersion,omitempty"`
+	LogFileSize           int64    `protobuf:"varint,6,opt,name=LogFileSize,proto3" json:"LogFileSize,omitempty"`
+	SrcCacheSize          int64    `protobuf:"varint,7,opt,name=SrcCacheSize,proto3" json:"SrcCacheSize,omitempty"`
+	ObjCacheSize          int64    `protobuf:"varint,8,opt,name=ObjCacheSize,proto3" json:"ObjCacheSize,omitempty"`
+	ULimit                int64    `protobuf:"varint,9,opt,name=ULimit,proto3" json:"ULimit,omitempty"`
+	UName                 string   `protobuf:"bytes,10,opt,name=UName,proto3" json:"UName,omitempty"`
+	SessionsTotal         int64    `protobuf:"varint,11,opt,name=SessionsTotal,proto3" json:"SessionsTotal,omitempty"`
+	SessionsActive        int64    `protobuf:"varint,12,opt,name=SessionsActive,proto3" json:"SessionsActive,omitempty"`
+	LongestActiveSessions []string `protobuf:"bytes,13,rep,name=LongestActiveSessions,proto3" json:"LongestActiveSessions,omitempty"`
+	SrcFilesReused        int64    `protobuf:"varint,14,opt,name=SrcFilesReused,proto3" json:"SrcFilesReused,omitempty"`
+	SrcBytesReused        int64    `protobuf:"varint,15,opt,name=SrcBytesReused,proto3" json:"SrcBytesReused,omitempty"`
+	SrcFilesUploaded      int64    `protobuf:"varint,16,opt,name=SrcFilesUploaded,proto3" json:"SrcFilesUploaded,omitempty"`
+	SrcBytesUploaded      int64    `protobuf:"varint,17,opt,name=SrcBytesUploaded,proto3" json:"SrcBytesUploaded,omitempty"`
+	CxxCalls              int64    `protobuf:"varint,20,opt,name=CxxCalls,proto3" json:"CxxCalls,omitempty"`
+	CxxDurMore10Sec       int64    `protobuf:"varint,21,opt,name=CxxDurMore10sec,proto3" json:"CxxDurMore10sec,omitempty"`
+	CxxDurMore30Sec       int64    `protobuf:"varint,22,opt,name=CxxDurMore30sec,proto3" json:"CxxDurMore30sec,omitempty"`
+	UniqueRemotes         []string `protobuf:"bytes,30,rep,name=UniqueRemotes,proto3" json:"UniqueRemotes,omitempty"`
+}
+
+func (x *StatusReply) Reset() {
+	*x = StatusReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[17]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StatusReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusReply) ProtoMessage() {}
+
+func (x *StatusReply) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[17]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusReply.ProtoReflect.Descriptor instead.
+func (*StatusReply) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *StatusReply) GetServerVersion() string {
+	if x != nil {
+		return x.ServerVersion
+	}
+	return ""
+}
+
+func (x *StatusReply) GetServerArgs() []string {
+	if x != nil {
+		return x.ServerArgs
+	}
+	return nil
+}
+
+func (x *StatusReply) GetServerUptime() int64 {
+	if x != nil {
+		return x.ServerUptime
+	}
+	return 0
+}
+
+func (x *StatusReply) GetGccVersion() string {
+	if x != nil {
+		return x.GccVersion
+	}
+	return ""
+}
+
+func (x *StatusReply) GetClangVersion() string {
+	if x != nil {
+		return x.ClangVersion
+	}
+	return ""
+}
+
+func (x *StatusReply) GetLogFileSize() int64 {
+	if x != nil {
+		return x.LogFileSize
+	}
+	return 0
+}
+
+func (x *StatusReply) GetSrcCacheSize() int64 {
+	if x != nil {
+		return x.SrcCacheSize
+	}
+	return 0
+}
+
+func (x *StatusReply) GetObjCacheSize() int64 {
+	if x != nil {
+		return x.ObjCacheSize
+	}
+	return 0
+}
+
+func (x *StatusReply) GetULimit() int64 {
+	if x != nil {
+		return x.ULimit
+	}
+	return 0
+}
+
+func (x *StatusReply) GetUName() string {
+	if x != nil {
+		return x.UName
+	}
+	return ""
+}
+
+func (x *StatusReply) GetSessionsTotal() int64 {
+	if x != nil {
+		return x.SessionsTotal
+	}
+	return 0
+}
+
+func (x *StatusReply) GetSessionsActive() int64 {
+	if x != nil {
+		return x.SessionsActive
+	}
+	return 0
+}
+
+func (x *StatusReply) GetLongestActiveSessions() []string {
+	if x != nil {
+		return x.LongestActiveSessions
+	}
+	return nil
+}
+
+func (x *StatusReply) GetSrcFilesReused() int64 {
+	if x != nil {
+		return x.SrcFilesReused
+	}
+	return 0
+}
+
+func (x *StatusReply) GetSrcBytesReused() int64 {
+	if x != nil {
+		return x.SrcBytesReused
+	}
+	return 0
+}
+
+func (x *StatusReply) GetSrcFilesUploaded() int64 {
+	if x != nil {
+		return x.SrcFilesUploaded
+	}
+	return 0
+}
+
+func (x *StatusReply) GetSrcBytesUploaded() int64 {
+	if x != nil {
+		return x.SrcBytesUploaded
+	}
+	return 0
+}
+
+func (x *StatusReply) GetCxxCalls() int64 {
+	if x != nil {
+		return x.CxxCalls
+	}
+	return 0
+}
+
+func (x *StatusReply) GetCxxDurMore10Sec() int64 {
+	if x != nil {
+		return x.CxxDurMore10Sec
+	}
+	return 0
+}
+
+func (x *StatusReply) GetCxxDurMore30Sec() int64 {
+	if x != nil {
+		return x.CxxDurMore30Sec
+	}
+	return 0
+}
+
+func (x *StatusReply) GetUniqueRemotes() []string {
+	if x != nil {
+		return x.UniqueRemotes
+	}
+	return nil
+}
+
+type DumpLogsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *DumpLogsRequest) Reset() {
+	*x = DumpLogsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[18]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DumpLogsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DumpLogsRequest) ProtoMessage() {}
+
+func (x *DumpLogsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[18]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DumpLogsRequest.ProtoReflect.Descriptor instead.
+func (*DumpLogsRequest) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{18}
+}
+
+type DumpLogsReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	LogFileExt string `protobuf:"bytes,1,opt,name=LogFileExt,proto3" json:"LogFileExt,omitempty"`
+	ChunkBody  []byte `protobuf:"bytes,2,opt,name=ChunkBody,proto3" json:"ChunkBody,omitempty"`
+}
+
+func (x *DumpLogsReply) Reset() {
+	*x = DumpLogsReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[19]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DumpLogsReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DumpLogsReply) ProtoMessage() {}
+
+func (x *DumpLogsReply) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[19]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DumpLogsReply.ProtoReflect.Descriptor instead.
+func (*DumpLogsReply) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *DumpLogsReply) GetLogFileExt() string {
+	if x != nil {
+		return x.LogFileExt
+	}
+	return ""
+}
+
+func (x *DumpLogsReply) GetChunkBody() []byte {
+	if x != nil {
+		return x.ChunkBody
+	}
+	return nil
+}
+
+type FollowLogsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *FollowLogsRequest) Reset() {
+	*x = FollowLogsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[20]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FollowLogsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FollowLogsRequest) ProtoMessage() {}
+
+func (x *FollowLogsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[20]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FollowLogsRequest.ProtoReflect.Descriptor instead.
+func (*FollowLogsRequest) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{20}
+}
+
+type FollowLogsReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Lines []byte `protobuf:"bytes,1,opt,name=Lines,proto3" json:"Lines,omitempty"` // one or more complete lines, each ending with \n
+}
+
+func (x *FollowLogsReply) Reset() {
+	*x = FollowLogsReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[21]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FollowLogsReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FollowLogsReply) ProtoMessage() {}
+
+func (x *FollowLogsReply) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[21]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FollowLogsReply.ProtoReflect.Descriptor instead.
+func (*FollowLogsReply) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *FollowLogsReply) GetLines() []byte {
+	if x != nil {
+		return x.Lines
+	}
+	return nil
+}
+
+type DropAllCachesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 }
 
 func (x *DropAllCachesRequest) Reset() {
 	*x = DropAllCachesRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_pb_nocc_protobuf_proto_msgTypes[15]
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[22]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1030,7 +1655,7 @@ func (x *DropAllCachesRequest) String() string {
 func (*DropAllCachesRequest) ProtoMessage() {}
 
 func (x *DropAllCachesRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_pb_nocc_protobuf_proto_msgTypes[15]
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[22]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1043,7 +1668,7 @@ func (x *DropAllCachesRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DropAllCachesRequest.ProtoReflect.Descriptor instead.
 func (*DropAllCachesRequest) Descriptor() ([]byte, []int) {
-	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{15}
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{22}
 }
 
 type DropAllCachesReply struct {
@@ -1058,7 +1683,7 @@ type DropAllCachesReply struct {
 func (x *DropAllCachesReply) Reset() {
 	*x = DropAllCachesReply{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_pb_nocc_protobuf_proto_msgTypes[16]
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[23]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1071,7 +1696,7 @@ func (x *DropAllCachesReply) String() string {
 func (*DropAllCachesReply) ProtoMessage() {}
 
 func (x *DropAllCachesReply) ProtoReflect() protoreflect.Message {
-	mi := &file_pb_nocc_protobuf_proto_msgTypes[16]
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[23]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1084,7 +1709,7 @@ func (x *DropAllCachesReply) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DropAllCachesReply.ProtoReflect.Descriptor instead.
 func (*DropAllCachesReply) Descriptor() ([]byte, []int) {
-	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{16}
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{23}
 }
 
 func (x *DropAllCachesReply) GetDroppedSrcFiles() int64 {
@@ -1101,6 +1726,124 @@ func (x *DropAllCachesReply) GetDroppedObjFiles() int64 {
 	return 0
 }
 
+type PurgeStaleCachesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	OlderThanSeconds int64 `protobuf:"varint,1,opt,name=OlderThanSeconds,proto3" json:"OlderThanSeconds,omitempty"`
+}
+
+func (x *PurgeStaleCachesRequest) Reset() {
+	*x = PurgeStaleCachesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[24]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PurgeStaleCachesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PurgeStaleCachesRequest) ProtoMessage() {}
+
+func (x *PurgeStaleCachesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[24]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PurgeStaleCachesRequest.ProtoReflect.Descriptor instead.
+func (*PurgeStaleCachesRequest) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *PurgeStaleCachesRequest) GetOlderThanSeconds() int64 {
+	if x != nil {
+		return x.OlderThanSeconds
+	}
+	return 0
+}
+
+type PurgeStaleCachesReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	PurgedSrcFiles int64 `protobuf:"varint,1,opt,name=PurgedSrcFiles,proto3" json:"PurgedSrcFiles,omitempty"`
+	PurgedSrcBytes int64 `protobuf:"varint,2,opt,name=PurgedSrcBytes,proto3" json:"PurgedSrcBytes,omitempty"`
+	PurgedObjFiles int64 `protobuf:"varint,3,opt,name=PurgedObjFiles,proto3" json:"PurgedObjFiles,omitempty"`
+	PurgedObjBytes int64 `protobuf:"varint,4,opt,name=PurgedObjBytes,proto3" json:"PurgedObjBytes,omitempty"`
+}
+
+func (x *PurgeStaleCachesReply) Reset() {
+	*x = PurgeStaleCachesReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_nocc_protobuf_proto_msgTypes[25]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PurgeStaleCachesReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PurgeStaleCachesReply) ProtoMessage() {}
+
+func (x *PurgeStaleCachesReply) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_nocc_protobuf_proto_msgTypes[25]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PurgeStaleCachesReply.ProtoReflect.Descriptor instead.
+func (*PurgeStaleCachesReply) Descriptor() ([]byte, []int) {
+	return file_pb_nocc_protobuf_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *PurgeStaleCachesReply) GetPurgedSrcFiles() int64 {
+	if x != nil {
+		return x.PurgedSrcFiles
+	}
+	return 0
+}
+
+func (x *PurgeStaleCachesReply) GetPurgedSrcBytes() int64 {
+	if x != nil {
+		return x.PurgedSrcBytes
+	}
+	return 0
+}
+
+func (x *PurgeStaleCachesReply) GetPurgedObjFiles() int64 {
+	if x != nil {
+		return x.PurgedObjFiles
+	}
+	return 0
+}
+
+func (x *PurgeStaleCachesReply) GetPurgedObjBytes() int64 {
+	if x != nil {
+		return x.PurgedObjBytes
+	}
+	return 0
+}
+
 var File_pb_nocc_protobuf_proto protoreflect.FileDescriptor
 
 var file_pb_nocc_protobuf_proto_rawDesc = []byte{
@@ -1119,7 +1862,7 @@ var file_pb_nocc_protobuf_proto_rawDesc = []byte{
 	0x42, 0x31, 0x36, 0x5f, 0x32, 0x33, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x06, 0x52, 0x0b, 0x53, 0x48,
 	0x41, 0x32, 0x35, 0x36, 0x42, 0x31, 0x36, 0x32, 0x33, 0x12, 0x22, 0x0a, 0x0d, 0x53, 0x48, 0x41,
 	0x32, 0x35, 0x36, 0x5f, 0x42, 0x32, 0x34, 0x5f, 0x33, 0x31, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x06,
-	0x52, 0x0b, 0x53, 0x48, 0x41, 0x32, 0x35, 0x36, 0x42, 0x32, 0x34, 0x33, 0x31, 0x22, 0xce, 0x01,
+	0x52, 0x0b, 0x53, 0x48, 0x41, 0x32, 0x35, 0x36, 0x42, 0x32, 0x34, 0x33, 0x31, 0x22, 0xd8, 0x02,
 	0x0a, 0x12, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71,
 	0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44,
 	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44,
@@ -1130,16 +1873,128 @@ var file_pb_nocc_protobuf_proto_rawDesc = []byte{
 	0x65, 0x6e, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x28, 0x0a, 0x0f, 0x44, 0x69,
 	0x73, 0x61, 0x62, 0x6c, 0x65, 0x4f, 0x62, 0x6a, 0x43, 0x61, 0x63, 0x68, 0x65, 0x18, 0x0a, 0x20,
 	0x01, 0x28, 0x08, 0x52, 0x0f, 0x44, 0x69, 0x73, 0x61, 0x62, 0x6c, 0x65, 0x4f, 0x62, 0x6a, 0x43,
-	0x61, 0x63, 0x68, 0x65, 0x12, 0x28, 0x0a, 0x0f, 0x41, 0x6c, 0x6c, 0x52, 0x65, 0x6d, 0x6f, 0x74,
-	0x65, 0x73, 0x44, 0x65, 0x6c, 0x69, 0x6d, 0x18, 0x14, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x41,
-	0x6c, 0x6c, 0x52, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x73, 0x44, 0x65, 0x6c, 0x69, 0x6d, 0x22, 0x12,
-	0x0a, 0x10, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x70,
-	0x6c, 0x79, 0x22, 0x94, 0x02, 0x0a, 0x1e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x6f, 0x6d, 0x70,
-	0x69, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65,
-	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49,
-	0x44, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49,
-	0x44, 0x12, 0x1c, 0x0a, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x18, 0x02,
-	0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x12,
+	0x61, 0x63, 0x68, 0x65, 0x12, 0x2c, 0x0a, 0x11, 0x45, 0x63, 0x68, 0x6f, 0x53, 0x65, 0x72, 0x76,
+	0x65, 0x72, 0x43, 0x6d, 0x64, 0x4c, 0x69, 0x6e, 0x65, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x11, 0x45, 0x63, 0x68, 0x6f, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x43, 0x6d, 0x64, 0x4c, 0x69,
+	0x6e, 0x65, 0x12, 0x2c, 0x0a, 0x11, 0x43, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x54, 0x72,
+	0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x73, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x08, 0x52, 0x11, 0x43,
+	0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x73,
+	0x12, 0x2c, 0x0a, 0x11, 0x4f, 0x62, 0x6a, 0x43, 0x61, 0x63, 0x68, 0x65, 0x4e, 0x61, 0x6d, 0x65,
+	0x73, 0x70, 0x61, 0x63, 0x65, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x11, 0x4f, 0x62, 0x6a,
+	0x43, 0x61, 0x63, 0x68, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x12, 0x28,
+	0x0a, 0x0f, 0x41, 0x6c, 0x6c, 0x52, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x73, 0x44, 0x65, 0x6c, 0x69,
+	0x6d, 0x18, 0x14, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x41, 0x6c, 0x6c, 0x52, 0x65, 0x6d, 0x6f,
+	0x74, 0x65, 0x73, 0x44, 0x65, 0x6c, 0x69, 0x6d, 0x22, 0x40, 0x0a, 0x10, 0x53, 0x74, 0x61, 0x72,
+	0x74, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x2c, 0x0a, 0x11,
+	0x43, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72,
+	0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x11, 0x43, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73,
+	0x73, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x73, 0x22, 0xa8, 0x03, 0x0a, 0x1e, 0x53,
+	0x74, 0x61, 0x72, 0x74, 0x43, 0x6f, 0x6d, 0x70, 0x69, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53,
+	0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a,
+	0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x12, 0x1c, 0x0a, 0x09, 0x53, 0x65, 0x73,
+	0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x53, 0x65,
+	0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x12, 0x10, 0x0a, 0x03, 0x43, 0x77, 0x64, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x43, 0x77, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x43, 0x70, 0x70,
+	0x49, 0x6e, 0x46, 0x69, 0x6c, 0x65, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x43, 0x70,
+	0x70, 0x49, 0x6e, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x43, 0x78, 0x78, 0x4e, 0x61,
+	0x6d, 0x65, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x43, 0x78, 0x78, 0x4e, 0x61, 0x6d,
+	0x65, 0x12, 0x18, 0x0a, 0x07, 0x43, 0x78, 0x78, 0x41, 0x72, 0x67, 0x73, 0x18, 0x0c, 0x20, 0x03,
+	0x28, 0x09, 0x52, 0x07, 0x43, 0x78, 0x78, 0x41, 0x72, 0x67, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x43,
+	0x78, 0x78, 0x49, 0x44, 0x69, 0x72, 0x73, 0x18, 0x0d, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x43,
+	0x78, 0x78, 0x49, 0x44, 0x69, 0x72, 0x73, 0x12, 0x38, 0x0a, 0x0d, 0x52, 0x65, 0x71, 0x75, 0x69,
+	0x72, 0x65, 0x64, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x18, 0x0e, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12,
+	0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61,
+	0x74, 0x61, 0x52, 0x0d, 0x52, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x46, 0x69, 0x6c, 0x65,
+	0x73, 0x12, 0x2e, 0x0a, 0x12, 0x53, 0x6b, 0x69, 0x70, 0x4f, 0x62, 0x6a, 0x43, 0x61, 0x63, 0x68,
+	0x65, 0x4c, 0x6f, 0x6f, 0x6b, 0x75, 0x70, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x08, 0x52, 0x12, 0x53,
+	0x6b, 0x69, 0x70, 0x4f, 0x62, 0x6a, 0x43, 0x61, 0x63, 0x68, 0x65, 0x4c, 0x6f, 0x6f, 0x6b, 0x75,
+	0x70, 0x12, 0x1e, 0x0a, 0x0a, 0x53, 0x79, 0x6e, 0x74, 0x61, 0x78, 0x4f, 0x6e, 0x6c, 0x79, 0x18,
+	0x10, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x53, 0x79, 0x6e, 0x74, 0x61, 0x78, 0x4f, 0x6e, 0x6c,
+	0x79, 0x12, 0x1e, 0x0a, 0x0a, 0x52, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x4f, 0x62, 0x6a, 0x18,
+	0x11, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x52, 0x65, 0x63, 0x61, 0x63, 0x68, 0x65, 0x4f, 0x62,
+	0x6a, 0x12, 0x22, 0x0a, 0x0c, 0x4f, 0x62, 0x6a, 0x43, 0x61, 0x63, 0x68, 0x65, 0x4f, 0x6e, 0x6c,
+	0x79, 0x18, 0x12, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x4f, 0x62, 0x6a, 0x43, 0x61, 0x63, 0x68,
+	0x65, 0x4f, 0x6e, 0x6c, 0x79, 0x22, 0xc6, 0x01, 0x0a, 0x1c, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43,
+	0x6f, 0x6d, 0x70, 0x69, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f,
+	0x6e, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x30, 0x0a, 0x13, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e,
+	0x64, 0x65, 0x78, 0x65, 0x73, 0x54, 0x6f, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x01, 0x20,
+	0x03, 0x28, 0x0d, 0x52, 0x13, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x65, 0x73,
+	0x54, 0x6f, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x24, 0x0a, 0x0d, 0x53, 0x65, 0x72, 0x76,
+	0x65, 0x72, 0x43, 0x6d, 0x64, 0x4c, 0x69, 0x6e, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0d, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x43, 0x6d, 0x64, 0x4c, 0x69, 0x6e, 0x65, 0x12, 0x2a,
+	0x0a, 0x10, 0x42, 0x75, 0x73, 0x79, 0x52, 0x65, 0x74, 0x72, 0x79, 0x41, 0x66, 0x74, 0x65, 0x72,
+	0x4d, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x10, 0x42, 0x75, 0x73, 0x79, 0x52, 0x65,
+	0x74, 0x72, 0x79, 0x41, 0x66, 0x74, 0x65, 0x72, 0x4d, 0x73, 0x12, 0x22, 0x0a, 0x0c, 0x4f, 0x62,
+	0x6a, 0x43, 0x61, 0x63, 0x68, 0x65, 0x4d, 0x69, 0x73, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x0c, 0x4f, 0x62, 0x6a, 0x43, 0x61, 0x63, 0x68, 0x65, 0x4d, 0x69, 0x73, 0x73, 0x22, 0xa8,
+	0x01, 0x0a, 0x16, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x46, 0x69, 0x6c, 0x65, 0x43, 0x68, 0x75,
+	0x6e, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69,
+	0x65, 0x6e, 0x74, 0x49, 0x44, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43, 0x6c, 0x69,
+	0x65, 0x6e, 0x74, 0x49, 0x44, 0x12, 0x1c, 0x0a, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
+	0x49, 0x44, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f,
+	0x6e, 0x49, 0x44, 0x12, 0x1c, 0x0a, 0x09, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x64, 0x65, 0x78,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x64, 0x65,
+	0x78, 0x12, 0x1c, 0x0a, 0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x12,
+	0x18, 0x0a, 0x07, 0x47, 0x7a, 0x69, 0x70, 0x70, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x07, 0x47, 0x7a, 0x69, 0x70, 0x70, 0x65, 0x64, 0x22, 0x11, 0x0a, 0x0f, 0x55, 0x70, 0x6c,
+	0x6f, 0x61, 0x64, 0x46, 0x69, 0x6c, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x36, 0x0a, 0x18,
+	0x4f, 0x70, 0x65, 0x6e, 0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x53, 0x74, 0x72, 0x65, 0x61,
+	0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65,
+	0x6e, 0x74, 0x49, 0x44, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65,
+	0x6e, 0x74, 0x49, 0x44, 0x22, 0xdf, 0x02, 0x0a, 0x19, 0x52, 0x65, 0x63, 0x76, 0x43, 0x6f, 0x6d,
+	0x70, 0x69, 0x6c, 0x65, 0x64, 0x4f, 0x62, 0x6a, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x52, 0x65, 0x70,
+	0x6c, 0x79, 0x12, 0x1c, 0x0a, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44,
+	0x12, 0x20, 0x0a, 0x0b, 0x43, 0x78, 0x78, 0x45, 0x78, 0x69, 0x74, 0x43, 0x6f, 0x64, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b, 0x43, 0x78, 0x78, 0x45, 0x78, 0x69, 0x74, 0x43, 0x6f,
+	0x64, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64, 0x6f, 0x75, 0x74, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64, 0x6f, 0x75, 0x74,
+	0x12, 0x1c, 0x0a, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64, 0x65, 0x72, 0x72, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64, 0x65, 0x72, 0x72, 0x12, 0x20,
+	0x0a, 0x0b, 0x43, 0x78, 0x78, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x0b, 0x43, 0x78, 0x78, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x1a, 0x0a, 0x08, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x08, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x1c, 0x0a, 0x09,
+	0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x12, 0x22, 0x0a, 0x0c, 0x46, 0x72,
+	0x6f, 0x6d, 0x4f, 0x62, 0x6a, 0x43, 0x61, 0x63, 0x68, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x0c, 0x46, 0x72, 0x6f, 0x6d, 0x4f, 0x62, 0x6a, 0x43, 0x61, 0x63, 0x68, 0x65, 0x12, 0x18,
+	0x0a, 0x07, 0x47, 0x7a, 0x69, 0x70, 0x70, 0x65, 0x64, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x07, 0x47, 0x7a, 0x69, 0x70, 0x70, 0x65, 0x64, 0x12, 0x2c, 0x0a, 0x11, 0x43, 0x78, 0x78, 0x4b,
+	0x69, 0x6c, 0x6c, 0x65, 0x64, 0x42, 0x79, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x6c, 0x18, 0x0a, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x11, 0x43, 0x78, 0x78, 0x4b, 0x69, 0x6c, 0x6c, 0x65, 0x64, 0x42, 0x79,
+	0x53, 0x69, 0x67, 0x6e, 0x61, 0x6c, 0x22, 0x2f, 0x0a, 0x11, 0x53, 0x74, 0x6f, 0x70, 0x43, 0x6c,
+	0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43,
+	0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43,
+	0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x22, 0x11, 0x0a, 0x0f, 0x53, 0x74, 0x6f, 0x70, 0x43,
+	0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0xc6, 0x02, 0x0a, 0x14, 0x53,
+	0x74, 0x6f, 0x72, 0x65, 0x4f, 0x62, 0x6a, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x12,
+	0x1c, 0x0a, 0x09, 0x43, 0x70, 0x70, 0x49, 0x6e, 0x46, 0x69, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x09, 0x43, 0x70, 0x70, 0x49, 0x6e, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x18, 0x0a,
+	0x07, 0x43, 0x78, 0x78, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
+	0x43, 0x78, 0x78, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x43, 0x78, 0x78, 0x41, 0x72,
+	0x67, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x43, 0x78, 0x78, 0x41, 0x72, 0x67,
+	0x73, 0x12, 0x38, 0x0a, 0x0d, 0x52, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x46, 0x69, 0x6c,
+	0x65, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e,
+	0x46, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x52, 0x0d, 0x52, 0x65,
+	0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x46,
+	0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x46,
+	0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b,
+	0x42, 0x6f, 0x64, 0x79, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x43, 0x68, 0x75, 0x6e,
+	0x6b, 0x42, 0x6f, 0x64, 0x79, 0x12, 0x1c, 0x0a, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64, 0x6f,
+	0x75, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64,
+	0x6f, 0x75, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64, 0x65, 0x72, 0x72,
+	0x18, 0x09, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64, 0x65, 0x72,
+	0x72, 0x12, 0x10, 0x0a, 0x03, 0x43, 0x77, 0x64, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03,
+	0x43, 0x77, 0x64, 0x22, 0x0f, 0x0a, 0x0d, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4f, 0x62, 0x6a, 0x52,
+	0x65, 0x70, 0x6c, 0x79, 0x22, 0x9c, 0x02, 0x0a, 0x1a, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74,
+	0x44, 0x65, 0x70, 0x73, 0x4f, 0x6e, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x12,
 	0x10, 0x0a, 0x03, 0x43, 0x77, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x43, 0x77,
 	0x64, 0x12, 0x1c, 0x0a, 0x09, 0x43, 0x70, 0x70, 0x49, 0x6e, 0x46, 0x69, 0x6c, 0x65, 0x18, 0x0a,
 	0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x43, 0x70, 0x70, 0x49, 0x6e, 0x46, 0x69, 0x6c, 0x65, 0x12,
@@ -1148,100 +2003,111 @@ var file_pb_nocc_protobuf_proto_rawDesc = []byte{
 	0x41, 0x72, 0x67, 0x73, 0x18, 0x0c, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x43, 0x78, 0x78, 0x41,
 	0x72, 0x67, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x78, 0x78, 0x49, 0x44, 0x69, 0x72, 0x73, 0x18,
 	0x0d, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x43, 0x78, 0x78, 0x49, 0x44, 0x69, 0x72, 0x73, 0x12,
-	0x38, 0x0a, 0x0d, 0x52, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x46, 0x69, 0x6c, 0x65, 0x73,
-	0x18, 0x0e, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x46, 0x69,
-	0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x52, 0x0d, 0x52, 0x65, 0x71, 0x75,
-	0x69, 0x72, 0x65, 0x64, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x22, 0x50, 0x0a, 0x1c, 0x53, 0x74, 0x61,
-	0x72, 0x74, 0x43, 0x6f, 0x6d, 0x70, 0x69, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x65, 0x73,
-	0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x30, 0x0a, 0x13, 0x46, 0x69, 0x6c,
+	0x32, 0x0a, 0x0a, 0x4b, 0x6e, 0x6f, 0x77, 0x6e, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x18, 0x0e, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x4d,
+	0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x52, 0x0a, 0x4b, 0x6e, 0x6f, 0x77, 0x6e, 0x46, 0x69,
+	0x6c, 0x65, 0x73, 0x12, 0x2e, 0x0a, 0x0a, 0x46, 0x69, 0x6c, 0x65, 0x42, 0x6f, 0x64, 0x69, 0x65,
+	0x73, 0x18, 0x0f, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x46,
+	0x69, 0x6c, 0x65, 0x42, 0x6f, 0x64, 0x79, 0x52, 0x0a, 0x46, 0x69, 0x6c, 0x65, 0x42, 0x6f, 0x64,
+	0x69, 0x65, 0x73, 0x22, 0x3c, 0x0a, 0x08, 0x46, 0x69, 0x6c, 0x65, 0x42, 0x6f, 0x64, 0x79, 0x12,
+	0x1c, 0x0a, 0x09, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x09, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x12, 0x0a,
+	0x04, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x42, 0x6f, 0x64,
+	0x79, 0x22, 0x90, 0x01, 0x0a, 0x18, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x44, 0x65, 0x70,
+	0x73, 0x4f, 0x6e, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x30,
+	0x0a, 0x13, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x65, 0x73, 0x54, 0x6f, 0x55,
+	0x70, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0d, 0x52, 0x13, 0x46, 0x69, 0x6c,
 	0x65, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x65, 0x73, 0x54, 0x6f, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64,
-	0x18, 0x01, 0x20, 0x03, 0x28, 0x0d, 0x52, 0x13, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x64, 0x65,
-	0x78, 0x65, 0x73, 0x54, 0x6f, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x22, 0x8e, 0x01, 0x0a, 0x16,
-	0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x46, 0x69, 0x6c, 0x65, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x52,
-	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74,
-	0x49, 0x44, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74,
-	0x49, 0x44, 0x12, 0x1c, 0x0a, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x18,
-	0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44,
-	0x12, 0x1c, 0x0a, 0x09, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x03, 0x20,
-	0x01, 0x28, 0x0d, 0x52, 0x09, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x1c,
-	0x0a, 0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28,
-	0x0c, 0x52, 0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x22, 0x11, 0x0a, 0x0f,
-	0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x46, 0x69, 0x6c, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22,
-	0x36, 0x0a, 0x18, 0x4f, 0x70, 0x65, 0x6e, 0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x53, 0x74,
-	0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43,
-	0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43,
-	0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x22, 0xf3, 0x01, 0x0a, 0x19, 0x52, 0x65, 0x63, 0x76,
-	0x43, 0x6f, 0x6d, 0x70, 0x69, 0x6c, 0x65, 0x64, 0x4f, 0x62, 0x6a, 0x43, 0x68, 0x75, 0x6e, 0x6b,
-	0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x1c, 0x0a, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
-	0x49, 0x44, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f,
-	0x6e, 0x49, 0x44, 0x12, 0x20, 0x0a, 0x0b, 0x43, 0x78, 0x78, 0x45, 0x78, 0x69, 0x74, 0x43, 0x6f,
-	0x64, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b, 0x43, 0x78, 0x78, 0x45, 0x78, 0x69,
-	0x74, 0x43, 0x6f, 0x64, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64, 0x6f,
-	0x75, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64,
-	0x6f, 0x75, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64, 0x65, 0x72, 0x72,
-	0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x43, 0x78, 0x78, 0x53, 0x74, 0x64, 0x65, 0x72,
-	0x72, 0x12, 0x20, 0x0a, 0x0b, 0x43, 0x78, 0x78, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
-	0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b, 0x43, 0x78, 0x78, 0x44, 0x75, 0x72, 0x61, 0x74,
-	0x69, 0x6f, 0x6e, 0x12, 0x1a, 0x0a, 0x08, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x18,
-	0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12,
-	0x1c, 0x0a, 0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x07, 0x20, 0x01,
-	0x28, 0x0c, 0x52, 0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x22, 0x2f, 0x0a,
-	0x11, 0x53, 0x74, 0x6f, 0x70, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65,
-	0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x22, 0x11,
-	0x0a, 0x0f, 0x53, 0x74, 0x6f, 0x70, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x70, 0x6c,
-	0x79, 0x22, 0x0f, 0x0a, 0x0d, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
-	0x73, 0x74, 0x22, 0xb7, 0x04, 0x0a, 0x0b, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x70,
-	0x6c, 0x79, 0x12, 0x24, 0x0a, 0x0d, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x56, 0x65, 0x72, 0x73,
-	0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x53, 0x65, 0x72, 0x76, 0x65,
-	0x72, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x1e, 0x0a, 0x0a, 0x53, 0x65, 0x72, 0x76,
-	0x65, 0x72, 0x41, 0x72, 0x67, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x53, 0x65,
-	0x72, 0x76, 0x65, 0x72, 0x41, 0x72, 0x67, 0x73, 0x12, 0x22, 0x0a, 0x0c, 0x53, 0x65, 0x72, 0x76,
-	0x65, 0x72, 0x55, 0x70, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c,
-	0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x55, 0x70, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x1e, 0x0a, 0x0a,
-	0x47, 0x63, 0x63, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x0a, 0x47, 0x63, 0x63, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x22, 0x0a, 0x0c,
-	0x43, 0x6c, 0x61, 0x6e, 0x67, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x0c, 0x43, 0x6c, 0x61, 0x6e, 0x67, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
-	0x12, 0x20, 0x0a, 0x0b, 0x4c, 0x6f, 0x67, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x18,
-	0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x4c, 0x6f, 0x67, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x69,
-	0x7a, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x53, 0x72, 0x63, 0x43, 0x61, 0x63, 0x68, 0x65, 0x53, 0x69,
-	0x7a, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x53, 0x72, 0x63, 0x43, 0x61, 0x63,
-	0x68, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x4f, 0x62, 0x6a, 0x43, 0x61, 0x63,
-	0x68, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x4f, 0x62,
-	0x6a, 0x43, 0x61, 0x63, 0x68, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x55, 0x4c,
-	0x69, 0x6d, 0x69, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x55, 0x4c, 0x69, 0x6d,
-	0x69, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x55, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x0a, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x05, 0x55, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x24, 0x0a, 0x0d, 0x53, 0x65, 0x73, 0x73,
-	0x69, 0x6f, 0x6e, 0x73, 0x54, 0x6f, 0x74, 0x61, 0x6c, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x03, 0x52,
-	0x0d, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x54, 0x6f, 0x74, 0x61, 0x6c, 0x12, 0x26,
-	0x0a, 0x0e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x41, 0x63, 0x74, 0x69, 0x76, 0x65,
-	0x18, 0x0c, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73,
-	0x41, 0x63, 0x74, 0x69, 0x76, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x78, 0x78, 0x43, 0x61, 0x6c,
-	0x6c, 0x73, 0x18, 0x14, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x43, 0x78, 0x78, 0x43, 0x61, 0x6c,
-	0x6c, 0x73, 0x12, 0x28, 0x0a, 0x0f, 0x43, 0x78, 0x78, 0x44, 0x75, 0x72, 0x4d, 0x6f, 0x72, 0x65,
-	0x31, 0x30, 0x73, 0x65, 0x63, 0x18, 0x15, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x43, 0x78, 0x78,
-	0x44, 0x75, 0x72, 0x4d, 0x6f, 0x72, 0x65, 0x31, 0x30, 0x73, 0x65, 0x63, 0x12, 0x28, 0x0a, 0x0f,
-	0x43, 0x78, 0x78, 0x44, 0x75, 0x72, 0x4d, 0x6f, 0x72, 0x65, 0x33, 0x30, 0x73, 0x65, 0x63, 0x18,
-	0x16, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x43, 0x78, 0x78, 0x44, 0x75, 0x72, 0x4d, 0x6f, 0x72,
-	0x65, 0x33, 0x30, 0x73, 0x65, 0x63, 0x12, 0x24, 0x0a, 0x0d, 0x55, 0x6e, 0x69, 0x71, 0x75, 0x65,
-	0x52, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x73, 0x18, 0x1e, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0d, 0x55,
-	0x6e, 0x69, 0x71, 0x75, 0x65, 0x52, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x73, 0x22, 0x11, 0x0a, 0x0f,
-	0x44, 0x75, 0x6d, 0x70, 0x4c, 0x6f, 0x67, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22,
-	0x4d, 0x0a, 0x0d, 0x44, 0x75, 0x6d, 0x70, 0x4c, 0x6f, 0x67, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79,
-	0x12, 0x1e, 0x0a, 0x0a, 0x4c, 0x6f, 0x67, 0x46, 0x69, 0x6c, 0x65, 0x45, 0x78, 0x74, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x4c, 0x6f, 0x67, 0x46, 0x69, 0x6c, 0x65, 0x45, 0x78, 0x74,
-	0x12, 0x1c, 0x0a, 0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x02, 0x20,
-	0x01, 0x28, 0x0c, 0x52, 0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x22, 0x16,
-	0x0a, 0x14, 0x44, 0x72, 0x6f, 0x70, 0x41, 0x6c, 0x6c, 0x43, 0x61, 0x63, 0x68, 0x65, 0x73, 0x52,
-	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x68, 0x0a, 0x12, 0x44, 0x72, 0x6f, 0x70, 0x41, 0x6c,
-	0x6c, 0x43, 0x61, 0x63, 0x68, 0x65, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x28, 0x0a, 0x0f,
-	0x64, 0x72, 0x6f, 0x70, 0x70, 0x65, 0x64, 0x53, 0x72, 0x63, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x64, 0x72, 0x6f, 0x70, 0x70, 0x65, 0x64, 0x53, 0x72,
-	0x63, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x28, 0x0a, 0x0f, 0x64, 0x72, 0x6f, 0x70, 0x70, 0x65,
-	0x64, 0x4f, 0x62, 0x6a, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52,
-	0x0f, 0x64, 0x72, 0x6f, 0x70, 0x70, 0x65, 0x64, 0x4f, 0x62, 0x6a, 0x46, 0x69, 0x6c, 0x65, 0x73,
-	0x32, 0xe4, 0x04, 0x0a, 0x12, 0x43, 0x6f, 0x6d, 0x70, 0x69, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x12, 0x0a, 0x04, 0x44, 0x65, 0x70, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04,
+	0x44, 0x65, 0x70, 0x73, 0x12, 0x2e, 0x0a, 0x12, 0x55, 0x6e, 0x72, 0x65, 0x73, 0x6f, 0x6c, 0x76,
+	0x65, 0x64, 0x49, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x09,
+	0x52, 0x12, 0x55, 0x6e, 0x72, 0x65, 0x73, 0x6f, 0x6c, 0x76, 0x65, 0x64, 0x49, 0x6e, 0x63, 0x6c,
+	0x75, 0x64, 0x65, 0x73, 0x22, 0x0f, 0x0a, 0x0d, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x95, 0x06, 0x0a, 0x0b, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73,
+	0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x24, 0x0a, 0x0d, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x56,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x53, 0x65,
+	0x72, 0x76, 0x65, 0x72, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x1e, 0x0a, 0x0a, 0x53,
+	0x65, 0x72, 0x76, 0x65, 0x72, 0x41, 0x72, 0x67, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x0a, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x41, 0x72, 0x67, 0x73, 0x12, 0x22, 0x0a, 0x0c, 0x53,
+	0x65, 0x72, 0x76, 0x65, 0x72, 0x55, 0x70, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x0c, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x55, 0x70, 0x74, 0x69, 0x6d, 0x65, 0x12,
+	0x1e, 0x0a, 0x0a, 0x47, 0x63, 0x63, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0a, 0x47, 0x63, 0x63, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12,
+	0x22, 0x0a, 0x0c, 0x43, 0x6c, 0x61, 0x6e, 0x67, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x43, 0x6c, 0x61, 0x6e, 0x67, 0x56, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x12, 0x20, 0x0a, 0x0b, 0x4c, 0x6f, 0x67, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x69,
+	0x7a, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x4c, 0x6f, 0x67, 0x46, 0x69, 0x6c,
+	0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x53, 0x72, 0x63, 0x43, 0x61, 0x63, 0x68,
+	0x65, 0x53, 0x69, 0x7a, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x53, 0x72, 0x63,
+	0x43, 0x61, 0x63, 0x68, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x4f, 0x62, 0x6a,
+	0x43, 0x61, 0x63, 0x68, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0c, 0x4f, 0x62, 0x6a, 0x43, 0x61, 0x63, 0x68, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x16, 0x0a,
+	0x06, 0x55, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x55,
+	0x4c, 0x69, 0x6d, 0x69, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x55, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x0a,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x55, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x24, 0x0a, 0x0d, 0x53,
+	0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x54, 0x6f, 0x74, 0x61, 0x6c, 0x18, 0x0b, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x0d, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x54, 0x6f, 0x74, 0x61,
+	0x6c, 0x12, 0x26, 0x0a, 0x0e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x41, 0x63, 0x74,
+	0x69, 0x76, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x53, 0x65, 0x73, 0x73, 0x69,
+	0x6f, 0x6e, 0x73, 0x41, 0x63, 0x74, 0x69, 0x76, 0x65, 0x12, 0x34, 0x0a, 0x15, 0x4c, 0x6f, 0x6e,
+	0x67, 0x65, 0x73, 0x74, 0x41, 0x63, 0x74, 0x69, 0x76, 0x65, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f,
+	0x6e, 0x73, 0x18, 0x0d, 0x20, 0x03, 0x28, 0x09, 0x52, 0x15, 0x4c, 0x6f, 0x6e, 0x67, 0x65, 0x73,
+	0x74, 0x41, 0x63, 0x74, 0x69, 0x76, 0x65, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x12,
+	0x26, 0x0a, 0x0e, 0x53, 0x72, 0x63, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x52, 0x65, 0x75, 0x73, 0x65,
+	0x64, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x53, 0x72, 0x63, 0x46, 0x69, 0x6c, 0x65,
+	0x73, 0x52, 0x65, 0x75, 0x73, 0x65, 0x64, 0x12, 0x26, 0x0a, 0x0e, 0x53, 0x72, 0x63, 0x42, 0x79,
+	0x74, 0x65, 0x73, 0x52, 0x65, 0x75, 0x73, 0x65, 0x64, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0e, 0x53, 0x72, 0x63, 0x42, 0x79, 0x74, 0x65, 0x73, 0x52, 0x65, 0x75, 0x73, 0x65, 0x64, 0x12,
+	0x2a, 0x0a, 0x10, 0x53, 0x72, 0x63, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x55, 0x70, 0x6c, 0x6f, 0x61,
+	0x64, 0x65, 0x64, 0x18, 0x10, 0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x53, 0x72, 0x63, 0x46, 0x69,
+	0x6c, 0x65, 0x73, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x65, 0x64, 0x12, 0x2a, 0x0a, 0x10, 0x53,
+	0x72, 0x63, 0x42, 0x79, 0x74, 0x65, 0x73, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x65, 0x64, 0x18,
+	0x11, 0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x53, 0x72, 0x63, 0x42, 0x79, 0x74, 0x65, 0x73, 0x55,
+	0x70, 0x6c, 0x6f, 0x61, 0x64, 0x65, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x78, 0x78, 0x43, 0x61,
+	0x6c, 0x6c, 0x73, 0x18, 0x14, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x43, 0x78, 0x78, 0x43, 0x61,
+	0x6c, 0x6c, 0x73, 0x12, 0x28, 0x0a, 0x0f, 0x43, 0x78, 0x78, 0x44, 0x75, 0x72, 0x4d, 0x6f, 0x72,
+	0x65, 0x31, 0x30, 0x73, 0x65, 0x63, 0x18, 0x15, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x43, 0x78,
+	0x78, 0x44, 0x75, 0x72, 0x4d, 0x6f, 0x72, 0x65, 0x31, 0x30, 0x73, 0x65, 0x63, 0x12, 0x28, 0x0a,
+	0x0f, 0x43, 0x78, 0x78, 0x44, 0x75, 0x72, 0x4d, 0x6f, 0x72, 0x65, 0x33, 0x30, 0x73, 0x65, 0x63,
+	0x18, 0x16, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x43, 0x78, 0x78, 0x44, 0x75, 0x72, 0x4d, 0x6f,
+	0x72, 0x65, 0x33, 0x30, 0x73, 0x65, 0x63, 0x12, 0x24, 0x0a, 0x0d, 0x55, 0x6e, 0x69, 0x71, 0x75,
+	0x65, 0x52, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x73, 0x18, 0x1e, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0d,
+	0x55, 0x6e, 0x69, 0x71, 0x75, 0x65, 0x52, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x73, 0x22, 0x11, 0x0a,
+	0x0f, 0x44, 0x75, 0x6d, 0x70, 0x4c, 0x6f, 0x67, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x22, 0x4d, 0x0a, 0x0d, 0x44, 0x75, 0x6d, 0x70, 0x4c, 0x6f, 0x67, 0x73, 0x52, 0x65, 0x70, 0x6c,
+	0x79, 0x12, 0x1e, 0x0a, 0x0a, 0x4c, 0x6f, 0x67, 0x46, 0x69, 0x6c, 0x65, 0x45, 0x78, 0x74, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x4c, 0x6f, 0x67, 0x46, 0x69, 0x6c, 0x65, 0x45, 0x78,
+	0x74, 0x12, 0x1c, 0x0a, 0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x6f, 0x64, 0x79, 0x22,
+	0x13, 0x0a, 0x11, 0x46, 0x6f, 0x6c, 0x6c, 0x6f, 0x77, 0x4c, 0x6f, 0x67, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x22, 0x27, 0x0a, 0x0f, 0x46, 0x6f, 0x6c, 0x6c, 0x6f, 0x77, 0x4c, 0x6f,
+	0x67, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x4c, 0x69, 0x6e, 0x65, 0x73,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x4c, 0x69, 0x6e, 0x65, 0x73, 0x22, 0x16, 0x0a,
+	0x14, 0x44, 0x72, 0x6f, 0x70, 0x41, 0x6c, 0x6c, 0x43, 0x61, 0x63, 0x68, 0x65, 0x73, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x68, 0x0a, 0x12, 0x44, 0x72, 0x6f, 0x70, 0x41, 0x6c, 0x6c,
+	0x43, 0x61, 0x63, 0x68, 0x65, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x28, 0x0a, 0x0f, 0x64,
+	0x72, 0x6f, 0x70, 0x70, 0x65, 0x64, 0x53, 0x72, 0x63, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x64, 0x72, 0x6f, 0x70, 0x70, 0x65, 0x64, 0x53, 0x72, 0x63,
+	0x46, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x28, 0x0a, 0x0f, 0x64, 0x72, 0x6f, 0x70, 0x70, 0x65, 0x64,
+	0x4f, 0x62, 0x6a, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f,
+	0x64, 0x72, 0x6f, 0x70, 0x70, 0x65, 0x64, 0x4f, 0x62, 0x6a, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x22,
+	0x45, 0x0a, 0x17, 0x50, 0x75, 0x72, 0x67, 0x65, 0x53, 0x74, 0x61, 0x6c, 0x65, 0x43, 0x61, 0x63,
+	0x68, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x2a, 0x0a, 0x10, 0x4f, 0x6c,
+	0x64, 0x65, 0x72, 0x54, 0x68, 0x61, 0x6e, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x4f, 0x6c, 0x64, 0x65, 0x72, 0x54, 0x68, 0x61, 0x6e, 0x53,
+	0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x22, 0xb7, 0x01, 0x0a, 0x15, 0x50, 0x75, 0x72, 0x67, 0x65,
+	0x53, 0x74, 0x61, 0x6c, 0x65, 0x43, 0x61, 0x63, 0x68, 0x65, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79,
+	0x12, 0x26, 0x0a, 0x0e, 0x50, 0x75, 0x72, 0x67, 0x65, 0x64, 0x53, 0x72, 0x63, 0x46, 0x69, 0x6c,
+	0x65, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x50, 0x75, 0x72, 0x67, 0x65, 0x64,
+	0x53, 0x72, 0x63, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x26, 0x0a, 0x0e, 0x50, 0x75, 0x72, 0x67,
+	0x65, 0x64, 0x53, 0x72, 0x63, 0x42, 0x79, 0x74, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0e, 0x50, 0x75, 0x72, 0x67, 0x65, 0x64, 0x53, 0x72, 0x63, 0x42, 0x79, 0x74, 0x65, 0x73,
+	0x12, 0x26, 0x0a, 0x0e, 0x50, 0x75, 0x72, 0x67, 0x65, 0x64, 0x4f, 0x62, 0x6a, 0x46, 0x69, 0x6c,
+	0x65, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x50, 0x75, 0x72, 0x67, 0x65, 0x64,
+	0x4f, 0x62, 0x6a, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x26, 0x0a, 0x0e, 0x50, 0x75, 0x72, 0x67,
+	0x65, 0x64, 0x4f, 0x62, 0x6a, 0x42, 0x79, 0x74, 0x65, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0e, 0x50, 0x75, 0x72, 0x67, 0x65, 0x64, 0x4f, 0x62, 0x6a, 0x42, 0x79, 0x74, 0x65, 0x73,
+	0x32, 0x9b, 0x07, 0x0a, 0x12, 0x43, 0x6f, 0x6d, 0x70, 0x69, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e,
 	0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x41, 0x0a, 0x0b, 0x53, 0x74, 0x61, 0x72, 0x74,
 	0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x12, 0x18, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x53, 0x74,
 	0x61, 0x72, 0x74, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
@@ -1267,21 +2133,41 @@ var file_pb_nocc_protobuf_proto_rawDesc = []byte{
 	0x0a, 0x0a, 0x53, 0x74, 0x6f, 0x70, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x12, 0x17, 0x2e, 0x6e,
 	0x6f, 0x63, 0x63, 0x2e, 0x53, 0x74, 0x6f, 0x70, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65,
 	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x53, 0x74, 0x6f,
-	0x70, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x32,
-	0x0a, 0x06, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x13, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e,
-	0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x11, 0x2e,
-	0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79,
-	0x22, 0x00, 0x12, 0x3a, 0x0a, 0x08, 0x44, 0x75, 0x6d, 0x70, 0x4c, 0x6f, 0x67, 0x73, 0x12, 0x15,
-	0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x44, 0x75, 0x6d, 0x70, 0x4c, 0x6f, 0x67, 0x73, 0x52, 0x65,
-	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x13, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x44, 0x75, 0x6d,
-	0x70, 0x4c, 0x6f, 0x67, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x30, 0x01, 0x12, 0x47,
-	0x0a, 0x0d, 0x44, 0x72, 0x6f, 0x70, 0x41, 0x6c, 0x6c, 0x43, 0x61, 0x63, 0x68, 0x65, 0x73, 0x12,
-	0x1a, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x44, 0x72, 0x6f, 0x70, 0x41, 0x6c, 0x6c, 0x43, 0x61,
-	0x63, 0x68, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x18, 0x2e, 0x6e, 0x6f,
-	0x63, 0x63, 0x2e, 0x44, 0x72, 0x6f, 0x70, 0x41, 0x6c, 0x6c, 0x43, 0x61, 0x63, 0x68, 0x65, 0x73,
-	0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x42, 0x1a, 0x5a, 0x18, 0x67, 0x69, 0x74, 0x68, 0x75,
-	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x56, 0x4b, 0x43, 0x4f, 0x4d, 0x2f, 0x6e, 0x6f, 0x63, 0x63,
-	0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x70, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x46,
+	0x0a, 0x0f, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4f, 0x62, 0x6a, 0x54, 0x6f, 0x43, 0x61, 0x63, 0x68,
+	0x65, 0x12, 0x1a, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4f, 0x62,
+	0x6a, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x13, 0x2e,
+	0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4f, 0x62, 0x6a, 0x52, 0x65, 0x70,
+	0x6c, 0x79, 0x22, 0x00, 0x28, 0x01, 0x12, 0x59, 0x0a, 0x13, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63,
+	0x74, 0x44, 0x65, 0x70, 0x73, 0x4f, 0x6e, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x12, 0x20, 0x2e,
+	0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x44, 0x65, 0x70, 0x73,
+	0x4f, 0x6e, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x1e, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x44, 0x65,
+	0x70, 0x73, 0x4f, 0x6e, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22,
+	0x00, 0x12, 0x32, 0x0a, 0x06, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x13, 0x2e, 0x6e, 0x6f,
+	0x63, 0x63, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x11, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65,
+	0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x3a, 0x0a, 0x08, 0x44, 0x75, 0x6d, 0x70, 0x4c, 0x6f, 0x67,
+	0x73, 0x12, 0x15, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x44, 0x75, 0x6d, 0x70, 0x4c, 0x6f, 0x67,
+	0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x13, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e,
+	0x44, 0x75, 0x6d, 0x70, 0x4c, 0x6f, 0x67, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x30,
+	0x01, 0x12, 0x40, 0x0a, 0x0a, 0x46, 0x6f, 0x6c, 0x6c, 0x6f, 0x77, 0x4c, 0x6f, 0x67, 0x73, 0x12,
+	0x17, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x46, 0x6f, 0x6c, 0x6c, 0x6f, 0x77, 0x4c, 0x6f, 0x67,
+	0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e,
+	0x46, 0x6f, 0x6c, 0x6c, 0x6f, 0x77, 0x4c, 0x6f, 0x67, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22,
+	0x00, 0x30, 0x01, 0x12, 0x47, 0x0a, 0x0d, 0x44, 0x72, 0x6f, 0x70, 0x41, 0x6c, 0x6c, 0x43, 0x61,
+	0x63, 0x68, 0x65, 0x73, 0x12, 0x1a, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x44, 0x72, 0x6f, 0x70,
+	0x41, 0x6c, 0x6c, 0x43, 0x61, 0x63, 0x68, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x18, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x44, 0x72, 0x6f, 0x70, 0x41, 0x6c, 0x6c, 0x43,
+	0x61, 0x63, 0x68, 0x65, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x50, 0x0a, 0x10,
+	0x50, 0x75, 0x72, 0x67, 0x65, 0x53, 0x74, 0x61, 0x6c, 0x65, 0x43, 0x61, 0x63, 0x68, 0x65, 0x73,
+	0x12, 0x1d, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x50, 0x75, 0x72, 0x67, 0x65, 0x53, 0x74, 0x61,
+	0x6c, 0x65, 0x43, 0x61, 0x63, 0x68, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x1b, 0x2e, 0x6e, 0x6f, 0x63, 0x63, 0x2e, 0x50, 0x75, 0x72, 0x67, 0x65, 0x53, 0x74, 0x61, 0x6c,
+	0x65, 0x43, 0x61, 0x63, 0x68, 0x65, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x42, 0x1a,
+	0x5a, 0x18, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x56, 0x4b, 0x43,
+	0x4f, 0x4d, 0x2f, 0x6e, 0x6f, 0x63, 0x63, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
 }
 
 var (
@@ -1296,7 +2182,7 @@ func file_pb_nocc_protobuf_proto_rawDescGZIP() []byte {
 	return file_pb_nocc_protobuf_proto_rawDescData
 }
 
-var file_pb_nocc_protobuf_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
+var file_pb_nocc_protobuf_proto_msgTypes = make([]protoimpl.MessageInfo, 26)
 var file_pb_nocc_protobuf_proto_goTypes = []interface{}{
 	(*FileMetadata)(nil),                   // 0: nocc.FileMetadata
 	(*StartClientRequest)(nil),             // 1: nocc.StartClientRequest
@@ -1309,36 +2195,56 @@ var file_pb_nocc_protobuf_proto_goTypes = []interface{}{
 	(*RecvCompiledObjChunkReply)(nil),      // 8: nocc.RecvCompiledObjChunkReply
 	(*StopClientRequest)(nil),              // 9: nocc.StopClientRequest
 	(*StopClientReply)(nil),                // 10: nocc.StopClientReply
-	(*StatusRequest)(nil),                  // 11: nocc.StatusRequest
-	(*StatusReply)(nil),                    // 12: nocc.StatusReply
-	(*DumpLogsRequest)(nil),                // 13: nocc.DumpLogsRequest
-	(*DumpLogsReply)(nil),                  // 14: nocc.DumpLogsReply
-	(*DropAllCachesRequest)(nil),           // 15: nocc.DropAllCachesRequest
-	(*DropAllCachesReply)(nil),             // 16: nocc.DropAllCachesReply
+	(*StoreObjChunkRequest)(nil),           // 11: nocc.StoreObjChunkRequest
+	(*StoreObjReply)(nil),                  // 12: nocc.StoreObjReply
+	(*CollectDepsOnServerRequest)(nil),     // 13: nocc.CollectDepsOnServerRequest
+	(*FileBody)(nil),                       // 14: nocc.FileBody
+	(*CollectDepsOnServerReply)(nil),       // 15: nocc.CollectDepsOnServerReply
+	(*StatusRequest)(nil),                  // 16: nocc.StatusRequest
+	(*StatusReply)(nil),                    // 17: nocc.StatusReply
+	(*DumpLogsRequest)(nil),                // 18: nocc.DumpLogsRequest
+	(*DumpLogsReply)(nil),                  // 19: nocc.DumpLogsReply
+	(*FollowLogsRequest)(nil),              // 20: nocc.FollowLogsRequest
+	(*FollowLogsReply)(nil),                // 21: nocc.FollowLogsReply
+	(*DropAllCachesRequest)(nil),           // 22: nocc.DropAllCachesRequest
+	(*DropAllCachesReply)(nil),             // 23: nocc.DropAllCachesReply
+	(*PurgeStaleCachesRequest)(nil),        // 24: nocc.PurgeStaleCachesRequest
+	(*PurgeStaleCachesReply)(nil),          // 25: nocc.PurgeStaleCachesReply
 }
 var file_pb_nocc_protobuf_proto_depIdxs = []int32{
 	0,  // 0: nocc.StartCompilationSessionRequest.RequiredFiles:type_name -> nocc.FileMetadata
-	1,  // 1: nocc.CompilationService.StartClient:input_type -> nocc.StartClientRequest
-	3,  // 2: nocc.CompilationService.StartCompilationSession:input_type -> nocc.StartCompilationSessionRequest
-	5,  // 3: nocc.CompilationService.UploadFileStream:input_type -> nocc.UploadFileChunkRequest
-	7,  // 4: nocc.CompilationService.RecvCompiledObjStream:input_type -> nocc.OpenReceiveStreamRequest
-	9,  // 5: nocc.CompilationService.StopClient:input_type -> nocc.StopClientRequest
-	11, // 6: nocc.CompilationService.Status:input_type -> nocc.StatusRequest
-	13, // 7: nocc.CompilationService.DumpLogs:input_type -> nocc.DumpLogsRequest
-	15, // 8: nocc.CompilationService.DropAllCaches:input_type -> nocc.DropAllCachesRequest
-	2,  // 9: nocc.CompilationService.StartClient:output_type -> nocc.StartClientReply
-	4,  // 10: nocc.CompilationService.StartCompilationSession:output_type -> nocc.StartCompilationSessionReply
-	6,  // 11: nocc.CompilationService.UploadFileStream:output_type -> nocc.UploadFileReply
-	8,  // 12: nocc.CompilationService.RecvCompiledObjStream:output_type -> nocc.RecvCompiledObjChunkReply
-	10, // 13: nocc.CompilationService.StopClient:output_type -> nocc.StopClientReply
-	12, // 14: nocc.CompilationService.Status:output_type -> nocc.StatusReply
-	14, // 15: nocc.CompilationService.DumpLogs:output_type -> nocc.DumpLogsReply
-	16, // 16: nocc.CompilationService.DropAllCaches:output_type -> nocc.DropAllCachesReply
-	9,  // [9:17] is the sub-list for method output_type
-	1,  // [1:9] is the sub-list for method input_type
-	1,  // [1:1] is the sub-list for extension type_name
-	1,  // [1:1] is the sub-list for extension extendee
-	0,  // [0:1] is the sub-list for field type_name
+	0,  // 1: nocc.StoreObjChunkRequest.RequiredFiles:type_name -> nocc.FileMetadata
+	0,  // 2: nocc.CollectDepsOnServerRequest.KnownFiles:type_name -> nocc.FileMetadata
+	14, // 3: nocc.CollectDepsOnServerRequest.FileBodies:type_name -> nocc.FileBody
+	1,  // 4: nocc.CompilationService.StartClient:input_type -> nocc.StartClientRequest
+	3,  // 5: nocc.CompilationService.StartCompilationSession:input_type -> nocc.StartCompilationSessionRequest
+	5,  // 6: nocc.CompilationService.UploadFileStream:input_type -> nocc.UploadFileChunkRequest
+	7,  // 7: nocc.CompilationService.RecvCompiledObjStream:input_type -> nocc.OpenReceiveStreamRequest
+	9,  // 8: nocc.CompilationService.StopClient:input_type -> nocc.StopClientRequest
+	11, // 9: nocc.CompilationService.StoreObjToCache:input_type -> nocc.StoreObjChunkRequest
+	13, // 10: nocc.CompilationService.CollectDepsOnServer:input_type -> nocc.CollectDepsOnServerRequest
+	16, // 11: nocc.CompilationService.Status:input_type -> nocc.StatusRequest
+	18, // 12: nocc.CompilationService.DumpLogs:input_type -> nocc.DumpLogsRequest
+	20, // 13: nocc.CompilationService.FollowLogs:input_type -> nocc.FollowLogsRequest
+	22, // 14: nocc.CompilationService.DropAllCaches:input_type -> nocc.DropAllCachesRequest
+	24, // 15: nocc.CompilationService.PurgeStaleCaches:input_type -> nocc.PurgeStaleCachesRequest
+	2,  // 16: nocc.CompilationService.StartClient:output_type -> nocc.StartClientReply
+	4,  // 17: nocc.CompilationService.StartCompilationSession:output_type -> nocc.StartCompilationSessionReply
+	6,  // 18: nocc.CompilationService.UploadFileStream:output_type -> nocc.UploadFileReply
+	8,  // 19: nocc.CompilationService.RecvCompiledObjStream:output_type -> nocc.RecvCompiledObjChunkReply
+	10, // 20: nocc.CompilationService.StopClient:output_type -> nocc.StopClientReply
+	12, // 21: nocc.CompilationService.StoreObjToCache:output_type -> nocc.StoreObjReply
+	15, // 22: nocc.CompilationService.CollectDepsOnServer:output_type -> nocc.CollectDepsOnServerReply
+	17, // 23: nocc.CompilationService.Status:output_type -> nocc.StatusReply
+	19, // 24: nocc.CompilationService.DumpLogs:output_type -> nocc.DumpLogsReply
+	21, // 25: nocc.CompilationService.FollowLogs:output_type -> nocc.FollowLogsReply
+	23, // 26: nocc.CompilationService.DropAllCaches:output_type -> nocc.DropAllCachesReply
+	25, // 27: nocc.CompilationService.PurgeStaleCaches:output_type -> nocc.PurgeStaleCachesReply
+	16, // [16:28] is the sub-list for method output_type
+	4,  // [4:16] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_pb_nocc_protobuf_proto_init() }
@@ -1480,7 +2386,7 @@ func file_pb_nocc_protobuf_proto_init() {
 			}
 		}
 		file_pb_nocc_protobuf_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*StatusRequest); i {
+			switch v := v.(*StoreObjChunkRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1492,7 +2398,7 @@ func file_pb_nocc_protobuf_proto_init() {
 			}
 		}
 		file_pb_nocc_protobuf_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*StatusReply); i {
+			switch v := v.(*StoreObjReply); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1504,7 +2410,7 @@ func file_pb_nocc_protobuf_proto_init() {
 			}
 		}
 		file_pb_nocc_protobuf_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DumpLogsRequest); i {
+			switch v := v.(*CollectDepsOnServerRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1516,7 +2422,7 @@ func file_pb_nocc_protobuf_proto_init() {
 			}
 		}
 		file_pb_nocc_protobuf_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DumpLogsReply); i {
+			switch v := v.(*FileBody); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1528,7 +2434,7 @@ func file_pb_nocc_protobuf_proto_init() {
 			}
 		}
 		file_pb_nocc_protobuf_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DropAllCachesRequest); i {
+			switch v := v.(*CollectDepsOnServerReply); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1540,6 +2446,90 @@ func file_pb_nocc_protobuf_proto_init() {
 			}
 		}
 		file_pb_nocc_protobuf_proto_msgTypes[16].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatusRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pb_nocc_protobuf_proto_msgTypes[17].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatusReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pb_nocc_protobuf_proto_msgTypes[18].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DumpLogsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pb_nocc_protobuf_proto_msgTypes[19].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DumpLogsReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pb_nocc_protobuf_proto_msgTypes[20].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FollowLogsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pb_nocc_protobuf_proto_msgTypes[21].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FollowLogsReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pb_nocc_protobuf_proto_msgTypes[22].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DropAllCachesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pb_nocc_protobuf_proto_msgTypes[23].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*DropAllCachesReply); i {
 			case 0:
 				return &v.state
@@ -1551,6 +2541,30 @@ func file_pb_nocc_protobuf_proto_init() {
 				return nil
 			}
 		}
+		file_pb_nocc_protobuf_proto_msgTypes[24].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PurgeStaleCachesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pb_nocc_protobuf_proto_msgTypes[25].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PurgeStaleCachesReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -1558,7 +2572,7 @@ func file_pb_nocc_protobuf_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_pb_nocc_protobuf_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   17,
+			NumMessages:   26,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -24,10 +24,14 @@ type CompilationServiceClient interface {
 	UploadFileStream(ctx context.Context, opts ...grpc.CallOption) (CompilationService_UploadFileStreamClient, error)
 	RecvCompiledObjStream(ctx context.Context, in *OpenReceiveStreamRequest, opts ...grpc.CallOption) (CompilationService_RecvCompiledObjStreamClient, error)
 	StopClient(ctx context.Context, in *StopClientRequest, opts ...grpc.CallOption) (*StopClientReply, error)
+	StoreObjToCache(ctx context.Context, opts ...grpc.CallOption) (CompilationService_StoreObjToCacheClient, error)
+	CollectDepsOnServer(ctx context.Context, in *CollectDepsOnServerRequest, opts ...grpc.CallOption) (*CollectDepsOnServerReply, error)
 	// Service api
 	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusReply, error)
 	DumpLogs(ctx context.Context, in *DumpLogsRequest, opts ...grpc.CallOption) (CompilationService_DumpLogsClient, error)
+	FollowLogs(ctx context.Context, in *FollowLogsRequest, opts ...grpc.CallOption) (CompilationService_FollowLogsClient, error)
 	DropAllCaches(ctx context.Context, in *DropAllCachesRequest, opts ...grpc.CallOption) (*DropAllCachesReply, error)
+	PurgeStaleCaches(ctx context.Context, in *PurgeStaleCachesRequest, opts ...grpc.CallOption) (*PurgeStaleCachesReply, error)
 }
 
 type compilationServiceClient struct {
@@ -128,6 +132,49 @@ func (c *compilationServiceClient) StopClient(ctx context.Context, in *StopClien
 	return out, nil
 }
 
+func (c *compilationServiceClient) StoreObjToCache(ctx context.Context, opts ...grpc.CallOption) (CompilationService_StoreObjToCacheClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CompilationService_ServiceDesc.Streams[2], "/nocc.CompilationService/StoreObjToCache", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compilationServiceStoreObjToCacheClient{stream}
+	return x, nil
+}
+
+type CompilationService_StoreObjToCacheClient interface {
+	Send(*StoreObjChunkRequest) error
+	CloseAndRecv() (*StoreObjReply, error)
+	grpc.ClientStream
+}
+
+type compilationServiceStoreObjToCacheClient struct {
+	grpc.ClientStream
+}
+
+func (x *compilationServiceStoreObjToCacheClient) Send(m *StoreObjChunkRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *compilationServiceStoreObjToCacheClient) CloseAndRecv() (*StoreObjReply, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(StoreObjReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *compilationServiceClient) CollectDepsOnServer(ctx context.Context, in *CollectDepsOnServerRequest, opts ...grpc.CallOption) (*CollectDepsOnServerReply, error) {
+	out := new(CollectDepsOnServerReply)
+	err := c.cc.Invoke(ctx, "/nocc.CompilationService/CollectDepsOnServer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *compilationServiceClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusReply, error) {
 	out := new(StatusReply)
 	err := c.cc.Invoke(ctx, "/nocc.CompilationService/Status", in, out, opts...)
@@ -138,7 +185,7 @@ func (c *compilationServiceClient) Status(ctx context.Context, in *StatusRequest
 }
 
 func (c *compilationServiceClient) DumpLogs(ctx context.Context, in *DumpLogsRequest, opts ...grpc.CallOption) (CompilationService_DumpLogsClient, error) {
-	stream, err := c.cc.NewStream(ctx, &CompilationService_ServiceDesc.Streams[2], "/nocc.CompilationService/DumpLogs", opts...)
+	stream, err := c.cc.NewStream(ctx, &CompilationService_ServiceDesc.Streams[3], "/nocc.CompilationService/DumpLogs", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -169,6 +216,38 @@ func (x *compilationServiceDumpLogsClient) Recv() (*DumpLogsReply, error) {
 	return m, nil
 }
 
+func (c *compilationServiceClient) FollowLogs(ctx context.Context, in *FollowLogsRequest, opts ...grpc.CallOption) (CompilationService_FollowLogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CompilationService_ServiceDesc.Streams[4], "/nocc.CompilationService/FollowLogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compilationServiceFollowLogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CompilationService_FollowLogsClient interface {
+	Recv() (*FollowLogsReply, error)
+	grpc.ClientStream
+}
+
+type compilationServiceFollowLogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *compilationServiceFollowLogsClient) Recv() (*FollowLogsReply, error) {
+	m := new(FollowLogsReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *compilationServiceClient) DropAllCaches(ctx context.Context, in *DropAllCachesRequest, opts ...grpc.CallOption) (*DropAllCachesReply, error) {
 	out := new(DropAllCachesReply)
 	err := c.cc.Invoke(ctx, "/nocc.CompilationService/DropAllCaches", in, out, opts...)
@@ -178,6 +257,15 @@ func (c *compilationServiceClient) DropAllCaches(ctx context.Context, in *DropAl
 	return out, nil
 }
 
+func (c *compilationServiceClient) PurgeStaleCaches(ctx context.Context, in *PurgeStaleCachesRequest, opts ...grpc.CallOption) (*PurgeStaleCachesReply, error) {
+	out := new(PurgeStaleCachesReply)
+	err := c.cc.Invoke(ctx, "/nocc.CompilationService/PurgeStaleCaches", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CompilationServiceServer is the server API for CompilationService service.
 // All implementations must embed UnimplementedCompilationServiceServer
 // for forward compatibility
@@ -188,10 +276,14 @@ type CompilationServiceServer interface {
 	UploadFileStream(CompilationService_UploadFileStreamServer) error
 	RecvCompiledObjStream(*OpenReceiveStreamRequest, CompilationService_RecvCompiledObjStreamServer) error
 	StopClient(context.Context, *StopClientRequest) (*StopClientReply, error)
+	StoreObjToCache(CompilationService_StoreObjToCacheServer) error
+	CollectDepsOnServer(context.Context, *CollectDepsOnServerRequest) (*CollectDepsOnServerReply, error)
 	// Service api
 	Status(context.Context, *StatusRequest) (*StatusReply, error)
 	DumpLogs(*DumpLogsRequest, CompilationService_DumpLogsServer) error
+	FollowLogs(*FollowLogsRequest, CompilationService_FollowLogsServer) error
 	DropAllCaches(context.Context, *DropAllCachesRequest) (*DropAllCachesReply, error)
+	PurgeStaleCaches(context.Context, *PurgeStaleCachesRequest) (*PurgeStaleCachesReply, error)
 	mustEmbedUnimplementedCompilationServiceServer()
 }
 
@@ -214,15 +306,27 @@ func (UnimplementedCompilationServiceServer) RecvCompiledObjStream(*OpenReceiveS
 func (UnimplementedCompilationServiceServer) StopClient(context.Context, *StopClientRequest) (*StopClientReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method StopClient not implemented")
 }
+func (UnimplementedCompilationServiceServer) StoreObjToCache(CompilationService_StoreObjToCacheServer) error {
+	return status.Errorf(codes.Unimplemented, "method StoreObjToCache not implemented")
+}
+func (UnimplementedCompilationServiceServer) CollectDepsOnServer(context.Context, *CollectDepsOnServerRequest) (*CollectDepsOnServerReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CollectDepsOnServer not implemented")
+}
 func (UnimplementedCompilationServiceServer) Status(context.Context, *StatusRequest) (*StatusReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
 }
 func (UnimplementedCompilationServiceServer) DumpLogs(*DumpLogsRequest, CompilationService_DumpLogsServer) error {
 	return status.Errorf(codes.Unimplemented, "method DumpLogs not implemented")
 }
+func (UnimplementedCompilationServiceServer) FollowLogs(*FollowLogsRequest, CompilationService_FollowLogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method FollowLogs not implemented")
+}
 func (UnimplementedCompilationServiceServer) DropAllCaches(context.Context, *DropAllCachesRequest) (*DropAllCachesReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DropAllCaches not implemented")
 }
+func (UnimplementedCompilationServiceServer) PurgeStaleCaches(context.Context, *PurgeStaleCachesRequest) (*PurgeStaleCachesReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeStaleCaches not implemented")
+}
 func (UnimplementedCompilationServiceServer) mustEmbedUnimplementedCompilationServiceServer() {}
 
 // UnsafeCompilationServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -337,6 +441,50 @@ func _CompilationService_StopClient_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CompilationService_StoreObjToCache_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CompilationServiceServer).StoreObjToCache(&compilationServiceStoreObjToCacheServer{stream})
+}
+
+type CompilationService_StoreObjToCacheServer interface {
+	SendAndClose(*StoreObjReply) error
+	Recv() (*StoreObjChunkRequest, error)
+	grpc.ServerStream
+}
+
+type compilationServiceStoreObjToCacheServer struct {
+	grpc.ServerStream
+}
+
+func (x *compilationServiceStoreObjToCacheServer) SendAndClose(m *StoreObjReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *compilationServiceStoreObjToCacheServer) Recv() (*StoreObjChunkRequest, error) {
+	m := new(StoreObjChunkRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _CompilationService_CollectDepsOnServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CollectDepsOnServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompilationServiceServer).CollectDepsOnServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nocc.CompilationService/CollectDepsOnServer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompilationServiceServer).CollectDepsOnServer(ctx, req.(*CollectDepsOnServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _CompilationService_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(StatusRequest)
 	if err := dec(in); err != nil {
@@ -376,6 +524,27 @@ func (x *compilationServiceDumpLogsServer) Send(m *DumpLogsReply) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _CompilationService_FollowLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FollowLogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CompilationServiceServer).FollowLogs(m, &compilationServiceFollowLogsServer{stream})
+}
+
+type CompilationService_FollowLogsServer interface {
+	Send(*FollowLogsReply) error
+	grpc.ServerStream
+}
+
+type compilationServiceFollowLogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *compilationServiceFollowLogsServer) Send(m *FollowLogsReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _CompilationService_DropAllCaches_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(DropAllCachesRequest)
 	if err := dec(in); err != nil {
@@ -394,6 +563,24 @@ func _CompilationService_DropAllCaches_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CompilationService_PurgeStaleCaches_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeStaleCachesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompilationServiceServer).PurgeStaleCaches(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nocc.CompilationService/PurgeStaleCaches",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompilationServiceServer).PurgeStaleCaches(ctx, req.(*PurgeStaleCachesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CompilationService_ServiceDesc is the grpc.ServiceDesc for CompilationService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -413,6 +600,10 @@ var CompilationService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "StopClient",
 			Handler:    _CompilationService_StopClient_Handler,
 		},
+		{
+			MethodName: "CollectDepsOnServer",
+			Handler:    _CompilationService_CollectDepsOnServer_Handler,
+		},
 		{
 			MethodName: "Status",
 			Handler:    _CompilationService_Status_Handler,
@@ -421,6 +612,10 @@ var CompilationService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DropAllCaches",
 			Handler:    _CompilationService_DropAllCaches_Handler,
 		},
+		{
+			MethodName: "PurgeStaleCaches",
+			Handler:    _CompilationService_PurgeStaleCaches_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -434,11 +629,21 @@ var CompilationService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _CompilationService_RecvCompiledObjStream_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "StoreObjToCache",
+			Handler:       _CompilationService_StoreObjToCache_Handler,
+			ClientStreams: true,
+		},
 		{
 			StreamName:    "DumpLogs",
 			Handler:       _CompilationService_DumpLogs_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "FollowLogs",
+			Handler:       _CompilationService_FollowLogs_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "pb/nocc-protobuf.proto",
 }
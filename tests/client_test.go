@@ -5,8 +5,23 @@ package tests
 // 2) then, run `go test` or these tests from IDE
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/VKCOM/nocc/internal/client"
+	"github.com/VKCOM/nocc/internal/common"
 )
 
 func Test_compileMainCpp(t *testing.T) {
@@ -142,3 +157,1698 @@ func Test_relativePathMacro(t *testing.T) {
 		t.Errorf("%s", stdout)
 	}
 }
+
+func Test_nonExistingOutputDir(t *testing.T) {
+	var cmdLineStr = "g++ -c dt/path-macro.cpp -o dt/non-existing-dir/path-macro.o -std=gnu++17"
+	exitCode, _, stderr, err := createClientAndEmulateDaemonForTesting(cmdLineStr)
+	if err != nil {
+		t.Errorf("Error initing nocc client %s", err)
+		return
+	}
+
+	if exitCode == 0 {
+		t.Errorf("exitCode 0, but -o dir doesn't exist")
+		return
+	}
+	if !strings.Contains(string(stderr), "can't create dt/non-existing-dir/path-macro.o") {
+		t.Errorf("unexpected stderr %s", stderr)
+	}
+	if _, err := os.Stat("dt/non-existing-dir"); err == nil {
+		t.Errorf("-o dir was created, but it shouldn't")
+	}
+}
+
+func Test_outputFileWithDotDot(t *testing.T) {
+	// "-o build/../out/1.o" is relative to cwd of an invocation (not of a daemon), and ".." is resolved by the OS:
+	// if "link" is a symlink to "real/deep", then "link/../1.o" is "real/1.o", not "1.o" (like path.Clean would give)
+	dir := t.TempDir()
+	for _, subdir := range []string{"build", "out", "real/deep"} {
+		_ = os.MkdirAll(filepath.Join(dir, subdir), os.ModePerm)
+	}
+	if err := os.Symlink(filepath.Join(dir, "real", "deep"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte("int f() { return 1; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	for objOutFile, expectedLocation := range map[string]string{
+		"build/../out/1.o": "out/1.o",
+		"link/../1.o":      "real/1.o",
+	} {
+		response := daemon.HandleInvocation(client.DaemonSockRequest{
+			Cwd:     dir,
+			CmdLine: []string{"g++", "-MD", "-c", "1.cpp", "-o", objOutFile},
+		})
+		if response.ExitCode != 0 {
+			t.Fatalf("%s: exitCode %d\nstdout %s\nstderr %s", objOutFile, response.ExitCode, response.Stdout, response.Stderr)
+		}
+		if _, err := os.Stat(filepath.Join(dir, expectedLocation)); err != nil {
+			t.Errorf("%s: obj was expected to be saved to %s", objOutFile, expectedLocation)
+		}
+		depFile, err := os.ReadFile(filepath.Join(dir, common.ReplaceFileExt(expectedLocation, ".d")))
+		if err != nil || !strings.HasPrefix(string(depFile), objOutFile+":") {
+			t.Errorf("%s: unexpected depfile %q %v", objOutFile, depFile, err)
+		}
+	}
+
+	// like for g++, a non-existing dir before ".." is an error
+	response := daemon.HandleInvocation(client.DaemonSockRequest{
+		Cwd:     dir,
+		CmdLine: []string{"g++", "-c", "1.cpp", "-o", "non-existing/../1.o"},
+	})
+	if response.ExitCode == 0 {
+		t.Errorf("exitCode 0, but non-existing/.. can't be resolved")
+	}
+}
+
+func Test_disableOwnPch(t *testing.T) {
+	// a project mixing pch and non-pch builds: all.h.nocc-pch was generated earlier, and all.h has changed since
+	dir := t.TempDir()
+	hFile := filepath.Join(dir, "all.h")
+	cppFile := filepath.Join(dir, "main.cpp")
+	if err := os.WriteFile(hFile, []byte("#define OLD_VALUE 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exitCode, stdout, stderr, err := createClientAndEmulateDaemonForTesting("g++ -x c++-header -o " + hFile + ".gch " + hFile)
+	if err != nil || exitCode != 0 {
+		t.Fatalf("failed to generate pch: %v %d %s %s", err, exitCode, stdout, stderr)
+	}
+	if _, err := os.Stat(hFile + ".nocc-pch"); err != nil {
+		t.Fatalf("nocc-pch not generated: %v", err)
+	}
+	if err := os.WriteFile(hFile, []byte("#define OLD_VALUE 1\n#define NEW_VALUE 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cppFile, []byte("#include \"all.h\"\nint f() { return NEW_VALUE; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmdLine := strings.Split("g++ -c "+cppFile+" -o "+filepath.Join(dir, "main.o"), " ")
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// by default, the stale pch is used instead of all.h
+	exitCode, _, _ = client.EmulateDaemonInsideThisProcessForDev([]string{"127.0.0.1:43210"}, cmdLine, false, false, false, nil, 0)
+	if exitCode == 0 {
+		t.Errorf("expected a stale pch to be picked up")
+	}
+
+	// with own pch disabled, all.h is used directly
+	exitCode, stdout, stderr = client.EmulateDaemonInsideThisProcessForDev([]string{"127.0.0.1:43210"}, cmdLine, false, true, false, nil, 0)
+	if exitCode != 0 {
+		t.Errorf("exitCode %d\nstdout %s\nstderr %s", exitCode, stdout, stderr)
+	}
+	time.Sleep(100 * time.Millisecond) // for all goroutines to finish
+}
+
+func Test_ownPchRelativeOutput(t *testing.T) {
+	// a relative -o is relative to a cwd of an invocation, not of a daemon
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "all.h"), []byte("#define VALUE 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-x", "c++-header", "-o", "all.h.gch", "all.h"}})
+	if response.ExitCode != 0 {
+		t.Fatalf("exitCode %d\nstderr %s", response.ExitCode, response.Stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "all.h.nocc-pch")); err != nil {
+		t.Errorf("nocc-pch not generated in an invocation cwd: %v", err)
+	}
+	if _, err := os.Stat("all.h.nocc-pch"); err == nil {
+		_ = os.Remove("all.h.nocc-pch")
+		t.Errorf("nocc-pch generated in a daemon cwd")
+	}
+}
+
+func Test_explicitPchInclude(t *testing.T) {
+	// a build passes a .gch compiled by itself (not by nocc): it's replaced by a header it was compiled from
+	dir := t.TempDir()
+	hFile := filepath.Join(dir, "all.h")
+	if err := os.WriteFile(hFile, []byte("#define FROM_PCH 42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.cpp"), []byte("int f() { return FROM_PCH; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.Command("g++", "-x", "c++-header", "-o", hFile+".gch", hFile).CombinedOutput(); err != nil {
+		t.Fatalf("failed to generate gch: %v %s", err, output)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43210"},
+		DisableObjCache:      true,
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	invocation := client.ParseCmdLineInvocation(daemon, dir, strings.Split("g++ -include all.h.gch -c main.cpp -o main.o", " "))
+	hFiles, _, err := invocation.CollectDependentIncludes(dir, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasHFile := false
+	for _, file := range hFiles {
+		clientFileName := file.ToPbFileMetadata().ClientFileName
+		hasHFile = hasHFile || clientFileName == hFile
+		if strings.HasSuffix(clientFileName, ".gch") {
+			t.Errorf("%s must not be a dependency", clientFileName)
+		}
+	}
+	if !hasHFile {
+		t.Errorf("expected %s to be a dependency", hFile)
+	}
+	response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: strings.Split("g++ -include all.h.gch -c main.cpp -o main.o", " ")})
+	if response.ExitCode != 0 || !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+		t.Errorf("exitCode %d, outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+	}
+
+	// without a header next to it, a .gch can't be used remotely: not ignored, but compiled locally as is
+	// (g++ treats an explicit .gch as a text file, so a text one is used here, a real one would be a flood of errors)
+	if err := os.WriteFile(filepath.Join(dir, "other.h.gch"), []byte("#define FROM_PCH 42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	response = daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: strings.Split("g++ -include other.h.gch -c main.cpp -o main.o", " ")})
+	if response.ExitCode != 0 || response.Outcome != client.OutcomeLocal {
+		t.Errorf("expected local fallback, exitCode %d, outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+	}
+}
+
+func Test_preprocessedInputSplitPipeline(t *testing.T) {
+	// like ccache: `cxx -E` locally, then compiling a preprocessed file, probably with a declared language
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.h"), []byte("#define A 7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.cpp"), []byte("#include \"a.h\"\nint f() { return A; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cxxECommand := exec.Command("g++", "-E", "main.cpp", "-o", "main.ii")
+	cxxECommand.Dir = dir
+	if output, err := cxxECommand.CombinedOutput(); err != nil {
+		t.Fatalf("failed to preprocess: %v %s", err, output)
+	}
+	if err := os.Rename(filepath.Join(dir, "main.ii"), filepath.Join(dir, "main.pp")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43210"},
+		DisableObjCache:      true,
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	cmdLine := strings.Split("g++ -x c++-cpp-output -c main.pp -o main.o -MD", " ")
+	invocation := client.ParseCmdLineInvocation(daemon, dir, cmdLine)
+	hFiles, _, err := invocation.CollectDependentIncludes(dir, false, false)
+	if err != nil || len(hFiles) != 0 {
+		t.Errorf("expected no dependencies for a preprocessed input, got %d, err %v", len(hFiles), err)
+	}
+
+	response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: cmdLine})
+	if response.ExitCode != 0 || !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+		t.Errorf("exitCode %d, outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.o")); err != nil {
+		t.Errorf("main.o not saved: %v", err)
+	}
+	// like cxx does, no depfile for a preprocessed input
+	if _, err := os.Stat(filepath.Join(dir, "main.d")); !os.IsNotExist(err) {
+		t.Errorf("main.d must not be generated")
+	}
+}
+
+func Test_compilationDatabase(t *testing.T) {
+	dir := t.TempDir()
+	for _, cppName := range []string{"a.cpp", "b.cpp", "c.cpp"} {
+		if err := os.WriteFile(filepath.Join(dir, cppName), []byte("int f() { return 1; }\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compDBFileName := filepath.Join(dir, "compile_commands.json")
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// a daemon quits when a build is idle and is started again: next ones merge with a file written before
+	// (a.cpp recompiled with other flags replaces its entry)
+	for _, cmdLines := range [][]string{
+		{"g++ -c a.cpp -o a.o", "g++ -c b.cpp -o b.o"},
+		{"g++ -O2 -c a.cpp -o a.o", "g++ -c c.cpp -o c.o"},
+	} {
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts:      []string{"127.0.0.1:43210"},
+			DisableObjCache:      true,
+			CompDBFileName:       compDBFileName,
+			MaxLocalCxxProcesses: 1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg := sync.WaitGroup{}
+		for _, cmdLine := range cmdLines {
+			wg.Add(1)
+			go func(cmdLine string) {
+				defer wg.Done()
+				daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: strings.Split(cmdLine, " ")})
+			}(cmdLine)
+		}
+		wg.Wait()
+		// entries are written in batches while a daemon is alive, not only on quit
+		lastFile := strings.Split(cmdLines[1], " ")[2]
+		for start := time.Now(); ; time.Sleep(100 * time.Millisecond) {
+			if contents, _ := os.ReadFile(compDBFileName); bytes.Contains(contents, []byte(lastFile)) {
+				break
+			}
+			if time.Since(start) > 10*time.Second {
+				t.Fatalf("%s was not written to a compilation database before a daemon quit", lastFile)
+			}
+		}
+		daemon.QuitDaemonGracefully("done")
+	}
+
+	contents, err := os.ReadFile(compDBFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []struct {
+		Directory string   `json:"directory"`
+		Arguments []string `json:"arguments"`
+		File      string   `json:"file"`
+		Output    string   `json:"output"`
+	}
+	if err := json.Unmarshal(contents, &entries); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, contents)
+	}
+	argsByFile := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Directory != dir {
+			t.Errorf("unexpected directory %s", entry.Directory)
+		}
+		argsByFile[entry.File] = strings.Join(entry.Arguments, " ")
+	}
+	if len(entries) != 3 || argsByFile["a.cpp"] != "g++ -O2 -c a.cpp -o a.o" || argsByFile["b.cpp"] == "" || argsByFile["c.cpp"] == "" {
+		t.Errorf("unexpected compilation database:\n%s", contents)
+	}
+}
+
+func Test_diagnosticsMatchLocal(t *testing.T) {
+	// build systems parse errors, so remote stderr must be the same as local, diagnostics flags included
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.cpp"), []byte("int f() { return x1; }\nint g() { return x2; }\nint h() { return x3; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43210"},
+		DisableObjCache:      true,
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	for _, diagnosticsArgs := range []string{"", "-fmax-errors=2 ", "-Wfatal-errors "} {
+		cmdLine := strings.Split("g++ "+diagnosticsArgs+"-c broken.cpp -o broken.o", " ")
+		response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: cmdLine})
+		if !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+			t.Errorf("%s: expected to be compiled remotely, outcome %q", diagnosticsArgs, response.Outcome)
+		}
+
+		cxxCommand := exec.Command(cmdLine[0], cmdLine[1:]...)
+		cxxCommand.Dir = dir
+		var localStderr bytes.Buffer
+		cxxCommand.Stderr = &localStderr
+		_ = cxxCommand.Run()
+		if response.ExitCode != cxxCommand.ProcessState.ExitCode() || string(response.Stderr) != localStderr.String() {
+			t.Errorf("%s: remote exitCode %d, stderr\n%s\nlocal exitCode %d, stderr\n%s", diagnosticsArgs, response.ExitCode, response.Stderr, cxxCommand.ProcessState.ExitCode(), localStderr.String())
+		}
+	}
+}
+
+func Test_nonExistingSourceFile(t *testing.T) {
+	var cmdLineStr = "g++ -c dt/non-existing.cpp -o dt/non-existing.o -std=gnu++17"
+	exitCode, _, stderr, err := createClientAndEmulateDaemonForTesting(cmdLineStr)
+	if err != nil {
+		t.Errorf("Error initing nocc client %s", err)
+		return
+	}
+
+	if exitCode != 1 {
+		t.Errorf("exitCode %d, but source file doesn't exist", exitCode)
+		return
+	}
+	if !strings.Contains(string(stderr), "fatal error: dt/non-existing.cpp: No such file or directory") {
+		t.Errorf("unexpected stderr %s", stderr)
+	}
+}
+
+func Test_compileToObjExtension(t *testing.T) {
+	objFile := filepath.Join(t.TempDir(), "foo.obj")
+	var cmdLineStr = "g++ -c dt/path-macro.cpp -o " + objFile + " -std=gnu++17"
+	exitCode, stdout, stderr, err := createClientAndEmulateDaemonForTesting(cmdLineStr)
+	if err != nil {
+		t.Errorf("Error initing nocc client %s", err)
+		return
+	}
+
+	if exitCode != 0 {
+		t.Errorf("exitCode %d\nstdout %s\nstderr %s", exitCode, stdout, stderr)
+		return
+	}
+	if _, err := os.Stat(objFile); err != nil {
+		t.Errorf("foo.obj wasn't created: %v", err)
+	}
+}
+
+func Test_emptySourceFile(t *testing.T) {
+	// a zero-byte source is valid, as well as a zero-byte header: both are uploaded as a single empty chunk
+	dir := t.TempDir()
+	for fileName, contents := range map[string]string{"empty.cpp": "", "empty.h": "", "with-empty-h.cpp": "#include \"empty.h\"\n"} {
+		if err := os.WriteFile(filepath.Join(dir, fileName), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// emulates a compiler producing an empty obj: it's also sent back as a single empty chunk
+	fakeCxx := filepath.Join(dir, "empty-obj-g++")
+	if err := os.WriteFile(fakeCxx, []byte("#!/bin/sh\ng++ \"$@\" || exit $?\nwhile [ $# -gt 0 ]; do [ \"$1\" = -o ] && : > \"$2\"; shift; done\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	for _, cmdLine := range [][]string{
+		{"g++", "-c", "empty.cpp", "-o", "empty.o"},
+		{"g++", "-c", "with-empty-h.cpp", "-o", "with-empty-h.o"},
+		{fakeCxx, "-c", "empty.cpp", "-o", "fake.o"},
+	} {
+		objFile := filepath.Join(dir, cmdLine[len(cmdLine)-1])
+		response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: cmdLine})
+		if response.ExitCode != 0 {
+			t.Errorf("%v: exitCode %d\nstderr %s", cmdLine, response.ExitCode, response.Stderr)
+			continue
+		}
+		if outcome := strings.Fields(response.Outcome)[0]; outcome != client.OutcomeRemote && outcome != client.OutcomeObjCache {
+			t.Errorf("%v: expected to be compiled remotely, outcome %q", cmdLine, response.Outcome)
+		}
+		stat, err := os.Stat(objFile)
+		if err != nil {
+			t.Errorf("%v: %v", cmdLine, err)
+		} else if isEmpty := cmdLine[0] == fakeCxx; isEmpty != (stat.Size() == 0) {
+			t.Errorf("%v: unexpected obj size %d", cmdLine, stat.Size())
+		}
+	}
+}
+
+func Test_cxxQueryFlags(t *testing.T) {
+	// nothing is compiled, cxx is queried locally even if local compilation is disabled, with the same output
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	for _, cmdLineStr := range []string{"g++ -dumpversion", "g++ -print-file-name=libstdc++.a", "g++ -O2 -print-prog-name=ld", "gcc -dumpmachine"} {
+		response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: t.TempDir(), CmdLine: strings.Split(cmdLineStr, " ")})
+		exitCode, output, _ := runCmdLocallyForTesting(cmdLineStr)
+		if response.ExitCode != exitCode || string(response.Stdout) != string(output) || len(response.Stderr) != 0 {
+			t.Errorf("%s: unexpected response %d %q %q, expected %d %q", cmdLineStr, response.ExitCode, response.Stdout, response.Stderr, exitCode, output)
+		}
+		if response.Outcome != client.OutcomeLocal {
+			t.Errorf("%s: unexpected outcome %q", cmdLineStr, response.Outcome)
+		}
+	}
+
+	// values of options look like queries, but they aren't: a source is compiled remotely (or taken from obj cache)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte("int f() { return 1; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmdLineStr := range []string{"g++ -c 1.cpp -o 1.o -Xlinker --print-memory-usage", "g++ -c 1.cpp -o 1.o -Wl,--print-map"} {
+		response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: strings.Split(cmdLineStr, " ")})
+		if response.ExitCode != 0 || response.Outcome == client.OutcomeLocal {
+			t.Errorf("%s: expected to be compiled remotely, exitCode %d, outcome %q\nstderr %s", cmdLineStr, response.ExitCode, response.Outcome, response.Stderr)
+		}
+	}
+}
+
+func Test_compileAndLinkOneLiner(t *testing.T) {
+	// without -c, cxx also links, and the linker must be launched locally with all -Wl, options
+	// -Wl,-Map makes the linker write a file: it appears only if linking was done locally
+	dir := t.TempDir()
+	for _, outFile := range []string{"app", "app.o"} {
+		mapFile := filepath.Join(dir, outFile+".map")
+		var cmdLineStr = "g++ dt/path-macro.cpp -o " + filepath.Join(dir, outFile) + " -Wl,-Map," + mapFile
+		exitCode, stdout, stderr, err := createClientAndEmulateDaemonWithLocalCxxForTesting(cmdLineStr)
+		if err != nil {
+			t.Errorf("Error initing nocc client %s", err)
+			return
+		}
+
+		if exitCode != 0 {
+			t.Errorf("%s: exitCode %d\nstdout %s\nstderr %s", outFile, exitCode, stdout, stderr)
+			continue
+		}
+		if _, err := os.Stat(mapFile); err != nil {
+			t.Errorf("%s: linking wasn't done locally: %v", outFile, err)
+		}
+	}
+}
+
+func Test_daemonBatchRequest(t *testing.T) {
+	// a batch of commands is sent via one socket connection; a failed command doesn't stop others
+	dir := t.TempDir()
+	sources := map[string]string{
+		"1.cpp": "int f1() { return 1; }\n",
+		"2.cpp": "int f2() { return undeclared; }\n",
+		"3.cpp": "int f3() { return 3; }\n",
+	}
+	for name, contents := range sources {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sockName := filepath.Join(dir, "nocc.sock")
+	if err := daemon.StartListeningUnixSocket(sockName); err != nil {
+		t.Fatal(err)
+	}
+	go daemon.ServeUntilNobodyAlive()
+	defer daemon.QuitDaemonGracefully("done")
+
+	conn, err := net.Dial("unix", sockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	request := "BATCH\b4\b2\000"
+	for _, name := range []string{"1.cpp", "2.cpp", "3.cpp"} {
+		request += dir + "\bg++\b-c\b" + name + "\b-o\b" + filepath.Join(dir, name+".o") + "\000"
+	}
+	request += dir + "\bg++\000" // invalid, no arguments
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := bytes.Split(bytes.TrimSuffix(response, []byte{0}), []byte{0})
+	if len(parts) != 4*3 {
+		t.Fatalf("expected 4 responses of 3 parts, got %q", response)
+	}
+	expectedExitCodes := []string{"0", "1", "0", "1"}
+	for i, expected := range expectedExitCodes {
+		if exitCode := string(parts[i*3]); exitCode != expected {
+			t.Errorf("command %d: exitCode %s, expected %s\nstderr %s", i+1, exitCode, expected, parts[i*3+2])
+		}
+	}
+	if !bytes.Contains(parts[1*3+2], []byte("undeclared")) {
+		t.Errorf("unexpected stderr of a failed command: %s", parts[1*3+2])
+	}
+	for _, name := range []string{"1.cpp.o", "3.cpp.o"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s wasn't compiled", name)
+		}
+	}
+
+	// sizes from a socket are checked before allocating memory for them
+	for _, malformed := range []string{"BATCH\b999999999999\b1\000", "STDIN\b999999999999\000"} {
+		conn, err := net.Dial("unix", sockName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte(malformed)); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		response, err := io.ReadAll(conn)
+		_ = conn.Close()
+		if err != nil || string(response) != "\000" {
+			t.Errorf("%q: expected to be rejected, got %q %v", malformed, response, err)
+		}
+	}
+}
+
+func Test_daemonResponseOutcome(t *testing.T) {
+	// the same source is compiled twice: by a server and then from obj cache; a compile-and-link one-liner is compiled locally
+	dir := t.TempDir()
+	uniqueSource := fmt.Sprintf("int f_%d() { return 1; }\n", time.Now().UnixNano())
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte(uniqueSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.cpp"), []byte("int main() { return 0; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43210"},
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sockName := filepath.Join(dir, "nocc.sock")
+	if err := daemon.StartListeningUnixSocket(sockName); err != nil {
+		t.Fatal(err)
+	}
+	go daemon.ServeUntilNobodyAlive()
+	defer daemon.QuitDaemonGracefully("done")
+
+	conn, err := net.Dial("unix", sockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	request := "BATCH\b3\b1\bOUTCOME\000"
+	request += dir + "\bg++\b-c\b1.cpp\b-o\b" + filepath.Join(dir, "1.o") + "\000"
+	request += dir + "\bg++\b-c\b1.cpp\b-o\b" + filepath.Join(dir, "1-again.o") + "\000"
+	request += dir + "\bg++\bmain.cpp\b-o\b" + filepath.Join(dir, "app") + "\000"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := bytes.Split(bytes.TrimSuffix(response, []byte{0}), []byte{0})
+	if len(parts) != 3*4 {
+		t.Fatalf("expected 3 responses of 4 parts, got %q", response)
+	}
+	for i, expected := range []string{"remote 127.0.0.1", "cache 127.0.0.1", "local"} {
+		if exitCode, outcome := string(parts[i*4]), string(parts[i*4+3]); exitCode != "0" || outcome != expected {
+			t.Errorf("command %d: exitCode %s, outcome %q, expected %q\nstderr %s", i+1, exitCode, outcome, expected, parts[i*4+2])
+		}
+	}
+}
+
+func Test_daemonSetServers(t *testing.T) {
+	// a daemon is started with a server nobody listens on, then a working one is set via a socket
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte(fmt.Sprintf("int f_%d() { return 1; }\n", time.Now().UnixNano())), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43299"},
+		ConnectTimeout:       500 * time.Millisecond,
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sockName := filepath.Join(dir, "nocc.sock")
+	if err := daemon.StartListeningUnixSocket(sockName); err != nil {
+		t.Fatal(err)
+	}
+	go daemon.ServeUntilNobodyAlive()
+	defer daemon.QuitDaemonGracefully("done")
+
+	request := client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-c", "1.cpp", "-o", "1.o"}}
+	if response := daemon.HandleInvocation(request); response.Outcome != client.OutcomeLocal {
+		t.Errorf("expected local compilation, outcome %q", response.Outcome)
+	}
+
+	exitCode, _, stderr, err := client.RequestDaemonSetServers(sockName, []string{"127.0.0.1"})
+	if err != nil || exitCode == 0 || !strings.Contains(string(stderr), "invalid server") {
+		t.Errorf("expected a list without a port to be rejected: %d %s %v", exitCode, stderr, err)
+	}
+
+	exitCode, stdout, stderr, err := client.RequestDaemonSetServers(sockName, []string{"127.0.0.1:43210", "127.0.0.1:43299"})
+	if err != nil || exitCode != 0 || string(stdout) != "connected 1 of 2 servers\n" {
+		t.Fatalf("failed to set servers: %d %q %s %v", exitCode, stdout, stderr, err)
+	}
+	// 1.cpp may be hashed to either of two servers, leave only a working one not to depend on hashing
+	exitCode, stdout, stderr, err = client.RequestDaemonSetServers(sockName, []string{"127.0.0.1:43210"})
+	if err != nil || exitCode != 0 || string(stdout) != "connected 1 of 1 servers\n" {
+		t.Fatalf("failed to set servers: %d %q %s %v", exitCode, stdout, stderr, err)
+	}
+	if response := daemon.HandleInvocation(request); response.ExitCode != 0 || !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+		t.Errorf("expected remote compilation, exitCode %d, outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+	}
+}
+
+func Test_daemonSetServersReAdded(t *testing.T) {
+	// a server removed and added back before a delayed close must stay registered: a new connection has the same clientID
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "nocc.log")
+	if err := client.MakeLoggerClient(logFile, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.MakeLoggerClient("", -1, false) }()
+
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43210"},
+		ConnectTimeout:       500 * time.Millisecond,
+		InterruptTimeout:     300 * time.Millisecond,
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	if _, err := daemon.SetRemoteServers([]string{"127.0.0.1:43299"}); err != nil {
+		t.Fatal(err)
+	}
+	if nConnected, err := daemon.SetRemoteServers([]string{"127.0.0.1:43210"}); err != nil || nConnected != 1 {
+		t.Fatalf("failed to add a server back: %d %v", nConnected, err)
+	}
+	time.Sleep(time.Second) // more than a force interrupt timeout, after which a removed connection is closed
+
+	if logContents, _ := os.ReadFile(logFile); !strings.Contains(string(logContents), "remote 127.0.0.1:43210 was added back to servers list") {
+		t.Errorf("a re-added server was unregistered:\n%s", logContents)
+	}
+}
+
+func Test_echoServerCmdLine(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "nocc.log")
+	if err := client.MakeLoggerClient(logFile, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.MakeLoggerClient("", -1, false) }()
+
+	// obj cache is disabled, so that cxx is launched on a server for sure
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:   []string{"127.0.0.1:43210"},
+		DisableObjCache:   true,
+		EchoServerCmdLine: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwd, _ := os.Getwd()
+	response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: cwd, CmdLine: []string{"g++", "-DECHOED_DEFINE", "-c", "dt/dep1/1.cpp", "-o", filepath.Join(dir, "1.o")}})
+	daemon.QuitDaemonGracefully("done")
+	if response.ExitCode != 0 {
+		t.Fatalf("exitCode %d\nstderr %s", response.ExitCode, response.Stderr)
+	}
+
+	logContents, _ := os.ReadFile(logFile)
+	var cmdLineLogged string
+	for _, line := range strings.Split(string(logContents), "\n") {
+		if strings.Contains(line, "server cmd line:") {
+			cmdLineLogged = line
+		}
+	}
+	if cmdLineLogged == "" {
+		t.Fatalf("server cmd line not logged:\n%s", logContents)
+	}
+	// server paths are shown as-is, not remapped to client ones
+	if !strings.Contains(cmdLineLogged, "/tests && g++ ") || !strings.Contains(cmdLineLogged, " -DECHOED_DEFINE -c -o /") || !strings.HasSuffix(cmdLineLogged, " dt/dep1/1.cpp") {
+		t.Errorf("unexpected server cmd line: %s", cmdLineLogged)
+	}
+}
+
+func Test_daemonStdinRequest(t *testing.T) {
+	// `g++ -x c++ -c - -o 1.o`: stdin is sent before a command line, quoted #include-s are searched from cwd
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "h.h"), []byte("int f() { return 1; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sockName := filepath.Join(dir, "nocc.sock")
+	if err := daemon.StartListeningUnixSocket(sockName); err != nil {
+		t.Fatal(err)
+	}
+	go daemon.ServeUntilNobodyAlive()
+	defer daemon.QuitDaemonGracefully("done")
+
+	sendStdinRequest := func(stdin string, outFile string) []string {
+		conn, err := net.Dial("unix", sockName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		request := "STDIN\b" + strconv.Itoa(len(stdin)) + "\000" + stdin
+		request += dir + "\bg++\b-x\bc++\b-c\b-\b-MD\b-o\b" + filepath.Join(dir, outFile) + "\000"
+		if _, err := conn.Write([]byte(request)); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		response, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(string(response), "\000")
+	}
+
+	// local fallback is disabled, so success means that stdin was compiled remotely
+	parts := sendStdinRequest("#include \"h.h\"\nint main() { return f(); }\n", "ok.o")
+	if len(parts) < 3 || parts[0] != "0" {
+		t.Fatalf("unexpected response %q", parts)
+	}
+	depFile, err := client.MakeDepFileFromFile(filepath.Join(dir, "ok.d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deps := depFile.FindDepListByTargetName(filepath.Join(dir, "ok.o")); len(deps) == 0 || !strings.HasSuffix(deps[len(deps)-1], "h.h") {
+		t.Errorf("unexpected depfile deps %v", deps)
+	}
+
+	// the same stdin is saved to the same file, which is already known to a server
+	parts = sendStdinRequest("#include \"h.h\"\nint main() { return f(); }\n", "again.o")
+	if len(parts) < 3 || parts[0] != "0" {
+		t.Fatalf("unexpected response %q", parts)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "again.o")); err != nil {
+		t.Error(err)
+	}
+
+	parts = sendStdinRequest("int main() { return undeclared; }\n", "bad.o")
+	if len(parts) < 3 || parts[0] != "1" || !strings.Contains(parts[2], "<stdin>:1:") {
+		t.Errorf("unexpected response %q", parts)
+	}
+}
+
+func Test_daemonRequestCwd(t *testing.T) {
+	// all relative paths are resolved against cwd of a request, so a malformed one is rejected
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "real", "1.cpp"), []byte("int main() { return 0; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "real"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sockName := filepath.Join(dir, "nocc.sock")
+	if err := daemon.StartListeningUnixSocket(sockName); err != nil {
+		t.Fatal(err)
+	}
+	go daemon.ServeUntilNobodyAlive()
+	defer daemon.QuitDaemonGracefully("done")
+
+	sendRequest := func(cwd string) []string {
+		conn, err := net.Dial("unix", sockName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte(cwd + "\bg++\b-c\b1.cpp\b-o\b1.o\000")); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		response, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(string(response), "\000")
+	}
+
+	for _, cwd := range []string{"real", "./" + filepath.Base(dir), filepath.Join(dir, "not-exists"), filepath.Join(dir, "real", "1.cpp")} {
+		parts := sendRequest(cwd)
+		if len(parts) < 3 || parts[0] != "1" || !strings.Contains(parts[2], "nocc: cwd") {
+			t.Errorf("cwd %q: unexpected response %q", cwd, parts)
+		}
+	}
+
+	// a symlinked cwd is resolved, an obj is written next to a source
+	if parts := sendRequest(filepath.Join(dir, "link", "..", "link") + "/"); len(parts) < 3 || parts[0] != "0" {
+		t.Fatalf("unexpected response %q", parts)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "real", "1.o")); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_sideFilesOptionsCompiledLocally(t *testing.T) {
+	// dumps are written next to .o; they aren't sent back from a remote, so compilation is done locally
+	dir := t.TempDir()
+	var cmdLineStr = "g++ -fdump-tree-original -c dt/dep1/1.cpp -o " + dir + "/1.o"
+	exitCode, stdout, stderr, err := createClientAndEmulateDaemonWithLocalCxxForTesting(cmdLineStr)
+	if err != nil {
+		t.Fatalf("Error initing nocc client %s", err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("exitCode %d\nstdout %s\nstderr %s", exitCode, stdout, stderr)
+	}
+	if dumps, _ := filepath.Glob(dir + "/1.cpp.*.original"); len(dumps) != 1 {
+		t.Errorf("dump file not found in %s", dir)
+	}
+}
+
+func Test_summaryFile(t *testing.T) {
+	dir := t.TempDir()
+	summaryFile := filepath.Join(dir, "summary.tsv")
+	for _, cppName := range []string{"1.cpp", "2.cpp", "3.cpp"} {
+		if err := os.WriteFile(filepath.Join(dir, cppName), []byte("int f_"+cppName[:1]+"() { return 1; }\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+		SummaryFileName: summaryFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for _, cppName := range []string{"1.cpp", "2.cpp", "3.cpp"} {
+		wg.Add(1)
+		go func(cppName string) {
+			defer wg.Done()
+			response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-c", cppName, "-o", filepath.Join(dir, cppName+".o")}})
+			if response.ExitCode != 0 {
+				t.Errorf("%s: exitCode %d\nstderr %s", cppName, response.ExitCode, response.Stderr)
+			}
+		}(cppName)
+	}
+	wg.Wait()
+	daemon.QuitDaemonGracefully("done")
+
+	contents, _ := os.ReadFile(summaryFile)
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "time\tsession_id\tcpp_in_file\t") {
+		t.Fatalf("expected a header and 3 records:\n%s", contents)
+	}
+	nColumns := len(strings.Split(lines[0], "\t"))
+	for _, line := range lines[1:] {
+		columns := strings.Split(line, "\t")
+		if len(columns) != nColumns || !strings.HasSuffix(columns[2], ".cpp") || !strings.Contains(columns[nColumns-1], "received_obj=") {
+			t.Errorf("unexpected record: %s", line)
+		}
+	}
+}
+
+func Test_uploadsFile(t *testing.T) {
+	// every uploaded file is listed (only once for a daemon), with no contents
+	dir := t.TempDir()
+	uploadsFile := filepath.Join(dir, "uploads.tsv")
+	secret := fmt.Sprintf("secret_%d", time.Now().UnixNano()) // unique contents, not to be found in src cache of a server
+	for name, contents := range map[string]string{
+		"uploaded.h": "#define SECRET " + secret + "\n",
+		"1.cpp":      "#include \"uploaded.h\"\nint f() { return sizeof(\"SECRET\"); } // " + secret + "\n",
+		"2.cpp":      "#include \"uploaded.h\"\nint g() { return 2; } // " + secret + "\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+		UploadsFileName: uploadsFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cppName := range []string{"1.cpp", "2.cpp"} {
+		response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-c", cppName, "-o", cppName + ".o"}})
+		if response.ExitCode != 0 {
+			t.Fatalf("%s: exitCode %d\nstderr %s", cppName, response.ExitCode, response.Stderr)
+		}
+	}
+	daemon.QuitDaemonGracefully("done")
+
+	contents, _ := os.ReadFile(uploadsFile)
+	if strings.Contains(string(contents), secret) {
+		t.Errorf("contents of files must not be written:\n%s", contents)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	if len(lines) != 4 || lines[0] != "time\tsession_id\tcpp_in_file\tremote\tuploaded_file" {
+		t.Fatalf("expected a header and 3 records:\n%s", contents)
+	}
+	var uploaded []string
+	for _, line := range lines[1:] {
+		columns := strings.Split(line, "\t")
+		if len(columns) != 5 || columns[3] != "127.0.0.1" {
+			t.Fatalf("unexpected record: %s", line)
+		}
+		uploaded = append(uploaded, columns[2]+" "+columns[4])
+	}
+	sort.Strings(uploaded)
+	expected := []string{"1.cpp " + filepath.Join(dir, "1.cpp"), "1.cpp " + filepath.Join(dir, "uploaded.h"), "2.cpp " + filepath.Join(dir, "2.cpp")}
+	if strings.Join(uploaded, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected uploads:\n%s", strings.Join(uploaded, "\n"))
+	}
+}
+
+func Test_preflight(t *testing.T) {
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		remote        string
+		localCxxQueue int64
+		expectErr     bool
+	}{
+		{"127.0.0.1:43210", 0, false},
+		{"127.0.0.1:43299", 1, false}, // nobody listens there, but everything will be compiled locally
+		{"127.0.0.1:43299", 0, true},
+	} {
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts:      []string{tc.remote},
+			ConnectTimeout:       500 * time.Millisecond,
+			MaxLocalCxxProcesses: tc.localCxxQueue,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = daemon.RunPreflight()
+		daemon.QuitDaemonGracefully("done")
+		if (err != nil) != tc.expectErr {
+			t.Errorf("%s (local queue %d): unexpected preflight result: %v", tc.remote, tc.localCxxQueue, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "check NOCC_SERVERS") {
+			t.Errorf("preflight error is not actionable: %v", err)
+		}
+	}
+}
+
+func Test_localCxxQueueSizePartialOutage(t *testing.T) {
+	// a "compiler" detects whether it's launched in parallel with another one
+	dir := t.TempDir()
+	cxxScript := filepath.Join(dir, "slow-cxx.sh")
+	if err := os.WriteFile(cxxScript, []byte("#!/bin/sh\nmkdir running 2>/dev/null || touch overlapped\nsleep 0.3\nrmdir running 2>/dev/null\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		partialOutageQueue int64
+		expectOverlapped   bool
+	}{
+		{0, true},
+		{1, false},
+	} {
+		_ = os.Remove(filepath.Join(dir, "overlapped"))
+		// nobody listens on 43299: one remote is down, another is up
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts:                   []string{"127.0.0.1:43210", "127.0.0.1:43299"},
+			ConnectTimeout:                    500 * time.Millisecond,
+			MaxLocalCxxProcesses:              4,
+			MaxLocalCxxProcessesPartialOutage: tc.partialOutageQueue,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg := sync.WaitGroup{}
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// linking is always done locally
+				daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{cxxScript, "main.o", "-o", "app"}})
+			}()
+		}
+		wg.Wait()
+		daemon.QuitDaemonGracefully("done")
+
+		_, err = os.Stat(filepath.Join(dir, "overlapped"))
+		if overlapped := err == nil; overlapped != tc.expectOverlapped {
+			t.Errorf("partial outage queue %d: overlapped %v, expected %v", tc.partialOutageQueue, overlapped, tc.expectOverlapped)
+		}
+	}
+}
+
+func Test_routingServersOrder(t *testing.T) {
+	// nobody listens on 43299: a file is compiled locally if routed there, so outcomes reveal routing
+	dir := t.TempDir()
+	cppNames := []string{"a.cpp", "b.cpp", "c.cpp", "d.cpp", "e.cpp", "f.cpp"}
+	for _, cppName := range cppNames {
+		if err := os.WriteFile(filepath.Join(dir, cppName), []byte("int f() { return 1; }\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	routeAll := func(remoteNoccHosts []string, sortServers bool) []string {
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts:      remoteNoccHosts,
+			SortServers:          sortServers,
+			ConnectTimeout:       500 * time.Millisecond,
+			DisableObjCache:      true,
+			MaxLocalCxxProcesses: 1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer daemon.QuitDaemonGracefully("done")
+		outcomes := make([]string, 0, len(cppNames))
+		for _, cppName := range cppNames {
+			response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-c", cppName, "-o", cppName + ".o"}})
+			outcomes = append(outcomes, response.Outcome)
+		}
+		return outcomes
+	}
+	hostsAB := []string{"127.0.0.1:43210", "127.0.0.1:43299"}
+	hostsBA := []string{"127.0.0.1:43299", "127.0.0.1:43210"}
+
+	// by default, servers are taken in a listed order, like always: with 2 servers, every file goes to another one
+	outcomesAB, outcomesBA := routeAll(hostsAB, false), routeAll(hostsBA, false)
+	for i := range cppNames {
+		if outcomesAB[i] == outcomesBA[i] {
+			t.Errorf("%s is routed regardless of servers order: %v and %v", cppNames[i], outcomesAB, outcomesBA)
+		}
+	}
+
+	// with NOCC_SORT_SERVERS, the order doesn't matter
+	outcomesAB, outcomesBA = routeAll(hostsAB, true), routeAll(hostsBA, true)
+	if strings.Join(outcomesAB, ",") != strings.Join(outcomesBA, ",") {
+		t.Errorf("routing depends on servers order: %v and %v", outcomesAB, outcomesBA)
+	}
+}
+
+func Test_serversAffinity(t *testing.T) {
+	// nobody listens on 43299: a file is compiled locally if routed there, so outcomes reveal routing
+	dir := t.TempDir()
+	cppNames := []string{"a.cpp", "b.cpp", "c.cpp", "d.cpp", "e.cpp", "f.cpp"}
+	for _, cppName := range cppNames {
+		if err := os.WriteFile(filepath.Join(dir, cppName), []byte("int f() { return 1; }\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	affinityFile := filepath.Join(dir, "affinity.json")
+
+	compileAll := func(remoteNoccHosts []string, affinityFile string) (nRemote int) {
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts:         remoteNoccHosts,
+			ConnectTimeout:          500 * time.Millisecond,
+			DisableObjCache:         true,
+			MaxLocalCxxProcesses:    1,
+			ServersAffinityFileName: affinityFile,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer daemon.QuitDaemonGracefully("done")
+		for _, cppName := range cppNames {
+			response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-c", cppName, "-o", cppName + ".o"}})
+			if strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+				nRemote++
+			}
+		}
+		return
+	}
+
+	// without affinity, a new server takes some files by hashing
+	if nRemote := compileAll([]string{"127.0.0.1:43210", "127.0.0.1:43299"}, ""); nRemote == len(cppNames) {
+		t.Fatalf("all files are routed to one server, the test makes no sense")
+	}
+	// with affinity, files stay on a server they were compiled on before
+	if nRemote := compileAll([]string{"127.0.0.1:43210"}, affinityFile); nRemote != len(cppNames) {
+		t.Fatalf("expected all files to be compiled remotely, got %d", nRemote)
+	}
+	if nRemote := compileAll([]string{"127.0.0.1:43210", "127.0.0.1:43299"}, affinityFile); nRemote != len(cppNames) {
+		t.Errorf("files didn't stay on a server by affinity, compiled remotely %d", nRemote)
+	}
+
+	// a server is removed from a list: its entries are invalidated, files are routed to remaining ones
+	compileAll([]string{"127.0.0.1:43299"}, affinityFile)
+	contents, err := os.ReadFile(affinityFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type affinityEntry struct {
+		RemoteHostPort string `json:"remote"`
+		LastUsed       int64  `json:"last_used"`
+	}
+	var entries map[string]affinityEntry
+	if err := json.Unmarshal(contents, &entries); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, contents)
+	}
+	if strings.Contains(string(contents), "43210") || entries["a.cpp"].RemoteHostPort != "127.0.0.1:43299" {
+		t.Errorf("entries of a removed server are not invalidated: %s", contents)
+	}
+
+	// entries not used for a long time are evicted, recently used ones are kept
+	entries["stale.cpp"] = affinityEntry{"127.0.0.1:43299", 1}
+	if contents, err = json.Marshal(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(affinityFile, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	compileAll([]string{"127.0.0.1:43299"}, affinityFile)
+	contents, _ = os.ReadFile(affinityFile)
+	if strings.Contains(string(contents), "stale.cpp") || !strings.Contains(string(contents), "a.cpp") {
+		t.Errorf("stale entries are not evicted: %s", contents)
+	}
+}
+
+func Test_selftest(t *testing.T) {
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	if nFailed := client.RunSelftest([]string{"127.0.0.1:43210"}, "g++"); nFailed != 0 {
+		t.Errorf("selftest failed against a working server")
+	}
+	// nobody listens there
+	if nFailed := client.RunSelftest([]string{"127.0.0.1:43210", "127.0.0.1:43299"}, "g++"); nFailed != 1 {
+		t.Errorf("expected selftest to fail against 1 server, got %d", nFailed)
+	}
+	// a selftest gets its own clientID, but the environment of a process is left as is
+	t.Setenv("NOCC_CLIENT_ID", "selftest-env")
+	for i := 0; i < 2; i++ {
+		if nFailed := client.RunSelftest([]string{"127.0.0.1:43210"}, "g++"); nFailed != 0 {
+			t.Errorf("selftest failed with NOCC_CLIENT_ID set")
+		}
+	}
+	if clientID := os.Getenv("NOCC_CLIENT_ID"); clientID != "selftest-env" {
+		t.Errorf("NOCC_CLIENT_ID changed to %s", clientID)
+	}
+}
+
+func Test_compressTransfers(t *testing.T) {
+	if !common.ShouldCompressTransfer("/proj/a.h", 64*1024) || common.ShouldCompressTransfer("/proj/a.h", 100) || common.ShouldCompressTransfer("/proj/all.h.nocc-pch", 64*1024) {
+		t.Errorf("unexpected compression policy")
+	}
+
+	// a header of several chunks and an obj of more than 4K, both are sent gzipped
+	dir := t.TempDir()
+	var hBody, cppBody strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&hBody, "#define CONSTANT_%d %d\n", i, i)
+	}
+	cppBody.WriteString("#include \"big.h\"\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&cppBody, "int f%d(int x) { return x * CONSTANT_%d; }\n", i, i)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "big.h"), []byte(hBody.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.cpp"), []byte(cppBody.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cxxCommand := exec.Command("g++", "-c", "main.cpp", "-o", "local.o")
+	cxxCommand.Dir = dir
+	if output, err := cxxCommand.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile locally: %v %s", err, output)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	tuning := client.MakeDefaultTransferTuning()
+	tuning.Compress = true
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43210"},
+		TransferTuning:       tuning,
+		DisableObjCache:      true,
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: strings.Split("g++ -c main.cpp -o main.o", " ")})
+	if response.ExitCode != 0 || !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+		t.Fatalf("exitCode %d, outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+	}
+	localObj, _ := os.ReadFile(filepath.Join(dir, "local.o"))
+	remoteObj, _ := os.ReadFile(filepath.Join(dir, "main.o"))
+	if len(remoteObj) < 4*1024 || !bytes.Equal(localObj, remoteObj) {
+		t.Errorf("remote obj (%d bytes) differs from a local one (%d bytes)", len(remoteObj), len(localObj))
+	}
+}
+
+func Test_skipUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	stateFileName := filepath.Join(dir, "unchanged.json")
+	hFile, cppFile := filepath.Join(dir, "a.h"), filepath.Join(dir, "main.cpp")
+	if err := os.WriteFile(hFile, []byte("#define A 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cppFile, []byte("#include \"a.h\"\nint f() { return A; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// sources modified right before compilation aren't trusted, see unchangedDepsMTimeSlack
+	minuteAgo := time.Now().Add(-time.Minute)
+	_ = os.Chtimes(hFile, minuteAgo, minuteAgo)
+	_ = os.Chtimes(cppFile, minuteAgo, minuteAgo)
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	compile := func(daemon *client.Daemon, cmdLineStr string) string {
+		response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: strings.Split(cmdLineStr, " ")})
+		if response.ExitCode != 0 {
+			t.Fatalf("exitCode %d\nstderr %s", response.ExitCode, response.Stderr)
+		}
+		return response.Outcome
+	}
+
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:       []string{"127.0.0.1:43210"},
+		DisableObjCache:       true,
+		SkipUnchangedFileName: stateFileName,
+		MaxLocalCxxProcesses:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome := compile(daemon, "g++ -MD -c main.cpp -o main.o"); outcome == client.OutcomeUnchanged {
+		t.Errorf("the first compilation can't be skipped")
+	}
+	if outcome := compile(daemon, "g++ -MD -c main.cpp -o main.o"); outcome != client.OutcomeUnchanged {
+		t.Errorf("expected to be skipped, outcome %q", outcome)
+	}
+	// flags changed
+	if outcome := compile(daemon, "g++ -MD -O2 -c main.cpp -o main.o"); outcome == client.OutcomeUnchanged {
+		t.Errorf("expected to be compiled after flags changed")
+	}
+	// without a complete depfile, it's never skipped
+	compile(daemon, "g++ -MMD -c main.cpp -o main.o")
+	if outcome := compile(daemon, "g++ -MMD -c main.cpp -o main.o"); outcome == client.OutcomeUnchanged {
+		t.Errorf("expected to be compiled with -MMD")
+	}
+	compile(daemon, "g++ -MD -c main.cpp -o main.o")
+	daemon.QuitDaemonGracefully("done")
+
+	// the state is kept between daemon launches
+	daemon, err = client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:       []string{"127.0.0.1:43210"},
+		DisableObjCache:       true,
+		SkipUnchangedFileName: stateFileName,
+		MaxLocalCxxProcesses:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+	if outcome := compile(daemon, "g++ -MD -c main.cpp -o main.o"); outcome != client.OutcomeUnchanged {
+		t.Errorf("expected to be skipped after daemon restart, outcome %q", outcome)
+	}
+	// a header changed
+	halfMinuteAgo := time.Now().Add(-30 * time.Second)
+	_ = os.Chtimes(hFile, halfMinuteAgo, halfMinuteAgo)
+	if outcome := compile(daemon, "g++ -MD -c main.cpp -o main.o"); outcome == client.OutcomeUnchanged {
+		t.Errorf("expected to be compiled after a header changed")
+	}
+	if outcome := compile(daemon, "g++ -MD -c main.cpp -o main.o"); outcome != client.OutcomeUnchanged {
+		t.Errorf("expected to be skipped again, outcome %q", outcome)
+	}
+	// the compiler upgraded: another g++ binary is found in PATH, the same cmd line must be compiled again
+	realCxx, err := exec.LookPath("g++")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cxxContents, err := os.ReadFile(realCxx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binDir := filepath.Join(dir, "bin")
+	_ = os.Mkdir(binDir, 0755)
+	if err := os.WriteFile(filepath.Join(binDir, "g++"), cxxContents, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if outcome := compile(daemon, "g++ -MD -c main.cpp -o main.o"); outcome == client.OutcomeUnchanged {
+		t.Errorf("expected to be compiled after the compiler changed")
+	}
+	if outcome := compile(daemon, "g++ -MD -c main.cpp -o main.o"); outcome != client.OutcomeUnchanged {
+		t.Errorf("expected to be skipped with the same compiler, outcome %q", outcome)
+	}
+}
+
+func Test_localCxxOverride(t *testing.T) {
+	dir := t.TempDir()
+	wrappersDir, markerFile := filepath.Join(dir, "wrappers"), filepath.Join(dir, "marker")
+	if err := os.Mkdir(wrappersDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// like /usr/lib/ccache/g++, a wrapper launches a real compiler
+	wrapper := "#!/bin/sh\necho \"$@\" > " + markerFile + "\nexec /usr/bin/g++ \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(wrappersDir, "g++"), []byte(wrapper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.cpp"), []byte("int f() { return 1; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43299"},
+		ConnectTimeout:       500 * time.Millisecond,
+		MaxLocalCxxProcesses: 1,
+		LocalCxxOverride:     filepath.Join(dir, "not-exists"),
+	}); err == nil {
+		t.Errorf("expected an error for a non-existing override")
+	}
+
+	for _, localCxxOverride := range []string{wrappersDir, filepath.Join(wrappersDir, "g++")} {
+		_ = os.Remove(markerFile)
+		// nobody listens on 43299, so everything is compiled locally
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts:      []string{"127.0.0.1:43299"},
+			ConnectTimeout:       500 * time.Millisecond,
+			MaxLocalCxxProcesses: 1,
+			LocalCxxOverride:     localCxxOverride,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-c", "main.cpp", "-o", "main.o"}})
+		daemon.QuitDaemonGracefully("done")
+		if response.ExitCode != 0 || response.Outcome != client.OutcomeLocal {
+			t.Fatalf("exitCode %d outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+		}
+		if marker, err := os.ReadFile(markerFile); err != nil || string(marker) != "-c main.cpp -o main.o\n" {
+			t.Errorf("override %s wasn't launched with original args: %q %v", localCxxOverride, marker, err)
+		}
+	}
+}
+
+func Test_syntaxOnly(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "valid.cpp"), []byte("int f() { return 1; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "invalid.cpp"), []byte("int f() { return 1 }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	// local cxx is disabled, so exitCode 0 means that it was checked remotely
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-fsyntax-only", "valid.cpp"}})
+	if response.ExitCode != 0 || !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+		t.Fatalf("exitCode %d outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+	}
+
+	response = daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-fsyntax-only", "-c", "invalid.cpp", "-o", "invalid.o"}})
+	if response.ExitCode == 0 || !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+		t.Fatalf("expected a remote error, exitCode %d outcome %q", response.ExitCode, response.Outcome)
+	}
+	if !strings.Contains(string(response.Stderr), "invalid.cpp:1") {
+		t.Errorf("an error isn't reported:\n%s", response.Stderr)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("expected no output files, found %d files in %s", len(entries), dir)
+	}
+}
+
+func Test_syntaxOnlyHeader(t *testing.T) {
+	// "every header compiles standalone" checks: a header is an input, there is no pch output
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "types.h"), []byte("#pragma once\n#include <vector>\nstruct A { std::vector<int> v; };\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "standalone.h"), []byte("#pragma once\n#include \"types.h\"\ninline int f(const A &a) { return a.v.size(); }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "not-standalone.h"), []byte("#pragma once\ninline int g(const A &a) { return a.v.size(); }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	// local cxx is disabled, so exitCode 0 means that it was checked remotely
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-fsyntax-only", "standalone.h"}})
+	if response.ExitCode != 0 || !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+		t.Fatalf("exitCode %d outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+	}
+
+	response = daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-fsyntax-only", "-x", "c++-header", "not-standalone.h", "-o", "not-standalone.h.gch"}})
+	if response.ExitCode == 0 || !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+		t.Fatalf("expected a remote error, exitCode %d outcome %q", response.ExitCode, response.Outcome)
+	}
+	if !strings.Contains(string(response.Stderr), "not-standalone.h:2") {
+		t.Errorf("an error isn't reported:\n%s", response.Stderr)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("expected no output files, found %d files in %s", len(entries), dir)
+	}
+}
+
+func Test_jitterStaysWithinBounds(t *testing.T) {
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		d := common.Jitter(10*time.Second, 0.2)
+		if d < 8*time.Second || d > 12*time.Second {
+			t.Fatalf("%v is out of ±20%% of 10s", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("expected different values")
+	}
+	if common.Jitter(10*time.Second, 0) != 10*time.Second {
+		t.Errorf("zero jitter must keep a duration as is")
+	}
+}
+
+func Test_recacheObjs(t *testing.T) {
+	dir := t.TempDir()
+	// unique contents, not to be found in obj cache after previous test runs
+	cppContents := fmt.Sprintf("int f() { return %d; }\n", time.Now().UnixNano()%1000000)
+	if err := os.WriteFile(filepath.Join(dir, "recache.cpp"), []byte(cppContents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	compile := func(recacheObjs bool) string {
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts: []string{"127.0.0.1:43210"},
+			RecacheObjs:     recacheObjs,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer daemon.QuitDaemonGracefully("done")
+		response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-c", "recache.cpp", "-o", "recache.o"}})
+		if response.ExitCode != 0 {
+			t.Fatalf("exitCode %d\nstderr %s", response.ExitCode, response.Stderr)
+		}
+		return strings.Fields(response.Outcome)[0]
+	}
+	// like `NOCC_RECACHE=1 nocc g++ ...`: only this invocation is recompiled, by a daemon without NOCC_RECACHE_OBJS
+	compileWithRecacheMarker := func() string {
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts: []string{"127.0.0.1:43210"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sockName := filepath.Join(dir, "nocc.sock")
+		if err := daemon.StartListeningUnixSocket(sockName); err != nil {
+			t.Fatal(err)
+		}
+		go daemon.ServeUntilNobodyAlive()
+		defer daemon.QuitDaemonGracefully("done")
+
+		conn, err := net.Dial("unix", sockName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("RECACHE\000" + dir + "\bg++\b-c\brecache.cpp\b-o\brecache.o\000")); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		response, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := strings.Split(string(response), "\000")
+		if len(parts) < 4 || parts[0] != "0" {
+			t.Fatalf("unexpected response %q", parts)
+		}
+		return strings.Fields(parts[3])[0]
+	}
+
+	for i, expected := range []struct {
+		compile func() string
+		outcome string
+	}{
+		{func() string { return compile(false) }, client.OutcomeRemote},
+		{func() string { return compile(false) }, client.OutcomeObjCache},
+		{func() string { return compile(true) }, client.OutcomeRemote}, // compiled again, replacing an obj in cache
+		{func() string { return compile(false) }, client.OutcomeObjCache},
+		{compileWithRecacheMarker, client.OutcomeRemote},
+		{func() string { return compile(false) }, client.OutcomeObjCache},
+	} {
+		if outcome := expected.compile(); outcome != expected.outcome {
+			t.Errorf("compilation %d: expected outcome %q, got %q", i+1, expected.outcome, outcome)
+		}
+	}
+}
+
+func Test_ccacheCompatEnv(t *testing.T) {
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		ccacheDisable, ccacheRecache string
+		disableObjCache, recacheObjs bool
+	}{
+		{"1", "", true, false},
+		{"", "yes", false, true},
+		{"false", "0", false, false},
+		{"", "", false, false},
+	} {
+		for envName, value := range map[string]string{"CCACHE_DISABLE": tc.ccacheDisable, "CCACHE_RECACHE": tc.ccacheRecache} {
+			if value != "" {
+				t.Setenv(envName, value)
+			} else {
+				t.Setenv(envName, "")
+				_ = os.Unsetenv(envName)
+			}
+		}
+		disableObjCache, recacheObjs := false, false
+		client.ApplyCcacheCompatEnv(&disableObjCache, &recacheObjs)
+		if disableObjCache != tc.disableObjCache || recacheObjs != tc.recacheObjs {
+			t.Errorf("CCACHE_DISABLE=%q CCACHE_RECACHE=%q: got %v %v", tc.ccacheDisable, tc.ccacheRecache, disableObjCache, recacheObjs)
+		}
+	}
+}
+
+func Test_serversFilenameMergesFiles(t *testing.T) {
+	dir := t.TempDir()
+	baseFile := filepath.Join(dir, "base.txt")
+	overlayFile := filepath.Join(dir, "overlay.txt")
+	if err := os.WriteFile(baseFile, []byte("# base pool\n10.0.0.1:43210\n10.0.0.2:43210 # rack 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(overlayFile, []byte("10.0.0.2:43210;\n\n  # extra hosts\n10.0.0.3:43210\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"10.0.0.1:43210", "10.0.0.2:43210", "10.0.0.3:43210"}
+	for _, envValue := range []string{baseFile + "," + overlayFile, baseFile + ":" + overlayFile} {
+		hosts, err := client.ReadNoccServersFiles(envValue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(hosts, ";") != strings.Join(expected, ";") {
+			t.Errorf("%s: unexpected hosts %v", envValue, hosts)
+		}
+	}
+
+	if _, err := client.ReadNoccServersFiles(baseFile + "," + filepath.Join(dir, "missing.txt")); err == nil {
+		t.Errorf("a missing file is not reported")
+	}
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VKCOM/nocc/internal/server"
+	"github.com/VKCOM/nocc/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_keepClientDirs(t *testing.T) {
+	if err := server.MakeLoggerServer("", -1); err != nil {
+		t.Fatal(err)
+	}
+	clientsDir := t.TempDir()
+	keptDirs := func() []string {
+		keptDirs, _ := filepath.Glob(filepath.Join(clientsDir, "kept.old.*"))
+		return keptDirs
+	}
+
+	for _, keepClientDirs := range []time.Duration{0, 500 * time.Millisecond} {
+		clients, _ := server.MakeClientsStorage(clientsDir, keepClientDirs)
+		client, err := clients.OnClientConnected("kept", server.ClientOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(client.MapClientFileNameToServerAbs("/proj"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(client.MapClientFileNameToServerAbs("/proj/1.cpp"), []byte("int f();\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		clients.DeleteClient(client)
+		time.Sleep(100 * time.Millisecond) // without keeping, it's removed in the background
+
+		if keepClientDirs == 0 {
+			if len(keptDirs()) != 0 {
+				t.Errorf("expected a working dir to be removed, got %v", keptDirs())
+			}
+			continue
+		}
+		if len(keptDirs()) != 1 {
+			t.Fatalf("expected a working dir to be kept, got %v", keptDirs())
+		}
+		if _, err := os.Stat(filepath.Join(keptDirs()[0], "proj/1.cpp")); err != nil {
+			t.Errorf("uploaded file not kept: %v", err)
+		}
+		clients.DeleteExpiredKeptClientDirs()
+		if len(keptDirs()) != 1 {
+			t.Errorf("a kept dir removed before its retention period")
+		}
+		time.Sleep(keepClientDirs + time.Second) // DeleteExpiredKeptClientDirs lists a dir at most once a second
+		clients.DeleteExpiredKeptClientDirs()
+		if len(keptDirs()) != 0 {
+			t.Errorf("a kept dir not removed after its retention period")
+		}
+	}
+}
+
+func Test_modifiedFileReplacedWhenUnused(t *testing.T) {
+	if err := server.MakeLoggerServer("", -1); err != nil {
+		t.Fatal(err)
+	}
+	clients, _ := server.MakeClientsStorage(t.TempDir(), 0)
+	client, err := clients.OnClientConnected("modified", server.ClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(sessionID uint32, sha256B0_7 uint64) *pb.StartCompilationSessionRequest {
+		return &pb.StartCompilationSessionRequest{
+			ClientID:      "modified",
+			SessionID:     sessionID,
+			CppInFile:     "/proj/1.cpp",
+			RequiredFiles: []*pb.FileMetadata{{ClientFileName: "/proj/1.cpp", FileSize: 9, SHA256_B0_7: sha256B0_7}},
+		}
+	}
+
+	first, err := client.CreateNewSession(request(1, 1), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// while a previous version is used, it can't be replaced, a client retries later
+	if _, err := client.CreateNewSession(request(2, 2), 0); status.Code(err) != codes.Aborted {
+		t.Errorf("expected codes.Aborted, got %v", err)
+	}
+	client.CloseSession(first)
+	// after that, a modified file is accepted, and a previous version can't be used anymore
+	second, err := client.CreateNewSession(request(3, 2), 0)
+	if err != nil {
+		t.Fatalf("a modified file was not replaced: %v", err)
+	}
+	if _, err := client.CreateNewSession(request(4, 1), 0); status.Code(err) != codes.Aborted {
+		t.Errorf("expected codes.Aborted, got %v", err)
+	}
+	client.CloseSession(second)
+}
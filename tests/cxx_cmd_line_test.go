@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VKCOM/nocc/internal/server"
+)
+
+func Test_includeDirsMovedToResponseFile(t *testing.T) {
+	// an artificially large -I set with a lowered threshold: real ARG_MAX can't be reached with gcc, see the func
+	dir := t.TempDir()
+	lastIDir := filepath.Join(dir, "last \"include\" dir")
+	if err := os.Mkdir(lastIDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(lastIDir, "last.h"), []byte("#define FROM_LAST 42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.cpp"), []byte("#include \"last.h\"\nint f() { return FROM_LAST; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.MakeLoggerServer("", -1); err != nil {
+		t.Fatal(err)
+	}
+	cxxCmdLine := make([]string, 0)
+	for i := 0; i < 500; i++ {
+		cxxCmdLine = append(cxxCmdLine, "-I", fmt.Sprintf("%s/non-existing/include dir/%03d", dir, i))
+	}
+	cxxCmdLine = append(cxxCmdLine, "-isystem", lastIDir, "-c", "-o", "main.o", "main.cpp")
+
+	same, removeRspFile, err := server.MoveIncludeDirsToResponseFile(cxxCmdLine, filepath.Join(dir, "main.o.rsp"), 1024*1024)
+	if err != nil || len(same) != len(cxxCmdLine) {
+		t.Fatalf("a short cmd line must be left as is, err %v", err)
+	}
+	removeRspFile()
+
+	rspCmdLine, removeRspFile, err := server.MoveIncludeDirsToResponseFile(cxxCmdLine, filepath.Join(dir, "main.o.rsp"), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rspCmdLine, " ") != "@"+filepath.Join(dir, "main.o.rsp")+" -c -o main.o main.cpp" {
+		t.Errorf("unexpected cmd line %v", rspCmdLine)
+	}
+	cxxCommand := exec.Command("g++", rspCmdLine...)
+	cxxCommand.Dir = dir
+	if output, err := cxxCommand.CombinedOutput(); err != nil {
+		t.Errorf("g++ failed with a response file: %v %s", err, output)
+	}
+	removeRspFile()
+	if _, err := os.Stat(filepath.Join(dir, "main.o.rsp")); !os.IsNotExist(err) {
+		t.Errorf("response file must be removed")
+	}
+}
+
+func Test_specsFileOption(t *testing.T) {
+	mapToServer := func(clientFileName string) string {
+		return "/tmp/nocc/cpp/clients/c1" + clientFileName
+	}
+	for cxxArg, expected := range map[string]string{
+		"-specs=/home/alice/my.specs":  "-specs=/tmp/nocc/cpp/clients/c1/home/alice/my.specs",
+		"--specs=/home/alice/my.specs": "--specs=/tmp/nocc/cpp/clients/c1/home/alice/my.specs",
+		"-specs=nano.specs":            "-specs=nano.specs", // looked up by cxx in its own dirs
+		"-O2":                          "-O2",
+	} {
+		if mapped := server.SpecsFileOption(cxxArg, mapToServer); mapped != expected {
+			t.Errorf("%s: expected %s, got %s", cxxArg, expected, mapped)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package tests
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
@@ -144,3 +145,108 @@ func Test_parseDepFile(t *testing.T) {
 		t.Errorf(strings.Join(diff2, "\n"))
 	}
 }
+
+func Test_workingDirectory(t *testing.T) {
+	// clang's -working-directory: relative paths are resolved from it, not from cwd
+	// g++ doesn't support it, but nocc strips it off, so compare with g++ launched right in that dir
+	outDir := t.TempDir()
+	var cmdLineStr = "g++ -working-directory=dt/dep1 -MD -MF " + outDir + "/wd.o.d -o " + outDir + "/wd.o -c 1.cpp"
+	exitCode, stdout, stderr, err := createClientAndEmulateDaemonForTesting(cmdLineStr)
+	if err != nil {
+		t.Fatalf("Error initing nocc client %v", err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("Nocc client exitCode %d\nstdout %s\nstderr %s", exitCode, stdout, stderr)
+	}
+	noccDepsOut, err := client.MakeDepFileFromFile(outDir + "/wd.o.d")
+	if err != nil {
+		t.Fatalf("Error parsing depfile after nocc: %v", err)
+	}
+
+	gccCmd := exec.Command("g++", "-MD", "-MF", outDir+"/gcc.o.d", "-o", outDir+"/gcc.o", "-c", "1.cpp")
+	gccCmd.Dir = "dt/dep1"
+	if output, err := gccCmd.CombinedOutput(); err != nil {
+		t.Fatalf("Error run gcc %v\n%s", err, output)
+	}
+	gccDepsOut, err := client.MakeDepFileFromFile(outDir + "/gcc.o.d")
+	if err != nil {
+		t.Fatalf("Error parsing depfile after gcc: %v", err)
+	}
+
+	depsBaseNames := func(depFile *client.DepFile) map[string]bool {
+		baseNames := make(map[string]bool)
+		for _, target := range depFile.DTargets {
+			for _, dep := range target.TargetDepList {
+				baseNames[path.Base(strings.ReplaceAll(dep, "\\ ", " "))] = true
+			}
+		}
+		return baseNames
+	}
+	noccDeps := depsBaseNames(noccDepsOut)
+	for gccDep := range depsBaseNames(gccDepsOut) {
+		if !noccDeps[gccDep] {
+			t.Errorf("Item '%s' exists in gcc depfile but not in nocc", gccDep)
+		}
+	}
+}
+
+func Test_outputsAreWrittenViaTempFiles(t *testing.T) {
+	// .o and .o.d are renamed from temp files, so an out dir contains only them, nothing partially written
+	outDir := t.TempDir()
+	var cmdLineStr = "g++ -MD -MF " + outDir + "/1.o.d -o " + outDir + "/1.o -c dt/dep1/1.cpp"
+	exitCode, stdout, stderr, err := createClientAndEmulateDaemonForTesting(cmdLineStr)
+	if err != nil {
+		t.Fatalf("Error initing nocc client %v", err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("Nocc client exitCode %d\nstdout %s\nstderr %s", exitCode, stdout, stderr)
+	}
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, " ") != "1.o 1.o.d" {
+		t.Errorf("unexpected files in out dir: %v", names)
+	}
+	if _, err := client.MakeDepFileFromFile(outDir + "/1.o.d"); err != nil {
+		t.Errorf("Error parsing depfile: %v", err)
+	}
+}
+
+func Test_MFDevStdout(t *testing.T) {
+	// -MF /dev/stdout is stdout of nocc (sent back by a daemon), like g++ prints it; nothing is created in /dev
+	outDir := t.TempDir()
+	var cmdLineStr = "g++ -MD -MF /dev/stdout -o " + outDir + "/1.o -c dt/dep1/1.cpp"
+	exitCode, gccStdout, err := runCmdLocallyForTesting(cmdLineStr)
+	if err != nil || exitCode != 0 {
+		t.Fatalf("Gcc exitCode %d %v\n%s", exitCode, err, gccStdout)
+	}
+	gccDepsOut, err := client.MakeDepFileFromBytes(gccStdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devEntriesBefore, _ := os.ReadDir("/dev")
+	exitCode, stdout, stderr, err := createClientAndEmulateDaemonForTesting(cmdLineStr)
+	if err != nil {
+		t.Fatalf("Error initing nocc client %v", err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("Nocc client exitCode %d\nstdout %s\nstderr %s", exitCode, stdout, stderr)
+	}
+	noccDepsOut, err := client.MakeDepFileFromBytes(stdout)
+	if err != nil {
+		t.Fatalf("Error parsing stdout %q: %v", stdout, err)
+	}
+	if errors := compareTwoDepfiles(noccDepsOut, gccDepsOut, "nocc stdout", "gcc stdout"); len(errors) != 0 {
+		t.Errorf("Diff if d contents:\n%s", strings.Join(errors, "\n"))
+	}
+	if devEntriesAfter, _ := os.ReadDir("/dev"); len(devEntriesAfter) != len(devEntriesBefore) {
+		t.Errorf("files in /dev changed: %d before, %d after", len(devEntriesBefore), len(devEntriesAfter))
+	}
+}
@@ -0,0 +1,6 @@
+// inc/sub is inside inc, which is also an -I dir: #include_next must continue after inc/sub, not after inc
+#include <next.h>
+
+int main() {
+  return NEXT;
+}
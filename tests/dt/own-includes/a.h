@@ -0,0 +1,9 @@
+#pragma once
+
+#include "sub/b.h"
+#include <lib/c.h>
+
+/* #include "commented-out.h" */
+// #include "commented-out.h"
+
+inline int a() { return b() + c(); }
@@ -0,0 +1,2 @@
+#pragma once
+#define CRLF_INNER 1
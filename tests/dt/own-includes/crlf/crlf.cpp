@@ -0,0 +1,6 @@
+#include "crlf.h"
+// #include "commented-out.h"
+
+int main() {
+  return crlf() + CRLF_INNER;
+}
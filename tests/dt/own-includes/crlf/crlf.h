@@ -0,0 +1,8 @@
+#pragma once
+
+/* #include "commented-out.h"
+ */
+#include <vector>
+#  include "crlf-inner.h"
+
+inline int crlf() { return (int)std::vector<int>{1, 2}.size(); }
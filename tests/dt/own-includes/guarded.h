@@ -0,0 +1,3 @@
+#ifndef GUARDED_H
+#define GUARDED_H
+#endif // GUARDED_H
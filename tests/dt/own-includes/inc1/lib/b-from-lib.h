@@ -0,0 +1,6 @@
+#pragma once
+/* a multiline comment
+ * #include "commented-out.h"
+ */
+#define B_FROM_LIB 4
+// #1 at the end without a newline
\ No newline at end of file
@@ -0,0 +1,5 @@
+#pragma once
+#include <wrap.h>
+#include "no-newline.h"
+
+inline int c() { return WRAPPED + NO_NEWLINE; }
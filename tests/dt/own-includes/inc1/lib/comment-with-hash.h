@@ -0,0 +1,3 @@
+#pragma once
+#define COMMENT_WITH_HASH 3
+#include "b-from-lib.h" // see #123
\ No newline at end of file
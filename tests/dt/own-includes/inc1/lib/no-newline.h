@@ -0,0 +1,3 @@
+#pragma once
+#include "comment-with-hash.h"
+#define NO_NEWLINE COMMENT_WITH_HASH
\ No newline at end of file
@@ -0,0 +1,2 @@
+#pragma once
+#define WRAPPED 1
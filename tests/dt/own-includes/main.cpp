@@ -0,0 +1,8 @@
+#include "a.h"
+#  include <vector>
+#include "quoted.h"
+
+int main() {
+  std::vector<int> v{a(), QUOTED, B_FROM_LIB};
+  return (int)v.size();
+}
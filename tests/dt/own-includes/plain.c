@@ -0,0 +1,5 @@
+#include <stdio.h>
+#include "sub/b2.h"
+#include <lib/no-newline.h>
+
+int f(void) { printf("%d", NO_NEWLINE + (int)b2()); return 0; }
\ No newline at end of file
@@ -0,0 +1,9 @@
+#ifndef SUB_B_H
+#define SUB_B_H
+
+#	include "b2.h"
+#include "../guarded.h"
+
+inline int b() { return b2(); }
+
+#endif
@@ -0,0 +1,3 @@
+#pragma once
+
+inline int b2() { return 2; }
@@ -0,0 +1,842 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/VKCOM/nocc/internal/client"
+)
+
+func Test_defaultIncludeDirsWithoutDevNull(t *testing.T) {
+	// emulate a platform without /dev/null: a compiler wrapper fails if /dev/null is passed in any way
+	fakeCxx := filepath.Join(t.TempDir(), "g++-no-dev-null")
+	script := `#!/bin/sh
+for arg in "$@"; do
+  [ "$arg" = "/dev/null" ] && exit 1
+done
+for fd in 0 1 2; do
+  [ "$(readlink /proc/$$/fd/$fd)" = "/dev/null" ] && exit 1
+done
+exec g++ "$@"
+`
+	if err := os.WriteFile(fakeCxx, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defIDirs, err := client.GetDefaultCxxIncludeDirsOnLocal(fakeCxx, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to detect default include dirs: %v", err)
+	}
+	if defIDirs.Count() == 0 {
+		t.Fatalf("no default include dirs detected")
+	}
+	if !strings.Contains(strings.Join(defIDirs.AsCxxArgs(), " "), "/usr/") {
+		t.Errorf("unexpected default include dirs %v", defIDirs.AsCxxArgs())
+	}
+}
+
+func Test_customBPrefix(t *testing.T) {
+	// a custom toolchain prefix: an assembler that leaves a marker, and a header found only via {prefix}/include
+	dirB := t.TempDir()
+	markerFile := filepath.Join(dirB, "as-was-called")
+	fakeAs := "#!/bin/sh\ntouch " + markerFile + "\nexec as \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(dirB, "as"), []byte(fakeAs), 0755); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Mkdir(filepath.Join(dirB, "include"), os.ModePerm)
+	if err := os.WriteFile(filepath.Join(dirB, "include", "nocc-b-prefix.h"), []byte("#define NOCC_B_PREFIX 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cppFile := filepath.Join(dirB, "b-prefix.cpp")
+	if err := os.WriteFile(cppFile, []byte("#include <nocc-b-prefix.h>\nint f() { return NOCC_B_PREFIX; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defIDirs, err := client.GetDefaultCxxIncludeDirsOnLocal("g++", []string{dirB + "/"}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to detect default include dirs: %v", err)
+	}
+	if !strings.Contains(strings.Join(defIDirs.AsCxxArgs(), " "), filepath.Join(dirB, "include")) {
+		t.Errorf("-B/include not detected in default include dirs %v", defIDirs.AsCxxArgs())
+	}
+
+	// -B points to a client-only dir, it can't be mirrored on a remote, so compiled locally with this -B
+	var cmdLineStr = "g++ -B " + dirB + "/ -c " + cppFile + " -o " + filepath.Join(dirB, "b-prefix.o")
+	exitCode, stdout, stderr, err := createClientAndEmulateDaemonWithLocalCxxForTesting(cmdLineStr)
+	if err != nil {
+		t.Fatalf("Error initing nocc client %s", err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("exitCode %d\nstdout %s\nstderr %s", exitCode, stdout, stderr)
+	}
+	if _, err := os.Stat(markerFile); err != nil {
+		t.Errorf("assembler from -B wasn't called")
+	}
+}
+
+func Test_cachedHeaderChangedWhileDaemonIsAlive(t *testing.T) {
+	// headers from -isystem dirs are cached in a daemon; if one is regenerated, a daemon must notice it
+	dir := t.TempDir()
+	hFile := filepath.Join(dir, "sys", "generated.h")
+	_ = os.Mkdir(filepath.Join(dir, "sys"), os.ModePerm)
+	if err := os.WriteFile(hFile, []byte("#define OLD_VALUE 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte("#include <generated.h>\nint f() { return OLD_VALUE; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "2.cpp"), []byte("#include <generated.h>\nint g() { return NEW_VALUE; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	// with a stale hash, the remote would compile 2.cpp with an old generated.h (already uploaded), failing on NEW_VALUE;
+	// with an actual one, the remote reports a conflict with an already uploaded file, and it's compiled locally
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43210"},
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	compile := func(cppName string) {
+		response := daemon.HandleInvocation(client.DaemonSockRequest{
+			Cwd:     dir,
+			CmdLine: []string{"g++", "-isystem", filepath.Join(dir, "sys"), "-c", filepath.Join(dir, cppName), "-o", filepath.Join(dir, cppName+".o")},
+		})
+		if response.ExitCode != 0 {
+			t.Errorf("%s: exitCode %d\nstdout %s\nstderr %s", cppName, response.ExitCode, response.Stdout, response.Stderr)
+		}
+	}
+
+	compile("1.cpp")
+
+	if err := os.WriteFile(hFile, []byte("#define NEW_VALUE 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Second) // mtime granularity may be coarse
+	_ = os.Chtimes(hFile, future, future)
+
+	compile("2.cpp")
+}
+
+func Test_generatedIncludeDirs(t *testing.T) {
+	// like Qt's ui_*.h: generated headers are cached, but every build dir has its own ones, and they are regenerated
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"debug/gen/ui_form.h":   "#define FORM_VALUE 1\n",
+		"release/gen/ui_form.h": "#define FORM_VALUE 2\n",
+		"form.cpp":              "#include <ui_form.h>\nint f() { return FORM_VALUE; }\n",
+		"late.cpp":              "#include <ui_late.h>\nint g() { return LATE_VALUE; }\n",
+	} {
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), os.ModePerm)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	generatedDirs := []string{filepath.Join(dir, "debug", "gen") + "/", filepath.Join(dir, "release", "gen") + "/"}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43210"},
+		MaxLocalCxxProcesses: 1,
+		GeneratedIncludeDirs: generatedDirs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	// an obj must be the same as compiled locally, whatever header was taken
+	compile := func(buildDir string, cppName string) {
+		genDir := filepath.Join(dir, buildDir, "gen")
+		objFile := filepath.Join(dir, buildDir, cppName+".o")
+		response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-I", genDir, "-c", cppName, "-o", objFile}})
+		if response.ExitCode != 0 {
+			t.Fatalf("%s/%s: exitCode %d\nstderr %s", buildDir, cppName, response.ExitCode, response.Stderr)
+		}
+		localObjFile := objFile + ".local.o"
+		if exitCode, output, _ := runCmdLocallyForTesting("g++ -I " + genDir + " -c " + filepath.Join(dir, cppName) + " -o " + localObjFile); exitCode != 0 {
+			t.Fatalf("local compilation failed: %s", output)
+		}
+		remoteObj, _ := os.ReadFile(objFile)
+		localObj, _ := os.ReadFile(localObjFile)
+		if len(remoteObj) == 0 || !bytes.Equal(remoteObj, localObj) {
+			t.Errorf("%s/%s: an obj differs from a local one", buildDir, cppName)
+		}
+	}
+
+	compile("debug", "form.cpp")
+	compile("release", "form.cpp") // <ui_form.h> isn't taken from a resolve cached for debug/gen
+
+	// regenerated with a new nested header
+	hFile := filepath.Join(dir, "debug", "gen", "ui_form.h")
+	_ = os.WriteFile(filepath.Join(dir, "debug", "gen", "ui_form_extra.h"), []byte("#define FORM_EXTRA 10\n"), 0644)
+	_ = os.WriteFile(hFile, []byte("#include \"ui_form_extra.h\"\n#define FORM_VALUE (3 + FORM_EXTRA)\n"), 0644)
+	future := time.Now().Add(time.Second) // mtime granularity may be coarse
+	_ = os.Chtimes(hFile, future, future)
+	compile("debug", "form.cpp")
+
+	// <ui_late.h> is not found until it's generated, this fact isn't cached
+	response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-I", filepath.Join(dir, "debug", "gen"), "-c", "late.cpp", "-o", filepath.Join(dir, "late.o")}})
+	if response.ExitCode == 0 {
+		t.Fatalf("ui_late.h doesn't exist yet, but compiled")
+	}
+	_ = os.WriteFile(filepath.Join(dir, "debug", "gen", "ui_late.h"), []byte("#define LATE_VALUE 4\n"), 0644)
+	compile("debug", "late.cpp")
+}
+
+func Test_includesCacheLimit(t *testing.T) {
+	// many system headers don't fit a small limit: the least recently used are evicted, and everything still works
+	dir := t.TempDir()
+	_ = os.Mkdir(filepath.Join(dir, "sys"), os.ModePerm)
+	cppContents := ""
+	for i := 0; i < 500; i++ {
+		if err := os.WriteFile(filepath.Join(dir, "sys", fmt.Sprintf("header_%d.h", i)), []byte(fmt.Sprintf("#define H_%d %d\n", i, i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cppContents += fmt.Sprintf("#include <header_%d.h>\n", i)
+	}
+	cppContents += "int f() { return H_499; }\n"
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte(cppContents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	const limit = 64 * 1024
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43210"},
+		DisableObjCache:      true,
+		MaxLocalCxxProcesses: 1,
+		IncludesCacheLimit:   limit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	// twice: the second time, a part of headers is taken from cache, and evicted ones are processed again
+	for i := 0; i < 2; i++ {
+		response := daemon.HandleInvocation(client.DaemonSockRequest{
+			Cwd:     dir,
+			CmdLine: []string{"g++", "-isystem", filepath.Join(dir, "sys"), "-c", "1.cpp", "-o", filepath.Join(dir, "1.o")},
+		})
+		if response.ExitCode != 0 || !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+			t.Fatalf("exitCode %d, outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+		}
+	}
+
+	includesCache, err := daemon.GetOrCreateIncludesCache("g++", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nHFiles, nResolves, sizeBytes, evictedCount := includesCache.GetStats()
+	if sizeBytes > limit || evictedCount == 0 || nHFiles == 0 || nResolves == 0 {
+		t.Errorf("unexpected includes cache stats: hFiles %d resolves %d sizeBytes %d evicted %d", nHFiles, nResolves, sizeBytes, evictedCount)
+	}
+}
+
+func Test_ownIncludesParserFindsAllCxxMDependencies(t *testing.T) {
+	// own includes parser may find more dependencies than `cxx -M` (it knows nothing about #ifdef), but never fewer
+	// dt/own-includes contains tricky cases: #include_next, -iquote, comments with #, files without a trailing newline, CRLF, etc.
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+	cwd, _ := os.Getwd()
+
+	ownIncludesDirs := "-I dt/own-includes/inc1 -I dt/own-includes/inc2 -iquote dt/own-includes/quoted"
+	cmdLines := []string{
+		"g++ " + ownIncludesDirs + " -c dt/own-includes/main.cpp -o main.o",
+		"g++ -std=c++17 -O2 " + ownIncludesDirs + " -c dt/own-includes/main.cpp -o main.o",
+		"gcc " + ownIncludesDirs + " -c dt/own-includes/plain.c -o plain.o",
+		"g++ -c dt/own-includes/crlf/crlf.cpp -o crlf.o",
+		"g++ -I dt/own-includes/inc1 -include dt/own-includes/guarded.h -c dt/own-includes/inc1/lib/c.h -o c.h.gch -I dt/own-includes/inc2",
+		"g++ -I dt/own-includes-next/inc -I dt/own-includes-next/inc/sub -I dt/own-includes-next/other -c dt/own-includes-next/main.cpp -o main.o",
+		"g++ -c dt/dep1/1.cpp -o 1.o",
+		"g++ -c dt/path-macro.cpp -o path-macro.o",
+		"g++ -I dt/cmake1/src -c dt/cmake1/src/main.cpp -o main.o",
+		"g++ -I dt/cmake1/src -c dt/cmake1/src/my-strings.cpp -o my-strings.o",
+	}
+
+	for _, cmdLineStr := range cmdLines {
+		invocation := client.ParseCmdLineInvocation(daemon, cwd, strings.Split(cmdLineStr, " "))
+		ownFoundHFiles, cppFile, err := invocation.CollectDependentIncludes(cwd, false, true)
+		if err != nil {
+			t.Fatalf("%s: own includes parser failed: %v", cmdLineStr, err)
+		}
+		ownFoundHFiles = append(ownFoundHFiles, &cppFile) // when compiling a header, cxx -M lists it as a dependency
+		cxxMFoundHFiles, _, err := invocation.CollectDependentIncludes(cwd, true, true)
+		if err != nil {
+			t.Fatalf("%s: cxx -M failed: %v", cmdLineStr, err)
+		}
+		if len(cxxMFoundHFiles) == 0 {
+			t.Errorf("%s: cxx -M found no dependencies", cmdLineStr)
+		}
+		if notFound := client.CompareOwnIncludesParserAndCxxM(cmdLineStr, ownFoundHFiles, cxxMFoundHFiles); len(notFound) != 0 {
+			t.Errorf("%s: not found by own includes parser: %v", cmdLineStr, notFound)
+		}
+	}
+}
+
+func Test_macroIncludeIsPreprocessedDirectivesOnly(t *testing.T) {
+	// own includes parser can't resolve #include MACRO, so a file is preprocessed locally, but still compiled remotely
+	dir := t.TempDir()
+	_ = os.Mkdir(filepath.Join(dir, "inc"), os.ModePerm)
+	if err := os.WriteFile(filepath.Join(dir, "inc", "value.h"), []byte("#define VALUE 42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cppFile := filepath.Join(dir, "macro-include.cpp")
+	cppContents := "#define VALUE_H <value.h>\n#include VALUE_H\nint f() { return VALUE + FROM_CMD + __LINE__; }\nconst char *g() { return __FILE__; }\n"
+	if err := os.WriteFile(cppFile, []byte(cppContents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// local cxx is disabled for testing, so exitCode 0 means that it was compiled remotely
+	objFile := filepath.Join(dir, "macro-include.o")
+	var cmdLineStr = "g++ -DFROM_CMD=1 -I " + filepath.Join(dir, "inc") + " -MD -c " + cppFile + " -o " + objFile
+	exitCode, stdout, stderr, err := createClientAndEmulateDaemonForTesting(cmdLineStr)
+	if err != nil {
+		t.Fatalf("Error initing nocc client %s", err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("exitCode %d\nstdout %s\nstderr %s", exitCode, stdout, stderr)
+	}
+
+	localObjFile := filepath.Join(dir, "macro-include-local.o")
+	if exitCode, output, _ := runCmdLocallyForTesting("g++ -DFROM_CMD=1 -I " + filepath.Join(dir, "inc") + " -c " + cppFile + " -o " + localObjFile); exitCode != 0 {
+		t.Fatalf("local compilation failed: %s", output)
+	}
+	remoteObj, _ := os.ReadFile(objFile)
+	localObj, _ := os.ReadFile(localObjFile)
+	if len(remoteObj) == 0 || !bytes.Equal(remoteObj, localObj) {
+		t.Errorf("an obj compiled remotely differs from a local one")
+	}
+
+	depFile, _ := os.ReadFile(filepath.Join(dir, "macro-include.d"))
+	if !strings.Contains(string(depFile), filepath.Join(dir, "inc", "value.h")) {
+		t.Errorf("value.h is missing in a depfile:\n%s", depFile)
+	}
+}
+
+func Test_macroIncludeInCxxDefaultDir(t *testing.T) {
+	// #include MACRO is ignored in cxx default include dirs (system headers), but not in a dir just sharing a prefix with one:
+	// a file preprocessed by own includes is uploaded as is, whereas a directives-only file is sent instead of a given up one
+	dir := t.TempDir()
+	secret := fmt.Sprintf("secret_%d", time.Now().UnixNano()) // unique contents, not to be found in src cache of a server
+	cppContents := "#ifdef NOCC_NEVER_DEFINED\n#define VALUE_H <value.h>\n#include VALUE_H\n#endif\nint f() { return 1; } // " + secret + "\n"
+	for _, cppFile := range []string{"sys/in-default-dir.cpp", "sys2/in-sibling-dir.cpp"} {
+		_ = os.MkdirAll(filepath.Join(dir, filepath.Dir(cppFile)), os.ModePerm)
+		if err := os.WriteFile(filepath.Join(dir, cppFile), []byte(cppContents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("CPLUS_INCLUDE_PATH", filepath.Join(dir, "sys")) // a default include dir outside /usr/
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	uploadsFile := filepath.Join(dir, "uploads.tsv")
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+		UploadsFileName: uploadsFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cppFile := range []string{"sys/in-default-dir.cpp", "sys2/in-sibling-dir.cpp"} {
+		response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-c", cppFile, "-o", cppFile + ".o"}})
+		if response.ExitCode != 0 {
+			t.Fatalf("%s: exitCode %d\nstderr %s", cppFile, response.ExitCode, response.Stderr)
+		}
+	}
+	daemon.QuitDaemonGracefully("done")
+
+	contents, _ := os.ReadFile(uploadsFile)
+	if !strings.Contains(string(contents), "\t"+filepath.Join(dir, "sys/in-default-dir.cpp")+"\n") {
+		t.Errorf("a file in a default include dir is not uploaded as is:\n%s", contents)
+	}
+	if strings.Contains(string(contents), "\t"+filepath.Join(dir, "sys2/in-sibling-dir.cpp")+"\n") {
+		t.Errorf("a file in a dir sharing a prefix with a default include dir is treated as a system one:\n%s", contents)
+	}
+}
+
+func Test_remoteErrorsPointToInputFile(t *testing.T) {
+	// a preprocessed file is sent instead of a source one, but errors must mention a source file, not a temporary one
+	dir := t.TempDir()
+	_ = os.Mkdir(filepath.Join(dir, "inc"), os.ModePerm)
+	if err := os.WriteFile(filepath.Join(dir, "inc", "value.h"), []byte("#define VALUE 42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cppFile := filepath.Join(dir, "broken.cpp")
+	cppContents := "#define VALUE_H <value.h>\n#include VALUE_H\nint f() { return VALUE + undeclared; }\n"
+	if err := os.WriteFile(cppFile, []byte(cppContents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var cmdLineStr = "g++ -I " + filepath.Join(dir, "inc") + " -c " + cppFile + " -o " + filepath.Join(dir, "broken.o")
+	exitCode, _, stderr, err := createClientAndEmulateDaemonForTesting(cmdLineStr)
+	if err != nil {
+		t.Fatalf("Error initing nocc client %s", err)
+	}
+	if exitCode == 0 {
+		t.Fatalf("expected a compilation error")
+	}
+	if !strings.Contains(string(stderr), cppFile+":3") || strings.Contains(string(stderr), "broken.ii") {
+		t.Errorf("stderr doesn't point to %s:\n%s", cppFile, stderr)
+	}
+	if strings.Contains(string(stderr), "nocc: failed to compile") {
+		t.Errorf("ordinary compiler output is not left as is:\n%s", stderr)
+	}
+}
+
+func Test_macroIncludeIsPreprocessedRewriteIncludes(t *testing.T) {
+	// the same for clang with NOCC_REWRITE_INCLUDES: -frewrite-includes inlines headers, and a file is compiled remotely
+	if _, err := exec.LookPath("clang++"); err != nil {
+		t.Skip("clang++ is not installed")
+	}
+	dir := t.TempDir()
+	_ = os.Mkdir(filepath.Join(dir, "inc"), os.ModePerm)
+	if err := os.WriteFile(filepath.Join(dir, "inc", "value.h"), []byte("#define VALUE 42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cppFile := filepath.Join(dir, "macro-include.cpp")
+	cppContents := "#define VALUE_H <value.h>\n#include VALUE_H\nint f() { return VALUE + FROM_CMD + __LINE__; }\nconst char *g() { return __FILE__; }\n"
+	if err := os.WriteFile(cppFile, []byte(cppContents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+		RewriteIncludes: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	// local cxx is disabled for testing, so exitCode 0 means that it was compiled remotely
+	objFile := filepath.Join(dir, "macro-include.o")
+	response := daemon.HandleInvocation(client.DaemonSockRequest{
+		Cwd:     dir,
+		CmdLine: strings.Split("clang++ -DFROM_CMD=1 -I "+filepath.Join(dir, "inc")+" -MD -c "+cppFile+" -o "+objFile, " "),
+	})
+	if response.ExitCode != 0 {
+		t.Fatalf("exitCode %d\nstdout %s\nstderr %s", response.ExitCode, response.Stdout, response.Stderr)
+	}
+
+	localObjFile := filepath.Join(dir, "macro-include-local.o")
+	if exitCode, output, _ := runCmdLocallyForTesting("clang++ -DFROM_CMD=1 -I " + filepath.Join(dir, "inc") + " -c " + cppFile + " -o " + localObjFile); exitCode != 0 {
+		t.Fatalf("local compilation failed: %s", output)
+	}
+	remoteObj, _ := os.ReadFile(objFile)
+	localObj, _ := os.ReadFile(localObjFile)
+	if len(remoteObj) == 0 || !bytes.Equal(remoteObj, localObj) {
+		t.Errorf("an obj compiled remotely differs from a local one")
+	}
+
+	depFile, _ := os.ReadFile(filepath.Join(dir, "macro-include.d"))
+	if !strings.Contains(string(depFile), filepath.Join(dir, "inc", "value.h")) {
+		t.Errorf("value.h is missing in a depfile:\n%s", depFile)
+	}
+}
+
+// Benchmark_macroIncludePreprocessedOrLocal measures the tradeoff of NOCC_REWRITE_INCLUDES (for clang) and -fdirectives-only (for gcc):
+// when own includes parser gives up on #include MACRO in a TU with many headers, it's preprocessed locally and compiled remotely
+// instead of being compiled locally. Both time per invocation and client CPU (a daemon and cxx launched by it) are reported.
+// Run it with `go test -run XXX -bench macroIncludePreprocessed ./tests/`, a server must be listening on 43210, like for other tests.
+func Benchmark_macroIncludePreprocessedOrLocal(b *testing.B) {
+	dir := b.TempDir()
+	const nHeaders = 500
+	cppContents := "#define VALUE_H <value.h>\n#include VALUE_H\n"
+	for i := 0; i < nHeaders; i++ {
+		hContents := fmt.Sprintf("#pragma once\n#include <vector>\n#include <map>\ninline int h%d() { std::map<int, std::vector<int>> m; m[%d].push_back(%d); return m.size(); }\n", i, i, i)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("h%d.h", i)), []byte(hContents), 0644); err != nil {
+			b.Fatal(err)
+		}
+		cppContents += fmt.Sprintf("#include \"h%d.h\"\n", i)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "value.h"), []byte("#define VALUE 42\n"), 0644); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte(cppContents+"int main() { return h0() + VALUE; }\n"), 0644); err != nil {
+		b.Fatal(err)
+	}
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		b.Fatal(err)
+	}
+
+	cxxArgs := []string{"-O2", "-I", dir, "-c", "1.cpp", "-o", "1.o"}
+	for _, mode := range []struct {
+		name string
+		cxx  string
+		opts *client.DaemonOptions // nil means local compilation, like a fallback does
+	}{
+		{"clang-local", "clang++", nil},
+		{"clang-rewrite-includes", "clang++", &client.DaemonOptions{RewriteIncludes: true}},
+		{"gcc-local", "g++", nil},
+		{"gcc-directives-only", "g++", &client.DaemonOptions{}},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			if _, err := exec.LookPath(mode.cxx); err != nil {
+				b.Skip(mode.cxx + " is not installed")
+			}
+			compile := func() {
+				cxxCommand := exec.Command(mode.cxx, cxxArgs...)
+				cxxCommand.Dir = dir
+				if output, err := cxxCommand.CombinedOutput(); err != nil {
+					b.Fatalf("local compilation failed: %v %s", err, output)
+				}
+			}
+			if mode.opts != nil {
+				opts := *mode.opts
+				opts.RemoteNoccHosts = []string{"127.0.0.1:43210"}
+				opts.DisableObjCache = true // otherwise, only the first remote compilation is measured
+				daemon, err := client.MakeDaemon(opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer daemon.QuitDaemonGracefully("done")
+				compile = func() {
+					// local cxx is disabled for testing, so exitCode 0 means that it was compiled remotely
+					response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: append([]string{mode.cxx}, cxxArgs...)})
+					if response.ExitCode != 0 {
+						b.Fatalf("exitCode %d\nstderr %s", response.ExitCode, response.Stderr)
+					}
+				}
+			}
+
+			b.ResetTimer()
+			cpuStart := clientCPUTimeForTesting()
+			for i := 0; i < b.N; i++ {
+				compile()
+			}
+			b.ReportMetric(float64((clientCPUTimeForTesting()-cpuStart).Milliseconds())/float64(b.N), "client-cpu-ms/op")
+		})
+	}
+}
+
+func Test_idirafterIsSearchedLast(t *testing.T) {
+	// only-after.h exists only in -idirafter dir; shadowed.h exists in both, and a -I one must win
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"after/only-after.h": "#define ONLY_AFTER 1\n",
+		"after/shadowed.h":   "#define SHADOWED 100\n",
+		"inc/shadowed.h":     "#define SHADOWED 2\n",
+		"idirafter.cpp":      "#include <only-after.h>\n#include \"shadowed.h\"\nint f() { return ONLY_AFTER + SHADOWED; }\n",
+	} {
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), os.ModePerm)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cppFile := filepath.Join(dir, "idirafter.cpp")
+	includeFlags := "-idirafter " + filepath.Join(dir, "after") + " -I " + filepath.Join(dir, "inc")
+
+	// local cxx is disabled for testing, so exitCode 0 means that it was compiled remotely
+	objFile := filepath.Join(dir, "idirafter.o")
+	exitCode, stdout, stderr, err := createClientAndEmulateDaemonForTesting("g++ " + includeFlags + " -c " + cppFile + " -o " + objFile)
+	if err != nil {
+		t.Fatalf("Error initing nocc client %s", err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("exitCode %d\nstdout %s\nstderr %s", exitCode, stdout, stderr)
+	}
+
+	localObjFile := filepath.Join(dir, "idirafter-local.o")
+	if exitCode, output, _ := runCmdLocallyForTesting("g++ " + includeFlags + " -c " + cppFile + " -o " + localObjFile); exitCode != 0 {
+		t.Fatalf("local compilation failed: %s", output)
+	}
+	remoteObj, _ := os.ReadFile(objFile)
+	localObj, _ := os.ReadFile(localObjFile)
+	if len(remoteObj) == 0 || !bytes.Equal(remoteObj, localObj) {
+		t.Errorf("an obj compiled remotely differs from a local one")
+	}
+}
+
+func Test_ownIncludesLimitsFallbackToCxxM(t *testing.T) {
+	// deep.cpp -> h1.h -> h2.h -> ... -> h6.h, it exceeds both limits, but all dependencies must be found by cxx -M
+	dir := t.TempDir()
+	const chainLen = 6
+	for i := 1; i <= chainLen; i++ {
+		contents := fmt.Sprintf("#pragma once\n#include \"h%d.h\"\n", i+1)
+		if i == chainLen {
+			contents = "#pragma once\n#define DEEP 6\n"
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("h%d.h", i)), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cppFile := filepath.Join(dir, "deep.cpp")
+	if err := os.WriteFile(cppFile, []byte("#include \"h1.h\"\nint f() { return DEEP; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limits := range [][2]int64{{3, 0}, {0, 3}} {
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts:     []string{"127.0.0.1:43210"},
+			OwnIncludesMaxDepth: limits[0],
+			OwnIncludesMaxFiles: limits[1],
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		invocation := client.ParseCmdLineInvocation(daemon, dir, []string{"g++", "-c", cppFile, "-o", filepath.Join(dir, "deep.o")})
+		hFiles, _, err := invocation.CollectDependentIncludes(dir, false, true)
+		if err != nil {
+			t.Errorf("limits %v: collecting includes failed: %v", limits, err)
+		}
+		nFound := 0
+		for _, hFile := range hFiles {
+			if strings.HasPrefix(hFile.ToPbFileMetadata().ClientFileName, dir+"/h") {
+				nFound++
+			}
+		}
+		if nFound != chainLen {
+			t.Errorf("limits %v: expected %d headers, found %d", limits, chainLen, nFound)
+		}
+		daemon.QuitDaemonGracefully("done")
+	}
+}
+
+func Test_collectIncludesOnServer(t *testing.T) {
+	// includes are resolved by a server with cxx -M, even #include MACRO (own includes parser gives up on it)
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"inc/lib/lib.h":       "#pragma once\n#include \"lib-inner.h\"\n",
+		"inc/lib/lib-inner.h": "#define LIB 1\n",
+		"src/lib-inner.h":     "#define LIB 100\n", // not the one lib.h includes, but a client can't tell, see CollectDependentIncludesOnServer
+		"src/a.h":             "#pragma once\n#include <lib/lib.h>\n#define INNER_H \"inner.h\"\n#include INNER_H\n",
+		"src/inner.h":         "#define INNER 2\n",
+		"src/1.cpp":           "#include \"a.h\"\nint f() { return LIB + INNER; }\n",
+		"src/2.cpp":           "#include \"a.h\"\nint g() { return LIB - INNER; }\n",
+	} {
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), os.ModePerm)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	summaryFile := filepath.Join(dir, "summary.tsv")
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:  []string{"127.0.0.1:43210"},
+		IncludesOnServer: true,
+		SummaryFileName:  summaryFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2.cpp is compiled when a server already has all headers
+	for _, cppName := range []string{"1.cpp", "2.cpp"} {
+		objFile := filepath.Join(dir, cppName+".o")
+		response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: filepath.Join(dir, "src"), CmdLine: []string{"g++", "-I", "../inc", "-c", cppName, "-o", objFile}})
+		if response.ExitCode != 0 {
+			t.Fatalf("%s: exitCode %d\nstderr %s", cppName, response.ExitCode, response.Stderr)
+		}
+		localObjFile := filepath.Join(dir, cppName+".local.o")
+		if exitCode, output, _ := runCmdLocallyForTesting("g++ -I " + filepath.Join(dir, "inc") + " -c " + filepath.Join(dir, "src", cppName) + " -o " + localObjFile); exitCode != 0 {
+			t.Fatalf("local compilation failed: %s", output)
+		}
+		remoteObj, _ := os.ReadFile(objFile)
+		localObj, _ := os.ReadFile(localObjFile)
+		if len(remoteObj) == 0 || !bytes.Equal(remoteObj, localObj) {
+			t.Errorf("%s: an obj compiled remotely differs from a local one", cppName)
+		}
+	}
+	daemon.QuitDaemonGracefully("done")
+
+	contents, _ := os.ReadFile(summaryFile)
+	if strings.Count(string(contents), "collected_includes_on_server=") != 2 {
+		t.Errorf("includes were not collected on a server:\n%s", contents)
+	}
+}
+
+// clientCPUTimeForTesting is CPU time spent by this process (a daemon is inside it) and by its children (cxx -M)
+func clientCPUTimeForTesting() time.Duration {
+	var self, children syscall.Rusage
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, &self)
+	_ = syscall.Getrusage(syscall.RUSAGE_CHILDREN, &children)
+	total := time.Duration(0)
+	for _, usage := range []syscall.Rusage{self, children} {
+		total += time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	}
+	return total
+}
+
+// Benchmark_collectIncludesOnServer compares client CPU spent per invocation of a TU with many headers,
+// when includes are collected by own includes parser, by local cxx -M, and by a server (NOCC_COLLECT_INCLUDES_ON_SERVER).
+// Run it with `go test -run XXX -bench collectIncludes ./tests/`, a server must be listening on 43210, like for other tests.
+func Benchmark_collectIncludesOnServer(b *testing.B) {
+	dir := b.TempDir()
+	const nHeaders = 500
+	cppContents := ""
+	for i := 0; i < nHeaders; i++ {
+		hContents := fmt.Sprintf("#pragma once\n#include <vector>\ninline int h%d() { return %d; }\n", i, i)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("h%d.h", i)), []byte(hContents), 0644); err != nil {
+			b.Fatal(err)
+		}
+		cppContents += fmt.Sprintf("#include \"h%d.h\"\n", i)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte(cppContents+"int main() { return h0(); }\n"), 0644); err != nil {
+		b.Fatal(err)
+	}
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, mode := range []struct {
+		name string
+		opts client.DaemonOptions
+	}{
+		{"own-includes-parser", client.DaemonOptions{}},
+		{"local-cxx-M", client.DaemonOptions{DisableOwnIncludes: true}},
+		{"on-server", client.DaemonOptions{IncludesOnServer: true}},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			opts := mode.opts
+			opts.RemoteNoccHosts = []string{"127.0.0.1:43210"}
+			daemon, err := client.MakeDaemon(opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer daemon.QuitDaemonGracefully("done")
+
+			b.ResetTimer()
+			cpuStart := clientCPUTimeForTesting()
+			for i := 0; i < b.N; i++ {
+				response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-c", "1.cpp", "-o", "1.o"}})
+				if response.ExitCode != 0 {
+					b.Fatalf("exitCode %d\nstderr %s", response.ExitCode, response.Stderr)
+				}
+			}
+			b.ReportMetric(float64((clientCPUTimeForTesting()-cpuStart).Milliseconds())/float64(b.N), "client-cpu-ms/op")
+		})
+	}
+}
+
+func Test_defaultIncludeDirsPerMultilib(t *testing.T) {
+	defIDirs, err := client.GetDefaultCxxIncludeDirsOnLocal("g++", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defIDirs32, err := client.GetDefaultCxxIncludeDirsOnLocal("g++", nil, nil, []string{"-m32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defIDirs32.Count() == 0 || strings.Join(defIDirs.AsCxxArgs(), " ") == strings.Join(defIDirs32.AsCxxArgs(), " ") {
+		t.Skipf("g++ has no separate multilib for -m32 here: %v", defIDirs32.AsCxxArgs())
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{"127.0.0.1:43210"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	cache, err := daemon.GetOrCreateIncludesCache("g++", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache32, err := daemon.GetOrCreateIncludesCache("g++", nil, nil, []string{"-m32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache == cache32 {
+		t.Errorf("-m32 must have its own includes cache")
+	}
+	if cacheAgain, _ := daemon.GetOrCreateIncludesCache("g++", nil, nil, []string{"-m32"}); cacheAgain != cache32 {
+		t.Errorf("includes cache for -m32 must be reused")
+	}
+
+	// Apple universal binaries: g++ doesn't know -arch, header sets can't be resolved, so it's left for local compilation
+	if _, err := daemon.GetOrCreateIncludesCache("g++", nil, nil, []string{"-arch", "x86_64", "-arch", "arm64"}); err == nil {
+		t.Errorf("expected an error for unresolved -arch header sets")
+	}
+}
+
+func Test_specsFile(t *testing.T) {
+	dir := t.TempDir()
+	unique := fmt.Sprintf("%d", time.Now().UnixNano()) // not to be found in src cache of a server
+	for name, contents := range map[string]string{
+		"sysroot/include/sysval.h": "#define SYSVAL 42 // " + unique + "\n",
+		"forced.h":                 "#define FORCED 1\n",
+		"a.cpp":                    "#include <sysval.h>\nint f() { return SYSVAL + FROM_SPECS; }\n",
+		"b.cpp":                    "int g() { return FORCED; }\n",
+		// an include dir in a specs file becomes a default one: it's passed explicitly and mirrored on a server
+		"sysroot.specs": "*cc1plus:\n+ -isystem " + filepath.Join(dir, "sysroot/include") + " -DFROM_SPECS=1 -DSPECS_ID=" + unique + "\n\n",
+		// a file is not mirrored on a server (it isn't uploaded), it can be compiled only locally
+		"forced.specs": "*cc1plus:\n+ -include " + filepath.Join(dir, "forced.h") + "\n\n",
+	} {
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	uploadsFile := filepath.Join(dir, "uploads.tsv")
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{"127.0.0.1:43210"},
+		DisableObjCache:      true,
+		MaxLocalCxxProcesses: 1,
+		UploadsFileName:      uploadsFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-specs=sysroot.specs", "-c", "a.cpp", "-o", "a.o"}})
+	if response.ExitCode != 0 || !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+		t.Errorf("expected a.cpp to be compiled remotely, exitCode %d, outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+	}
+	response = daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-specs=forced.specs", "-c", "b.cpp", "-o", "b.o"}})
+	if response.ExitCode != 0 || response.Outcome != client.OutcomeLocal {
+		t.Errorf("expected b.cpp to be compiled locally, exitCode %d, outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+	}
+	daemon.QuitDaemonGracefully("done")
+
+	// a specs file is uploaded along with a header from a dir it adds
+	uploads, _ := os.ReadFile(uploadsFile)
+	for _, uploaded := range []string{filepath.Join(dir, "sysroot.specs"), filepath.Join(dir, "sysroot/include/sysval.h")} {
+		if !strings.Contains(string(uploads), "\t"+uploaded+"\n") {
+			t.Errorf("%s was not uploaded:\n%s", uploaded, uploads)
+		}
+	}
+}
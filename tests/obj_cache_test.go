@@ -0,0 +1,388 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VKCOM/nocc/internal/common"
+	"github.com/VKCOM/nocc/internal/server"
+)
+
+func Test_objCacheKeyIgnoresIrrelevantArgs(t *testing.T) {
+	cache := &server.ObjFileCache{}
+	cxxArgs := []string{"-Wall", "-O2", "-std=c++17"}
+	keyWithoutPipe := cache.MakeObjCacheKey("g++", cxxArgs, nil, "/home/user/proj/1.cpp")
+
+	for _, irrelevantArgs := range [][]string{
+		{"-pipe", "-Wall", "-O2", "-std=c++17"},
+		{"-Wall", "-O2", "-pipe", "-std=c++17", "-Wl,--as-needed", "-L/usr/lib/custom"},
+	} {
+		key := cache.MakeObjCacheKey("g++", irrelevantArgs, nil, "/home/user/proj/1.cpp")
+		if key != keyWithoutPipe {
+			t.Errorf("obj cache key changed with %v", irrelevantArgs)
+		}
+	}
+
+	keyO3 := cache.MakeObjCacheKey("g++", []string{"-Wall", "-O3", "-std=c++17", "-pipe"}, nil, "/home/user/proj/1.cpp")
+	if keyO3 == keyWithoutPipe {
+		t.Errorf("obj cache key must depend on -O3")
+	}
+}
+
+func Test_objCacheKeyIsOrderSensitive(t *testing.T) {
+	cache := &server.ObjFileCache{}
+	key := cache.MakeObjCacheKey("g++", []string{"-include", "a.h", "-include", "b.h"}, nil, "/home/user/proj/1.cpp")
+
+	for _, otherArgs := range [][]string{
+		{"-include", "b.h", "-include", "a.h"},
+		{"-includea.h", "-include", "b.h"},
+	} {
+		if cache.MakeObjCacheKey("g++", otherArgs, nil, "/home/user/proj/1.cpp") == key {
+			t.Errorf("obj cache key must differ for %v", otherArgs)
+		}
+	}
+}
+
+func Test_objCacheKeyDepsSwapped(t *testing.T) {
+	// with XOR-folding, two deps swapping their contents gave the same key
+	cache := &server.ObjFileCache{}
+	shaA, shaB := common.SHA256{B0_7: 1, B24_31: 2}, common.SHA256{B0_7: 3, B24_31: 4}
+	key := cache.MakeObjCacheKey("g++", []string{"-O2"}, server.MakeSessionFilesForTesting([]int64{10, 20}, []common.SHA256{shaA, shaB}), "1.cpp")
+	keySwapped := cache.MakeObjCacheKey("g++", []string{"-O2"}, server.MakeSessionFilesForTesting([]int64{10, 20}, []common.SHA256{shaB, shaA}), "1.cpp")
+	if key == keySwapped {
+		t.Errorf("obj cache key must differ when deps swap their contents")
+	}
+}
+
+// objCacheKeyXorFoldForBenchmark is how MakeObjCacheKey folded deps before they were hashed in one sha256 stream,
+// it's kept only as a baseline for Benchmark_objCacheKey.
+func objCacheKeyXorFoldForBenchmark(cxxName string, cxxArgs []string, fileSizes []int64, fileSHA256s []common.SHA256, cppInFile string) common.SHA256 {
+	hasher := sha256.New()
+	hasher.Write([]byte(cxxName))
+	for _, arg := range cxxArgs {
+		hasher.Write([]byte(arg))
+	}
+	hasher.Write([]byte(filepath.Base(cppInFile)))
+
+	sha256xor := common.MakeSHA256Struct(hasher)
+	sha256xor.B8_15 ^= uint64(len(cxxArgs))
+	sha256xor.B16_23 ^= uint64(len(fileSizes))
+	for i := range fileSizes {
+		sha256xor.XorWith(&fileSHA256s[i])
+		sha256xor.B0_7 ^= uint64(fileSizes[i])
+	}
+	return sha256xor
+}
+
+// Benchmark_objCacheKey measures an obj cache key of a session with many deps (it's calculated on every session open),
+// hashed in one sha256 stream compared to XOR-folding as it was before.
+// Run it with `go test -run XXX -bench objCacheKey ./tests/`.
+func Benchmark_objCacheKey(b *testing.B) {
+	const nDeps = 800
+	cxxArgs := []string{"-Wall", "-O2", "-std=c++17", "-DNDEBUG", "-fPIC"}
+	fileSizes := make([]int64, nDeps)
+	fileSHA256s := make([]common.SHA256, nDeps)
+	for i := 0; i < nDeps; i++ {
+		fileSizes[i] = int64(1000 + i)
+		fileSHA256s[i] = common.SHA256{B0_7: uint64(i), B8_15: uint64(i) * 31, B16_23: uint64(i) * 131, B24_31: uint64(i) * 1031}
+	}
+
+	b.Run("sha256-stream", func(b *testing.B) {
+		cache := &server.ObjFileCache{}
+		sessionFiles := server.MakeSessionFilesForTesting(fileSizes, fileSHA256s)
+		for i := 0; i < b.N; i++ {
+			cache.MakeObjCacheKey("g++", cxxArgs, sessionFiles, "/home/user/proj/1.cpp")
+		}
+	})
+	b.Run("xor-fold", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			objCacheKeyXorFoldForBenchmark("g++", cxxArgs, fileSizes, fileSHA256s, "/home/user/proj/1.cpp")
+		}
+	})
+}
+
+func Test_objCacheKeyNamespaced(t *testing.T) {
+	cache := &server.ObjFileCache{}
+	key := cache.MakeObjCacheKey("g++", []string{"-O2"}, nil, "/home/user/proj/1.cpp")
+
+	keyA, keyB := server.NamespaceObjCacheKey(key, "agent-a"), server.NamespaceObjCacheKey(key, "agent-b")
+	if keyA == key || keyB == key || keyA == keyB {
+		t.Errorf("obj cache keys must differ between namespaces")
+	}
+	if server.NamespaceObjCacheKey(key, "agent-a") != keyA {
+		t.Errorf("a namespaced key must be stable")
+	}
+}
+
+func Test_fileCacheReconcilesSizeOnDisk(t *testing.T) {
+	if err := server.MakeLoggerServer("", -1); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	_ = os.Mkdir(filepath.Join(dir, "cache"), os.ModePerm)
+	cache, err := server.MakeFileCache(filepath.Join(dir, "cache"), 1024*1024, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []common.SHA256
+	for i, contents := range []string{"1234567890", "12345", "123"} {
+		srcFile := filepath.Join(dir, strconv.Itoa(i)+".txt")
+		if err := os.WriteFile(srcFile, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		key := common.SHA256{B0_7: uint64(i + 1)}
+		if err := cache.SaveFileToCache(srcFile, strconv.Itoa(i)+".txt", key, int64(len(contents))); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if cache.GetBytesOnDisk() != 18 {
+		t.Fatalf("unexpected size %d", cache.GetBytesOnDisk())
+	}
+
+	// remove one file and overwrite another out-of-band: the counter drifts until reconciliation
+	_ = os.Remove(cache.LookupInCache(keys[0]))
+	_ = os.WriteFile(cache.LookupInCache(keys[1]), []byte("1234567"), 0644)
+	cache.ReconcileSizeOnDisk()
+
+	if cache.GetBytesOnDisk() != 7+3 {
+		t.Errorf("unexpected size after reconciliation %d", cache.GetBytesOnDisk())
+	}
+	if cache.GetFilesCount() != 2 || cache.LookupInCache(keys[0]) != "" {
+		t.Errorf("a removed file must be dropped from the cache")
+	}
+}
+
+func Test_fileCachePurgeOlderThan(t *testing.T) {
+	if err := server.MakeLoggerServer("", -1); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	_ = os.Mkdir(filepath.Join(dir, "cache"), os.ModePerm)
+	cache, err := server.MakeFileCache(filepath.Join(dir, "cache"), 1024*1024, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []common.SHA256
+	for i, contents := range []string{"1234567890", "12345", "123"} {
+		srcFile := filepath.Join(dir, strconv.Itoa(i)+".txt")
+		if err := os.WriteFile(srcFile, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		key := common.SHA256{B0_7: uint64(i + 1)}
+		if err := cache.SaveFileToCache(srcFile, strconv.Itoa(i)+".txt", key, int64(len(contents))); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	// the first file is accessed again, so it becomes fresh, while the other two stay stale
+	time.Sleep(200 * time.Millisecond)
+	pathOfFresh := cache.LookupInCache(keys[0])
+	purgedCount, purgedBytes := cache.PurgeOlderThan(100 * time.Millisecond)
+	if purgedCount != 2 || purgedBytes != 8 {
+		t.Errorf("unexpected purged %d files %d bytes", purgedCount, purgedBytes)
+	}
+	if cache.GetFilesCount() != 1 || cache.GetBytesOnDisk() != 10 || cache.LookupInCache(keys[1]) != "" {
+		t.Errorf("unexpected cache state: %d files %d bytes", cache.GetFilesCount(), cache.GetBytesOnDisk())
+	}
+	// they are counted separately from evictions because of limits, which mean that a cache is too small
+	if cache.GetPurgedByTTLCount() != 2 || cache.GetPurgedByTTLBytes() != 8 || cache.GetPurgedFilesCount() != 0 || cache.GetPurgedBytes() != 0 {
+		t.Errorf("unexpected counters: by ttl %d files %d bytes, by limits %d files %d bytes", cache.GetPurgedByTTLCount(), cache.GetPurgedByTTLBytes(), cache.GetPurgedFilesCount(), cache.GetPurgedBytes())
+	}
+	if _, err := os.Stat(pathOfFresh); err != nil {
+		t.Errorf("a fresh file must be kept: %v", err)
+	}
+
+	if purgedCount, _ := cache.PurgeOlderThan(time.Hour); purgedCount != 0 {
+		t.Errorf("nothing must be purged, purged %d", purgedCount)
+	}
+}
+
+func Test_checkHardLinks(t *testing.T) {
+	if err := server.MakeLoggerServer("", -1); err != nil {
+		t.Fatal(err)
+	}
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	if err := server.CheckHardLinks(srcDir, dstDir); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{srcDir, dstDir} {
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("a probe file left in %s", dir)
+		}
+	}
+	// different filesystems are fine (files are copied), but not being able to link at all is not
+	if _, err := os.Stat("/dev/shm"); err == nil {
+		if err := server.CheckHardLinks(srcDir, "/dev/shm"); err != nil {
+			t.Errorf("cross-device dirs must be allowed: %v", err)
+		}
+	}
+	if err := server.CheckHardLinks(srcDir, filepath.Join(dstDir, "not-exists")); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func Test_fileCacheWithFsync(t *testing.T) {
+	if err := server.MakeLoggerServer("", -1); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	srcFile := filepath.Join(dir, "1.txt")
+	if err := os.WriteFile(srcFile, []byte("12345"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fsync := range []bool{false, true} {
+		cacheDir := filepath.Join(dir, fmt.Sprintf("cache-%v", fsync))
+		_ = os.Mkdir(cacheDir, os.ModePerm)
+		cache, err := server.MakeFileCache(cacheDir, 1024*1024, fsync)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := common.SHA256{B0_7: 1}
+		if err := cache.SaveFileToCache(srcFile, "1.txt", key, 5); err != nil {
+			t.Fatal(err)
+		}
+		if contents, _ := os.ReadFile(cache.LookupInCache(key)); string(contents) != "12345" {
+			t.Errorf("unexpected contents in cache %q", contents)
+		}
+		// a saved file and its dir are flushed, only if enabled
+		expectedFsyncs := int64(0)
+		if fsync {
+			expectedFsyncs = 2
+		}
+		if cache.GetFsyncCount() != expectedFsyncs {
+			t.Errorf("fsync %v: expected %d flushes, got %d", fsync, expectedFsyncs, cache.GetFsyncCount())
+		}
+
+		// a failed save leaves nothing in the cache, and nothing is flushed
+		if err := cache.SaveFileToCache(filepath.Join(dir, "nonexisting.txt"), "2.txt", common.SHA256{B0_7: 2}, 5); err == nil {
+			t.Errorf("expected an error saving a non-existing file")
+		}
+		if cache.GetFilesCount() != 1 || cache.GetFsyncCount() != expectedFsyncs {
+			t.Errorf("unexpected files count %d, flushes %d", cache.GetFilesCount(), cache.GetFsyncCount())
+		}
+	}
+}
+
+func Test_objCacheKeyDependsOnTarget(t *testing.T) {
+	cache := &server.ObjFileCache{}
+	keys := make(map[common.SHA256][]string)
+	for _, cxxArgs := range [][]string{
+		{"-O2"},
+		{"-O2", "-m32"},
+		{"-O2", "-mx32"},
+		{"-target", "aarch64-linux-gnu", "-O2"},
+		{"--target=x86_64-linux-gnu", "-O2"},
+		{"-target", "aarch64-linux-gnu", "-O2", "-m32"},
+	} {
+		key := cache.MakeObjCacheKey("clang++", cxxArgs, nil, "/home/user/proj/1.cpp")
+		if other, exists := keys[key]; exists {
+			t.Errorf("obj cache keys for %v and %v are equal", cxxArgs, other)
+		}
+		keys[key] = cxxArgs
+	}
+
+	for cxxArgs, expected := range map[string]string{
+		"-O2":                                    "",
+		"-m32 -O2 -m64":                          "m64",
+		"-target aarch64-linux-gnu -m32":         "aarch64-linux-gnu m32",
+		"--target=arm-none-eabi -target riscv64": "riscv64",
+	} {
+		if target := common.ParseCxxTarget(strings.Split(cxxArgs, " ")); target != expected {
+			t.Errorf("%s: expected target '%s', got '%s'", cxxArgs, expected, target)
+		}
+	}
+}
+
+func Test_objCacheServesObjsForTheTarget(t *testing.T) {
+	// the same source compiled for two targets (twice, the second time from obj cache) must produce different objs
+	dir := t.TempDir()
+	cppFile := filepath.Join(dir, "target.cpp")
+	if err := os.WriteFile(cppFile, []byte("long f(long a) { return a * 2; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		for _, model := range []string{"-m32", "-m64"} {
+			objFile := filepath.Join(dir, "target"+model+strconv.Itoa(attempt)+".o")
+			exitCode, stdout, stderr, err := createClientAndEmulateDaemonForTesting("g++ " + model + " -c " + cppFile + " -o " + objFile)
+			if err != nil || exitCode != 0 {
+				t.Fatalf("%s: exitCode %d err %v\nstdout %s\nstderr %s", model, exitCode, err, stdout, stderr)
+			}
+			// EI_CLASS in ELF header: 1 for 32-bit objects, 2 for 64-bit ones
+			obj, _ := os.ReadFile(objFile)
+			if expected := map[string]byte{"-m32": 1, "-m64": 2}[model]; len(obj) < 5 || obj[4] != expected {
+				t.Errorf("%s: wrong ELF class of %s", model, objFile)
+			}
+		}
+	}
+}
+
+func Test_srcCacheCompressed(t *testing.T) {
+	if err := server.MakeLoggerServer("", -1); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	_ = os.Mkdir(filepath.Join(dir, "cache"), os.ModePerm)
+	cache, err := server.MakeSrcFileCache(filepath.Join(dir, "cache"), 64*1024*1024, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// system headers are a realistic workload: save them all, then restore every one into a client dir
+	hFiles, _ := filepath.Glob("/usr/include/*.h")
+	if len(hFiles) == 0 {
+		t.Skip("no headers in /usr/include")
+	}
+	var totalSize int64
+	keys := make([]common.SHA256, len(hFiles))
+	for i, hFile := range hFiles {
+		stat, err := os.Stat(hFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i], _ = common.GetFileSHA256(hFile)
+		totalSize += stat.Size()
+		if err := cache.SaveFileToCache(hFile, filepath.Base(hFile), keys[i], stat.Size()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clientDir := filepath.Join(dir, "client")
+	_ = os.Mkdir(clientDir, os.ModePerm)
+	start := time.Now()
+	for i, hFile := range hFiles {
+		serverFileName := filepath.Join(clientDir, strconv.Itoa(i)+".h")
+		if !cache.CreateHardLinkFromCache(serverFileName, keys[i]) {
+			t.Fatalf("%s not restored from cache", hFile)
+		}
+		restored, _ := os.ReadFile(serverFileName)
+		original, _ := os.ReadFile(hFile)
+		if string(restored) != string(original) {
+			t.Fatalf("%s restored incorrectly", hFile)
+		}
+	}
+	t.Logf("%d headers: %d bytes, %d in cache (%.1fx), restoring took %d ms", len(hFiles), totalSize, cache.GetBytesOnDisk(), float64(totalSize)/float64(cache.GetBytesOnDisk()), time.Since(start).Milliseconds())
+
+	if cache.GetBytesOnDisk() >= totalSize/2 || cache.GetCompressBytesIn() != totalSize {
+		t.Errorf("headers are not compressed: %d bytes, %d in cache", totalSize, cache.GetBytesOnDisk())
+	}
+	if cache.GetDecompressedCount() != int64(len(hFiles)) {
+		t.Errorf("unexpected decompressed count %d", cache.GetDecompressedCount())
+	}
+	// tmp files for compressing are removed after saving
+	if tmpFiles, _ := filepath.Glob(filepath.Join(dir, "cache", "*.gz.*")); len(tmpFiles) != 0 {
+		t.Errorf("tmp files are left: %v", tmpFiles)
+	}
+}
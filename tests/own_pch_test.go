@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/VKCOM/nocc/internal/common"
+)
+
+func makeOwnPchForTesting(t *testing.T, dir string, withEqualDeps bool) (*common.OwnPch, map[string][]byte) {
+	deps := map[string][]byte{
+		filepath.Join(dir, "src", "all.h"):   []byte("#include \"one.h\"\n" + strings.Repeat("// padding to be compressed\n", 1000)),
+		filepath.Join(dir, "src", "one.h"):   []byte("int one();\n"),
+		filepath.Join(dir, "src", "empty.h"): {},
+	}
+	if withEqualDeps { // like autogenerated headers
+		generated := []byte(strings.Repeat("// autogenerated\n", 1000))
+		deps[filepath.Join(dir, "src", "gen1.h")] = generated
+		deps[filepath.Join(dir, "src", "gen", "gen2.h")] = generated
+		deps[filepath.Join(dir, "src", "gen", "gen3.h")] = generated
+	}
+	ownPch := &common.OwnPch{
+		OwnPchFile:  filepath.Join(dir, "src", "all.h.nocc-pch"),
+		OrigHFile:   filepath.Join(dir, "src", "all.h"),
+		OrigPchFile: filepath.Join(dir, "src", "all.h.gch"),
+		CxxName:     "g++",
+		CxxArgs:     []string{"-x", "c++-header"},
+	}
+	for fileName, contents := range deps {
+		if err := common.MkdirForFile(fileName); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fileName, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		fileSHA256, _ := common.GetFileSHA256(fileName)
+		ownPch.AddDepInclude(fileName, int64(len(contents)), fileSHA256)
+	}
+	ownPch.CalcPchHash()
+	return ownPch, deps
+}
+
+func saveOwnPchForTesting(t *testing.T, dir string, compressDeps bool, withEqualDeps bool) (*common.OwnPch, map[string][]byte) {
+	ownPch, deps := makeOwnPchForTesting(t, dir, withEqualDeps)
+	if _, err := ownPch.SaveToOwnPchFile(compressDeps, true); err != nil {
+		t.Fatal(err)
+	}
+	return ownPch, deps
+}
+
+func Test_ownPchCompressedDeps(t *testing.T) {
+	var sizes []int64
+	for _, compressDeps := range []bool{false, true} {
+		dir := t.TempDir()
+		saved, deps := saveOwnPchForTesting(t, dir, compressDeps, true)
+		stat, _ := os.Stat(saved.OwnPchFile)
+		sizes = append(sizes, stat.Size())
+
+		// the header is plain text in both cases, like a server sees it after uploading
+		ownPch, err := common.ParseOwnPchFile(saved.OwnPchFile)
+		if err != nil {
+			t.Fatalf("compress %v: %v", compressDeps, err)
+		}
+		if ownPch.PchHash != saved.PchHash || ownPch.OrigHFile != saved.OrigHFile || ownPch.CxxName != "g++" {
+			t.Errorf("compress %v: header parsed incorrectly: %+v", compressDeps, ownPch)
+		}
+
+		rootDir := filepath.Join(dir, "root")
+		if err := ownPch.ExtractAllDepsToRootDir(rootDir); err != nil {
+			t.Fatalf("compress %v: %v", compressDeps, err)
+		}
+		if len(ownPch.DepIncludes) != len(deps) {
+			t.Errorf("compress %v: extracted %d deps, expected %d", compressDeps, len(ownPch.DepIncludes), len(deps))
+		}
+		for fileName, contents := range deps {
+			extracted, err := os.ReadFile(filepath.Join(rootDir, fileName))
+			if err != nil || !bytes.Equal(extracted, contents) {
+				t.Errorf("compress %v: %s extracted incorrectly: %v", compressDeps, fileName, err)
+			}
+		}
+	}
+
+	if sizes[1] >= sizes[0] {
+		t.Errorf("compressed pch is not smaller: %d >= %d", sizes[1], sizes[0])
+	}
+}
+
+func Test_ownPchUnsupportedFormat(t *testing.T) {
+	saved, _ := saveOwnPchForTesting(t, t.TempDir(), false, false)
+	contents, _ := os.ReadFile(saved.OwnPchFile)
+	contents = regexp.MustCompile(`FORMAT=\d+\n`).ReplaceAll(contents, []byte("FORMAT=100\n"))
+	_ = os.WriteFile(saved.OwnPchFile, contents, 0644)
+
+	if _, err := common.ParseOwnPchFile(saved.OwnPchFile); err == nil || !strings.Contains(err.Error(), "upgrade nocc") {
+		t.Errorf("expected a clear error for a newer format, got %v", err)
+	}
+}
+
+func Test_ownPchDedupesEqualDeps(t *testing.T) {
+	saved, deps := saveOwnPchForTesting(t, t.TempDir(), false, true)
+	var depsSize int64
+	for _, contents := range deps {
+		depsSize += int64(len(contents))
+	}
+	stat, _ := os.Stat(saved.OwnPchFile)
+	// 3 equal generated headers are saved once: the file is smaller than all deps even with a header
+	if stat.Size() >= depsSize {
+		t.Errorf("equal deps were not deduplicated: pch size %d, deps size %d", stat.Size(), depsSize)
+	}
+	if saved.FormatVersion != 3 {
+		t.Errorf("format %d, expected 3 for references", saved.FormatVersion)
+	}
+
+	// without equal deps, a file is readable by servers that don't know references
+	saved, _ = saveOwnPchForTesting(t, t.TempDir(), false, false)
+	if saved.FormatVersion != 2 {
+		t.Errorf("format %d, expected 2 without references", saved.FormatVersion)
+	}
+
+	// with equal deps, but without NOCC_DEDUPE_OWN_PCH, too
+	dir := t.TempDir()
+	ownPch, deps := makeOwnPchForTesting(t, dir, true)
+	if _, err := ownPch.SaveToOwnPchFile(false, false); err != nil {
+		t.Fatal(err)
+	}
+	if ownPch.FormatVersion != 2 {
+		t.Errorf("format %d, expected 2 without deduplication", ownPch.FormatVersion)
+	}
+	parsed, err := common.ParseOwnPchFile(ownPch.OwnPchFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.ExtractAllDepsToRootDir(filepath.Join(dir, "root")); err != nil {
+		t.Fatal(err)
+	}
+	for fileName, contents := range deps {
+		if extracted, _ := os.ReadFile(filepath.Join(dir, "root", fileName)); !bytes.Equal(extracted, contents) {
+			t.Errorf("%s extracted incorrectly", fileName)
+		}
+	}
+}
+
+func Test_ownPchLongHeader(t *testing.T) {
+	dir := t.TempDir()
+	ownPch, _ := makeOwnPchForTesting(t, dir, false)
+	for i := 0; i < 2000; i++ {
+		ownPch.CxxArgs = append(ownPch.CxxArgs, fmt.Sprintf("-DSOME_RATHER_LONG_DEFINE_%d=1", i))
+	}
+	if _, err := ownPch.SaveToOwnPchFile(false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the header is more than 32KB
+	parsed, err := common.ParseOwnPchFile(ownPch.OwnPchFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.CxxArgs) != len(ownPch.CxxArgs) {
+		t.Errorf("parsed %d cxx args, expected %d", len(parsed.CxxArgs), len(ownPch.CxxArgs))
+	}
+	if err := parsed.ExtractAllDepsToRootDir(filepath.Join(dir, "root")); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_ownPchCorrupted(t *testing.T) {
+	sepLine := []byte("#=======#'\"\\/\"'#=======#")
+
+	tests := []struct {
+		name         string
+		compressDeps bool
+		corrupt      func(contents []byte) []byte
+		expectedErr  string // regexp
+	}{
+		{"truncated inside header", false, func(c []byte) []byte { return c[:100] }, "is truncated: no dependencies found in 100 bytes"},
+		{"truncated inside dependency", false, func(c []byte) []byte { return c[:len(c)-5] }, `is truncated: \d of 3 dependencies \(\d+ of \d+ bytes\)`},
+		{"truncated at dependency start", false, func(c []byte) []byte { return c[:bytes.LastIndex(c, sepLine)] }, `is truncated: 2 of 3 dependencies`},
+		{"truncated compressed", true, func(c []byte) []byte { return c[:len(c)-100] }, "is truncated: compressed dependencies end unexpectedly"},
+		{"missing fields", false, func(c []byte) []byte {
+			return regexp.MustCompile(`(?m)^(CXX_NAME|PCH_HASH)=.*\n`).ReplaceAll(c, nil)
+		}, `missing PCH_HASH, CXX_NAME in the header`},
+		{"wrong dependency size", false, func(c []byte) []byte {
+			return bytes.Replace(c, []byte("one.h \\11 "), []byte("one.h \\10 "), 1)
+		}, "one.h has 11 bytes, expected 10"},
+		{"empty dependency name", false, func(c []byte) []byte {
+			return regexp.MustCompile(`/\S*/one\.h \\`).ReplaceAll(c, []byte(`\`))
+		}, "invalid dependency line at offset"},
+		{"no dependency size", false, func(c []byte) []byte {
+			return regexp.MustCompile(`(?m)(one\.h \\).*$`).ReplaceAll(c, []byte(`$1`))
+		}, `invalid size of .*/one\.h`},
+		{"dependency escaping root dir", false, func(c []byte) []byte {
+			return bytes.Replace(c, []byte("/src/one.h \\"), []byte("/src/../../../../../../../../one.h \\"), 1)
+		}, `invalid file name ".*/src/\.\./.*one\.h"`},
+		{"relative orig pch", false, func(c []byte) []byte {
+			return bytes.Replace(c, []byte("ORIG_PCH=/"), []byte("ORIG_PCH=../../"), 1)
+		}, `invalid file name "\.\./\.\./`},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		saved, _ := saveOwnPchForTesting(t, dir, test.compressDeps, false)
+		contents, _ := os.ReadFile(saved.OwnPchFile)
+		_ = os.WriteFile(saved.OwnPchFile, test.corrupt(contents), 0644)
+
+		ownPch, err := common.ParseOwnPchFile(saved.OwnPchFile)
+		if err == nil {
+			err = ownPch.ExtractAllDepsToRootDir(filepath.Join(dir, "root"))
+		}
+		if err == nil || !regexp.MustCompile(test.expectedErr).MatchString(err.Error()) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.expectedErr, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VKCOM/nocc/internal/common"
+	"github.com/VKCOM/nocc/internal/server"
+	"github.com/VKCOM/nocc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func Test_clientFileNamesCantEscapeWorkingDir(t *testing.T) {
+	clients, _ := server.MakeClientsStorage(t.TempDir(), 0)
+	client, err := clients.OnClientConnected("traversal", server.ClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	workingDir := client.MapClientFileNameToServerAbs("/")
+
+	for clientFileName, expected := range map[string]string{
+		"/proj/1.cpp":                  workingDir + "proj/1.cpp",
+		"proj/1.cpp":                   workingDir + "proj/1.cpp",
+		"/proj/../../etc/passwd":       workingDir + "etc/passwd",
+		"../../etc/passwd":             workingDir + "etc/passwd",
+		"/..":                          workingDir,
+		"/usr/local/../../etc/passwd":  workingDir + "etc/passwd",
+		"/usr/local/include/../x.h":    "/usr/local/x.h", // system files aren't written, only compared with server ones
+		"/usr/local/include/stdio.h":   "/usr/local/include/stdio.h",
+		"//proj/./sub/../1.cpp":        workingDir + "proj/1.cpp",
+		"/proj/dir with space/../1.h":  workingDir + "proj/1.h",
+		"/proj/..hidden/1.h":           workingDir + "proj/..hidden/1.h",
+		"/proj/../../../../../tmp/1.h": workingDir + "tmp/1.h",
+	} {
+		if serverFileName := client.MapClientFileNameToServerAbs(clientFileName); strings.TrimSuffix(serverFileName, "/") != strings.TrimSuffix(expected, "/") {
+			t.Errorf("%s mapped to %s, expected %s", clientFileName, serverFileName, expected)
+		}
+	}
+
+	for _, clientID := range []string{"", ".", "..", "../escaped", "a/b"} {
+		if _, err := clients.OnClientConnected(clientID, server.ClientOptions{}); err == nil {
+			t.Errorf("clientID %q must be rejected", clientID)
+		}
+	}
+}
+
+func Test_uploadCantWriteOutsideWorkingDir(t *testing.T) {
+	// emulate a malicious client: it requests a session with a dependency pointing outside its working dir and uploads it
+	escapedFileName := filepath.Join(t.TempDir(), "escaped.h")
+	clientFileName := strings.Repeat("/..", 20) + escapedFileName
+	contents := []byte("#define ESCAPED 1\n")
+	hasher := sha256.New()
+	hasher.Write(contents)
+	fileSHA256 := common.MakeSHA256Struct(hasher)
+
+	connection, err := grpc.Dial("127.0.0.1:43210", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	remote := pb.NewCompilationServiceClient(connection)
+
+	clientID := fmt.Sprintf("traversal%d", time.Now().UnixNano())
+	if _, err := remote.StartClient(ctx, &pb.StartClientRequest{ClientID: clientID}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _, _ = remote.StopClient(context.Background(), &pb.StopClientRequest{ClientID: clientID}) }()
+
+	reply, err := remote.StartCompilationSession(ctx, &pb.StartCompilationSessionRequest{
+		ClientID:  clientID,
+		SessionID: 1,
+		Cwd:       "/proj",
+		CppInFile: clientFileName,
+		CxxName:   "g++",
+		CxxArgs:   []string{"-c"},
+		RequiredFiles: []*pb.FileMetadata{{
+			ClientFileName: clientFileName,
+			FileSize:       int64(len(contents)),
+			SHA256_B0_7:    fileSHA256.B0_7,
+			SHA256_B8_15:   fileSHA256.B8_15,
+			SHA256_B16_23:  fileSHA256.B16_23,
+			SHA256_B24_31:  fileSHA256.B24_31,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reply.FileIndexesToUpload) == 1 { // it may be taken from src cache, then it's not uploaded, but hard linked
+		stream, err := remote.UploadFileStream(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.Send(&pb.UploadFileChunkRequest{ClientID: clientID, SessionID: 1, FileIndex: 0, ChunkBody: contents}); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = stream.Recv()
+		_ = stream.CloseSend()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(escapedFileName); err == nil {
+		t.Fatalf("a client managed to write %s outside its working dir", escapedFileName)
+	}
+}
@@ -0,0 +1,883 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/VKCOM/nocc/internal/client"
+	"github.com/VKCOM/nocc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// unlike other tests, this one starts its own nocc-server (on another port), as it needs to restart it
+const restartedServerHostPort = "127.0.0.1:43211"
+
+// serverBinForTesting is nocc-server built once for all tests that start their own server, see TestMain
+var serverBinForTesting string
+
+func TestMain(m *testing.M) {
+	os.Exit(buildServerAndRunTests(m))
+}
+
+func buildServerAndRunTests(m *testing.M) int {
+	tmpDir, err := os.MkdirTemp("", "nocc-tests-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(tmpDir)
+
+	serverBinForTesting = filepath.Join(tmpDir, "nocc-server")
+	if out, err := exec.Command("go", "build", "-o", serverBinForTesting, "../cmd/nocc-server").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build nocc-server: %v %s\n", err, out)
+		return 1
+	}
+	return m.Run()
+}
+
+func startServerForRestartTesting(t *testing.T, dir string, extraArgs ...string) *exec.Cmd {
+	args := []string{"-port", "43211", "-cpp-dir", filepath.Join(dir, "cpp"), "-obj-dir", filepath.Join(dir, "obj"), "-log-filename", filepath.Join(dir, "server.log")}
+	cmd := exec.Command(serverBinForTesting, append(args, extraArgs...)...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", restartedServerHostPort); err == nil {
+			_ = conn.Close()
+			return cmd
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("nocc-server didn't start listening")
+	return nil
+}
+
+func stopServerForRestartTesting(cmd *exec.Cmd) {
+	_ = cmd.Process.Signal(os.Interrupt)
+	_ = cmd.Wait()
+}
+
+func Test_remoteRestartedMidBuild(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1.cpp", "2.cpp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("int f_"+name[:1]+"() { return 1; }\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := startServerForRestartTesting(t, dir)
+	defer func() { stopServerForRestartTesting(server) }()
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	// local cxx is disabled: if compilation succeeds, it was done remotely
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{restartedServerHostPort},
+		ConnectAttempts: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	compile := func(cppName string) {
+		response := daemon.HandleInvocation(client.DaemonSockRequest{
+			Cwd:     dir,
+			CmdLine: []string{"g++", "-c", filepath.Join(dir, cppName), "-o", filepath.Join(dir, cppName+".o")},
+		})
+		if response.ExitCode != 0 {
+			t.Errorf("%s: exitCode %d\nstdout %s\nstderr %s", cppName, response.ExitCode, response.Stdout, response.Stderr)
+		}
+	}
+
+	compile("1.cpp")
+
+	// after a restart, the server doesn't know the client; the daemon should register again, not compile locally
+	stopServerForRestartTesting(server)
+	server = startServerForRestartTesting(t, dir)
+
+	compile("2.cpp")
+}
+
+func Test_serverKillsCxxAfterMaxDuration(t *testing.T) {
+	dir := t.TempDir()
+	// constexpr evaluation takes minutes, like a runaway template instantiation
+	slowCpp := "constexpr long f() { long s = 0; for (long i = 0; i < 100000000000; ++i) s += i % 7; return s; }\nstatic_assert(f() > 0);\n"
+	if err := os.WriteFile(filepath.Join(dir, "slow.cpp"), []byte(slowCpp), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := startServerForRestartTesting(t, dir, "-max-cxx-duration", "1")
+	defer func() { stopServerForRestartTesting(server) }()
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{restartedServerHostPort},
+		ConnectAttempts: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	start := time.Now()
+	response := daemon.HandleInvocation(client.DaemonSockRequest{
+		Cwd:     dir,
+		CmdLine: []string{"g++", "-fconstexpr-loop-limit=2147483647", "-fconstexpr-ops-limit=9223372036854775807", "-c", "slow.cpp", "-o", "slow.o"},
+	})
+	if response.ExitCode == 0 || !strings.Contains(string(response.Stderr), "-max-cxx-duration") {
+		t.Errorf("expected cxx to be killed, got exitCode %d\nstderr %s", response.ExitCode, response.Stderr)
+	}
+	if !strings.Contains(string(response.Stderr), "nocc: failed to compile slow.cpp on remote 127.0.0.1") {
+		t.Errorf("an error of nocc-server doesn't point to slow.cpp:\n%s", response.Stderr)
+	}
+	if elapsed := time.Since(start); elapsed > 15*time.Second {
+		t.Errorf("cxx was killed only after %v", elapsed)
+	}
+}
+
+func Test_forceInterruptTimeout(t *testing.T) {
+	dir := t.TempDir()
+	slowCpp := "constexpr long f() { long s = 0; for (long i = 0; i < 100000000000; ++i) s += i % 7; return s; }\nstatic_assert(f() > 0);\n"
+	if err := os.WriteFile(filepath.Join(dir, "slow.cpp"), []byte(slowCpp), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server would kill cxx much later, the client must give up earlier on its own
+	server := startServerForRestartTesting(t, dir, "-max-cxx-duration", "60")
+	defer func() { stopServerForRestartTesting(server) }()
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:  []string{restartedServerHostPort},
+		ConnectAttempts:  5,
+		InterruptTimeout: 2 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+	go daemon.PeriodicallyInterruptHangedInvocations()
+
+	start := time.Now()
+	response := daemon.HandleInvocation(client.DaemonSockRequest{
+		Cwd:     dir,
+		CmdLine: []string{"g++", "-fconstexpr-loop-limit=2147483647", "-fconstexpr-ops-limit=9223372036854775807", "-c", "slow.cpp", "-o", "slow.o"},
+	})
+	elapsed := time.Since(start)
+	// local cxx is disabled, so an interrupted invocation fails instead of compiling locally
+	if response.ExitCode == 0 || strings.Contains(string(response.Stderr), "-max-cxx-duration") {
+		t.Errorf("expected the invocation to be interrupted by a client, got exitCode %d\nstderr %s", response.ExitCode, response.Stderr)
+	}
+	if elapsed < 2*time.Second || elapsed > 10*time.Second {
+		t.Errorf("the invocation was interrupted after %v, expected after 2s timeout", elapsed)
+	}
+}
+
+func Test_skipObjCacheLookup(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.Mkdir(filepath.Join(dir, "gen"), os.ModePerm)
+	for _, name := range []string{"1.cpp", "gen/2.cpp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("int f() { return 1; }\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	isInObjCache := func(cppName string) bool {
+		found := false
+		_ = filepath.WalkDir(filepath.Join(dir, "obj", "obj-cache"), func(fullPath string, _ os.DirEntry, _ error) error {
+			found = found || strings.HasPrefix(filepath.Base(fullPath), filepath.Base(cppName)+".o.")
+			return nil
+		})
+		return found
+	}
+
+	server := startServerForRestartTesting(t, dir)
+	defer func() { stopServerForRestartTesting(server) }()
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:        []string{restartedServerHostPort},
+		ConnectAttempts:        5,
+		SkipObjCacheLookupDirs: []string{filepath.Join(dir, "gen") + "/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	compile := func(cppName string) {
+		response := daemon.HandleInvocation(client.DaemonSockRequest{
+			Cwd:     dir,
+			CmdLine: []string{"g++", "-c", cppName, "-o", filepath.Join(dir, filepath.Base(cppName)+".o")},
+		})
+		if response.ExitCode != 0 {
+			t.Errorf("%s: exitCode %d\nstdout %s\nstderr %s", cppName, response.ExitCode, response.Stdout, response.Stderr)
+		}
+	}
+
+	// per-session: sources inside NOCC_SKIP_OBJ_CACHE_LOOKUP_DIRS don't get into obj cache, others do
+	compile("1.cpp")
+	compile("gen/2.cpp")
+	if !isInObjCache("1.cpp") || isInObjCache("2.cpp") {
+		t.Errorf("only 1.cpp was expected to be in obj cache")
+	}
+
+	// server-wide: nothing gets into obj cache
+	stopServerForRestartTesting(server)
+	server = startServerForRestartTesting(t, dir, "-disable-obj-cache-lookup")
+	compile("1.cpp")
+	if isInObjCache("1.cpp") {
+		t.Errorf("obj cache was expected to be empty with -disable-obj-cache-lookup")
+	}
+}
+
+func Test_srcCacheReuseIsCounted(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "1.h"), []byte("#define ONE 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte("#include \"1.h\"\nint f() { return ONE; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// obj cache lookup is disabled, so that the second compilation requires all sources again
+	server := startServerForRestartTesting(t, dir, "-disable-obj-cache-lookup")
+	defer func() { stopServerForRestartTesting(server) }()
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	// every daemon is a new client with an empty working dir: the first one uploads files, the second one reuses them
+	for i := 0; i < 2; i++ {
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts: []string{restartedServerHostPort},
+			ConnectAttempts: 5,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := daemon.HandleInvocation(client.DaemonSockRequest{
+			Cwd:     dir,
+			CmdLine: []string{"g++", "-c", "1.cpp", "-o", filepath.Join(dir, "1.o")},
+		})
+		daemon.QuitDaemonGracefully("done")
+		if response.ExitCode != 0 {
+			t.Fatalf("exitCode %d\nstdout %s\nstderr %s", response.ExitCode, response.Stdout, response.Stderr)
+		}
+	}
+
+	connection, err := grpc.Dial(restartedServerHostPort, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	reply, err := pb.NewCompilationServiceClient(connection).Status(context.Background(), &pb.StatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.SrcFilesUploaded == 0 || reply.SrcFilesReused != reply.SrcFilesUploaded {
+		t.Errorf("expected all uploaded files to be reused, got %d uploaded and %d reused", reply.SrcFilesUploaded, reply.SrcFilesReused)
+	}
+	if reply.SrcBytesReused != reply.SrcBytesUploaded || reply.SrcBytesReused == 0 {
+		t.Errorf("expected reused bytes to equal uploaded ones, got %d and %d", reply.SrcBytesReused, reply.SrcBytesUploaded)
+	}
+}
+
+func Test_serverCompilerMap(t *testing.T) {
+	dir := t.TempDir()
+	// a "server compiler" leaves a marker, so that we know it was launched instead of g++
+	markerFile := filepath.Join(dir, "server-cxx-launched")
+	serverCxx := filepath.Join(dir, "server-g++")
+	if err := os.WriteFile(serverCxx, []byte("#!/bin/sh\ntouch "+markerFile+"\nexec g++ \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte("int f() { return 1; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a mapping to a non-existing compiler is rejected on start
+	badArgs := []string{"-port", "43211", "-cpp-dir", filepath.Join(dir, "bad"), "-obj-dir", filepath.Join(dir, "bad"), "-compiler-map", "g++=" + filepath.Join(dir, "nonexisting")}
+	if out, err := exec.Command(serverBinForTesting, badArgs...).CombinedOutput(); err == nil || !strings.Contains(string(out), "invalid compiler mapping") {
+		t.Errorf("expected a server to fail on start, got %v %s", err, out)
+	}
+
+	server := startServerForRestartTesting(t, dir, "-compiler-map", "g++="+serverCxx)
+	defer func() { stopServerForRestartTesting(server) }()
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{restartedServerHostPort},
+		ConnectAttempts: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	response := daemon.HandleInvocation(client.DaemonSockRequest{
+		Cwd:     dir,
+		CmdLine: []string{"/usr/bin/g++", "-c", "1.cpp", "-o", filepath.Join(dir, "1.o")},
+	})
+	if response.ExitCode != 0 {
+		t.Fatalf("exitCode %d\nstdout %s\nstderr %s", response.ExitCode, response.Stdout, response.Stderr)
+	}
+	if _, err := os.Stat(markerFile); err != nil {
+		t.Errorf("a mapped compiler was not launched on a server")
+	}
+}
+
+func Test_compilerNotFoundOnServer(t *testing.T) {
+	dir := t.TempDir()
+	// a mapped compiler exists on server start, but then disappears (like a server with a broken toolchain)
+	serverCxx := filepath.Join(dir, "server-g++")
+	if err := os.WriteFile(serverCxx, []byte("#!/bin/sh\nexec g++ \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte("int f() { return 1; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := startServerForRestartTesting(t, dir, "-compiler-map", "g++="+serverCxx)
+	defer func() { stopServerForRestartTesting(server) }()
+	_ = os.Remove(serverCxx)
+
+	logFile := filepath.Join(dir, "client.log")
+	if err := client.MakeLoggerClient(logFile, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.MakeLoggerClient("", -1, false) }()
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{restartedServerHostPort},
+		ConnectAttempts:      5,
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	response := daemon.HandleInvocation(client.DaemonSockRequest{
+		Cwd:     dir,
+		CmdLine: []string{"g++", "-c", "1.cpp", "-o", filepath.Join(dir, "1.o")},
+	})
+	if response.ExitCode != 0 || response.Outcome != client.OutcomeLocal {
+		t.Errorf("expected local compilation, exitCode %d, outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+	}
+	if logContents, _ := os.ReadFile(logFile); !strings.Contains(string(logContents), "compiler g++ not found on server 127.0.0.1") {
+		t.Errorf("a missing compiler is not reported clearly:\n%s", logContents)
+	}
+}
+
+func Test_compilerKilledOnServer(t *testing.T) {
+	dir := t.TempDir()
+	// a "server compiler" crashes, like cc1plus on a compiler bug
+	serverCxx := filepath.Join(dir, "server-g++")
+	if err := os.WriteFile(serverCxx, []byte("#!/bin/sh\nkill -SEGV $$\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte("int f() { return 1; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := startServerForRestartTesting(t, dir, "-compiler-map", "g++="+serverCxx)
+	defer func() { stopServerForRestartTesting(server) }()
+
+	logFile := filepath.Join(dir, "client.log")
+	if err := client.MakeLoggerClient(logFile, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.MakeLoggerClient("", -1, false) }()
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{restartedServerHostPort},
+		ConnectAttempts:      5,
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	// twice: a crash must not leave anything in obj cache
+	for i := 0; i < 2; i++ {
+		response := daemon.HandleInvocation(client.DaemonSockRequest{
+			Cwd:     dir,
+			CmdLine: []string{"g++", "-c", "1.cpp", "-o", filepath.Join(dir, "1.o")},
+		})
+		if response.ExitCode != 0 || response.Outcome != client.OutcomeLocal {
+			t.Errorf("expected local compilation, exitCode %d, outcome %q\nstderr %s", response.ExitCode, response.Outcome, response.Stderr)
+		}
+	}
+	if logContents, _ := os.ReadFile(logFile); !strings.Contains(string(logContents), "compiler killed by signal 11 on server 127.0.0.1") {
+		t.Errorf("a crashed compiler is not reported clearly:\n%s", logContents)
+	}
+	if out, err := exec.Command("nm", filepath.Join(dir, "1.o")).CombinedOutput(); err != nil || !strings.Contains(string(out), "f") {
+		t.Errorf("1.o is not a valid obj: %v %s", err, out)
+	}
+}
+
+func Test_objCacheWithWarnings(t *testing.T) {
+	dir := t.TempDir()
+	// a "server compiler" counts its launches, so that we know whether an obj was taken from obj cache
+	launchesFile := filepath.Join(dir, "server-cxx-launches")
+	serverCxx := filepath.Join(dir, "server-g++")
+	if err := os.WriteFile(serverCxx, []byte("#!/bin/sh\necho >> "+launchesFile+"\nexec g++ \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"1.cpp", "2.cpp", "3.cpp", "4.cpp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("int f() { int unused; return 1; }\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nLaunches := func() int {
+		launches, _ := os.ReadFile(launchesFile)
+		return len(launches)
+	}
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	compileSeeding := func(cwd string, cppName string, seedObjCache bool) client.DaemonSockResponse {
+		maxLocalCxx := int64(0) // seeding means compiling locally
+		if seedObjCache {
+			maxLocalCxx = 1
+		}
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts:      []string{restartedServerHostPort},
+			ConnectAttempts:      5,
+			SeedObjCache:         seedObjCache,
+			MaxLocalCxxProcesses: maxLocalCxx,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer daemon.QuitDaemonGracefully("done")
+		response := daemon.HandleInvocation(client.DaemonSockRequest{
+			Cwd:     cwd,
+			CmdLine: []string{"g++", "-Wall", "-c", cppName, "-o", filepath.Join(cwd, cppName+".o")},
+		})
+		if response.ExitCode != 0 || !strings.Contains(string(response.Stderr), "unused") {
+			t.Fatalf("expected a warning, got exitCode %d\nstdout %s\nstderr %s", response.ExitCode, response.Stdout, response.Stderr)
+		}
+		return response
+	}
+	compile := func() client.DaemonSockResponse {
+		return compileSeeding(dir, "1.cpp", false)
+	}
+
+	// with -cache-objs-with-warnings=false, an obj compiled with warnings isn't cached
+	server := startServerForRestartTesting(t, dir, "-compiler-map", "g++="+serverCxx, "-cache-objs-with-warnings=false", "-accept-obj-cache-seeds")
+	compile()
+	compile()
+	if nLaunches() != 2 {
+		t.Errorf("expected an obj with warnings not to be cached, cxx launched %d times", nLaunches())
+	}
+
+	// neither is a seeded one, and once refused, objs with warnings are not seeded there anymore
+	logFile := filepath.Join(dir, "client.log")
+	uploadsFile := filepath.Join(dir, "uploads.tsv")
+	if err := client.MakeLoggerClient(logFile, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	seedingDaemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{restartedServerHostPort},
+		ConnectAttempts:      5,
+		SeedObjCache:         true,
+		MaxLocalCxxProcesses: 1,
+		UploadsFileName:      uploadsFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := func(cppName string) {
+		response := seedingDaemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-Wall", "-c", cppName, "-o", filepath.Join(dir, cppName+".o")}})
+		if response.ExitCode != 0 || !strings.Contains(string(response.Stderr), "unused") {
+			t.Fatalf("expected a warning, got exitCode %d\nstderr %s", response.ExitCode, response.Stderr)
+		}
+	}
+	seed("3.cpp")
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if logContents, _ := os.ReadFile(logFile); strings.Contains(string(logContents), "refused to seed obj cache") {
+			break
+		}
+	}
+	seed("4.cpp")
+	seedingDaemon.QuitDaemonGracefully("done")
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	if uploads, _ := os.ReadFile(uploadsFile); !strings.Contains(string(uploads), "3.cpp.o") || strings.Contains(string(uploads), "4.cpp.o") {
+		t.Errorf("expected an obj with warnings not to be seeded after a refusal:\n%s", uploads)
+	}
+	stopServerForRestartTesting(server)
+	if nLaunches() != 2 {
+		t.Errorf("expected seeding to compile locally, cxx launched %d times", nLaunches())
+	}
+
+	// by default, it's cached, and warnings are replayed
+	_ = os.Remove(launchesFile)
+	server = startServerForRestartTesting(t, dir, "-compiler-map", "g++="+serverCxx, "-accept-obj-cache-seeds")
+	defer func() { stopServerForRestartTesting(server) }()
+	first := compile()
+	second := compile()
+	if nLaunches() != 1 {
+		t.Errorf("expected an obj with warnings to be cached, cxx launched %d times", nLaunches())
+	}
+	if !bytes.Equal(first.Stderr, second.Stderr) || !bytes.Equal(first.Stdout, second.Stdout) {
+		t.Errorf("warnings were not replayed from obj cache:\n%s\n---\n%s", first.Stderr, second.Stderr)
+	}
+
+	// an obj seeded by a client (compiled locally) also brings its warnings
+	local := compileSeeding(dir, "2.cpp", true)
+	remote := compileSeeding(dir, "2.cpp", false)
+	if nLaunches() != 1 {
+		t.Errorf("expected a seeded obj with warnings to be taken from cache, cxx launched %d times", nLaunches())
+	}
+	if !bytes.Equal(local.Stderr, remote.Stderr) {
+		t.Errorf("warnings of a seeded obj were not replayed from obj cache:\n%s\n---\n%s", local.Stderr, remote.Stderr)
+	}
+
+	// the same .cpp in another dir: warnings of 1.cpp contain its path, they must not be replayed here
+	otherDir := filepath.Join(dir, "other")
+	_ = os.Mkdir(otherDir, 0755)
+	if err := os.WriteFile(filepath.Join(otherDir, "1.cpp"), []byte("int f() { int unused; return 1; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := compileSeeding(otherDir, "1.cpp", false)
+	if nLaunches() != 2 {
+		t.Errorf("expected an obj with warnings not to be shared across client paths, cxx launched %d times", nLaunches())
+	}
+	if bytes.Contains(other.Stderr, []byte(dir+"/1.cpp")) {
+		t.Errorf("warnings of another client path were replayed:\n%s", other.Stderr)
+	}
+}
+
+func Test_serverRejectsTooManyDeps(t *testing.T) {
+	dir := t.TempDir()
+	markerFile := filepath.Join(dir, "server-cxx-launched")
+	serverCxx := filepath.Join(dir, "server-g++")
+	if err := os.WriteFile(serverCxx, []byte("#!/bin/sh\ntouch "+markerFile+"\nexec g++ \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// 1.cpp and 1.h are 2 dependencies, more than a limit
+	_ = os.WriteFile(filepath.Join(dir, "1.h"), []byte("#define ONE 1\n"), 0644)
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte("#include \"1.h\"\nint f() { return ONE; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := startServerForRestartTesting(t, dir, "-max-session-deps", "1", "-compiler-map", "g++="+serverCxx)
+	defer func() { stopServerForRestartTesting(server) }()
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{restartedServerHostPort},
+		ConnectAttempts:      5,
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	// a session is rejected, and a file is compiled locally
+	response := daemon.HandleInvocation(client.DaemonSockRequest{
+		Cwd:     dir,
+		CmdLine: []string{"g++", "-c", "1.cpp", "-o", filepath.Join(dir, "1.o")},
+	})
+	if response.ExitCode != 0 {
+		t.Fatalf("exitCode %d\nstdout %s\nstderr %s", response.ExitCode, response.Stdout, response.Stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.o")); err != nil {
+		t.Errorf("obj was not compiled: %v", err)
+	}
+	if _, err := os.Stat(markerFile); err == nil {
+		t.Errorf("a session with too many dependencies was compiled on a server")
+	}
+}
+
+func Test_busyServerHint(t *testing.T) {
+	dir := t.TempDir()
+	// one slow cxx at a time: a queue grows while files are compiled
+	serverCxx := filepath.Join(dir, "slow-g++")
+	if err := os.WriteFile(serverCxx, []byte("#!/bin/sh\nsleep 1\nexec g++ \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 7; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.cpp", i)), []byte(fmt.Sprintf("int f_%d() { return 1; }\n", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := startServerForRestartTesting(t, dir, "-compiler-map", "g++="+serverCxx, "-max-parallel-cxx", "1", "-busy-queue-size", "1")
+	defer func() { stopServerForRestartTesting(server) }()
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts:      []string{restartedServerHostPort},
+		ConnectAttempts:      5,
+		MaxLocalCxxProcesses: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.QuitDaemonGracefully("done")
+
+	compile := func(i int) client.DaemonSockResponse {
+		return daemon.HandleInvocation(client.DaemonSockRequest{Cwd: dir, CmdLine: []string{"g++", "-c", fmt.Sprintf("%d.cpp", i), "-o", fmt.Sprintf("%d.o", i)}})
+	}
+	// 7 is put to obj cache while a server is idle
+	if response := compile(7); !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+		t.Fatalf("expected 7.cpp to be compiled remotely, outcome %q", response.Outcome)
+	}
+	// 1..4 fill a queue, 5 is started when a queue is deep and gets a hint, 6 is compiled locally then
+	wg := sync.WaitGroup{}
+	outcomes := make([]string, 7)
+	for i := 1; i <= 5; i++ {
+		if i == 5 {
+			time.Sleep(500 * time.Millisecond)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outcomes[i] = compile(i).Outcome
+		}(i)
+	}
+	time.Sleep(300 * time.Millisecond)
+	response := compile(6)
+	outcomes[6] = response.Outcome
+	// while a server is still busy, an obj from its obj cache is preferred over a local cxx
+	cachedResponse := compile(7)
+	wg.Wait()
+
+	if response.ExitCode != 0 || outcomes[6] != client.OutcomeLocal {
+		t.Errorf("expected 6.cpp to be compiled locally while a server is busy, exitCode %d, outcomes %v", response.ExitCode, outcomes[1:])
+	}
+	if cachedResponse.ExitCode != 0 || !strings.HasPrefix(cachedResponse.Outcome, client.OutcomeObjCache) {
+		t.Errorf("expected 7.cpp to be taken from obj cache while a server is busy, exitCode %d, outcome %q", cachedResponse.ExitCode, cachedResponse.Outcome)
+	}
+	for i := 1; i <= 5; i++ {
+		if !strings.HasPrefix(outcomes[i], client.OutcomeRemote) {
+			t.Errorf("expected %d.cpp to be compiled remotely, outcomes %v", i, outcomes[1:])
+		}
+	}
+}
+
+// lockedBuffer is a bytes.Buffer written from several goroutines
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func Test_followServerLogs(t *testing.T) {
+	dir := t.TempDir()
+	server := startServerForRestartTesting(t, dir)
+	defer func() { stopServerForRestartTesting(server) }()
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	var out lockedBuffer
+	ctx, cancel := context.WithCancel(context.Background())
+	followDone := make(chan struct{})
+	go func() {
+		client.FollowRemoteLogs(ctx, []string{restartedServerHostPort}, &out)
+		close(followDone)
+	}()
+
+	// any request that the server logs: it's repeated, as lines before following has started aren't sent
+	waitForLogLine := func(olderThan time.Duration) bool {
+		for i := 0; i < 25; i++ {
+			client.RequestPurgeStaleCaches([]string{restartedServerHostPort}, olderThan)
+			time.Sleep(200 * time.Millisecond)
+			for _, line := range strings.Split(out.String(), "\n") {
+				if strings.Contains(line, "127.0.0.1") && strings.Contains(line, "older than "+olderThan.String()) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if !waitForLogLine(time.Hour) {
+		t.Fatalf("no lines followed:\n%s", out.String())
+	}
+
+	// logrotate: move a log file away and tell the server to reopen it
+	if err := os.Rename(filepath.Join(dir, "server.log"), filepath.Join(dir, "server.log.1")); err != nil {
+		t.Fatal(err)
+	}
+	_ = server.Process.Signal(syscall.SIGUSR1)
+	if !waitForLogLine(2 * time.Hour) {
+		t.Errorf("no lines followed after rotation:\n%s", out.String())
+	}
+
+	cancel()
+	select {
+	case <-followDone:
+	case <-time.After(5 * time.Second):
+		t.Errorf("following wasn't stopped on cancel")
+	}
+}
+
+// issueCertForTLSTesting writes {name}.crt and {name}.key to dir; a cert is self-signed if parent is nil (a CA)
+func issueCertForTLSTesting(t *testing.T, dir string, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func Test_mutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "1.cpp"), []byte("int f() { return 1; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ca, caKey := issueCertForTLSTesting(t, dir, "ca", nil, nil)
+	issueCertForTLSTesting(t, dir, "server", ca, caKey)
+	issueCertForTLSTesting(t, dir, "client", ca, caKey)
+	otherCA, otherCAKey := issueCertForTLSTesting(t, dir, "other-ca", nil, nil)
+	issueCertForTLSTesting(t, dir, "untrusted-client", otherCA, otherCAKey)
+
+	crt := func(name string) string { return filepath.Join(dir, name+".crt") }
+	key := func(name string) string { return filepath.Join(dir, name+".key") }
+	server := startServerForRestartTesting(t, dir, "-tls-cert", crt("server"), "-tls-key", key("server"), "-tls-client-ca", crt("ca"))
+	defer func() { stopServerForRestartTesting(server) }()
+
+	if err := client.MakeLoggerClient("", -1, false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.SetupTLS("", "", "") }()
+
+	for _, tc := range []struct {
+		name          string
+		clientCert    string
+		expectSuccess bool
+	}{
+		{"valid client cert", "client", true},
+		{"client cert of another CA", "untrusted-client", false},
+		{"no client cert", "", false},
+	} {
+		var err error
+		if tc.clientCert != "" {
+			err = client.SetupTLS(crt("ca"), crt(tc.clientCert), key(tc.clientCert))
+		} else {
+			err = client.SetupTLS(crt("ca"), "", "")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// local cxx is disabled: preflight fails if the server is unreachable, compilation succeeds only remotely
+		daemon, err := client.MakeDaemon(client.DaemonOptions{
+			RemoteNoccHosts: []string{restartedServerHostPort},
+			DisableObjCache: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = daemon.RunPreflight()
+		if (err == nil) != tc.expectSuccess {
+			t.Errorf("%s: unexpected preflight result: %v", tc.name, err)
+		}
+		if tc.expectSuccess {
+			response := daemon.HandleInvocation(client.DaemonSockRequest{
+				Cwd:     dir,
+				CmdLine: []string{"g++", "-c", filepath.Join(dir, "1.cpp"), "-o", filepath.Join(dir, "1.o")},
+			})
+			if response.ExitCode != 0 || !strings.HasPrefix(response.Outcome, client.OutcomeRemote) {
+				t.Errorf("%s: exitCode %d, outcome %q\nstderr %s", tc.name, response.ExitCode, response.Outcome, response.Stderr)
+			}
+		}
+		daemon.QuitDaemonGracefully("done")
+	}
+
+	// a plaintext client can't talk to a TLS server at all
+	if err := client.SetupTLS("", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := client.MakeDaemon(client.DaemonOptions{
+		RemoteNoccHosts: []string{restartedServerHostPort},
+		DisableObjCache: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.RunPreflight(); err == nil {
+		t.Errorf("plaintext client: expected preflight to fail")
+	}
+	daemon.QuitDaemonGracefully("done")
+}
@@ -18,7 +18,22 @@ func createClientAndEmulateDaemonForTesting(cmdLineStr string) (exitCode int, st
 		return
 	}
 
-	exitCode, stdout, stderr = client.EmulateDaemonInsideThisProcessForDev(remoteNoccHosts, cmdLine, false, 0)
+	exitCode, stdout, stderr = client.EmulateDaemonInsideThisProcessForDev(remoteNoccHosts, cmdLine, false, false, false, nil, 0)
+	time.Sleep(100 * time.Millisecond) // for all goroutines to finish
+	return
+}
+
+// createClientAndEmulateDaemonWithLocalCxxForTesting is like createClientAndEmulateDaemonForTesting,
+// but if nocc decides to fall back to local cxx, it's allowed.
+func createClientAndEmulateDaemonWithLocalCxxForTesting(cmdLineStr string) (exitCode int, stdout []byte, stderr []byte, err error) {
+	var cmdLine = strings.Split(cmdLineStr, " ")
+	var remoteNoccHosts = []string{"127.0.0.1:43210"}
+
+	if err = client.MakeLoggerClient("", -1, false); err != nil {
+		return
+	}
+
+	exitCode, stdout, stderr = client.EmulateDaemonInsideThisProcessForDev(remoteNoccHosts, cmdLine, false, false, false, nil, 1)
 	time.Sleep(100 * time.Millisecond) // for all goroutines to finish
 	return
 }